@@ -0,0 +1,152 @@
+// Package client provides a Go client for the /transaction/v1 HTTP API of the wallet.
+package client
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	kithttp "github.com/go-kit/kit/transport/http"
+	"github.com/google/uuid"
+
+	"github.com/nogurenn/cph-wallet/transaction"
+)
+
+// Client calls a remote wallet over HTTP. It implements transaction.Service, and failed calls return the same
+// domain errors as the service itself, e.g. transaction.ErrBalanceInsufficient, or *transaction.ResponseError.
+//
+// Reads, deposits and payments are retried on network errors and 5xx/429 responses. Every deposit and payment
+// carries an idempotency key, which is reused across its retries so that it is recorded at most once; callers
+// may supply their own key with transaction.WithIdempotencyKey. Other writes are never retried.
+//
+// Exports are retried until the first entry arrives, and not after. Their entries are read within the timeout of
+// the http.Client, so large exports call for a client with a longer or no timeout, see WithHTTPClient. The same
+// goes for account event streams, which last until the caller cancels them; to resume one, call again with the
+// Seq of the last event received.
+type Client struct {
+	transaction.Endpoints
+}
+
+var _ transaction.Service = (*Client)(nil)
+
+type config struct {
+	httpClient *http.Client
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+type Option func(*config)
+
+// WithHTTPClient sets the http.Client used for requests, e.g. to configure timeouts or TLS.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *config) { c.httpClient = httpClient }
+}
+
+// WithRetries sets how many times a failed call is retried, and the bounds of the jittered exponential backoff
+// between attempts. Zero maxRetries disables retries.
+func WithRetries(maxRetries int, minBackoff time.Duration, maxBackoff time.Duration) Option {
+	return func(c *config) {
+		c.maxRetries = maxRetries
+		c.minBackoff = minBackoff
+		c.maxBackoff = maxBackoff
+	}
+}
+
+// New returns a Client for the wallet at instance, e.g. "localhost:8080" or "https://wallet.internal".
+func New(instance string, options ...Option) (*Client, error) {
+	cfg := config{
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		maxRetries: 3,
+		minBackoff: 100 * time.Millisecond,
+		maxBackoff: 2 * time.Second,
+	}
+	for _, option := range options {
+		option(&cfg)
+	}
+
+	endpoints, err := transaction.MakeClientEndpoints(instance, kithttp.SetClient(cfg.httpClient))
+	if err != nil {
+		return nil, err
+	}
+
+	retry := retryMiddleware(cfg)
+
+	endpoints.GetAccountsEndpoint = retry(endpoints.GetAccountsEndpoint)
+	endpoints.GetAccountEndpoint = retry(endpoints.GetAccountEndpoint)
+	endpoints.GetPaymentTransactionsEndpoint = retry(endpoints.GetPaymentTransactionsEndpoint)
+	endpoints.GetPaymentRequestsEndpoint = retry(endpoints.GetPaymentRequestsEndpoint)
+	endpoints.GetAccountStatusChangesEndpoint = retry(endpoints.GetAccountStatusChangesEndpoint)
+	endpoints.GetAccountStatementEndpoint = retry(endpoints.GetAccountStatementEndpoint)
+	endpoints.ExportEntriesEndpoint = retry(endpoints.ExportEntriesEndpoint)
+	endpoints.StreamAccountEventsEndpoint = retry(endpoints.StreamAccountEventsEndpoint)
+	endpoints.ReconcileEndpoint = retry(endpoints.ReconcileEndpoint)
+	endpoints.GetAuditLogEndpoint = retry(endpoints.GetAuditLogEndpoint)
+	endpoints.VerifyAuditLogEndpoint = retry(endpoints.VerifyAuditLogEndpoint)
+	endpoints.DepositEndpoint = idempotencyKeyMiddleware(retry(endpoints.DepositEndpoint))
+	endpoints.SendPaymentEndpoint = idempotencyKeyMiddleware(retry(endpoints.SendPaymentEndpoint))
+
+	return &Client{endpoints}, nil
+}
+
+// idempotencyKeyMiddleware assigns a fresh idempotency key to calls that do not carry one yet.
+func idempotencyKeyMiddleware(next endpoint.Endpoint) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		if transaction.IdempotencyKeyFrom(ctx) == "" {
+			ctx = transaction.WithIdempotencyKey(ctx, uuid.New().String())
+		}
+		return next(ctx, request)
+	}
+}
+
+func retryMiddleware(cfg config) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			for attempt := 0; ; attempt++ {
+				response, err := next(ctx, request)
+				if err == nil || attempt >= cfg.maxRetries || !isRetryable(ctx, err) {
+					return response, err
+				}
+
+				select {
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				case <-time.After(backoff(cfg, attempt)):
+				}
+			}
+		}
+	}
+}
+
+// isRetryable reports whether err may go away on its own: network failures, overload and server faults.
+// Domain errors are final.
+func isRetryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var responseErr *transaction.ResponseError
+	if errors.As(err, &responseErr) {
+		return responseErr.StatusCode >= http.StatusInternalServerError ||
+			responseErr.StatusCode == http.StatusTooManyRequests
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// backoff returns a random delay between minBackoff and the exponentially growing cap for the given attempt.
+func backoff(cfg config, attempt int) time.Duration {
+	ceiling := cfg.minBackoff << uint(attempt)
+	if ceiling > cfg.maxBackoff || ceiling <= 0 {
+		ceiling = cfg.maxBackoff
+	}
+	if ceiling <= cfg.minBackoff {
+		return cfg.minBackoff
+	}
+	return cfg.minBackoff + time.Duration(rand.Int63n(int64(ceiling-cfg.minBackoff)))
+}
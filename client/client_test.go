@@ -0,0 +1,647 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/google/uuid"
+	"github.com/nogurenn/cph-wallet/client"
+	mocktransaction "github.com/nogurenn/cph-wallet/mocks/autogen/transaction"
+	"github.com/nogurenn/cph-wallet/transaction"
+	"github.com/nogurenn/cph-wallet/util"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/guregu/null.v4"
+)
+
+// newTestClient serves s through transaction.MakeHandler and returns a Client for it, along with
+// the Idempotency-Key header of every request the server received.
+func newTestClient(t *testing.T, s transaction.Service) (*client.Client, func() []string) {
+	var (
+		mu   sync.Mutex
+		keys []string
+	)
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		mu.Unlock()
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	c, err := client.New(server.URL, client.WithRetries(2, time.Millisecond, 5*time.Millisecond))
+	require.NoError(t, err)
+
+	return c, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, keys...)
+	}
+}
+
+func Test_Client_GetAccounts_Success(t *testing.T) {
+	// given
+	alice := transaction.Account{Username: "alice456", Balance: decimal.NewFromFloat(270.62), Currency: "USD", Status: transaction.ActiveAccountStatus}
+	bob := transaction.Account{Username: "bob123", Balance: decimal.NewFromFloat(44.47), Currency: "USD", Status: transaction.FrozenAccountStatus}
+
+	s := new(mocktransaction.Service)
+	s.On("GetAccounts", mock.Anything).Return([]transaction.Account{alice, bob}, nil)
+
+	c, _ := newTestClient(t, s)
+
+	// when
+	accounts, err := c.GetAccounts(context.Background())
+
+	// then
+	require.NoError(t, err)
+	require.Len(t, accounts, 2)
+	assert.Equal(t, alice.Username, accounts[0].Username)
+	assert.True(t, alice.Balance.Equal(accounts[0].Balance))
+	assert.Equal(t, bob.Status, accounts[1].Status)
+
+	s.AssertExpectations(t)
+}
+
+func Test_Client_GetAccount_AccountNotFound(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+	s.On("GetAccount", mock.Anything, "nobody").Return(nil, transaction.ErrAccountNotFound).Once()
+
+	c, _ := newTestClient(t, s)
+
+	// when
+	account, err := c.GetAccount(context.Background(), "nobody")
+
+	// then
+	assert.Nil(t, account)
+	assert.True(t, errors.Is(err, transaction.ErrAccountNotFound))
+
+	s.AssertExpectations(t)
+}
+
+func Test_Client_GetAccountAsOf_Success(t *testing.T) {
+	// given
+	asOf := time.Date(2022, 4, 30, 23, 59, 59, 999999000, time.UTC)
+	alice := &transaction.Account{Username: "alice456", Balance: decimal.NewFromFloat(44.79), Currency: "USD", Status: transaction.ActiveAccountStatus}
+
+	s := new(mocktransaction.Service)
+	s.On("GetAccountAsOf", mock.Anything, "alice456", asOf).Return(alice, nil).Once()
+
+	c, _ := newTestClient(t, s)
+
+	// when
+	account, err := c.GetAccountAsOf(context.Background(), "alice456", asOf)
+
+	// then
+	require.NoError(t, err)
+	assert.True(t, alice.Balance.Equal(account.Balance))
+
+	s.AssertExpectations(t)
+}
+
+func Test_Client_GetPaymentTransactions_Success(t *testing.T) {
+	// given
+	payment := transaction.Transaction{
+		Id:   uuid.New(),
+		Name: transaction.PaymentTransaction,
+		Entries: []transaction.Entry{
+			{
+				Name:              transaction.OutgoingEntry,
+				Debit:             decimal.NewFromFloat(-44.79),
+				AccountName:       "karen789",
+				TargetAccountId:   util.NewNullUUID(uuid.New()),
+				TargetAccountName: null.StringFrom("alice456"),
+			},
+		},
+	}
+
+	s := new(mocktransaction.Service)
+	s.On("GetPaymentTransactions", mock.Anything, transaction.TransactionFilter{}).Return([]transaction.Transaction{payment}, nil)
+
+	c, _ := newTestClient(t, s)
+
+	// when
+	payments, err := c.GetPaymentTransactions(context.Background(), transaction.TransactionFilter{})
+
+	// then
+	require.NoError(t, err)
+	require.Len(t, payments, 1)
+	assert.Equal(t, payment.Id, payments[0].Id)
+	require.Len(t, payments[0].Entries, 1)
+	assert.Equal(t, "karen789", payments[0].Entries[0].AccountName)
+	assert.Equal(t, "alice456", payments[0].Entries[0].TargetAccountName.String)
+	assert.True(t, payments[0].Entries[0].Debit.Equal(decimal.NewFromFloat(-44.79)))
+
+	s.AssertExpectations(t)
+}
+
+func Test_Client_GetPaymentTransactions_ByExternalReference(t *testing.T) {
+	// given
+	details := transaction.PaymentDetails{
+		Description:       null.StringFrom("March rent"),
+		ExternalReference: null.StringFrom("INV-0001"),
+		Metadata:          transaction.Metadata{"lines": json.Number("2")},
+	}
+	payment := transaction.Transaction{Id: uuid.New(), Name: transaction.PaymentTransaction, PaymentDetails: details}
+
+	s := new(mocktransaction.Service)
+	s.On("GetPaymentTransactions", mock.Anything, transaction.TransactionFilter{ExternalReference: "INV-0001"}).
+		Return([]transaction.Transaction{payment}, nil)
+
+	c, _ := newTestClient(t, s)
+
+	// when
+	payments, err := c.GetPaymentTransactions(context.Background(), transaction.TransactionFilter{ExternalReference: "INV-0001"})
+
+	// then
+	require.NoError(t, err)
+	require.Len(t, payments, 1)
+	assert.Equal(t, details, payments[0].PaymentDetails)
+
+	s.AssertExpectations(t)
+}
+
+func Test_Client_SendPayment_BalanceInsufficient(t *testing.T) {
+	// given
+	amount := decimal.NewFromFloat(1000.0)
+
+	s := new(mocktransaction.Service)
+	s.On("SendPayment", mock.Anything, "bob123", "alice456", mock.Anything, transaction.PaymentDetails{}).Return(transaction.ErrBalanceInsufficient).Once()
+
+	c, keys := newTestClient(t, s)
+
+	// when
+	err := c.SendPayment(context.Background(), "bob123", "alice456", amount, transaction.PaymentDetails{})
+
+	// then
+	assert.True(t, errors.Is(err, transaction.ErrBalanceInsufficient))
+	assert.Len(t, keys(), 1) // domain errors are not retried
+
+	s.AssertExpectations(t)
+}
+
+func Test_Client_SendPayment_RetriedWithSameIdempotencyKey(t *testing.T) {
+	// given
+	amount := decimal.NewFromFloat(60.41)
+	hasIdempotencyKey := mock.MatchedBy(func(ctx context.Context) bool {
+		return transaction.IdempotencyKeyFrom(ctx) != ""
+	})
+
+	s := new(mocktransaction.Service)
+	s.On("SendPayment", hasIdempotencyKey, "bob123", "alice456", mock.Anything, transaction.PaymentDetails{}).Return(errors.New("connection reset by peer")).Once()
+	s.On("SendPayment", hasIdempotencyKey, "bob123", "alice456", mock.Anything, transaction.PaymentDetails{}).Return(nil).Once()
+
+	c, keys := newTestClient(t, s)
+
+	// when
+	err := c.SendPayment(context.Background(), "bob123", "alice456", amount, transaction.PaymentDetails{})
+
+	// then
+	assert.NoError(t, err)
+	require.Len(t, keys(), 2)
+	assert.NotEmpty(t, keys()[0])
+	assert.Equal(t, keys()[0], keys()[1])
+
+	s.AssertExpectations(t)
+}
+
+func Test_Client_SendPayment_ExternalReferenceReused(t *testing.T) {
+	// given
+	details := transaction.PaymentDetails{ExternalReference: null.StringFrom("INV-0001")}
+
+	s := new(mocktransaction.Service)
+	s.On("SendPayment", mock.Anything, "bob123", "alice456", mock.Anything, details).Return(transaction.ErrExternalReferenceReused).Once()
+
+	c, _ := newTestClient(t, s)
+
+	// when
+	err := c.SendPayment(context.Background(), "bob123", "alice456", decimal.NewFromFloat(60.41), details)
+
+	// then
+	assert.True(t, errors.Is(err, transaction.ErrExternalReferenceReused))
+
+	s.AssertExpectations(t)
+}
+
+func Test_Client_SendPayment_RequestMalformedNotRetried(t *testing.T) {
+	// given
+	var requests int32
+	handler := transaction.MakeHandler(new(mocktransaction.Service), log.NewNopLogger())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		// as if the amount had been mangled on the way
+		r.Body = ioutil.NopCloser(strings.NewReader(`{"username": "bob123", "target_username": "alice456", "amount": "60.4l"}`))
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	c, err := client.New(server.URL, client.WithRetries(2, time.Millisecond, 5*time.Millisecond))
+	require.NoError(t, err)
+
+	// when
+	err = c.SendPayment(context.Background(), "bob123", "alice456", decimal.NewFromFloat(60.41), transaction.PaymentDetails{})
+
+	// then
+	assert.True(t, errors.Is(err, transaction.ErrRequestMalformed))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+}
+
+func Test_Client_CreatePaymentRequest_DefaultExpiry(t *testing.T) {
+	// given
+	created := &transaction.PaymentRequest{
+		Id:        uuid.New(),
+		Requester: "alice456",
+		Payer:     "bob123",
+		Amount:    decimal.RequireFromString("25.00"),
+		Memo:      null.StringFrom("dinner"),
+		Status:    transaction.PendingPaymentRequestStatus,
+		ExpiresAt: time.Date(2022, 5, 8, 12, 0, 0, 0, time.UTC),
+	}
+
+	s := new(mocktransaction.Service)
+	s.On("CreatePaymentRequest", mock.Anything, "alice456", "bob123", mock.MatchedBy(func(a decimal.Decimal) bool {
+		return a.Equal(created.Amount)
+	}), null.StringFrom("dinner"), time.Time{}).Return(created, nil).Once()
+
+	c, _ := newTestClient(t, s)
+
+	// when
+	request, err := c.CreatePaymentRequest(context.Background(), "alice456", "bob123", created.Amount, null.StringFrom("dinner"), time.Time{})
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, created.Id, request.Id)
+	assert.Equal(t, created.Requester, request.Requester)
+	assert.Equal(t, created.Payer, request.Payer)
+	assert.True(t, created.Amount.Equal(request.Amount))
+	assert.Equal(t, created.Memo, request.Memo)
+	assert.Equal(t, created.Status, request.Status)
+	assert.True(t, created.ExpiresAt.Equal(request.ExpiresAt))
+
+	s.AssertExpectations(t)
+}
+
+func Test_Client_GetPaymentRequests_FilterSent(t *testing.T) {
+	// given
+	filter := transaction.PaymentRequestFilter{Account: "bob123", Direction: transaction.IncomingEntry, Status: transaction.PendingPaymentRequestStatus}
+	pending := transaction.PaymentRequest{Id: uuid.New(), Requester: "alice456", Payer: "bob123", Status: transaction.PendingPaymentRequestStatus}
+
+	s := new(mocktransaction.Service)
+	s.On("GetPaymentRequests", mock.Anything, filter).Return([]transaction.PaymentRequest{pending}, nil).Once()
+
+	c, _ := newTestClient(t, s)
+
+	// when
+	requests, err := c.GetPaymentRequests(context.Background(), filter)
+
+	// then
+	require.NoError(t, err)
+	require.Len(t, requests, 1)
+	assert.Equal(t, pending.Id, requests[0].Id)
+
+	s.AssertExpectations(t)
+}
+
+func Test_Client_AcceptPaymentRequest_Success(t *testing.T) {
+	// given
+	id := uuid.New()
+	paid := &transaction.PaymentRequest{Id: id, Status: transaction.PaidPaymentRequestStatus, TransactionId: util.NewNullUUID(uuid.New())}
+
+	s := new(mocktransaction.Service)
+	s.On("AcceptPaymentRequest", mock.Anything, id, "bob123").Return(paid, nil).Once()
+
+	c, _ := newTestClient(t, s)
+
+	// when
+	request, err := c.AcceptPaymentRequest(context.Background(), id, "bob123")
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, paid.Status, request.Status)
+	assert.Equal(t, paid.TransactionId, request.TransactionId)
+
+	s.AssertExpectations(t)
+}
+
+func Test_Client_AcceptPaymentRequest_NotRetried(t *testing.T) {
+	// given
+	id := uuid.New()
+
+	s := new(mocktransaction.Service)
+	s.On("AcceptPaymentRequest", mock.Anything, id, "bob123").Return(nil, errors.New("connection reset by peer")).Once()
+
+	c, keys := newTestClient(t, s)
+
+	// when
+	_, err := c.AcceptPaymentRequest(context.Background(), id, "bob123")
+
+	// then
+	var responseErr *transaction.ResponseError
+	require.True(t, errors.As(err, &responseErr))
+	assert.Equal(t, http.StatusInternalServerError, responseErr.StatusCode)
+	assert.Len(t, keys(), 1)
+
+	s.AssertExpectations(t)
+}
+
+func Test_Client_CancelPaymentRequest_PaymentRequestNotFound(t *testing.T) {
+	// given
+	id := uuid.New()
+
+	s := new(mocktransaction.Service)
+	s.On("CancelPaymentRequest", mock.Anything, id, "bob123").Return(nil, transaction.ErrPaymentRequestNotFound).Once()
+
+	c, _ := newTestClient(t, s)
+
+	// when
+	_, err := c.CancelPaymentRequest(context.Background(), id, "bob123")
+
+	// then
+	assert.True(t, errors.Is(err, transaction.ErrPaymentRequestNotFound))
+
+	s.AssertExpectations(t)
+}
+
+func Test_Client_Deposit_CallerIdempotencyKey(t *testing.T) {
+	// given
+	key := uuid.New().String()
+	amount := decimal.NewFromFloat(200.0)
+
+	s := new(mocktransaction.Service)
+	s.On("Deposit", mock.MatchedBy(func(ctx context.Context) bool {
+		return transaction.IdempotencyKeyFrom(ctx) == key
+	}), "alice456", mock.MatchedBy(func(a decimal.Decimal) bool {
+		return a.Equal(amount)
+	})).Return(nil).Once()
+
+	c, keys := newTestClient(t, s)
+
+	// when
+	err := c.Deposit(transaction.WithIdempotencyKey(context.Background(), key), "alice456", amount)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, []string{key}, keys())
+
+	s.AssertExpectations(t)
+}
+
+func Test_Client_FreezeAccount_NotRetried(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+	s.On("FreezeAccount", mock.Anything, "alice456", "suspected fraud").Return(errors.New("connection reset by peer")).Once()
+
+	c, keys := newTestClient(t, s)
+
+	// when
+	err := c.FreezeAccount(context.Background(), "alice456", "suspected fraud")
+
+	// then
+	var responseErr *transaction.ResponseError
+	require.True(t, errors.As(err, &responseErr))
+	assert.Equal(t, http.StatusInternalServerError, responseErr.StatusCode)
+	assert.Equal(t, "connection reset by peer", responseErr.Message)
+	assert.Len(t, keys(), 1)
+
+	s.AssertExpectations(t)
+}
+
+func Test_Client_CreateAccount_AccountAlreadyExists(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+	s.On("CreateAccount", mock.Anything, "alice456").Return(transaction.ErrAccountAlreadyExists).Once()
+
+	c, _ := newTestClient(t, s)
+
+	// when
+	err := c.CreateAccount(context.Background(), "alice456")
+
+	// then
+	assert.True(t, errors.Is(err, transaction.ErrAccountAlreadyExists))
+
+	s.AssertExpectations(t)
+}
+
+func Test_Client_GetAuditLog_FilterAndActorSent(t *testing.T) {
+	// given
+	filter := transaction.AuditFilter{
+		Account: "alice456",
+		Since:   time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC),
+		Until:   time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC),
+		Limit:   10,
+	}
+	records := []transaction.AuditRecord{{Seq: 1, ClaimedActor: "ops@example.com", Account: "alice456", Counterparty: null.StringFrom("bob123")}}
+
+	s := new(mocktransaction.Service)
+	s.On("GetAuditLog", mock.MatchedBy(func(ctx context.Context) bool {
+		return transaction.ClaimedActorFrom(ctx) == "auditor"
+	}), filter).Return(records, nil).Once()
+
+	c, _ := newTestClient(t, s)
+
+	// when
+	fetched, err := c.GetAuditLog(transaction.WithClaimedActor(context.Background(), "auditor"), filter)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, records, fetched)
+
+	s.AssertExpectations(t)
+}
+
+func Test_Client_ExportEntries_Success(t *testing.T) {
+	// given
+	filter := transaction.ExportFilter{
+		Kind:    transaction.DepositTransaction,
+		Account: "alice456",
+		Since:   time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC),
+		Until:   time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC),
+	}
+	entries := []transaction.ExportedEntry{
+		{EntryId: uuid.New(), TransactionName: transaction.DepositTransaction, Account: "alice456", Amount: decimal.NewFromFloat(44.79)},
+		{EntryId: uuid.New(), TransactionName: transaction.DepositTransaction, Account: "alice456", Amount: decimal.NewFromFloat(0.21)},
+	}
+
+	s := new(mocktransaction.Service)
+	s.On("ExportEntries", mock.Anything, filter, mock.Anything).
+		Run(func(args mock.Arguments) {
+			fn := args.Get(2).(func(transaction.ExportedEntry) error)
+			for _, entry := range entries {
+				assert.NoError(t, fn(entry))
+			}
+		}).
+		Return(nil).
+		Once()
+
+	c, _ := newTestClient(t, s)
+
+	// when
+	var exported []transaction.ExportedEntry
+	err := c.ExportEntries(context.Background(), filter, func(entry transaction.ExportedEntry) error {
+		exported = append(exported, entry)
+		return nil
+	})
+
+	// then
+	require.NoError(t, err)
+	require.Len(t, exported, 2)
+	assert.Equal(t, entries[0].EntryId, exported[0].EntryId)
+	assert.True(t, entries[1].Amount.Equal(exported[1].Amount))
+
+	s.AssertExpectations(t)
+}
+
+func Test_Client_ExportEntries_AccountNotFound(t *testing.T) {
+	// given
+	filter := transaction.ExportFilter{Kind: transaction.StatementExport, Account: "nobody"}
+
+	s := new(mocktransaction.Service)
+	s.On("ExportEntries", mock.Anything, filter, mock.Anything).Return(transaction.ErrAccountNotFound).Once()
+
+	c, _ := newTestClient(t, s)
+
+	// when
+	err := c.ExportEntries(context.Background(), filter, func(transaction.ExportedEntry) error {
+		t.Fatal("no entry expected")
+		return nil
+	})
+
+	// then
+	assert.True(t, errors.Is(err, transaction.ErrAccountNotFound))
+
+	s.AssertExpectations(t)
+}
+
+func Test_Client_StreamAccountEvents_Resumed(t *testing.T) {
+	// given
+	events := []transaction.AccountEvent{
+		{Seq: 42, EntryId: uuid.New(), TransactionName: transaction.DepositTransaction, Amount: decimal.NewFromFloat(44.79), Balance: decimal.NewFromFloat(44.79)},
+		{Seq: 43, EntryId: uuid.New(), TransactionName: transaction.DepositTransaction, Amount: decimal.NewFromFloat(0.21), Balance: decimal.NewFromInt(45)},
+	}
+
+	s := new(mocktransaction.Service)
+	s.On("StreamAccountEvents", mock.Anything, "alice456", null.IntFrom(41), mock.Anything).
+		Run(func(args mock.Arguments) {
+			fn := args.Get(3).(func(transaction.AccountEvent) error)
+			for _, event := range events {
+				assert.NoError(t, fn(event))
+			}
+		}).
+		Return(nil).
+		Once()
+
+	c, _ := newTestClient(t, s)
+
+	// when
+	var streamed []transaction.AccountEvent
+	err := c.StreamAccountEvents(context.Background(), "alice456", null.IntFrom(41), func(event transaction.AccountEvent) error {
+		streamed = append(streamed, event)
+		return nil
+	})
+
+	// then
+	require.NoError(t, err)
+	require.Len(t, streamed, 2)
+	assert.Equal(t, int64(42), streamed[0].Seq)
+	assert.Equal(t, events[1].EntryId, streamed[1].EntryId)
+	assert.True(t, events[1].Balance.Equal(streamed[1].Balance))
+
+	s.AssertExpectations(t)
+}
+
+func Test_Client_StreamAccountEvents_AccountNotFound(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+	s.On("StreamAccountEvents", mock.Anything, "nobody", null.Int{}, mock.Anything).Return(transaction.ErrAccountNotFound).Once()
+
+	c, _ := newTestClient(t, s)
+
+	// when
+	err := c.StreamAccountEvents(context.Background(), "nobody", null.Int{}, func(transaction.AccountEvent) error {
+		t.Fatal("no event expected")
+		return nil
+	})
+
+	// then
+	assert.True(t, errors.Is(err, transaction.ErrAccountNotFound))
+
+	s.AssertExpectations(t)
+}
+
+func Test_Client_ImportAccounts_Success(t *testing.T) {
+	// given
+	rows := []transaction.ImportRow{{Username: "carol789", OpeningBalance: decimal.RequireFromString("150.5")}}
+	report := &transaction.ImportReport{DryRun: true, Rows: 1, Accounts: 1, OpeningBalance: decimal.RequireFromString("150.5")}
+
+	s := new(mocktransaction.Service)
+	s.On("ImportAccounts", mock.Anything, rows, true).Return(report, nil).Once()
+
+	c, _ := newTestClient(t, s)
+
+	// when
+	imported, err := c.ImportAccounts(context.Background(), rows, true)
+
+	// then
+	require.NoError(t, err)
+	assert.True(t, imported.DryRun)
+	assert.Equal(t, 1, imported.Accounts)
+
+	s.AssertExpectations(t)
+}
+
+func Test_Client_ImportAccounts_RowsInvalid(t *testing.T) {
+	// given
+	rows := []transaction.ImportRow{{Username: "bob123", OpeningBalance: decimal.Zero}}
+	report := &transaction.ImportReport{
+		Rows:           1,
+		OpeningBalance: decimal.Zero,
+		Errors:         []transaction.ImportRowError{{Row: 1, Username: "bob123", Error: "account already exists"}},
+	}
+
+	s := new(mocktransaction.Service)
+	s.On("ImportAccounts", mock.Anything, mock.Anything, false).Return(report, transaction.ErrImportRowsInvalid).Once()
+
+	c, _ := newTestClient(t, s)
+
+	// when
+	imported, err := c.ImportAccounts(context.Background(), rows, false)
+
+	// then
+	assert.Equal(t, transaction.ErrImportRowsInvalid, err)
+	require.NotNil(t, imported)
+	assert.Equal(t, report.Errors, imported.Errors)
+
+	s.AssertExpectations(t)
+}
+
+func Test_Client_VerifyAuditLog_Break(t *testing.T) {
+	// given
+	chainBreak := &transaction.AuditChainBreak{Seq: 42, Reason: "hash does not match the contents of the record"}
+
+	s := new(mocktransaction.Service)
+	s.On("VerifyAuditLog", mock.Anything).Return(chainBreak, nil).Once()
+
+	c, _ := newTestClient(t, s)
+
+	// when
+	fetched, err := c.VerifyAuditLog(context.Background())
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, chainBreak, fetched)
+
+	s.AssertExpectations(t)
+}
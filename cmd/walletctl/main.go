@@ -0,0 +1,50 @@
+// Command walletctl operates the wallet directly against its database, through transaction.Service.
+// It reads the same DB_* environment variables as the service.
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/nogurenn/cph-wallet/dbutil"
+	"github.com/nogurenn/cph-wallet/scripts/migrations"
+	"github.com/nogurenn/cph-wallet/transaction"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	code := run(ctx, os.Args[1:], newService, newMigrator, os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+func newService() (transaction.Service, func() error, error) {
+	cfg, err := dbutil.NewConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := dbutil.NewDb(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return transaction.NewService(transaction.NewPostgresDb(db)), db.Close, nil
+}
+
+func newMigrator() (migrator, func() error, error) {
+	cfg, err := dbutil.NewConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := dbutil.NewDb(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	m, err := dbutil.NewMigrator(db, migrations.WalletSchema, migrations.WalletFiles())
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return m, db.Close, nil
+}
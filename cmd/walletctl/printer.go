@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// printer writes command results either as aligned tables for people, or as JSON for scripts.
+type printer struct {
+	format string
+	w      io.Writer
+}
+
+// print writes v as indented JSON, or header and rows as a table.
+func (p printer) print(v interface{}, header []string, rows [][]string) error {
+	if p.format == jsonFormat {
+		encoder := json.NewEncoder(p.w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	}
+
+	tw := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// message reports the outcome of a command that has no other result.
+func (p printer) message(format string, args ...interface{}) error {
+	message := fmt.Sprintf(format, args...)
+	if p.format == jsonFormat {
+		return json.NewEncoder(p.w).Encode(map[string]string{"message": message})
+	}
+	_, err := fmt.Fprintln(p.w, message)
+	return err
+}
@@ -0,0 +1,583 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/nogurenn/cph-wallet/dbutil"
+	"github.com/nogurenn/cph-wallet/transaction"
+)
+
+const usage = `Usage: walletctl [-o table|json] [-idempotency-key key] [-actor name] [-as-of time] [-dry-run] <command> [arguments]
+
+Commands:
+  create-account <username>          create an account
+  deposit <username> <amount>        deposit funds to an account
+  pay <from> <to> <amount>           send a payment between accounts
+  balances [username]                print balances of all accounts, or of one, as of -as-of (RFC 3339) if set
+  statement <username>               print every entry of an account with running balances
+  freeze <username> <reason>         block an account from sending or receiving funds
+  unfreeze <username> <reason>       reactivate a frozen account
+  close <username> <reason>          permanently close an account with zero balance
+  reconcile                          check the ledger, exiting with 6 if discrepancies are found
+  export                             write every account with its statement and status changes as JSON Lines
+  import <file>                      create accounts with opening balances from a .csv file with username and
+                                     opening_balance columns, or from JSON Lines; only validate them with -dry-run
+  audit-log [username]               print the audit log, or the records involving one account
+  audit-verify                       check the hash chain of the audit log, exiting with 7 if it is broken
+  migrate up                         apply pending database migrations
+  migrate status                     print every database migration with its state
+
+Exit codes:
+  0 success, 1 unexpected failure, 2 usage error, 3 account not found,
+  4 invalid argument, 5 rejected by account state or balance, 6 ledger discrepancies found,
+  7 audit log tampered with
+`
+
+const (
+	exitOk = iota
+	exitFailure
+	exitUsage
+	exitNotFound
+	exitInvalid
+	exitRejected
+	exitDiscrepancies
+	exitAuditLogBroken
+)
+
+const (
+	tableFormat = "table"
+	jsonFormat  = "json"
+)
+
+// errUsage is returned for malformed command lines, and is reported together with usage.
+var errUsage = errors.New("invalid usage")
+
+// errDiscrepancies is returned by reconcile after printing the discrepancies it found.
+var errDiscrepancies = errors.New("ledger discrepancies found")
+
+// errAuditLogBroken is returned by audit-verify after printing the first broken link of the audit log.
+var errAuditLogBroken = errors.New("audit log hash chain is broken")
+
+type command struct {
+	args int // number of required arguments, or -1 for commands that take an optional username
+	run  func(ctx context.Context, s transaction.Service, p printer, args []string) error
+}
+
+// migrator applies the database migrations embedded in the binary.
+type migrator interface {
+	Up(ctx context.Context) ([]dbutil.Migration, error)
+	Status(ctx context.Context) ([]dbutil.MigrationStatus, error)
+}
+
+type migrateCommand func(ctx context.Context, m migrator, p printer) error
+
+var migrateCommands = map[string]migrateCommand{
+	"up":     migrateUp,
+	"status": migrateStatus,
+}
+
+var commands = map[string]command{
+	"create-account": {1, createAccount},
+	"deposit":        {2, deposit},
+	"pay":            {3, pay},
+	"balances":       {-1, balancesAsOf(time.Time{})},
+	"statement":      {1, statement},
+	"freeze":         {2, changeAccountStatus((transaction.Service).FreezeAccount, "frozen")},
+	"unfreeze":       {2, changeAccountStatus((transaction.Service).UnfreezeAccount, "unfrozen")},
+	"close":          {2, changeAccountStatus((transaction.Service).CloseAccount, "closed")},
+	"reconcile":      {0, reconcile},
+	"export":         {0, export},
+	"import":         {1, importAccounts(false)},
+	"audit-log":      {-1, auditLog},
+	"audit-verify":   {0, auditVerify},
+}
+
+// run executes the command line in args and returns the exit code. newService and newMigrator are called only
+// once the command line is known to be valid, so that usage errors do not need a database.
+func run(
+	ctx context.Context,
+	args []string,
+	newService func() (transaction.Service, func() error, error),
+	newMigrator func() (migrator, func() error, error),
+	stdout io.Writer,
+	stderr io.Writer,
+) int {
+	flags := flag.NewFlagSet("walletctl", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	format := flags.String("o", tableFormat, "output format, table or json")
+	idempotencyKey := flags.String("idempotency-key", "", "key under which deposits and payments are recorded once")
+	actor := flags.String("actor", "", "principal recorded as the claimed actor of changes made by the command in the audit log")
+	asOf := flags.String("as-of", "", "RFC 3339 time to print balances at instead of now")
+	dryRun := flags.Bool("dry-run", false, "validate an import without importing anything")
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fmt.Fprint(stdout, usage)
+			return exitOk
+		}
+		return fail(stderr, fmt.Errorf("%w: %s", errUsage, err))
+	}
+	if *format != tableFormat && *format != jsonFormat {
+		return fail(stderr, fmt.Errorf("%w: unknown output format %q", errUsage, *format))
+	}
+	if flags.NArg() == 0 {
+		return fail(stderr, fmt.Errorf("%w: missing command", errUsage))
+	}
+
+	name, cmdArgs := flags.Arg(0), flags.Args()[1:]
+	p := printer{format: *format, w: stdout}
+
+	if name == "migrate" {
+		if len(cmdArgs) != 1 || migrateCommands[cmdArgs[0]] == nil {
+			return fail(stderr, fmt.Errorf("%w: migrate takes up or status", errUsage))
+		}
+
+		m, closeMigrator, err := newMigrator()
+		if err != nil {
+			return fail(stderr, err)
+		}
+		defer closeMigrator()
+
+		if err := migrateCommands[cmdArgs[0]](ctx, m, p); err != nil {
+			return fail(stderr, err)
+		}
+		return exitOk
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		return fail(stderr, fmt.Errorf("%w: unknown command %q", errUsage, name))
+	}
+	if (cmd.args >= 0 && len(cmdArgs) != cmd.args) || (cmd.args < 0 && len(cmdArgs) > 1) {
+		return fail(stderr, fmt.Errorf("%w: wrong number of arguments for %s", errUsage, name))
+	}
+	if *asOf != "" {
+		if name != "balances" {
+			return fail(stderr, fmt.Errorf("%w: -as-of applies to balances only", errUsage))
+		}
+		t, err := time.Parse(time.RFC3339, *asOf)
+		if err != nil {
+			return fail(stderr, fmt.Errorf("%w: -as-of is not an RFC 3339 time: %q", errUsage, *asOf))
+		}
+		cmd.run = balancesAsOf(t)
+	}
+	if *dryRun {
+		if name != "import" {
+			return fail(stderr, fmt.Errorf("%w: -dry-run applies to import only", errUsage))
+		}
+		cmd.run = importAccounts(true)
+	}
+
+	s, closeService, err := newService()
+	if err != nil {
+		return fail(stderr, err)
+	}
+	defer closeService()
+
+	if *idempotencyKey != "" {
+		ctx = transaction.WithIdempotencyKey(ctx, *idempotencyKey)
+	}
+	if *actor != "" {
+		ctx = transaction.WithClaimedActor(ctx, *actor)
+	}
+
+	if err := cmd.run(ctx, s, p, cmdArgs); err != nil {
+		return fail(stderr, err)
+	}
+	return exitOk
+}
+
+func fail(stderr io.Writer, err error) int {
+	fmt.Fprintf(stderr, "walletctl: %s\n", err)
+	if errors.Is(err, errUsage) {
+		fmt.Fprint(stderr, "\n"+usage)
+	}
+	return exitCodeFrom(err)
+}
+
+// exitCodeFrom maps domain errors to exit codes, mirroring how the HTTP transport maps them to status codes.
+func exitCodeFrom(err error) int {
+	switch {
+	case errors.Is(err, errUsage):
+		return exitUsage
+	case errors.Is(err, errDiscrepancies):
+		return exitDiscrepancies
+	case errors.Is(err, errAuditLogBroken):
+		return exitAuditLogBroken
+	case errors.Is(err, transaction.ErrAccountNotFound):
+		return exitNotFound
+	case errors.Is(err, transaction.ErrCreditAmountInvalid),
+		errors.Is(err, transaction.ErrPaymentSenderReceiverIdentical),
+		errors.Is(err, transaction.ErrAccountStatusReasonMissing),
+		errors.Is(err, transaction.ErrImportMalformed),
+		errors.Is(err, transaction.ErrImportRowsInvalid):
+		return exitInvalid
+	case errors.Is(err, transaction.ErrBalanceInsufficient),
+		errors.Is(err, transaction.ErrAccountFrozen),
+		errors.Is(err, transaction.ErrAccountClosed),
+		errors.Is(err, transaction.ErrAccountStatusTransitionInvalid),
+		errors.Is(err, transaction.ErrAccountBalanceNotZero),
+		errors.Is(err, transaction.ErrAccountAlreadyExists),
+		errors.Is(err, transaction.ErrIdempotencyKeyReused):
+		return exitRejected
+	default:
+		return exitFailure
+	}
+}
+
+func createAccount(ctx context.Context, s transaction.Service, p printer, args []string) error {
+	if err := s.CreateAccount(ctx, args[0]); err != nil {
+		return err
+	}
+	return p.message("created account %s", args[0])
+}
+
+func deposit(ctx context.Context, s transaction.Service, p printer, args []string) error {
+	amount, err := parseAmount(args[1])
+	if err != nil {
+		return err
+	}
+	if err := s.Deposit(ctx, args[0], amount); err != nil {
+		return err
+	}
+	return p.message("deposited %s to %s", amount, args[0])
+}
+
+func pay(ctx context.Context, s transaction.Service, p printer, args []string) error {
+	amount, err := parseAmount(args[2])
+	if err != nil {
+		return err
+	}
+	if err := s.SendPayment(ctx, args[0], args[1], amount, transaction.PaymentDetails{}); err != nil {
+		return err
+	}
+	return p.message("sent %s from %s to %s", amount, args[0], args[1])
+}
+
+// balancesAsOf prints balances at asOf, or current balances if asOf is zero.
+func balancesAsOf(asOf time.Time) func(ctx context.Context, s transaction.Service, p printer, args []string) error {
+	return func(ctx context.Context, s transaction.Service, p printer, args []string) error {
+		accounts, err := getAccountsAsOf(ctx, s, args, asOf)
+		if err != nil {
+			return err
+		}
+		return printBalances(p, accounts)
+	}
+}
+
+func getAccountsAsOf(ctx context.Context, s transaction.Service, args []string, asOf time.Time) ([]transaction.Account, error) {
+	if len(args) == 0 {
+		if asOf.IsZero() {
+			return s.GetAccounts(ctx)
+		}
+		return s.GetAccountsAsOf(ctx, asOf)
+	}
+
+	var account *transaction.Account
+	var err error
+	if asOf.IsZero() {
+		account, err = s.GetAccount(ctx, args[0])
+	} else {
+		account, err = s.GetAccountAsOf(ctx, args[0], asOf)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []transaction.Account{*account}, nil
+}
+
+func printBalances(p printer, accounts []transaction.Account) error {
+
+	details := []transaction.AccountDetails{}
+	rows := [][]string{}
+	for _, account := range accounts {
+		details = append(details, mapAccountToAccountDetails(account))
+		rows = append(rows, []string{
+			account.Username,
+			account.Balance.String(),
+			account.AvailableBalance().String(),
+			account.Currency,
+			account.Status,
+		})
+	}
+	return p.print(details, []string{"ID", "BALANCE", "AVAILABLE", "CURRENCY", "STATUS"}, rows)
+}
+
+func statement(ctx context.Context, s transaction.Service, p printer, args []string) error {
+	lines, err := s.GetAccountStatement(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if lines == nil {
+		lines = []transaction.StatementLine{}
+	}
+
+	rows := [][]string{}
+	for _, line := range lines {
+		rows = append(rows, []string{
+			line.CreatedAt.UTC().Format("2006-01-02 15:04:05"),
+			line.TransactionName,
+			line.Counterparty.ValueOrZero(),
+			line.Amount.String(),
+			line.Balance.String(),
+		})
+	}
+	return p.print(lines, []string{"DATE", "TYPE", "COUNTERPARTY", "AMOUNT", "BALANCE"}, rows)
+}
+
+func changeAccountStatus(
+	change func(transaction.Service, context.Context, string, string) error,
+	past string,
+) func(context.Context, transaction.Service, printer, []string) error {
+	return func(ctx context.Context, s transaction.Service, p printer, args []string) error {
+		if err := change(s, ctx, args[0], args[1]); err != nil {
+			return err
+		}
+		return p.message("%s account %s", past, args[0])
+	}
+}
+
+func reconcile(ctx context.Context, s transaction.Service, p printer, _ []string) error {
+	discrepancies, err := s.Reconcile(ctx)
+	if err != nil {
+		return err
+	}
+	if discrepancies == nil {
+		discrepancies = []transaction.Discrepancy{}
+	}
+
+	if p.format == tableFormat && len(discrepancies) == 0 {
+		err = p.message("ledger is consistent")
+	} else {
+		rows := [][]string{}
+		for _, d := range discrepancies {
+			rows = append(rows, []string{d.Check, d.Reference, d.Detail})
+		}
+		err = p.print(discrepancies, []string{"CHECK", "REFERENCE", "DETAIL"}, rows)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(discrepancies) > 0 {
+		return fmt.Errorf("%w: %d", errDiscrepancies, len(discrepancies))
+	}
+	return nil
+}
+
+func auditLog(ctx context.Context, s transaction.Service, p printer, args []string) error {
+	var filter transaction.AuditFilter
+	if len(args) == 1 {
+		filter.Account = args[0]
+	}
+
+	records, err := s.GetAuditLog(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if records == nil {
+		records = []transaction.AuditRecord{}
+	}
+
+	rows := [][]string{}
+	for _, record := range records {
+		rows = append(rows, []string{
+			strconv.FormatInt(record.Seq, 10),
+			record.CreatedAt.UTC().Format("2006-01-02 15:04:05"),
+			record.ClaimedActor,
+			record.Action,
+			record.Account,
+			record.Counterparty.ValueOrZero(),
+			record.Outcome,
+		})
+	}
+	return p.print(records, []string{"SEQ", "DATE", "CLAIMED ACTOR", "ACTION", "ACCOUNT", "COUNTERPARTY", "OUTCOME"}, rows)
+}
+
+type auditVerification struct {
+	Intact bool                         `json:"intact"`
+	Break  *transaction.AuditChainBreak `json:"break"`
+}
+
+func auditVerify(ctx context.Context, s transaction.Service, p printer, _ []string) error {
+	chainBreak, err := s.VerifyAuditLog(ctx)
+	if err != nil {
+		return err
+	}
+
+	if p.format == tableFormat && chainBreak == nil {
+		err = p.message("audit log is intact")
+	} else {
+		rows := [][]string{}
+		if chainBreak != nil {
+			rows = append(rows, []string{strconv.FormatInt(chainBreak.Seq, 10), chainBreak.Reason})
+		}
+		err = p.print(auditVerification{Intact: chainBreak == nil, Break: chainBreak}, []string{"SEQ", "REASON"}, rows)
+	}
+	if err != nil {
+		return err
+	}
+
+	if chainBreak != nil {
+		return fmt.Errorf("%w at seq %d", errAuditLogBroken, chainBreak.Seq)
+	}
+	return nil
+}
+
+type exportedAccount struct {
+	Account       transaction.AccountDetails        `json:"account"`
+	Statement     []transaction.StatementLine       `json:"statement"`
+	StatusChanges []transaction.AccountStatusChange `json:"status_changes"`
+}
+
+// export ignores the output format, since JSON Lines is the only one that holds statements of every account.
+func export(ctx context.Context, s transaction.Service, p printer, _ []string) error {
+	accounts, err := s.GetAccounts(ctx)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(p.w)
+	for _, account := range accounts {
+		lines, err := s.GetAccountStatement(ctx, account.Username)
+		if err != nil {
+			return fmt.Errorf("statement of %s: %w", account.Username, err)
+		}
+		changes, err := s.GetAccountStatusChanges(ctx, account.Username)
+		if err != nil {
+			return fmt.Errorf("status changes of %s: %w", account.Username, err)
+		}
+		if lines == nil {
+			lines = []transaction.StatementLine{}
+		}
+		if changes == nil {
+			changes = []transaction.AccountStatusChange{}
+		}
+
+		err = encoder.Encode(exportedAccount{
+			Account:       mapAccountToAccountDetails(account),
+			Statement:     lines,
+			StatusChanges: changes,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importAccounts imports the file named by the argument, whose format is told by its extension. Invalid rows are
+// printed before failing.
+func importAccounts(dryRun bool) func(ctx context.Context, s transaction.Service, p printer, args []string) error {
+	return func(ctx context.Context, s transaction.Service, p printer, args []string) error {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		format := transaction.NDJSONFormat
+		if strings.EqualFold(filepath.Ext(args[0]), ".csv") {
+			format = transaction.CSVFormat
+		}
+		rows, err := transaction.ReadImportRows(f, format)
+		if err != nil {
+			return err
+		}
+
+		report, err := s.ImportAccounts(ctx, rows, dryRun)
+		if report == nil {
+			return err
+		}
+		if report.Errors == nil {
+			report.Errors = []transaction.ImportRowError{}
+		}
+
+		var printErr error
+		switch {
+		case p.format == jsonFormat || len(report.Errors) > 0:
+			tableRows := [][]string{}
+			for _, rowErr := range report.Errors {
+				tableRows = append(tableRows, []string{strconv.Itoa(rowErr.Row), rowErr.Username, rowErr.Error})
+			}
+			printErr = p.print(report, []string{"ROW", "USERNAME", "ERROR"}, tableRows)
+		case dryRun:
+			printErr = p.message("%d accounts can be imported with opening balances of %s in total", report.Accounts, report.OpeningBalance)
+		default:
+			printErr = p.message("imported %d accounts with opening balances of %s in total", report.Accounts, report.OpeningBalance)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %d of %d rows", err, len(report.Errors), report.Rows)
+		}
+		return printErr
+	}
+}
+
+func migrateUp(ctx context.Context, m migrator, p printer) error {
+	applied, err := m.Up(ctx)
+	for _, migration := range applied {
+		if err := p.message("applied %s", migration.Script); err != nil {
+			return err
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		return p.message("schema is up to date")
+	}
+	return nil
+}
+
+func migrateStatus(ctx context.Context, m migrator, p printer) error {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if statuses == nil {
+		statuses = []dbutil.MigrationStatus{}
+	}
+
+	rows := [][]string{}
+	for _, status := range statuses {
+		installedOn := ""
+		if status.InstalledOn.Valid {
+			installedOn = status.InstalledOn.Time.UTC().Format("2006-01-02 15:04:05")
+		}
+		rows = append(rows, []string{status.Version, status.Description, status.State, installedOn})
+	}
+	return p.print(statuses, []string{"VERSION", "DESCRIPTION", "STATE", "INSTALLED"}, rows)
+}
+
+// --- helpers
+
+func parseAmount(s string) (decimal.Decimal, error) {
+	amount, err := decimal.NewFromString(strings.TrimSpace(s))
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("%w: amount %q is not a valid decimal", transaction.ErrCreditAmountInvalid, s)
+	}
+	return amount, nil
+}
+
+func mapAccountToAccountDetails(account transaction.Account) transaction.AccountDetails {
+	return transaction.AccountDetails{
+		Username:         account.Username,
+		Balance:          account.Balance,
+		AvailableBalance: account.AvailableBalance(),
+		Currency:         account.Currency,
+		Status:           account.Status,
+		CreatedAt:        account.CreatedAt,
+	}
+}
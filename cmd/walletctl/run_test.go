@@ -0,0 +1,487 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/guregu/null.v4"
+
+	"github.com/nogurenn/cph-wallet/dbutil"
+	mocktransaction "github.com/nogurenn/cph-wallet/mocks/autogen/transaction"
+	"github.com/nogurenn/cph-wallet/transaction"
+)
+
+// runWith runs walletctl against s and returns the exit code, stdout and stderr.
+func runWith(s transaction.Service, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	newService := func() (transaction.Service, func() error, error) {
+		return s, func() error { return nil }, nil
+	}
+	newMigrator := func() (migrator, func() error, error) {
+		return nil, nil, errors.New("migrator must not be created for service commands")
+	}
+	code := run(context.Background(), args, newService, newMigrator, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+// runMigrateWith runs walletctl against m and returns the exit code, stdout and stderr.
+func runMigrateWith(m migrator, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	newService := func() (transaction.Service, func() error, error) {
+		return nil, nil, errors.New("service must not be created for migrate commands")
+	}
+	newMigrator := func() (migrator, func() error, error) {
+		return m, func() error { return nil }, nil
+	}
+	code := run(context.Background(), args, newService, newMigrator, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func Test_Run_Balances_Table(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+	s.On("GetAccounts", mock.Anything).Return([]transaction.Account{
+		{Username: "alice456", Balance: decimal.NewFromFloat(270.62), Currency: "USD", Status: transaction.ActiveAccountStatus},
+		{Username: "bob123", Balance: decimal.NewFromFloat(44.47), Currency: "USD", Status: transaction.FrozenAccountStatus},
+	}, nil)
+
+	// when
+	code, stdout, _ := runWith(s, "balances")
+
+	// then
+	assert.Equal(t, exitOk, code)
+	assert.Equal(t, ""+
+		"ID        BALANCE  AVAILABLE  CURRENCY  STATUS\n"+
+		"alice456  270.62   270.62     USD       active\n"+
+		"bob123    44.47    0          USD       frozen\n", stdout)
+
+	s.AssertExpectations(t)
+}
+
+func Test_Run_Balances_JSON(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+	s.On("GetAccount", mock.Anything, "bob123").Return(&transaction.Account{
+		Username: "bob123", Balance: decimal.NewFromFloat(44.47), Currency: "USD", Status: transaction.FrozenAccountStatus,
+	}, nil)
+
+	// when
+	code, stdout, _ := runWith(s, "-o", "json", "balances", "bob123")
+
+	// then
+	assert.Equal(t, exitOk, code)
+
+	var details []transaction.AccountDetails
+	require.NoError(t, json.Unmarshal([]byte(stdout), &details))
+	require.Len(t, details, 1)
+	assert.Equal(t, "bob123", details[0].Username)
+	assert.True(t, details[0].AvailableBalance.IsZero())
+
+	s.AssertExpectations(t)
+}
+
+func Test_Run_Balances_AsOf(t *testing.T) {
+	// given
+	asOf := time.Date(2022, 4, 30, 23, 59, 59, 0, time.UTC)
+
+	s := new(mocktransaction.Service)
+	s.On("GetAccountsAsOf", mock.Anything, asOf).Return([]transaction.Account{
+		{Username: "alice456", Balance: decimal.NewFromFloat(44.79), Currency: "USD", Status: transaction.ActiveAccountStatus},
+	}, nil)
+
+	// when
+	code, stdout, _ := runWith(s, "-as-of", "2022-04-30T23:59:59Z", "balances")
+
+	// then
+	assert.Equal(t, exitOk, code)
+	assert.Equal(t, ""+
+		"ID        BALANCE  AVAILABLE  CURRENCY  STATUS\n"+
+		"alice456  44.79    44.79      USD       active\n", stdout)
+
+	s.AssertExpectations(t)
+}
+
+func Test_Run_Statement_Table(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+	s.On("GetAccountStatement", mock.Anything, "bob123").Return([]transaction.StatementLine{
+		{TransactionName: transaction.DepositTransaction, Amount: decimal.NewFromFloat(200), Balance: decimal.NewFromFloat(200)},
+		{
+			TransactionName: transaction.PaymentTransaction,
+			Counterparty:    null.StringFrom("alice456"),
+			Amount:          decimal.NewFromFloat(-60.41),
+			Balance:         decimal.NewFromFloat(139.59),
+		},
+	}, nil)
+
+	// when
+	code, stdout, _ := runWith(s, "statement", "bob123")
+
+	// then
+	assert.Equal(t, exitOk, code)
+	assert.Contains(t, stdout, "DATE                 TYPE     COUNTERPARTY  AMOUNT  BALANCE\n")
+	assert.Contains(t, stdout, "payment  alice456      -60.41  139.59\n")
+
+	s.AssertExpectations(t)
+}
+
+func Test_Run_Pay_WithIdempotencyKey(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+	s.On("SendPayment", mock.MatchedBy(func(ctx context.Context) bool {
+		return transaction.IdempotencyKeyFrom(ctx) == "invoice-42"
+	}), "bob123", "alice456", mock.MatchedBy(func(amount decimal.Decimal) bool {
+		return amount.Equal(decimal.NewFromFloat(60.41))
+	}), transaction.PaymentDetails{}).Return(nil)
+
+	// when
+	code, stdout, _ := runWith(s, "-idempotency-key", "invoice-42", "pay", "bob123", "alice456", "60.41")
+
+	// then
+	assert.Equal(t, exitOk, code)
+	assert.Equal(t, "sent 60.41 from bob123 to alice456\n", stdout)
+
+	s.AssertExpectations(t)
+}
+
+func Test_Run_ExitCodes(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"not found", transaction.ErrAccountNotFound, exitNotFound},
+		{"invalid", transaction.ErrPaymentSenderReceiverIdentical, exitInvalid},
+		{"rejected", transaction.ErrBalanceInsufficient, exitRejected},
+		{"frozen", transaction.ErrAccountFrozen, exitRejected},
+		{"unexpected", assert.AnError, exitFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// given
+			s := new(mocktransaction.Service)
+			s.On("SendPayment", mock.Anything, "bob123", "alice456", mock.Anything, transaction.PaymentDetails{}).Return(tt.err)
+
+			// when
+			code, stdout, stderr := runWith(s, "pay", "bob123", "alice456", "60.41")
+
+			// then
+			assert.Equal(t, tt.code, code)
+			assert.Empty(t, stdout)
+			assert.Equal(t, "walletctl: "+tt.err.Error()+"\n", stderr)
+		})
+	}
+}
+
+func Test_Run_Deposit_AmountInvalid(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+
+	// when
+	code, _, stderr := runWith(s, "deposit", "alice456", "lots")
+
+	// then
+	assert.Equal(t, exitInvalid, code)
+	assert.Contains(t, stderr, `amount "lots" is not a valid decimal`)
+
+	s.AssertExpectations(t)
+}
+
+func Test_Run_UsageErrors(t *testing.T) {
+	tests := [][]string{
+		{},
+		{"launch"},
+		{"pay", "bob123"},
+		{"-o", "yaml", "balances"},
+		{"balances", "alice456", "bob123"},
+		{"-as-of", "2022-04-30", "balances"},
+		{"-as-of", "2022-04-30T23:59:59Z", "statement", "alice456"},
+		{"migrate"},
+		{"migrate", "down"},
+		{"-dry-run", "balances"},
+	}
+	for _, args := range tests {
+		// given
+		newService := func() (transaction.Service, func() error, error) {
+			t.Fatal("service must not be created for invalid command lines")
+			return nil, nil, nil
+		}
+		newMigrator := func() (migrator, func() error, error) {
+			t.Fatal("migrator must not be created for invalid command lines")
+			return nil, nil, nil
+		}
+		var stdout, stderr bytes.Buffer
+
+		// when
+		code := run(context.Background(), args, newService, newMigrator, &stdout, &stderr)
+
+		// then
+		assert.Equal(t, exitUsage, code, "%v", args)
+		assert.Contains(t, stderr.String(), "Usage: walletctl")
+	}
+}
+
+func Test_Run_Freeze(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+	s.On("FreezeAccount", mock.Anything, "alice456", "suspected fraud").Return(nil)
+
+	// when
+	code, stdout, _ := runWith(s, "-o", "json", "freeze", "alice456", "suspected fraud")
+
+	// then
+	assert.Equal(t, exitOk, code)
+	assert.JSONEq(t, `{"message":"frozen account alice456"}`, stdout)
+
+	s.AssertExpectations(t)
+}
+
+func Test_Run_Reconcile(t *testing.T) {
+	// given
+	paymentId := uuid.New().String()
+	s := new(mocktransaction.Service)
+	s.On("Reconcile", mock.Anything).Return([]transaction.Discrepancy{
+		{Check: "payment_unbalanced", Reference: paymentId, Detail: "1 entries summing to -44.79"},
+	}, nil).Once()
+	s.On("Reconcile", mock.Anything).Return(nil, nil).Once()
+
+	// when
+	failingCode, failingStdout, _ := runWith(s, "reconcile")
+	passingCode, passingStdout, _ := runWith(s, "reconcile")
+
+	// then
+	assert.Equal(t, exitDiscrepancies, failingCode)
+	assert.Contains(t, failingStdout, "payment_unbalanced  "+paymentId+"  1 entries summing to -44.79\n")
+	assert.Equal(t, exitOk, passingCode)
+	assert.Equal(t, "ledger is consistent\n", passingStdout)
+
+	s.AssertExpectations(t)
+}
+
+func Test_Run_AuditVerify(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+	s.On("VerifyAuditLog", mock.Anything).Return(&transaction.AuditChainBreak{Seq: 42, Reason: "hash does not match the contents of the record"}, nil).Once()
+	s.On("VerifyAuditLog", mock.Anything).Return(nil, nil).Once()
+
+	// when
+	failingCode, failingStdout, failingStderr := runWith(s, "-o", "json", "audit-verify")
+	passingCode, passingStdout, _ := runWith(s, "audit-verify")
+
+	// then
+	assert.Equal(t, exitAuditLogBroken, failingCode)
+	assert.JSONEq(t, `{"intact": false, "break": {"seq": 42, "reason": "hash does not match the contents of the record"}}`, failingStdout)
+	assert.Contains(t, failingStderr, "at seq 42")
+	assert.Equal(t, exitOk, passingCode)
+	assert.Equal(t, "audit log is intact\n", passingStdout)
+
+	s.AssertExpectations(t)
+}
+
+func Test_Run_AuditLog_ActorAndAccount(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+	s.On("FreezeAccount", mock.MatchedBy(func(ctx context.Context) bool {
+		return transaction.ClaimedActorFrom(ctx) == "ops@example.com"
+	}), "alice456", "suspected fraud").Return(nil)
+	s.On("GetAuditLog", mock.Anything, transaction.AuditFilter{Account: "alice456"}).Return([]transaction.AuditRecord{
+		{
+			Seq:          7,
+			ClaimedActor: "ops@example.com",
+			Action:       transaction.FreezeAccountAuditAction,
+			Account:      "alice456",
+			Outcome:      transaction.OkAuditOutcome,
+			CreatedAt:    time.Date(2022, 5, 1, 12, 30, 0, 0, time.UTC),
+		},
+	}, nil)
+
+	// when
+	freezeCode, _, _ := runWith(s, "-actor", "ops@example.com", "freeze", "alice456", "suspected fraud")
+	code, stdout, _ := runWith(s, "audit-log", "alice456")
+
+	// then
+	assert.Equal(t, exitOk, freezeCode)
+	assert.Equal(t, exitOk, code)
+	assert.Contains(t, stdout, "SEQ  DATE")
+	assert.Contains(t, stdout, "7    2022-05-01 12:30:00  ops@example.com  freeze_account  alice456")
+
+	s.AssertExpectations(t)
+}
+
+func Test_Run_Export(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+	s.On("GetAccounts", mock.Anything).Return([]transaction.Account{
+		{Username: "alice456", Balance: decimal.NewFromFloat(200), Currency: "USD", Status: transaction.ActiveAccountStatus},
+		{Username: "bob123", Currency: "USD", Status: transaction.ClosedAccountStatus},
+	}, nil)
+	s.On("GetAccountStatement", mock.Anything, "alice456").Return([]transaction.StatementLine{
+		{TransactionName: transaction.DepositTransaction, Amount: decimal.NewFromFloat(200), Balance: decimal.NewFromFloat(200)},
+	}, nil)
+	s.On("GetAccountStatement", mock.Anything, "bob123").Return(nil, nil)
+	s.On("GetAccountStatusChanges", mock.Anything, "alice456").Return(nil, nil)
+	s.On("GetAccountStatusChanges", mock.Anything, "bob123").Return([]transaction.AccountStatusChange{
+		{FromStatus: transaction.ActiveAccountStatus, ToStatus: transaction.ClosedAccountStatus, Reason: "requested by owner"},
+	}, nil)
+
+	// when
+	code, stdout, _ := runWith(s, "export")
+
+	// then
+	assert.Equal(t, exitOk, code)
+
+	decoder := json.NewDecoder(bytes.NewBufferString(stdout))
+	var alice, bob exportedAccount
+	require.NoError(t, decoder.Decode(&alice))
+	require.NoError(t, decoder.Decode(&bob))
+	assert.False(t, decoder.More())
+
+	assert.Equal(t, "alice456", alice.Account.Username)
+	assert.Len(t, alice.Statement, 1)
+	assert.Empty(t, alice.StatusChanges)
+	assert.Equal(t, "bob123", bob.Account.Username)
+	assert.Empty(t, bob.Statement)
+	assert.Len(t, bob.StatusChanges, 1)
+
+	s.AssertExpectations(t)
+}
+
+func Test_Run_Import_DryRun(t *testing.T) {
+	// given
+	path := filepath.Join(t.TempDir(), "accounts.csv")
+	require.NoError(t, os.WriteFile(path, []byte("username,opening_balance\ncarol789,150.50\ndave000,\n"), 0o600))
+
+	rows := []transaction.ImportRow{
+		{Username: "carol789", OpeningBalance: decimal.RequireFromString("150.50")},
+		{Username: "dave000", OpeningBalance: decimal.Zero},
+	}
+	s := new(mocktransaction.Service)
+	s.On("ImportAccounts", mock.Anything, rows, true).Return(&transaction.ImportReport{
+		DryRun: true, Rows: 2, Accounts: 2, OpeningBalance: decimal.RequireFromString("150.50"),
+	}, nil)
+
+	// when
+	code, stdout, _ := runWith(s, "-dry-run", "import", path)
+
+	// then
+	assert.Equal(t, exitOk, code)
+	assert.Equal(t, "2 accounts can be imported with opening balances of 150.5 in total\n", stdout)
+	s.AssertExpectations(t)
+}
+
+func Test_Run_Import_RowsInvalid(t *testing.T) {
+	// given
+	path := filepath.Join(t.TempDir(), "accounts.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte(`{"username":"bob123","opening_balance":"10"}`+"\n"), 0o600))
+
+	s := new(mocktransaction.Service)
+	s.On("ImportAccounts", mock.Anything, mock.Anything, false).Return(&transaction.ImportReport{
+		Rows:   1,
+		Errors: []transaction.ImportRowError{{Row: 1, Username: "bob123", Error: "account already exists"}},
+	}, transaction.ErrImportRowsInvalid)
+
+	// when
+	code, stdout, stderr := runWith(s, "import", path)
+
+	// then
+	assert.Equal(t, exitInvalid, code)
+	assert.Contains(t, stdout, "bob123")
+	assert.Contains(t, stdout, "account already exists")
+	assert.Contains(t, stderr, "1 of 1 rows")
+	s.AssertExpectations(t)
+}
+
+func Test_Run_Import_Malformed(t *testing.T) {
+	// given
+	path := filepath.Join(t.TempDir(), "accounts.csv")
+	require.NoError(t, os.WriteFile(path, []byte("name\ncarol789\n"), 0o600))
+	s := new(mocktransaction.Service)
+
+	// when
+	code, _, stderr := runWith(s, "import", path)
+
+	// then
+	assert.Equal(t, exitInvalid, code)
+	assert.Contains(t, stderr, "unknown column")
+	s.AssertNotCalled(t, "ImportAccounts", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func Test_Run_MigrateUp(t *testing.T) {
+	// given
+	m := &fakeMigrator{applied: []dbutil.Migration{
+		{Version: "0009", Description: "add column", Script: "V0009__add_column.sql"},
+	}}
+	failing := &fakeMigrator{
+		applied: m.applied,
+		err:     fmt.Errorf("migration V0010__broken.sql: %w", errors.New("syntax error")),
+	}
+
+	// when
+	code, stdout, _ := runMigrateWith(m, "migrate", "up")
+	upToDateCode, upToDateStdout, _ := runMigrateWith(&fakeMigrator{}, "migrate", "up")
+	failingCode, failingStdout, failingStderr := runMigrateWith(failing, "migrate", "up")
+
+	// then
+	assert.Equal(t, exitOk, code)
+	assert.Equal(t, "applied V0009__add_column.sql\n", stdout)
+	assert.Equal(t, exitOk, upToDateCode)
+	assert.Equal(t, "schema is up to date\n", upToDateStdout)
+	assert.Equal(t, exitFailure, failingCode)
+	assert.Equal(t, "applied V0009__add_column.sql\n", failingStdout)
+	assert.Contains(t, failingStderr, "V0010__broken.sql")
+}
+
+func Test_Run_MigrateStatus(t *testing.T) {
+	// given
+	installedOn := time.Date(2022, 2, 1, 20, 33, 14, 0, time.UTC)
+	m := &fakeMigrator{statuses: []dbutil.MigrationStatus{
+		{Version: "0001", Description: "init", Script: "V0001__init.sql", State: dbutil.MigrationStateApplied, InstalledOn: null.TimeFrom(installedOn)},
+		{Version: "0002", Description: "add column", Script: "V0002__add_column.sql", State: dbutil.MigrationStatePending},
+	}}
+
+	// when
+	code, stdout, _ := runMigrateWith(m, "migrate", "status")
+	jsonCode, jsonStdout, _ := runMigrateWith(m, "-o", "json", "migrate", "status")
+
+	// then
+	assert.Equal(t, exitOk, code)
+	assert.Equal(t, ""+
+		"VERSION  DESCRIPTION  STATE    INSTALLED\n"+
+		"0001     init         applied  2022-02-01 20:33:14\n"+
+		"0002     add column   pending  \n", stdout)
+
+	assert.Equal(t, exitOk, jsonCode)
+	var statuses []map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(jsonStdout), &statuses))
+	require.Len(t, statuses, 2)
+	assert.Equal(t, "applied", statuses[0]["state"])
+	assert.Nil(t, statuses[1]["installed_on"])
+}
+
+// --- helpers
+
+type fakeMigrator struct {
+	applied  []dbutil.Migration
+	statuses []dbutil.MigrationStatus
+	err      error
+}
+
+func (m *fakeMigrator) Up(context.Context) ([]dbutil.Migration, error) {
+	return m.applied, m.err
+}
+
+func (m *fakeMigrator) Status(context.Context) ([]dbutil.MigrationStatus, error) {
+	return m.statuses, m.err
+}
@@ -1,19 +1,47 @@
 package dbutil
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"time"
 
-	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
 	"github.com/jmoiron/sqlx"
 )
 
+// NewDb returns a connection pool sized and configured by config. Connections are opened lazily, but the
+// connection string, including any certificate files, is checked right away.
 func NewDb(config *Config) (*sqlx.DB, error) {
-	connString := fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=disable",
-		config.DbUser, config.DbPassword, config.DbHost, config.DbPort, config.DbName)
-	return sqlx.Open("pgx", connString)
+	return newDb(config, config.ConnString())
 }
 
+// NewReplicaDb is like NewDb, but connects to the read replica, which must be configured.
+func NewReplicaDb(config *Config) (*sqlx.DB, error) {
+	if !config.HasReplica() {
+		return nil, fmt.Errorf("%w: DB_REPLICA_URL is not set", ErrConfigInvalid)
+	}
+	return newDb(config, config.ReplicaConnString())
+}
+
+// ReplicationLag returns how far a replica is behind its primary, or zero if db is not a replica or has replayed
+// everything it received.
+func ReplicationLag(ctx context.Context, db sqlx.QueryerContext) (time.Duration, error) {
+	var seconds float64
+	if err := sqlx.GetContext(ctx, db, &seconds, sqlReplicationLag); err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+const sqlReplicationLag = `
+	SELECT CASE
+		WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+	END
+`
+
 func SwitchSchema(txn *sqlx.Tx, schema string) error {
 	_, err := txn.Exec(fmt.Sprintf("SET search_path TO %s", schema))
 	return err
@@ -25,5 +53,36 @@ type Transaction interface {
 	Commit() error
 	Get(dest interface{}, query string, args ...interface{}) error
 	Select(dest interface{}, query string, args ...interface{}) error
+	Queryx(query string, args ...interface{}) (*sqlx.Rows, error)
 	NamedExec(query string, arg interface{}) (sql.Result, error)
 }
+
+// SchemaVersion returns the latest migration version successfully applied to schema, or zero if none was.
+func SchemaVersion(ctx context.Context, db *sqlx.DB, schema string) (int, error) {
+	var version int
+	err := db.GetContext(ctx, &version, fmt.Sprintf(sqlSchemaVersion, schema))
+	return version, err
+}
+
+const sqlSchemaVersion = `
+	SELECT COALESCE(MAX(CAST(version AS INTEGER)), 0)
+	FROM %s.flyway_schema_history
+	WHERE success AND version IS NOT NULL
+`
+
+// --- helpers
+
+func newDb(config *Config, connString string) (*sqlx.DB, error) {
+	connConfig, err := pgx.ParseConfig(connString)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrConfigInvalid, err)
+	}
+	db := sqlx.NewDb(stdlib.OpenDB(*connConfig), "pgx")
+
+	db.SetMaxOpenConns(config.DbMaxOpenConns)
+	db.SetMaxIdleConns(config.DbMaxIdleConns)
+	db.SetConnMaxLifetime(config.DbConnMaxLifetime)
+	db.SetConnMaxIdleTime(config.DbConnMaxIdleTime)
+
+	return db, nil
+}
@@ -0,0 +1,95 @@
+package dbutil
+
+import (
+	"errors"
+
+	"github.com/jackc/pgconn"
+)
+
+// see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	uniqueViolationCode      = "23505"
+	serializationFailureCode = "40001"
+	deadlockDetectedCode     = "40P01"
+)
+
+// IsUniqueViolation reports whether err was caused by a unique constraint violation.
+func IsUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode
+}
+
+// IsUniqueViolationOf reports whether err was caused by a violation of the unique constraint or index named
+// constraint.
+func IsUniqueViolationOf(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode && pgErr.ConstraintName == constraint
+}
+
+// IsRetryable reports whether err aborted a transaction that may succeed when run again from the start.
+func IsRetryable(err error) bool {
+	var retryable *retryableError
+	if errors.As(err, &retryable) {
+		return true
+	}
+
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && (pgErr.Code == serializationFailureCode || pgErr.Code == deadlockDetectedCode)
+}
+
+// Retryable marks err as retryable by IsRetryable, e.g. a unique violation on a row that a concurrent transaction
+// committed in the meantime, which a new attempt will see.
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &retryableError{err}
+}
+
+type retryableError struct {
+	error
+}
+
+func (e *retryableError) Unwrap() error {
+	return e.error
+}
+
+type MigrationChecksumMismatch struct {
+	error
+}
+
+func (e *MigrationChecksumMismatch) Error() string {
+	return "applied migration was changed"
+}
+
+type MigrationMissing struct {
+	error
+}
+
+func (e *MigrationMissing) Error() string {
+	return "applied migration is missing"
+}
+
+type MigrationOutOfOrder struct {
+	error
+}
+
+func (e *MigrationOutOfOrder) Error() string {
+	return "pending migration is older than applied ones"
+}
+
+var (
+	ErrMigrationChecksumMismatch = &MigrationChecksumMismatch{}
+	ErrMigrationMissing          = &MigrationMissing{}
+	ErrMigrationOutOfOrder       = &MigrationOutOfOrder{}
+)
+
+type ConfigInvalid struct {
+	error
+}
+
+func (e *ConfigInvalid) Error() string {
+	return "database configuration is invalid"
+}
+
+var ErrConfigInvalid = &ConfigInvalid{}
@@ -0,0 +1,379 @@
+package dbutil
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"math/big"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/guregu/null.v4"
+)
+
+// migrationHistoryTable has the name and layout Flyway uses, so that databases migrated by either tool are
+// understood by the other.
+const migrationHistoryTable = "flyway_schema_history"
+
+// versionedMigrationPattern matches Flyway's naming of versioned migrations, e.g. V0003__create_accounts_table.sql.
+var versionedMigrationPattern = regexp.MustCompile(`^V(\d+)__(.+)\.sql$`)
+
+const (
+	// list of migration states reported by Migrator.Status
+	MigrationStateApplied          = "applied"
+	MigrationStatePending          = "pending"
+	MigrationStateChecksumMismatch = "checksum mismatch" // the file changed after it was applied
+	MigrationStateMissing          = "missing"           // applied, but no longer among the files
+)
+
+// Migration is a versioned SQL script.
+type Migration struct {
+	Version     string
+	Description string
+	Script      string
+	Checksum    int32
+	sql         string
+}
+
+// MigrationStatus is the state of a Migration in a database.
+type MigrationStatus struct {
+	Version     string    `json:"version"`
+	Description string    `json:"description"`
+	Script      string    `json:"script"`
+	State       string    `json:"state"`
+	InstalledOn null.Time `json:"installed_on"` // absent for pending migrations
+}
+
+type appliedMigration struct {
+	Version     string        `db:"version"`
+	Description string        `db:"description"`
+	Script      string        `db:"script"`
+	Checksum    sql.NullInt32 `db:"checksum"`
+	InstalledOn time.Time     `db:"installed_on"`
+}
+
+// Migrator applies versioned migrations to a schema, recording them like Flyway does.
+type Migrator struct {
+	db         *sqlx.DB
+	schema     string
+	migrations []Migration
+}
+
+// NewMigrator returns a Migrator for the migrations at the root of fsys.
+func NewMigrator(db *sqlx.DB, schema string, fsys fs.FS) (*Migrator, error) {
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, schema: schema, migrations: migrations}, nil
+}
+
+// LoadMigrations reads the versioned migrations at the root of fsys, sorted by version. Other files are ignored.
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	for _, entry := range entries {
+		match := versionedMigrationPattern.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, Migration{
+			Version:     match[1],
+			Description: strings.ReplaceAll(match[2], "_", " "),
+			Script:      entry.Name(),
+			Checksum:    migrationChecksum(string(content)),
+			sql:         string(content),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return compareVersions(migrations[i].Version, migrations[j].Version) < 0
+	})
+	for i := 1; i < len(migrations); i++ {
+		if compareVersions(migrations[i-1].Version, migrations[i].Version) == 0 {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", migrations[i-1].Script, migrations[i].Script)
+		}
+	}
+
+	return migrations, nil
+}
+
+// Status reports the state of every migration, whether it is among the files, applied to the database, or both.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.getAppliedMigrations(ctx, m.db)
+	if err != nil {
+		return nil, err
+	}
+	return m.status(applied), nil
+}
+
+// Up applies pending migrations in version order, each in its own transaction, while holding an advisory lock so
+// that concurrently starting instances apply each migration once. It refuses to run when applied migrations were
+// changed or removed, or when a pending migration is older than the latest applied one.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	conn, err := m.db.Connx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, sqlLockMigrations, m.lockKey()); err != nil {
+		return nil, err
+	}
+	defer conn.ExecContext(context.Background(), sqlUnlockMigrations, m.lockKey())
+
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf(sqlCreateMigrationHistory, m.schema)); err != nil {
+		return nil, err
+	}
+
+	applied, err := m.getAppliedMigrations(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		pending       []Migration
+		latestApplied string
+	)
+	for _, status := range m.status(applied) {
+		switch status.State {
+		case MigrationStateChecksumMismatch:
+			return nil, fmt.Errorf("%w: %s", ErrMigrationChecksumMismatch, status.Script)
+		case MigrationStateMissing:
+			return nil, fmt.Errorf("%w: version %s", ErrMigrationMissing, status.Version)
+		case MigrationStateApplied:
+			latestApplied = status.Version
+		case MigrationStatePending:
+			if latestApplied != "" && compareVersions(status.Version, latestApplied) < 0 {
+				return nil, fmt.Errorf("%w: %s is older than applied version %s", ErrMigrationOutOfOrder, status.Script, latestApplied)
+			}
+			pending = append(pending, m.migrationOf(status))
+		}
+	}
+
+	var done []Migration
+	for _, migration := range pending {
+		if err := m.apply(ctx, conn, migration); err != nil {
+			return done, fmt.Errorf("migration %s: %w", migration.Script, err)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// --- helpers
+
+func (m *Migrator) apply(ctx context.Context, conn *sqlx.Conn, migration Migration) error {
+	txn, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err := txn.ExecContext(ctx, fmt.Sprintf("SET LOCAL search_path TO %s", m.schema)); err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	start := time.Now()
+	if _, err := txn.ExecContext(ctx, migration.sql); err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	_, err = txn.ExecContext(ctx, fmt.Sprintf(sqlCreateAppliedMigration, m.schema),
+		migration.Version,
+		migration.Description,
+		migration.Script,
+		migration.Checksum,
+		time.Since(start).Milliseconds(),
+	)
+	if err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	return txn.Commit()
+}
+
+func (m *Migrator) getAppliedMigrations(ctx context.Context, q sqlx.QueryerContext) ([]appliedMigration, error) {
+	var exists bool
+	if err := sqlx.GetContext(ctx, q, &exists, sqlMigrationHistoryExists, m.schema, migrationHistoryTable); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	var applied []appliedMigration
+	if err := sqlx.SelectContext(ctx, q, &applied, fmt.Sprintf(sqlGetAppliedMigrations, m.schema)); err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// status merges migration files with applied ones, in version order.
+func (m *Migrator) status(applied []appliedMigration) []MigrationStatus {
+	appliedByVersion := make(map[string]appliedMigration, len(applied))
+	for _, a := range applied {
+		appliedByVersion[normalizeVersion(a.Version)] = a
+	}
+
+	var statuses []MigrationStatus
+	for _, migration := range m.migrations {
+		status := MigrationStatus{
+			Version:     migration.Version,
+			Description: migration.Description,
+			Script:      migration.Script,
+			State:       MigrationStatePending,
+		}
+		if a, ok := appliedByVersion[normalizeVersion(migration.Version)]; ok {
+			status.State = MigrationStateApplied
+			status.InstalledOn = null.TimeFrom(a.InstalledOn)
+			if a.Checksum.Valid && a.Checksum.Int32 != migration.Checksum {
+				status.State = MigrationStateChecksumMismatch
+			}
+			delete(appliedByVersion, normalizeVersion(migration.Version))
+		}
+		statuses = append(statuses, status)
+	}
+
+	for _, a := range appliedByVersion {
+		statuses = append(statuses, MigrationStatus{
+			Version:     a.Version,
+			Description: a.Description,
+			Script:      a.Script,
+			State:       MigrationStateMissing,
+			InstalledOn: null.TimeFrom(a.InstalledOn),
+		})
+	}
+	sort.SliceStable(statuses, func(i, j int) bool {
+		return compareVersions(statuses[i].Version, statuses[j].Version) < 0
+	})
+
+	return statuses
+}
+
+func (m *Migrator) migrationOf(status MigrationStatus) Migration {
+	for _, migration := range m.migrations {
+		if migration.Script == status.Script {
+			return migration
+		}
+	}
+	return Migration{}
+}
+
+// lockKey identifies the advisory lock guarding migrations of the schema.
+func (m *Migrator) lockKey() int64 {
+	return int64(crc32.ChecksumIEEE([]byte(m.schema + "." + migrationHistoryTable)))
+}
+
+// migrationChecksum computes the checksum Flyway records for a script: the CRC32 of its lines without line breaks.
+func migrationChecksum(content string) int32 {
+	content = strings.TrimPrefix(content, "\uFEFF")
+
+	hash := crc32.NewIEEE()
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(content)+1)
+	for scanner.Scan() {
+		hash.Write([]byte(strings.TrimSuffix(scanner.Text(), "\r")))
+	}
+	return int32(hash.Sum32())
+}
+
+// normalizeVersion drops leading zeros, since Flyway considers V0003 and V3 the same version.
+func normalizeVersion(version string) string {
+	trimmed := strings.TrimLeft(version, "0")
+	if trimmed == "" {
+		return "0"
+	}
+	return trimmed
+}
+
+func compareVersions(a string, b string) int {
+	x, _ := new(big.Int).SetString(normalizeVersion(a), 10)
+	y, _ := new(big.Int).SetString(normalizeVersion(b), 10)
+	if x == nil || y == nil {
+		return strings.Compare(a, b)
+	}
+	return x.Cmp(y)
+}
+
+const sqlLockMigrations = `SELECT pg_advisory_lock($1)`
+
+const sqlUnlockMigrations = `SELECT pg_advisory_unlock($1)`
+
+const sqlMigrationHistoryExists = `
+SELECT EXISTS (
+	SELECT 1 FROM information_schema.tables WHERE table_schema = $1 AND table_name = $2
+)
+`
+
+const sqlCreateMigrationHistory = `
+CREATE SCHEMA IF NOT EXISTS %[1]s;
+CREATE TABLE IF NOT EXISTS %[1]s.flyway_schema_history (
+	installed_rank INTEGER NOT NULL,
+	version VARCHAR(50),
+	description VARCHAR(200) NOT NULL,
+	type VARCHAR(20) NOT NULL,
+	script VARCHAR(1000) NOT NULL,
+	checksum INTEGER,
+	installed_by VARCHAR(100) NOT NULL,
+	installed_on TIMESTAMP NOT NULL DEFAULT now(),
+	execution_time INTEGER NOT NULL,
+	success BOOLEAN NOT NULL,
+	CONSTRAINT flyway_schema_history_pk PRIMARY KEY (installed_rank)
+);
+CREATE INDEX IF NOT EXISTS flyway_schema_history_s_idx ON %[1]s.flyway_schema_history (success);
+`
+
+const sqlGetAppliedMigrations = `
+SELECT
+	version,
+	description,
+	script,
+	checksum,
+	installed_on
+FROM %s.flyway_schema_history
+WHERE success AND version IS NOT NULL
+ORDER BY installed_rank
+`
+
+const sqlCreateAppliedMigration = `
+INSERT INTO %[1]s.flyway_schema_history (
+	installed_rank,
+	version,
+	description,
+	type,
+	script,
+	checksum,
+	installed_by,
+	execution_time,
+	success
+) VALUES (
+	(SELECT COALESCE(MAX(installed_rank), 0) + 1 FROM %[1]s.flyway_schema_history),
+	$1,
+	$2,
+	'SQL',
+	$3,
+	$4,
+	current_user,
+	$5,
+	TRUE
+)
+`
@@ -0,0 +1,133 @@
+//go:build integration
+// +build integration
+
+package dbutil_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nogurenn/cph-wallet/dbutil"
+	"github.com/nogurenn/cph-wallet/scripts/migrations"
+)
+
+func Test_Migrator_Up(t *testing.T) {
+	// given
+	ctx := context.Background()
+	cfg, err := dbutil.NewConfig()
+	require.NoError(t, err)
+	db, err := dbutil.NewDb(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	schema := "migrate_test"
+	_, err = db.Exec(fmt.Sprintf("DROP SCHEMA IF EXISTS %s CASCADE", schema))
+	require.NoError(t, err)
+	defer db.Exec(fmt.Sprintf("DROP SCHEMA IF EXISTS %s CASCADE", schema))
+
+	m, err := dbutil.NewMigrator(db, schema, migrations.WalletFiles())
+	require.NoError(t, err)
+
+	// when
+	applied, err := m.Up(ctx)
+	require.NoError(t, err)
+	reapplied, err := m.Up(ctx)
+	require.NoError(t, err)
+	statuses, err := m.Status(ctx)
+	require.NoError(t, err)
+	version, err := dbutil.SchemaVersion(ctx, db, schema)
+	require.NoError(t, err)
+
+	// then
+	expected, err := migrations.LatestVersion(migrations.WalletFiles(), ".")
+	require.NoError(t, err)
+
+	assert.Len(t, applied, expected)
+	assert.Empty(t, reapplied)
+	assert.Equal(t, expected, version)
+	for _, status := range statuses {
+		assert.Equal(t, dbutil.MigrationStateApplied, status.State, status.Script)
+	}
+
+	var accounts int
+	err = db.Get(&accounts, fmt.Sprintf("SELECT COUNT(*) FROM %s.accounts", schema))
+	assert.NoError(t, err)
+}
+
+func Test_Migrator_Up_ChecksumMismatch(t *testing.T) {
+	// given
+	ctx := context.Background()
+	cfg, err := dbutil.NewConfig()
+	require.NoError(t, err)
+	db, err := dbutil.NewDb(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	schema := "migrate_test"
+	_, err = db.Exec(fmt.Sprintf("DROP SCHEMA IF EXISTS %s CASCADE", schema))
+	require.NoError(t, err)
+	defer db.Exec(fmt.Sprintf("DROP SCHEMA IF EXISTS %s CASCADE", schema))
+
+	original, err := dbutil.NewMigrator(db, schema, fstest.MapFS{
+		"V0001__create_notes_table.sql": {Data: []byte("CREATE TABLE notes (id INT);")},
+	})
+	require.NoError(t, err)
+	_, err = original.Up(ctx)
+	require.NoError(t, err)
+
+	changed, err := dbutil.NewMigrator(db, schema, fstest.MapFS{
+		"V0001__create_notes_table.sql": {Data: []byte("CREATE TABLE notes (id BIGINT);")},
+		"V0002__add_text_to_notes.sql":  {Data: []byte("ALTER TABLE notes ADD COLUMN text TEXT;")},
+	})
+	require.NoError(t, err)
+
+	// when
+	applied, err := changed.Up(ctx)
+
+	// then
+	assert.ErrorIs(t, err, dbutil.ErrMigrationChecksumMismatch)
+	assert.Empty(t, applied)
+}
+
+func Test_Migrator_Up_FailedMigrationRolledBack(t *testing.T) {
+	// given
+	ctx := context.Background()
+	cfg, err := dbutil.NewConfig()
+	require.NoError(t, err)
+	db, err := dbutil.NewDb(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+
+	schema := "migrate_test"
+	_, err = db.Exec(fmt.Sprintf("DROP SCHEMA IF EXISTS %s CASCADE", schema))
+	require.NoError(t, err)
+	defer db.Exec(fmt.Sprintf("DROP SCHEMA IF EXISTS %s CASCADE", schema))
+
+	m, err := dbutil.NewMigrator(db, schema, fstest.MapFS{
+		"V0001__create_notes_table.sql": {Data: []byte("CREATE TABLE notes (id INT);")},
+		"V0002__broken.sql":             {Data: []byte("CREATE TABLE tags (id INT); SELEC 1;")},
+	})
+	require.NoError(t, err)
+
+	// when
+	applied, err := m.Up(ctx)
+
+	// then
+	assert.Error(t, err)
+	require.Len(t, applied, 1)
+	assert.Equal(t, "0001", applied[0].Version)
+
+	var tags bool
+	err = db.Get(&tags, "SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema = $1 AND table_name = 'tags')", schema)
+	assert.NoError(t, err)
+	assert.False(t, tags)
+
+	version, err := dbutil.SchemaVersion(ctx, db, schema)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, version)
+}
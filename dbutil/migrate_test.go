@@ -0,0 +1,100 @@
+package dbutil
+
+import (
+	"database/sql"
+	"hash/crc32"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_LoadMigrations_Success(t *testing.T) {
+	// given
+	fsys := fstest.MapFS{
+		"V0010__add_column.sql":            {Data: []byte("ALTER TABLE accounts ADD COLUMN x INT;\n")},
+		"V0002__create_accounts_table.sql": {Data: []byte("CREATE TABLE accounts ();\n")},
+		"V0001__init.sql":                  {Data: []byte("-- init")},
+		"R__refresh_views.sql":             {},
+		"README.md":                        {},
+	}
+
+	// when
+	migrations, err := LoadMigrations(fsys)
+
+	// then
+	require.NoError(t, err)
+	require.Len(t, migrations, 3)
+	assert.Equal(t, "0001", migrations[0].Version)
+	assert.Equal(t, "init", migrations[0].Description)
+	assert.Equal(t, "V0001__init.sql", migrations[0].Script)
+	assert.Equal(t, "0002", migrations[1].Version)
+	assert.Equal(t, "create accounts table", migrations[1].Description)
+	assert.Equal(t, "0010", migrations[2].Version)
+}
+
+func Test_LoadMigrations_DuplicateVersion(t *testing.T) {
+	// given
+	fsys := fstest.MapFS{
+		"V0001__init.sql": {},
+		"V1__init.sql":    {},
+	}
+
+	// when
+	_, err := LoadMigrations(fsys)
+
+	// then
+	assert.Error(t, err)
+}
+
+func Test_MigrationChecksum(t *testing.T) {
+	// given
+	lf := "CREATE TABLE accounts ();\n-- done\n"
+	crlf := "CREATE TABLE accounts ();\r\n-- done\r\n"
+	bom := "\uFEFF" + lf
+
+	// when
+	checksum := migrationChecksum(lf)
+
+	// then
+	assert.Equal(t, int32(crc32.ChecksumIEEE([]byte("CREATE TABLE accounts ();-- done"))), checksum)
+	assert.Equal(t, checksum, migrationChecksum(crlf))
+	assert.Equal(t, checksum, migrationChecksum(bom))
+	assert.NotEqual(t, checksum, migrationChecksum("CREATE TABLE accounts ();\n-- changed\n"))
+}
+
+func Test_Migrator_Status(t *testing.T) {
+	// given
+	m := &Migrator{migrations: []Migration{
+		{Version: "0001", Description: "init", Script: "V0001__init.sql", Checksum: 1},
+		{Version: "0002", Description: "create accounts table", Script: "V0002__create_accounts_table.sql", Checksum: 2},
+		{Version: "0004", Description: "add column", Script: "V0004__add_column.sql", Checksum: 4},
+	}}
+	installedOn := time.Date(2022, 2, 1, 20, 33, 14, 0, time.UTC)
+	applied := []appliedMigration{
+		{Version: "1", Description: "init", Script: "V1__init.sql", Checksum: nullInt32(1), InstalledOn: installedOn},
+		{Version: "0002", Description: "create accounts table", Script: "V0002__create_accounts_table.sql", Checksum: nullInt32(20), InstalledOn: installedOn},
+		{Version: "0003", Description: "dropped", Script: "V0003__dropped.sql", Checksum: nullInt32(3), InstalledOn: installedOn},
+	}
+
+	// when
+	statuses := m.status(applied)
+
+	// then
+	require.Len(t, statuses, 4)
+	assert.Equal(t, MigrationStateApplied, statuses[0].State)
+	assert.Equal(t, installedOn, statuses[0].InstalledOn.Time)
+	assert.Equal(t, MigrationStateChecksumMismatch, statuses[1].State)
+	assert.Equal(t, "0003", statuses[2].Version)
+	assert.Equal(t, MigrationStateMissing, statuses[2].State)
+	assert.Equal(t, MigrationStatePending, statuses[3].State)
+	assert.False(t, statuses[3].InstalledOn.Valid)
+}
+
+// --- helpers
+
+func nullInt32(i int32) sql.NullInt32 {
+	return sql.NullInt32{Int32: i, Valid: true}
+}
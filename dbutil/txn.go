@@ -0,0 +1,104 @@
+package dbutil
+
+import (
+	"context"
+	"database/sql"
+	"math/rand"
+	"time"
+)
+
+const (
+	defaultTxnMaxAttempts = 5
+	defaultTxnBaseDelay   = 10 * time.Millisecond
+	defaultTxnMaxDelay    = 500 * time.Millisecond
+)
+
+// BeginFunc starts a transaction with the given options, or the driver defaults if opts is nil.
+type BeginFunc func(ctx context.Context, opts *sql.TxOptions) (Transaction, error)
+
+// TxnRunner runs functions in transactions, retrying them from the start when Postgres aborts the transaction
+// because of a serialization failure or a deadlock.
+type TxnRunner struct {
+	begin       BeginFunc
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+}
+
+func NewTxnRunner(begin BeginFunc) *TxnRunner {
+	return &TxnRunner{
+		begin:       begin,
+		maxAttempts: defaultTxnMaxAttempts,
+		baseDelay:   defaultTxnBaseDelay,
+		maxDelay:    defaultTxnMaxDelay,
+	}
+}
+
+// WithRetries returns a copy of r that makes up to maxAttempts attempts, sleeping a random duration of up to
+// baseDelay doubled per failed attempt, capped at maxDelay, in between.
+func (r *TxnRunner) WithRetries(maxAttempts int, baseDelay time.Duration, maxDelay time.Duration) *TxnRunner {
+	copied := *r
+	copied.maxAttempts = maxAttempts
+	copied.baseDelay = baseDelay
+	copied.maxDelay = maxDelay
+	return &copied
+}
+
+// RunInTxn calls fn in a new transaction and commits it if fn succeeds, or rolls it back otherwise. fn may be
+// called more than once, so it must not have effects outside txn. The error of the last attempt is returned.
+func (r *TxnRunner) RunInTxn(ctx context.Context, isolation sql.IsolationLevel, fn func(txn Transaction) error) error {
+	var err error
+	for attempt := 0; attempt < r.maxAttempts; attempt++ {
+		if attempt > 0 {
+			if sleepErr := sleep(ctx, r.backoff(attempt)); sleepErr != nil {
+				return err
+			}
+		}
+
+		err = r.runOnce(ctx, isolation, fn)
+		if !IsRetryable(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// --- helpers
+
+func (r *TxnRunner) runOnce(ctx context.Context, isolation sql.IsolationLevel, fn func(txn Transaction) error) error {
+	txn, err := r.begin(ctx, &sql.TxOptions{Isolation: isolation})
+	if err != nil {
+		return err
+	}
+
+	if err := fn(txn); err != nil {
+		txn.Rollback()
+		return err
+	}
+
+	return txn.Commit()
+}
+
+// backoff returns a random delay with full jitter, so that transactions that conflicted once do not retry in step.
+func (r *TxnRunner) backoff(attempt int) time.Duration {
+	ceiling := r.baseDelay << (attempt - 1)
+	if ceiling > r.maxDelay || ceiling <= 0 {
+		ceiling = r.maxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling)))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,151 @@
+package dbutil_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nogurenn/cph-wallet/dbutil"
+	mockdbutil "github.com/nogurenn/cph-wallet/mocks/autogen/dbutil"
+)
+
+func Test_TxnRunner_RunInTxn_Commits(t *testing.T) {
+	// given
+	txn := new(mockdbutil.Transaction)
+	txn.On("Commit").Return(nil)
+	runner := dbutil.NewTxnRunner(beginReturning(t, sql.LevelSerializable, txn))
+
+	// when
+	calls := 0
+	err := runner.RunInTxn(context.Background(), sql.LevelSerializable, func(dbutil.Transaction) error {
+		calls++
+		return nil
+	})
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, 1, calls)
+	txn.AssertExpectations(t)
+}
+
+func Test_TxnRunner_RunInTxn_RollsBackOnError(t *testing.T) {
+	// given
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+	runner := dbutil.NewTxnRunner(beginReturning(t, sql.LevelReadCommitted, txn))
+	failure := errors.New("balance of sender is insufficient")
+
+	// when
+	calls := 0
+	err := runner.RunInTxn(context.Background(), sql.LevelReadCommitted, func(dbutil.Transaction) error {
+		calls++
+		return failure
+	})
+
+	// then
+	assert.Equal(t, failure, err)
+	assert.Equal(t, 1, calls)
+	txn.AssertExpectations(t)
+}
+
+func Test_TxnRunner_RunInTxn_RetriesDeadlocksAndSerializationFailures(t *testing.T) {
+	// given
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil).Once()
+	txn.On("Commit").Return(&pgconn.PgError{Code: "40001"}).Once()
+	txn.On("Commit").Return(nil).Once()
+	runner := dbutil.NewTxnRunner(beginReturning(t, sql.LevelReadCommitted, txn)).WithRetries(5, time.Millisecond, time.Millisecond)
+
+	// when
+	calls := 0
+	err := runner.RunInTxn(context.Background(), sql.LevelReadCommitted, func(dbutil.Transaction) error {
+		calls++
+		if calls == 1 {
+			return &pgconn.PgError{Code: "40P01"}
+		}
+		return nil
+	})
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+	txn.AssertExpectations(t)
+}
+
+func Test_TxnRunner_RunInTxn_RetriesErrorsMarkedRetryable(t *testing.T) {
+	// given
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil).Once()
+	txn.On("Commit").Return(nil).Once()
+	runner := dbutil.NewTxnRunner(beginReturning(t, sql.LevelReadCommitted, txn)).WithRetries(5, time.Millisecond, time.Millisecond)
+	conflict := &pgconn.PgError{Code: "23505", ConstraintName: "uq_transactions_idempotency_key"}
+
+	// when
+	calls := 0
+	err := runner.RunInTxn(context.Background(), sql.LevelReadCommitted, func(dbutil.Transaction) error {
+		calls++
+		if calls == 1 {
+			return dbutil.Retryable(conflict)
+		}
+		return nil
+	})
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+	assert.True(t, dbutil.IsUniqueViolationOf(dbutil.Retryable(conflict), "uq_transactions_idempotency_key"))
+	assert.Nil(t, dbutil.Retryable(nil))
+	txn.AssertExpectations(t)
+}
+
+func Test_TxnRunner_RunInTxn_GivesUp(t *testing.T) {
+	// given
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+	runner := dbutil.NewTxnRunner(beginReturning(t, sql.LevelReadCommitted, txn)).WithRetries(3, time.Millisecond, time.Millisecond)
+
+	// when
+	calls := 0
+	err := runner.RunInTxn(context.Background(), sql.LevelReadCommitted, func(dbutil.Transaction) error {
+		calls++
+		return &pgconn.PgError{Code: "40001"}
+	})
+
+	// then
+	assert.True(t, dbutil.IsRetryable(err))
+	assert.Equal(t, 3, calls)
+}
+
+func Test_TxnRunner_RunInTxn_StopsWhenContextDone(t *testing.T) {
+	// given
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+	runner := dbutil.NewTxnRunner(beginReturning(t, sql.LevelReadCommitted, txn)).WithRetries(5, time.Hour, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// when
+	calls := 0
+	err := runner.RunInTxn(ctx, sql.LevelReadCommitted, func(dbutil.Transaction) error {
+		calls++
+		cancel()
+		return &pgconn.PgError{Code: "40001"}
+	})
+
+	// then
+	assert.True(t, dbutil.IsRetryable(err))
+	assert.Equal(t, 1, calls)
+}
+
+// --- helpers
+
+func beginReturning(t *testing.T, isolation sql.IsolationLevel, txn dbutil.Transaction) dbutil.BeginFunc {
+	return func(_ context.Context, opts *sql.TxOptions) (dbutil.Transaction, error) {
+		assert.Equal(t, &sql.TxOptions{Isolation: isolation}, opts)
+		return txn, nil
+	}
+}
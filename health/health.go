@@ -0,0 +1,137 @@
+// Package health serves liveness and readiness probes.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusOk       = "ok"
+	StatusFailing  = "failing"
+	StatusDraining = "draining"
+)
+
+// Check reports whether a dependency is usable. A nil error means it is.
+type Check func(ctx context.Context) error
+
+// All returns a Check that runs every check in checks, and fails with the error of each failing one, by name.
+func All(checks map[string]Check) Check {
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return func(ctx context.Context) error {
+		var failures []string
+		for _, name := range names {
+			if err := checks[name](ctx); err != nil {
+				failures = append(failures, name+": "+err.Error())
+			}
+		}
+		if len(failures) > 0 {
+			return errors.New(strings.Join(failures, "; "))
+		}
+		return nil
+	}
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Handler serves /healthz and /readyz. Readiness runs every registered check, and fails once Drain is called.
+type Handler struct {
+	timeout  time.Duration
+	checks   []namedCheck
+	draining int32
+}
+
+// NewHandler returns a Handler whose readiness checks each get at most timeout to finish.
+func NewHandler(timeout time.Duration) *Handler {
+	return &Handler{timeout: timeout}
+}
+
+// AddCheck registers a readiness check under name. It is not safe to call once the Handler is serving.
+func (h *Handler) AddCheck(name string, check Check) {
+	h.checks = append(h.checks, namedCheck{name: name, check: check})
+}
+
+// Drain makes readiness fail from now on, so that load balancers stop routing traffic to the process.
+func (h *Handler) Drain() {
+	atomic.StoreInt32(&h.draining, 1)
+}
+
+type checkResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type response struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks,omitempty"`
+}
+
+// Live reports that the process is up and serving HTTP. It never touches dependencies.
+func (h *Handler) Live(w http.ResponseWriter, _ *http.Request) {
+	encode(w, http.StatusOK, response{Status: StatusOk})
+}
+
+// Ready reports whether the process can serve traffic, with the result of every check.
+func (h *Handler) Ready(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&h.draining) == 1 {
+		encode(w, http.StatusServiceUnavailable, response{Status: StatusDraining})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.timeout)
+	defer cancel()
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]checkResult, len(h.checks))
+	)
+	for _, c := range h.checks {
+		wg.Add(1)
+		go func(c namedCheck) {
+			defer wg.Done()
+
+			result := checkResult{Status: StatusOk}
+			if err := c.check(ctx); err != nil {
+				result = checkResult{Status: StatusFailing, Error: err.Error()}
+			}
+
+			mu.Lock()
+			results[c.name] = result
+			mu.Unlock()
+		}(c)
+	}
+	wg.Wait()
+
+	resp := response{Status: StatusOk, Checks: results}
+	code := http.StatusOK
+	for _, result := range results {
+		if result.Status != StatusOk {
+			resp.Status = StatusFailing
+			code = http.StatusServiceUnavailable
+		}
+	}
+	encode(w, code, resp)
+}
+
+func encode(w http.ResponseWriter, code int, resp response) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
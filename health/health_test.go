@@ -0,0 +1,137 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nogurenn/cph-wallet/health"
+)
+
+type body struct {
+	Status string `json:"status"`
+	Checks map[string]struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	} `json:"checks"`
+}
+
+func serve(t *testing.T, handler http.HandlerFunc) (int, body) {
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	var b body
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &b))
+	return rec.Code, b
+}
+
+func ok(context.Context) error { return nil }
+
+func Test_Handler_Live(t *testing.T) {
+	// given
+	h := health.NewHandler(time.Second)
+	h.AddCheck("db", func(context.Context) error { return errors.New("connection refused") })
+
+	// when
+	code, b := serve(t, h.Live)
+
+	// then
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, health.StatusOk, b.Status)
+}
+
+func Test_Handler_Ready_Success(t *testing.T) {
+	// given
+	h := health.NewHandler(time.Second)
+	h.AddCheck("db", ok)
+	h.AddCheck("migrations", ok)
+
+	// when
+	code, b := serve(t, h.Ready)
+
+	// then
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, health.StatusOk, b.Status)
+	assert.Equal(t, health.StatusOk, b.Checks["db"].Status)
+	assert.Equal(t, health.StatusOk, b.Checks["migrations"].Status)
+}
+
+func Test_Handler_Ready_CheckFailing(t *testing.T) {
+	// given
+	h := health.NewHandler(time.Second)
+	h.AddCheck("db", ok)
+	h.AddCheck("migrations", func(context.Context) error { return errors.New("schema wallet is at version 7, expected 8") })
+
+	// when
+	code, b := serve(t, h.Ready)
+
+	// then
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, health.StatusFailing, b.Status)
+	assert.Equal(t, health.StatusOk, b.Checks["db"].Status)
+	assert.Equal(t, health.StatusFailing, b.Checks["migrations"].Status)
+	assert.Equal(t, "schema wallet is at version 7, expected 8", b.Checks["migrations"].Error)
+}
+
+func Test_Handler_Ready_CheckTimedOut(t *testing.T) {
+	// given
+	h := health.NewHandler(10 * time.Millisecond)
+	h.AddCheck("db", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	// when
+	code, b := serve(t, h.Ready)
+
+	// then
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, context.DeadlineExceeded.Error(), b.Checks["db"].Error)
+}
+
+func Test_Handler_Ready_Draining(t *testing.T) {
+	// given
+	h := health.NewHandler(time.Second)
+	h.AddCheck("db", ok)
+
+	// when
+	h.Drain()
+	code, b := serve(t, h.Ready)
+
+	// then
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, health.StatusDraining, b.Status)
+}
+
+func Test_All(t *testing.T) {
+	// given
+	check := health.All(map[string]health.Check{
+		"sweeper":      func(context.Context) error { return errors.New("not running") },
+		"checkpointer": func(context.Context) error { return errors.New("connection refused") },
+		"listener":     ok,
+	})
+
+	// when
+	err := check(context.Background())
+
+	// then
+	assert.EqualError(t, err, "checkpointer: connection refused; sweeper: not running")
+}
+
+func Test_All_Success(t *testing.T) {
+	// given
+	check := health.All(map[string]health.Check{"checkpointer": ok, "listener": ok})
+
+	// when
+	err := check(context.Background())
+
+	// then
+	assert.NoError(t, err)
+}
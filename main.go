@@ -1,128 +1,381 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	"github.com/jmoiron/sqlx"
 	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/shopspring/decimal"
+	"google.golang.org/grpc"
 
 	"github.com/nogurenn/cph-wallet/dbutil"
+	"github.com/nogurenn/cph-wallet/health"
+	"github.com/nogurenn/cph-wallet/scripts/migrations"
+	"github.com/nogurenn/cph-wallet/seed"
+	"github.com/nogurenn/cph-wallet/tracing"
 	"github.com/nogurenn/cph-wallet/transaction"
+	"github.com/nogurenn/cph-wallet/transaction/pb"
 )
 
 func main() {
 	httpAddress := flag.String("http.addr", ":8080", "HTTP listen address")
+	grpcAddress := flag.String("grpc.addr", ":8081", "gRPC listen address")
+	shutdownDelay := flag.Duration("shutdown.delay", 0, "time between failing readiness and refusing new requests on shutdown")
+	shutdownTimeout := flag.Duration("shutdown.timeout", 30*time.Second, "time allowed for in-flight requests to finish on shutdown")
+	seedPath := flag.String("seed", "", "YAML fixtures file to load on startup, if any")
+	migrate := flag.Bool("migrate", false, "apply pending database migrations on startup")
+	logFormat := flag.String("log.format", "logfmt", "log output format, logfmt or json")
+	traceExporter := flag.String("trace.exporter", tracing.ExporterNone, "where to export trace spans: none, stdout or otlp")
+	traceEndpoint := flag.String("trace.otlp.endpoint", "localhost:4318", "host:port of the OTLP/HTTP collector to export trace spans to")
+	traceInsecure := flag.Bool("trace.otlp.insecure", true, "export trace spans to the OTLP collector over plain HTTP")
+	checkpointInterval := flag.Duration("checkpoint.interval", 24*time.Hour, "time between balance checkpoints for point-in-time balances, or 0 to disable them")
+	sweepInterval := flag.Duration("payment-requests.sweep-interval", time.Minute, "time between sweeps that mark expired payment requests, or 0 to disable them")
 	flag.Parse()
 
 	var logger log.Logger
-	logger = log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
+	switch *logFormat {
+	case "logfmt":
+		logger = log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
+	case "json":
+		logger = log.NewJSONLogger(log.NewSyncWriter(os.Stderr))
+	default:
+		fmt.Fprintf(os.Stderr, "unknown log format %q\n", *logFormat)
+		os.Exit(2)
+	}
 	logger = log.With(logger, "ts", log.DefaultTimestampUTC)
 
-	cfg := dbutil.NewConfig()
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		ServiceName:  "cph-wallet",
+		Exporter:     *traceExporter,
+		OTLPEndpoint: *traceEndpoint,
+		OTLPInsecure: *traceInsecure,
+		Stdout:       os.Stdout,
+	})
+	if err != nil {
+		logger.Log("fatal", "tracing could not be set up")
+		panic(err)
+	}
+
+	cfg, err := dbutil.NewConfig()
+	if err != nil {
+		logger.Log("fatal", "db configuration is invalid")
+		panic(err)
+	}
 	db, err := dbutil.NewDb(cfg)
 	if err != nil {
 		logger.Log("fatal", "db connection could not be established")
 		panic(err)
 	}
+	var replicaDb *sqlx.DB
+	if cfg.HasReplica() {
+		replicaDb, err = dbutil.NewReplicaDb(cfg)
+		if err != nil {
+			logger.Log("fatal", "replica db connection could not be established")
+			panic(err)
+		}
+	}
 
-	fieldKeys := []string{"method"}
+	if *migrate {
+		migrator, err := dbutil.NewMigrator(db, migrations.WalletSchema, migrations.WalletFiles())
+		if err != nil {
+			logger.Log("fatal", "migrations could not be read")
+			panic(err)
+		}
+		applied, err := migrator.Up(context.Background())
+		if err != nil {
+			logger.Log("fatal", "migrations could not be applied")
+			panic(err)
+		}
+		logger.Log("msg", "migrations applied", "count", len(applied))
+	}
 
 	var tdb transaction.Repository
-	tdb = transaction.NewPostgresDb(db)
+	if replicaDb != nil {
+		tdb = transaction.NewPostgresDbWithReplica(db, replicaDb, cfg.DbReplicaMaxStaleness)
+	} else {
+		tdb = transaction.NewPostgresDb(db)
+	}
+	tdb = transaction.NewTracingRepository(tdb)
+	tdb = transaction.NewInstrumentingRepository(
+		kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: "api",
+			Subsystem: "transaction_repository",
+			Name:      "lock_wait_seconds",
+			Help:      "Time spent waiting for the transactions lock in seconds.",
+			Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}, []string{}),
+		tdb,
+	)
+
+	fieldKeys := []string{"method", "error"}
+
+	activityListener := transaction.NewActivityListener(db, log.With(logger, "component", "activity_listener"))
 
 	var ts transaction.Service
-	ts = transaction.NewService(tdb)
+	ts = transaction.NewServiceWithActivity(tdb, activityListener)
+	ts = transaction.NewTracingService(ts)
 	ts = transaction.NewLoggingService(log.With(logger, "component", "transaction"), ts)
-	ts = transaction.NewInstrumentingService(
-		kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+	ts = transaction.NewInstrumentingService(transaction.ServiceMetrics{
+		RequestCount: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
 			Namespace: "api",
 			Subsystem: "transaction_service",
 			Name:      "request_count",
 			Help:      "Number of requests received.",
 		}, fieldKeys),
-		kitprometheus.NewSummaryFrom(stdprometheus.SummaryOpts{
+		RequestLatency: kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
 			Namespace: "api",
 			Subsystem: "transaction_service",
-			Name:      "request_latency_microseconds",
-			Help:      "Total duration of requests in microseconds.",
+			Name:      "request_latency_seconds",
+			Help:      "Duration of requests in seconds.",
+			Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
 		}, fieldKeys),
-		ts,
-	)
+		PaymentVolume: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "wallet",
+			Subsystem: "payments",
+			Name:      "volume_total",
+			Help:      "Sum of the amounts of recorded payments, including paid payment requests.",
+		}, []string{"currency"}),
+		PaymentAmount: kitprometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: "wallet",
+			Subsystem: "payments",
+			Name:      "amount",
+			Help:      "Amounts of recorded payments, including paid payment requests.",
+			Buckets:   []float64{1, 5, 10, 50, 100, 500, 1000, 5000, 10000},
+		}, []string{"currency"}),
+		BalanceRejections: kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: "wallet",
+			Subsystem: "payments",
+			Name:      "insufficient_balance_total",
+			Help:      "Number of payments rejected for insufficient balance of the sender.",
+		}, []string{}),
+	}, ts)
 
-	// make loading of test data mandatory for this exam code.
-	err = setupTestData(ts)
-	if err != nil {
-		logger.Log("fatal", "test data could not be loaded to the repository")
-		panic(err)
+	stdprometheus.MustRegister(collectors.NewDBStatsCollector(db.DB, "primary"))
+	if replicaDb != nil {
+		stdprometheus.MustRegister(collectors.NewDBStatsCollector(replicaDb.DB, "replica"))
+	}
+
+	if *seedPath != "" {
+		fixtures, err := seed.Load(*seedPath)
+		if err != nil {
+			logger.Log("fatal", "fixtures could not be read")
+			panic(err)
+		}
+		err = seed.Apply(context.Background(), ts, fixtures)
+		if err != nil {
+			logger.Log("fatal", "fixtures could not be loaded to the repository")
+			panic(err)
+		}
+		logger.Log("msg", "fixtures loaded", "path", *seedPath)
 	}
 
 	httpLogger := log.With(logger, "component", "http")
 
+	var (
+		checkpointer *transaction.Checkpointer
+		sweeper      *transaction.PaymentRequestSweeper
+		workerChecks = map[string]health.Check{"activity_listener": activityListener.Check}
+	)
+	if *checkpointInterval > 0 {
+		checkpointer = transaction.NewCheckpointer(tdb, log.With(logger, "component", "checkpointer"), *checkpointInterval)
+		workerChecks["checkpointer"] = checkpointer.Check
+	}
+	if *sweepInterval > 0 {
+		sweeper = transaction.NewPaymentRequestSweeper(tdb, log.With(logger, "component", "sweeper"), *sweepInterval)
+		workerChecks["sweeper"] = sweeper.Check
+	}
+
+	healthHandler := health.NewHandler(2 * time.Second)
+	healthHandler.AddCheck("db", db.PingContext)
+	healthHandler.AddCheck("migrations", makeMigrationsCheck(db))
+	healthHandler.AddCheck("workers", health.All(workerChecks))
+
 	mux := http.NewServeMux()
 	mux.Handle("/transaction/v1/", transaction.MakeHandler(ts, httpLogger))
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", healthHandler.Live)
+	mux.HandleFunc("/readyz", healthHandler.Ready)
+
+	httpServer := &http.Server{Handler: mux}
 
-	http.Handle("/", mux)
-	http.Handle("/metrics", promhttp.Handler())
+	grpcLogger := log.With(logger, "component", "grpc")
 
+	grpcServer := grpc.NewServer()
+	pb.RegisterTransactionServiceServer(grpcServer, transaction.MakeGRPCServer(ts, grpcLogger))
+
+	httpListener, err := net.Listen("tcp", *httpAddress)
+	if err != nil {
+		logger.Log("fatal", "http address could not be listened on")
+		panic(err)
+	}
+	grpcListener, err := net.Listen("tcp", *grpcAddress)
+	if err != nil {
+		logger.Log("fatal", "grpc address could not be listened on")
+		panic(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var workers sync.WaitGroup
+	runWorker := func(run func(ctx context.Context)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			run(ctx)
+		}()
+	}
+	if checkpointer != nil {
+		runWorker(checkpointer.Run)
+	}
+	if sweeper != nil {
+		runWorker(sweeper.Run)
+	}
+	runWorker(activityListener.Run)
+
+	err = serve(ctx, logger, healthHandler, httpServer, httpListener, grpcServer, grpcListener, *shutdownDelay, *shutdownTimeout)
+	if err != nil {
+		logger.Log("terminated", err)
+	} else {
+		logger.Log("msg", "shut down gracefully")
+	}
+
+	// stops the workers too if a server failed, and lets them roll back what they were doing before the pools close
+	stop()
+	if !waitTimeout(&workers, *shutdownTimeout) {
+		logger.Log("msg", "background workers did not stop in time")
+	}
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		logger.Log("msg", "trace spans could not be flushed", "err", err)
+	}
+
+	if err := db.Close(); err != nil {
+		logger.Log("msg", "db connection pool could not be closed", "err", err)
+	}
+	if replicaDb != nil {
+		if err := replicaDb.Close(); err != nil {
+			logger.Log("msg", "replica db connection pool could not be closed", "err", err)
+		}
+	}
+}
+
+// serve runs the HTTP and gRPC servers until ctx is done or either server fails. It then fails readiness, keeps
+// accepting requests for shutdownDelay while load balancers catch up, stops accepting requests and waits up to
+// shutdownTimeout for in-flight ones to finish before closing remaining connections.
+func serve(
+	ctx context.Context,
+	logger log.Logger,
+	healthHandler *health.Handler,
+	httpServer *http.Server,
+	httpListener net.Listener,
+	grpcServer *grpc.Server,
+	grpcListener net.Listener,
+	shutdownDelay time.Duration,
+	shutdownTimeout time.Duration,
+) error {
 	errs := make(chan error, 2)
 	go func() {
-		logger.Log("transport", "http", "address", *httpAddress, "msg", "listening")
+		logger.Log("transport", "http", "address", httpListener.Addr(), "msg", "listening")
 
-		errs <- http.ListenAndServe(*httpAddress, nil)
+		if err := httpServer.Serve(httpListener); err != http.ErrServerClosed {
+			errs <- err
+		}
 	}()
 	go func() {
-		c := make(chan os.Signal)
-		signal.Notify(c, syscall.SIGINT)
-		errs <- fmt.Errorf("%s", <-c)
+		logger.Log("transport", "grpc", "address", grpcListener.Addr(), "msg", "listening")
+
+		if err := grpcServer.Serve(grpcListener); err != nil {
+			errs <- err
+		}
+	}()
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-errs:
+	}
+	logger.Log("msg", "shutting down", "delay", shutdownDelay, "timeout", shutdownTimeout)
+
+	healthHandler.Drain()
+	time.Sleep(shutdownDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	grpcStopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(grpcStopped)
 	}()
 
-	logger.Log("terminated", <-errs)
+	if shutdownErr := httpServer.Shutdown(shutdownCtx); shutdownErr != nil {
+		logger.Log("transport", "http", "msg", "in-flight requests did not finish in time", "err", shutdownErr)
+		httpServer.Close()
+		if err == nil {
+			err = shutdownErr
+		}
+	}
+
+	select {
+	case <-grpcStopped:
+	case <-shutdownCtx.Done():
+		logger.Log("transport", "grpc", "msg", "in-flight requests did not finish in time")
+		grpcServer.Stop()
+		<-grpcStopped
+		if err == nil {
+			err = shutdownCtx.Err()
+		}
+	}
+
+	return err
 }
 
-// setupTestData loads test data to repositories.
-func setupTestData(ts transaction.Service) error {
-	alice := "alice456"
-	bob := "bob123"
-	karen := "karen789"
-	usernames := []string{bob, alice, karen}
-	initialBalance := decimal.NewFromFloat(200.00)
+// waitTimeout waits for wg up to timeout, and reports whether it finished in time.
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
 
-	for _, username := range usernames {
-		err := ts.CreateAccount(username)
+// makeMigrationsCheck fails readiness while the database lags behind the migrations embedded in the binary.
+func makeMigrationsCheck(db *sqlx.DB) health.Check {
+	return func(ctx context.Context) error {
+		expected, err := migrations.LatestVersion(migrations.Wallet, migrations.WalletSchema)
 		if err != nil {
 			return err
 		}
 
-		err = ts.Deposit(username, initialBalance)
+		applied, err := dbutil.SchemaVersion(ctx, db, migrations.WalletSchema)
 		if err != nil {
 			return err
 		}
+		if applied < expected {
+			return fmt.Errorf("schema %s is at version %d, expected %d", migrations.WalletSchema, applied, expected)
+		}
+		return nil
 	}
-
-	err := ts.SendPayment(bob, alice, decimal.NewFromFloat(60.41))
-	if err != nil {
-		return err
-	}
-	err = ts.SendPayment(bob, karen, decimal.NewFromFloat(95.12))
-	if err != nil {
-		return err
-	}
-	err = ts.SendPayment(alice, karen, decimal.NewFromFloat(34.58))
-	if err != nil {
-		return err
-	}
-	err = ts.SendPayment(karen, alice, decimal.NewFromFloat(44.79))
-	if err != nil {
-		return err
-	}
-
-	return nil
 }
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/nogurenn/cph-wallet/health"
+	mocktransaction "github.com/nogurenn/cph-wallet/mocks/autogen/transaction"
+	"github.com/nogurenn/cph-wallet/transaction"
+)
+
+// startServe runs serve for s on random local ports and returns the HTTP base URL and the result of serve.
+func startServe(
+	t *testing.T,
+	ctx context.Context,
+	s transaction.Service,
+	shutdownDelay time.Duration,
+	shutdownTimeout time.Duration,
+) (string, <-chan error) {
+	httpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	grpcListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	healthHandler := health.NewHandler(time.Second)
+
+	mux := http.NewServeMux()
+	mux.Handle("/transaction/v1/", transaction.MakeHandler(s, log.NewNopLogger()))
+	mux.HandleFunc("/readyz", healthHandler.Ready)
+
+	httpServer := &http.Server{Handler: mux}
+	grpcServer := grpc.NewServer()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- serve(ctx, log.NewNopLogger(), healthHandler, httpServer, httpListener, grpcServer, grpcListener,
+			shutdownDelay, shutdownTimeout)
+	}()
+
+	return "http://" + httpListener.Addr().String(), done
+}
+
+func Test_Serve_WaitsForInFlightSendPayment(t *testing.T) {
+	// given
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	s := new(mocktransaction.Service)
+	s.On("SendPayment", mock.Anything, "bob123", "alice456", mock.Anything, transaction.PaymentDetails{}).
+		Run(func(mock.Arguments) {
+			close(started)
+			<-release
+		}).
+		Return(nil).Once()
+
+	ctx, shutdown := context.WithCancel(context.Background())
+	defer shutdown()
+	url, done := startServe(t, ctx, s, 0, 5*time.Second)
+
+	responses := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.Post(url+"/transaction/v1/payments", "application/json",
+			strings.NewReader(`{"username":"bob123","target_username":"alice456","amount":"60.41"}`))
+		assert.NoError(t, err)
+		responses <- resp
+	}()
+	<-started
+
+	// when
+	shutdown()
+
+	// then
+	select {
+	case err := <-done:
+		t.Fatalf("serve returned before the in-flight payment finished: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	_, err := http.Get(url + "/transaction/v1/accounts")
+	assert.Error(t, err, "new requests must be refused during shutdown")
+
+	close(release)
+
+	resp := <-responses
+	require.NotNil(t, resp)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.NoError(t, <-done)
+
+	s.AssertExpectations(t)
+}
+
+func Test_Serve_ShutdownTimeoutExceeded(t *testing.T) {
+	// given
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	s := new(mocktransaction.Service)
+	s.On("SendPayment", mock.Anything, "bob123", "alice456", mock.Anything, transaction.PaymentDetails{}).
+		Run(func(mock.Arguments) {
+			close(started)
+			<-release
+		}).
+		Return(nil).Once()
+
+	ctx, shutdown := context.WithCancel(context.Background())
+	defer shutdown()
+	url, done := startServe(t, ctx, s, 0, 50*time.Millisecond)
+
+	go http.Post(url+"/transaction/v1/payments", "application/json",
+		strings.NewReader(`{"username":"bob123","target_username":"alice456","amount":"60.41"}`))
+	<-started
+
+	// when
+	shutdown()
+
+	// then
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve did not give up after the shutdown timeout")
+	}
+}
+
+func Test_Serve_ReadinessFailsDuringShutdownDelay(t *testing.T) {
+	// given
+	ctx, shutdown := context.WithCancel(context.Background())
+	defer shutdown()
+	url, done := startServe(t, ctx, new(mocktransaction.Service), 300*time.Millisecond, time.Second)
+
+	resp, err := http.Get(url + "/readyz")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// when
+	shutdown()
+	time.Sleep(50 * time.Millisecond)
+
+	// then
+	resp, err = http.Get(url + "/readyz")
+	require.NoError(t, err, "requests must still be served during the shutdown delay")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	assert.NoError(t, <-done)
+}
+
+func Test_WaitTimeout(t *testing.T) {
+	// given
+	var finished, stuck sync.WaitGroup
+	finished.Add(1)
+	go finished.Done()
+	stuck.Add(1)
+	defer stuck.Done()
+
+	// when
+	finishedInTime := waitTimeout(&finished, time.Second)
+	stuckInTime := waitTimeout(&stuck, 10*time.Millisecond)
+
+	// then
+	assert.True(t, finishedInTime)
+	assert.False(t, stuckInTime)
+}
@@ -5,6 +5,7 @@ package dbutil
 import (
 	sql "database/sql"
 
+	sqlx "github.com/jmoiron/sqlx"
 	mock "github.com/stretchr/testify/mock"
 )
 
@@ -93,6 +94,32 @@ func (_m *Transaction) NamedExec(query string, arg interface{}) (sql.Result, err
 	return r0, r1
 }
 
+// Queryx provides a mock function with given fields: query, args
+func (_m *Transaction) Queryx(query string, args ...interface{}) (*sqlx.Rows, error) {
+	var _ca []interface{}
+	_ca = append(_ca, query)
+	_ca = append(_ca, args...)
+	ret := _m.Called(_ca...)
+
+	var r0 *sqlx.Rows
+	if rf, ok := ret.Get(0).(func(string, ...interface{}) *sqlx.Rows); ok {
+		r0 = rf(query, args...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*sqlx.Rows)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, ...interface{}) error); ok {
+		r1 = rf(query, args...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Rollback provides a mock function with given fields:
 func (_m *Transaction) Rollback() error {
 	ret := _m.Called()
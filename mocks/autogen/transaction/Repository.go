@@ -3,12 +3,15 @@
 package transaction
 
 import (
-	dbutil "github.com/nogurenn/cph-wallet/dbutil"
-	mock "github.com/stretchr/testify/mock"
+	context "context"
+	sql "database/sql"
 
+	uuid "github.com/google/uuid"
+	dbutil "github.com/nogurenn/cph-wallet/dbutil"
 	transaction "github.com/nogurenn/cph-wallet/transaction"
+	mock "github.com/stretchr/testify/mock"
 
-	uuid "github.com/google/uuid"
+	time "time"
 )
 
 // Repository is an autogenerated mock type for the Repository type
@@ -16,13 +19,13 @@ type Repository struct {
 	mock.Mock
 }
 
-// BeginTxn provides a mock function with given fields:
-func (_m *Repository) BeginTxn() (dbutil.Transaction, error) {
-	ret := _m.Called()
+// BeginReplicaTxn provides a mock function with given fields: ctx
+func (_m *Repository) BeginReplicaTxn(ctx context.Context) (dbutil.Transaction, error) {
+	ret := _m.Called(ctx)
 
 	var r0 dbutil.Transaction
-	if rf, ok := ret.Get(0).(func() dbutil.Transaction); ok {
-		r0 = rf()
+	if rf, ok := ret.Get(0).(func(context.Context) dbutil.Transaction); ok {
+		r0 = rf(ctx)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(dbutil.Transaction)
@@ -30,8 +33,31 @@ func (_m *Repository) BeginTxn() (dbutil.Transaction, error) {
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func() error); ok {
-		r1 = rf()
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// BeginTxn provides a mock function with given fields: ctx, opts
+func (_m *Repository) BeginTxn(ctx context.Context, opts *sql.TxOptions) (dbutil.Transaction, error) {
+	ret := _m.Called(ctx, opts)
+
+	var r0 dbutil.Transaction
+	if rf, ok := ret.Get(0).(func(context.Context, *sql.TxOptions) dbutil.Transaction); ok {
+		r0 = rf(ctx, opts)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(dbutil.Transaction)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, *sql.TxOptions) error); ok {
+		r1 = rf(ctx, opts)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -53,6 +79,83 @@ func (_m *Repository) CreateAccount(txn dbutil.Transaction, account transaction.
 	return r0
 }
 
+// CreateAccountStatusChange provides a mock function with given fields: txn, change
+func (_m *Repository) CreateAccountStatusChange(txn dbutil.Transaction, change transaction.AccountStatusChange) error {
+	ret := _m.Called(txn, change)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(dbutil.Transaction, transaction.AccountStatusChange) error); ok {
+		r0 = rf(txn, change)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CreateAccounts provides a mock function with given fields: txn, accounts
+func (_m *Repository) CreateAccounts(txn dbutil.Transaction, accounts []transaction.Account) error {
+	ret := _m.Called(txn, accounts)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(dbutil.Transaction, []transaction.Account) error); ok {
+		r0 = rf(txn, accounts)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CreateAuditRecord provides a mock function with given fields: txn, record
+func (_m *Repository) CreateAuditRecord(txn dbutil.Transaction, record transaction.AuditRecord) error {
+	ret := _m.Called(txn, record)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(dbutil.Transaction, transaction.AuditRecord) error); ok {
+		r0 = rf(txn, record)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CreateBalanceCheckpoints provides a mock function with given fields: txn, asOf
+func (_m *Repository) CreateBalanceCheckpoints(txn dbutil.Transaction, asOf time.Time) (int64, error) {
+	ret := _m.Called(txn, asOf)
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(dbutil.Transaction, time.Time) int64); ok {
+		r0 = rf(txn, asOf)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(dbutil.Transaction, time.Time) error); ok {
+		r1 = rf(txn, asOf)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CreateEntries provides a mock function with given fields: txn, entries
+func (_m *Repository) CreateEntries(txn dbutil.Transaction, entries []transaction.Entry) error {
+	ret := _m.Called(txn, entries)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(dbutil.Transaction, []transaction.Entry) error); ok {
+		r0 = rf(txn, entries)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // CreateEntriesForTransactionId provides a mock function with given fields: txn, transactionId, entries
 func (_m *Repository) CreateEntriesForTransactionId(txn dbutil.Transaction, transactionId uuid.UUID, entries []transaction.Entry) error {
 	ret := _m.Called(txn, transactionId, entries)
@@ -67,6 +170,20 @@ func (_m *Repository) CreateEntriesForTransactionId(txn dbutil.Transaction, tran
 	return r0
 }
 
+// CreatePaymentRequest provides a mock function with given fields: txn, request
+func (_m *Repository) CreatePaymentRequest(txn dbutil.Transaction, request transaction.PaymentRequest) error {
+	ret := _m.Called(txn, request)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(dbutil.Transaction, transaction.PaymentRequest) error); ok {
+		r0 = rf(txn, request)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // CreateTransaction provides a mock function with given fields: txn, _a1
 func (_m *Repository) CreateTransaction(txn dbutil.Transaction, _a1 transaction.Transaction) error {
 	ret := _m.Called(txn, _a1)
@@ -81,6 +198,41 @@ func (_m *Repository) CreateTransaction(txn dbutil.Transaction, _a1 transaction.
 	return r0
 }
 
+// CreateTransactions provides a mock function with given fields: txn, transactions
+func (_m *Repository) CreateTransactions(txn dbutil.Transaction, transactions []transaction.Transaction) error {
+	ret := _m.Called(txn, transactions)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(dbutil.Transaction, []transaction.Transaction) error); ok {
+		r0 = rf(txn, transactions)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ExpirePaymentRequests provides a mock function with given fields: txn, now
+func (_m *Repository) ExpirePaymentRequests(txn dbutil.Transaction, now time.Time) (int64, error) {
+	ret := _m.Called(txn, now)
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(dbutil.Transaction, time.Time) int64); ok {
+		r0 = rf(txn, now)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(dbutil.Transaction, time.Time) error); ok {
+		r1 = rf(txn, now)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetAccountByUsername provides a mock function with given fields: txn, username
 func (_m *Repository) GetAccountByUsername(txn dbutil.Transaction, username string) (*transaction.Account, error) {
 	ret := _m.Called(txn, username)
@@ -104,6 +256,96 @@ func (_m *Repository) GetAccountByUsername(txn dbutil.Transaction, username stri
 	return r0, r1
 }
 
+// GetAccountByUsernameAsOf provides a mock function with given fields: txn, username, asOf
+func (_m *Repository) GetAccountByUsernameAsOf(txn dbutil.Transaction, username string, asOf time.Time) (*transaction.Account, error) {
+	ret := _m.Called(txn, username, asOf)
+
+	var r0 *transaction.Account
+	if rf, ok := ret.Get(0).(func(dbutil.Transaction, string, time.Time) *transaction.Account); ok {
+		r0 = rf(txn, username, asOf)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*transaction.Account)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(dbutil.Transaction, string, time.Time) error); ok {
+		r1 = rf(txn, username, asOf)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetAccountEvents provides a mock function with given fields: txn, accountId, afterSeq, limit
+func (_m *Repository) GetAccountEvents(txn dbutil.Transaction, accountId uuid.UUID, afterSeq int64, limit int) ([]transaction.AccountEvent, error) {
+	ret := _m.Called(txn, accountId, afterSeq, limit)
+
+	var r0 []transaction.AccountEvent
+	if rf, ok := ret.Get(0).(func(dbutil.Transaction, uuid.UUID, int64, int) []transaction.AccountEvent); ok {
+		r0 = rf(txn, accountId, afterSeq, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]transaction.AccountEvent)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(dbutil.Transaction, uuid.UUID, int64, int) error); ok {
+		r1 = rf(txn, accountId, afterSeq, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetAccountStatusChanges provides a mock function with given fields: txn, accountId
+func (_m *Repository) GetAccountStatusChanges(txn dbutil.Transaction, accountId uuid.UUID) ([]transaction.AccountStatusChange, error) {
+	ret := _m.Called(txn, accountId)
+
+	var r0 []transaction.AccountStatusChange
+	if rf, ok := ret.Get(0).(func(dbutil.Transaction, uuid.UUID) []transaction.AccountStatusChange); ok {
+		r0 = rf(txn, accountId)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]transaction.AccountStatusChange)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(dbutil.Transaction, uuid.UUID) error); ok {
+		r1 = rf(txn, accountId)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetAccountStatusForUpdate provides a mock function with given fields: txn, accountId
+func (_m *Repository) GetAccountStatusForUpdate(txn dbutil.Transaction, accountId uuid.UUID) (string, error) {
+	ret := _m.Called(txn, accountId)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(dbutil.Transaction, uuid.UUID) string); ok {
+		r0 = rf(txn, accountId)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(dbutil.Transaction, uuid.UUID) error); ok {
+		r1 = rf(txn, accountId)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetAccounts provides a mock function with given fields: txn
 func (_m *Repository) GetAccounts(txn dbutil.Transaction) ([]transaction.Account, error) {
 	ret := _m.Called(txn)
@@ -127,13 +369,264 @@ func (_m *Repository) GetAccounts(txn dbutil.Transaction) ([]transaction.Account
 	return r0, r1
 }
 
-// GetTransactionsByName provides a mock function with given fields: txn, name
-func (_m *Repository) GetTransactionsByName(txn dbutil.Transaction, name string) ([]transaction.Transaction, error) {
-	ret := _m.Called(txn, name)
+// GetAccountsAsOf provides a mock function with given fields: txn, asOf
+func (_m *Repository) GetAccountsAsOf(txn dbutil.Transaction, asOf time.Time) ([]transaction.Account, error) {
+	ret := _m.Called(txn, asOf)
+
+	var r0 []transaction.Account
+	if rf, ok := ret.Get(0).(func(dbutil.Transaction, time.Time) []transaction.Account); ok {
+		r0 = rf(txn, asOf)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]transaction.Account)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(dbutil.Transaction, time.Time) error); ok {
+		r1 = rf(txn, asOf)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetAuditRecords provides a mock function with given fields: txn, filter
+func (_m *Repository) GetAuditRecords(txn dbutil.Transaction, filter transaction.AuditFilter) ([]transaction.AuditRecord, error) {
+	ret := _m.Called(txn, filter)
+
+	var r0 []transaction.AuditRecord
+	if rf, ok := ret.Get(0).(func(dbutil.Transaction, transaction.AuditFilter) []transaction.AuditRecord); ok {
+		r0 = rf(txn, filter)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]transaction.AuditRecord)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(dbutil.Transaction, transaction.AuditFilter) error); ok {
+		r1 = rf(txn, filter)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetChainedEntries provides a mock function with given fields: txn, afterAccountId, afterSeq, limit
+func (_m *Repository) GetChainedEntries(txn dbutil.Transaction, afterAccountId uuid.UUID, afterSeq int64, limit int) ([]transaction.Entry, error) {
+	ret := _m.Called(txn, afterAccountId, afterSeq, limit)
+
+	var r0 []transaction.Entry
+	if rf, ok := ret.Get(0).(func(dbutil.Transaction, uuid.UUID, int64, int) []transaction.Entry); ok {
+		r0 = rf(txn, afterAccountId, afterSeq, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]transaction.Entry)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(dbutil.Transaction, uuid.UUID, int64, int) error); ok {
+		r1 = rf(txn, afterAccountId, afterSeq, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetLastAuditRecord provides a mock function with given fields: txn
+func (_m *Repository) GetLastAuditRecord(txn dbutil.Transaction) (*transaction.AuditRecord, error) {
+	ret := _m.Called(txn)
+
+	var r0 *transaction.AuditRecord
+	if rf, ok := ret.Get(0).(func(dbutil.Transaction) *transaction.AuditRecord); ok {
+		r0 = rf(txn)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*transaction.AuditRecord)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(dbutil.Transaction) error); ok {
+		r1 = rf(txn)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetLastEntrySeq provides a mock function with given fields: txn, accountId
+func (_m *Repository) GetLastEntrySeq(txn dbutil.Transaction, accountId uuid.UUID) (int64, error) {
+	ret := _m.Called(txn, accountId)
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func(dbutil.Transaction, uuid.UUID) int64); ok {
+		r0 = rf(txn, accountId)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(dbutil.Transaction, uuid.UUID) error); ok {
+		r1 = rf(txn, accountId)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetLedgerDiscrepancies provides a mock function with given fields: txn
+func (_m *Repository) GetLedgerDiscrepancies(txn dbutil.Transaction) ([]transaction.Discrepancy, error) {
+	ret := _m.Called(txn)
+
+	var r0 []transaction.Discrepancy
+	if rf, ok := ret.Get(0).(func(dbutil.Transaction) []transaction.Discrepancy); ok {
+		r0 = rf(txn)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]transaction.Discrepancy)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(dbutil.Transaction) error); ok {
+		r1 = rf(txn)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetPaymentRequestForUpdate provides a mock function with given fields: txn, id
+func (_m *Repository) GetPaymentRequestForUpdate(txn dbutil.Transaction, id uuid.UUID) (*transaction.PaymentRequest, error) {
+	ret := _m.Called(txn, id)
+
+	var r0 *transaction.PaymentRequest
+	if rf, ok := ret.Get(0).(func(dbutil.Transaction, uuid.UUID) *transaction.PaymentRequest); ok {
+		r0 = rf(txn, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*transaction.PaymentRequest)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(dbutil.Transaction, uuid.UUID) error); ok {
+		r1 = rf(txn, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetPaymentRequests provides a mock function with given fields: txn, filter
+func (_m *Repository) GetPaymentRequests(txn dbutil.Transaction, filter transaction.PaymentRequestFilter) ([]transaction.PaymentRequest, error) {
+	ret := _m.Called(txn, filter)
+
+	var r0 []transaction.PaymentRequest
+	if rf, ok := ret.Get(0).(func(dbutil.Transaction, transaction.PaymentRequestFilter) []transaction.PaymentRequest); ok {
+		r0 = rf(txn, filter)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]transaction.PaymentRequest)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(dbutil.Transaction, transaction.PaymentRequestFilter) error); ok {
+		r1 = rf(txn, filter)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetStatementLines provides a mock function with given fields: txn, accountId
+func (_m *Repository) GetStatementLines(txn dbutil.Transaction, accountId uuid.UUID) ([]transaction.StatementLine, error) {
+	ret := _m.Called(txn, accountId)
+
+	var r0 []transaction.StatementLine
+	if rf, ok := ret.Get(0).(func(dbutil.Transaction, uuid.UUID) []transaction.StatementLine); ok {
+		r0 = rf(txn, accountId)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]transaction.StatementLine)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(dbutil.Transaction, uuid.UUID) error); ok {
+		r1 = rf(txn, accountId)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetTakenUsernames provides a mock function with given fields: txn, usernames
+func (_m *Repository) GetTakenUsernames(txn dbutil.Transaction, usernames []string) ([]string, error) {
+	ret := _m.Called(txn, usernames)
+
+	var r0 []string
+	if rf, ok := ret.Get(0).(func(dbutil.Transaction, []string) []string); ok {
+		r0 = rf(txn, usernames)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(dbutil.Transaction, []string) error); ok {
+		r1 = rf(txn, usernames)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetTransactionByIdempotencyKey provides a mock function with given fields: txn, key
+func (_m *Repository) GetTransactionByIdempotencyKey(txn dbutil.Transaction, key string) (*transaction.Transaction, error) {
+	ret := _m.Called(txn, key)
+
+	var r0 *transaction.Transaction
+	if rf, ok := ret.Get(0).(func(dbutil.Transaction, string) *transaction.Transaction); ok {
+		r0 = rf(txn, key)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*transaction.Transaction)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(dbutil.Transaction, string) error); ok {
+		r1 = rf(txn, key)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetTransactionsByName provides a mock function with given fields: txn, name, filter
+func (_m *Repository) GetTransactionsByName(txn dbutil.Transaction, name string, filter transaction.TransactionFilter) ([]transaction.Transaction, error) {
+	ret := _m.Called(txn, name, filter)
 
 	var r0 []transaction.Transaction
-	if rf, ok := ret.Get(0).(func(dbutil.Transaction, string) []transaction.Transaction); ok {
-		r0 = rf(txn, name)
+	if rf, ok := ret.Get(0).(func(dbutil.Transaction, string, transaction.TransactionFilter) []transaction.Transaction); ok {
+		r0 = rf(txn, name, filter)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]transaction.Transaction)
@@ -141,8 +634,8 @@ func (_m *Repository) GetTransactionsByName(txn dbutil.Transaction, name string)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(dbutil.Transaction, string) error); ok {
-		r1 = rf(txn, name)
+	if rf, ok := ret.Get(1).(func(dbutil.Transaction, string, transaction.TransactionFilter) error); ok {
+		r1 = rf(txn, name, filter)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -150,6 +643,20 @@ func (_m *Repository) GetTransactionsByName(txn dbutil.Transaction, name string)
 	return r0, r1
 }
 
+// LockAuditLog provides a mock function with given fields: txn
+func (_m *Repository) LockAuditLog(txn dbutil.Transaction) error {
+	ret := _m.Called(txn)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(dbutil.Transaction) error); ok {
+		r0 = rf(txn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // LockTransactions provides a mock function with given fields: txn
 func (_m *Repository) LockTransactions(txn dbutil.Transaction) error {
 	ret := _m.Called(txn)
@@ -163,3 +670,45 @@ func (_m *Repository) LockTransactions(txn dbutil.Transaction) error {
 
 	return r0
 }
+
+// StreamExportedEntries provides a mock function with given fields: txn, filter, fn
+func (_m *Repository) StreamExportedEntries(txn dbutil.Transaction, filter transaction.ExportFilter, fn func(transaction.ExportedEntry) error) error {
+	ret := _m.Called(txn, filter, fn)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(dbutil.Transaction, transaction.ExportFilter, func(transaction.ExportedEntry) error) error); ok {
+		r0 = rf(txn, filter, fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateAccountStatus provides a mock function with given fields: txn, accountId, status
+func (_m *Repository) UpdateAccountStatus(txn dbutil.Transaction, accountId uuid.UUID, status string) error {
+	ret := _m.Called(txn, accountId, status)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(dbutil.Transaction, uuid.UUID, string) error); ok {
+		r0 = rf(txn, accountId, status)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdatePaymentRequestStatus provides a mock function with given fields: txn, id, status, transactionId
+func (_m *Repository) UpdatePaymentRequestStatus(txn dbutil.Transaction, id uuid.UUID, status string, transactionId uuid.NullUUID) error {
+	ret := _m.Called(txn, id, status, transactionId)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(dbutil.Transaction, uuid.UUID, string, uuid.NullUUID) error); ok {
+		r0 = rf(txn, id, status, transactionId)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
@@ -3,10 +3,16 @@
 package transaction
 
 import (
+	context "context"
+
+	uuid "github.com/google/uuid"
+	transaction "github.com/nogurenn/cph-wallet/transaction"
 	decimal "github.com/shopspring/decimal"
 	mock "github.com/stretchr/testify/mock"
 
-	transaction "github.com/nogurenn/cph-wallet/transaction"
+	null "gopkg.in/guregu/null.v4"
+
+	time "time"
 )
 
 // Service is an autogenerated mock type for the Service type
@@ -14,13 +20,147 @@ type Service struct {
 	mock.Mock
 }
 
-// CreateAccount provides a mock function with given fields: username
-func (_m *Service) CreateAccount(username string) error {
-	ret := _m.Called(username)
+// AcceptPaymentRequest provides a mock function with given fields: ctx, id, payerUsername
+func (_m *Service) AcceptPaymentRequest(ctx context.Context, id uuid.UUID, payerUsername string) (*transaction.PaymentRequest, error) {
+	ret := _m.Called(ctx, id, payerUsername)
+
+	var r0 *transaction.PaymentRequest
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, string) *transaction.PaymentRequest); ok {
+		r0 = rf(ctx, id, payerUsername)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*transaction.PaymentRequest)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID, string) error); ok {
+		r1 = rf(ctx, id, payerUsername)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CancelPaymentRequest provides a mock function with given fields: ctx, id, requesterUsername
+func (_m *Service) CancelPaymentRequest(ctx context.Context, id uuid.UUID, requesterUsername string) (*transaction.PaymentRequest, error) {
+	ret := _m.Called(ctx, id, requesterUsername)
+
+	var r0 *transaction.PaymentRequest
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, string) *transaction.PaymentRequest); ok {
+		r0 = rf(ctx, id, requesterUsername)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*transaction.PaymentRequest)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID, string) error); ok {
+		r1 = rf(ctx, id, requesterUsername)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CloseAccount provides a mock function with given fields: ctx, username, reason
+func (_m *Service) CloseAccount(ctx context.Context, username string, reason string) error {
+	ret := _m.Called(ctx, username, reason)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, username, reason)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CreateAccount provides a mock function with given fields: ctx, username
+func (_m *Service) CreateAccount(ctx context.Context, username string) error {
+	ret := _m.Called(ctx, username)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, username)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CreatePaymentRequest provides a mock function with given fields: ctx, requesterUsername, payerUsername, amount, memo, expiresAt
+func (_m *Service) CreatePaymentRequest(ctx context.Context, requesterUsername string, payerUsername string, amount decimal.Decimal, memo null.String, expiresAt time.Time) (*transaction.PaymentRequest, error) {
+	ret := _m.Called(ctx, requesterUsername, payerUsername, amount, memo, expiresAt)
+
+	var r0 *transaction.PaymentRequest
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, decimal.Decimal, null.String, time.Time) *transaction.PaymentRequest); ok {
+		r0 = rf(ctx, requesterUsername, payerUsername, amount, memo, expiresAt)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*transaction.PaymentRequest)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, decimal.Decimal, null.String, time.Time) error); ok {
+		r1 = rf(ctx, requesterUsername, payerUsername, amount, memo, expiresAt)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeclinePaymentRequest provides a mock function with given fields: ctx, id, payerUsername
+func (_m *Service) DeclinePaymentRequest(ctx context.Context, id uuid.UUID, payerUsername string) (*transaction.PaymentRequest, error) {
+	ret := _m.Called(ctx, id, payerUsername)
+
+	var r0 *transaction.PaymentRequest
+	if rf, ok := ret.Get(0).(func(context.Context, uuid.UUID, string) *transaction.PaymentRequest); ok {
+		r0 = rf(ctx, id, payerUsername)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*transaction.PaymentRequest)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, uuid.UUID, string) error); ok {
+		r1 = rf(ctx, id, payerUsername)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Deposit provides a mock function with given fields: ctx, username, amount
+func (_m *Service) Deposit(ctx context.Context, username string, amount decimal.Decimal) error {
+	ret := _m.Called(ctx, username, amount)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, decimal.Decimal) error); ok {
+		r0 = rf(ctx, username, amount)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ExportEntries provides a mock function with given fields: ctx, filter, fn
+func (_m *Service) ExportEntries(ctx context.Context, filter transaction.ExportFilter, fn func(transaction.ExportedEntry) error) error {
+	ret := _m.Called(ctx, filter, fn)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string) error); ok {
-		r0 = rf(username)
+	if rf, ok := ret.Get(0).(func(context.Context, transaction.ExportFilter, func(transaction.ExportedEntry) error) error); ok {
+		r0 = rf(ctx, filter, fn)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -28,13 +168,13 @@ func (_m *Service) CreateAccount(username string) error {
 	return r0
 }
 
-// Deposit provides a mock function with given fields: username, amount
-func (_m *Service) Deposit(username string, amount decimal.Decimal) error {
-	ret := _m.Called(username, amount)
+// FreezeAccount provides a mock function with given fields: ctx, username, reason
+func (_m *Service) FreezeAccount(ctx context.Context, username string, reason string) error {
+	ret := _m.Called(ctx, username, reason)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string, decimal.Decimal) error); ok {
-		r0 = rf(username, amount)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, username, reason)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -42,13 +182,128 @@ func (_m *Service) Deposit(username string, amount decimal.Decimal) error {
 	return r0
 }
 
-// GetAccounts provides a mock function with given fields:
-func (_m *Service) GetAccounts() ([]transaction.Account, error) {
-	ret := _m.Called()
+// GetAccount provides a mock function with given fields: ctx, username
+func (_m *Service) GetAccount(ctx context.Context, username string) (*transaction.Account, error) {
+	ret := _m.Called(ctx, username)
+
+	var r0 *transaction.Account
+	if rf, ok := ret.Get(0).(func(context.Context, string) *transaction.Account); ok {
+		r0 = rf(ctx, username)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*transaction.Account)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, username)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetAccountAsOf provides a mock function with given fields: ctx, username, asOf
+func (_m *Service) GetAccountAsOf(ctx context.Context, username string, asOf time.Time) (*transaction.Account, error) {
+	ret := _m.Called(ctx, username, asOf)
+
+	var r0 *transaction.Account
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time) *transaction.Account); ok {
+		r0 = rf(ctx, username, asOf)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*transaction.Account)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, time.Time) error); ok {
+		r1 = rf(ctx, username, asOf)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetAccountStatement provides a mock function with given fields: ctx, username
+func (_m *Service) GetAccountStatement(ctx context.Context, username string) ([]transaction.StatementLine, error) {
+	ret := _m.Called(ctx, username)
+
+	var r0 []transaction.StatementLine
+	if rf, ok := ret.Get(0).(func(context.Context, string) []transaction.StatementLine); ok {
+		r0 = rf(ctx, username)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]transaction.StatementLine)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, username)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetAccountStatusChanges provides a mock function with given fields: ctx, username
+func (_m *Service) GetAccountStatusChanges(ctx context.Context, username string) ([]transaction.AccountStatusChange, error) {
+	ret := _m.Called(ctx, username)
+
+	var r0 []transaction.AccountStatusChange
+	if rf, ok := ret.Get(0).(func(context.Context, string) []transaction.AccountStatusChange); ok {
+		r0 = rf(ctx, username)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]transaction.AccountStatusChange)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, username)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetAccounts provides a mock function with given fields: ctx
+func (_m *Service) GetAccounts(ctx context.Context) ([]transaction.Account, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []transaction.Account
+	if rf, ok := ret.Get(0).(func(context.Context) []transaction.Account); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]transaction.Account)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetAccountsAsOf provides a mock function with given fields: ctx, asOf
+func (_m *Service) GetAccountsAsOf(ctx context.Context, asOf time.Time) ([]transaction.Account, error) {
+	ret := _m.Called(ctx, asOf)
 
 	var r0 []transaction.Account
-	if rf, ok := ret.Get(0).(func() []transaction.Account); ok {
-		r0 = rf()
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) []transaction.Account); ok {
+		r0 = rf(ctx, asOf)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]transaction.Account)
@@ -56,8 +311,54 @@ func (_m *Service) GetAccounts() ([]transaction.Account, error) {
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func() error); ok {
-		r1 = rf()
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time) error); ok {
+		r1 = rf(ctx, asOf)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetAuditLog provides a mock function with given fields: ctx, filter
+func (_m *Service) GetAuditLog(ctx context.Context, filter transaction.AuditFilter) ([]transaction.AuditRecord, error) {
+	ret := _m.Called(ctx, filter)
+
+	var r0 []transaction.AuditRecord
+	if rf, ok := ret.Get(0).(func(context.Context, transaction.AuditFilter) []transaction.AuditRecord); ok {
+		r0 = rf(ctx, filter)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]transaction.AuditRecord)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, transaction.AuditFilter) error); ok {
+		r1 = rf(ctx, filter)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetPaymentRequests provides a mock function with given fields: ctx, filter
+func (_m *Service) GetPaymentRequests(ctx context.Context, filter transaction.PaymentRequestFilter) ([]transaction.PaymentRequest, error) {
+	ret := _m.Called(ctx, filter)
+
+	var r0 []transaction.PaymentRequest
+	if rf, ok := ret.Get(0).(func(context.Context, transaction.PaymentRequestFilter) []transaction.PaymentRequest); ok {
+		r0 = rf(ctx, filter)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]transaction.PaymentRequest)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, transaction.PaymentRequestFilter) error); ok {
+		r1 = rf(ctx, filter)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -65,13 +366,13 @@ func (_m *Service) GetAccounts() ([]transaction.Account, error) {
 	return r0, r1
 }
 
-// GetPaymentTransactions provides a mock function with given fields:
-func (_m *Service) GetPaymentTransactions() ([]transaction.Transaction, error) {
-	ret := _m.Called()
+// GetPaymentTransactions provides a mock function with given fields: ctx, filter
+func (_m *Service) GetPaymentTransactions(ctx context.Context, filter transaction.TransactionFilter) ([]transaction.Transaction, error) {
+	ret := _m.Called(ctx, filter)
 
 	var r0 []transaction.Transaction
-	if rf, ok := ret.Get(0).(func() []transaction.Transaction); ok {
-		r0 = rf()
+	if rf, ok := ret.Get(0).(func(context.Context, transaction.TransactionFilter) []transaction.Transaction); ok {
+		r0 = rf(ctx, filter)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]transaction.Transaction)
@@ -79,8 +380,119 @@ func (_m *Service) GetPaymentTransactions() ([]transaction.Transaction, error) {
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func() error); ok {
-		r1 = rf()
+	if rf, ok := ret.Get(1).(func(context.Context, transaction.TransactionFilter) error); ok {
+		r1 = rf(ctx, filter)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ImportAccounts provides a mock function with given fields: ctx, rows, dryRun
+func (_m *Service) ImportAccounts(ctx context.Context, rows []transaction.ImportRow, dryRun bool) (*transaction.ImportReport, error) {
+	ret := _m.Called(ctx, rows, dryRun)
+
+	var r0 *transaction.ImportReport
+	if rf, ok := ret.Get(0).(func(context.Context, []transaction.ImportRow, bool) *transaction.ImportReport); ok {
+		r0 = rf(ctx, rows, dryRun)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*transaction.ImportReport)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, []transaction.ImportRow, bool) error); ok {
+		r1 = rf(ctx, rows, dryRun)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Reconcile provides a mock function with given fields: ctx
+func (_m *Service) Reconcile(ctx context.Context) ([]transaction.Discrepancy, error) {
+	ret := _m.Called(ctx)
+
+	var r0 []transaction.Discrepancy
+	if rf, ok := ret.Get(0).(func(context.Context) []transaction.Discrepancy); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]transaction.Discrepancy)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SendPayment provides a mock function with given fields: ctx, fromUsername, toUsername, amount, details
+func (_m *Service) SendPayment(ctx context.Context, fromUsername string, toUsername string, amount decimal.Decimal, details transaction.PaymentDetails) error {
+	ret := _m.Called(ctx, fromUsername, toUsername, amount, details)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, decimal.Decimal, transaction.PaymentDetails) error); ok {
+		r0 = rf(ctx, fromUsername, toUsername, amount, details)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// StreamAccountEvents provides a mock function with given fields: ctx, username, lastEventId, fn
+func (_m *Service) StreamAccountEvents(ctx context.Context, username string, lastEventId null.Int, fn func(transaction.AccountEvent) error) error {
+	ret := _m.Called(ctx, username, lastEventId, fn)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, null.Int, func(transaction.AccountEvent) error) error); ok {
+		r0 = rf(ctx, username, lastEventId, fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UnfreezeAccount provides a mock function with given fields: ctx, username, reason
+func (_m *Service) UnfreezeAccount(ctx context.Context, username string, reason string) error {
+	ret := _m.Called(ctx, username, reason)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, username, reason)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// VerifyAuditLog provides a mock function with given fields: ctx
+func (_m *Service) VerifyAuditLog(ctx context.Context) (*transaction.AuditChainBreak, error) {
+	ret := _m.Called(ctx)
+
+	var r0 *transaction.AuditChainBreak
+	if rf, ok := ret.Get(0).(func(context.Context) *transaction.AuditChainBreak); ok {
+		r0 = rf(ctx)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*transaction.AuditChainBreak)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -1,10 +1,11 @@
 #!/bin/bash -e
 
-rm -rf bin/wallet
+rm -rf bin/wallet bin/walletctl
 
 ldflags=""
 if [ "$STAGE" == "production" ]; then
   ldflags="-s -w"
 fi
 
-GOOS=linux CGO_ENABLED=0 go build -ldflags="$ldflags" -o bin/wallet main.go
\ No newline at end of file
+GOOS=linux CGO_ENABLED=0 go build -ldflags="$ldflags" -o bin/wallet main.go
+GOOS=linux CGO_ENABLED=0 go build -ldflags="$ldflags" -o bin/walletctl ./cmd/walletctl
\ No newline at end of file
@@ -0,0 +1,52 @@
+// Package migrations embeds the SQL migration files, so that the binary knows which schema version it expects.
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"strconv"
+
+	"github.com/nogurenn/cph-wallet/dbutil"
+)
+
+// WalletSchema is the schema the Wallet migrations are applied to, and the directory they are under.
+const WalletSchema = "wallet"
+
+// Wallet holds the migrations of the wallet schema.
+//
+//go:embed wallet/*.sql
+var Wallet embed.FS
+
+// WalletFiles returns the migrations of the wallet schema at the root of the file system, as dbutil.Migrator
+// expects them.
+func WalletFiles() fs.FS {
+	files, err := fs.Sub(Wallet, WalletSchema)
+	if err != nil {
+		panic(err) // the directory is embedded, so this cannot happen
+	}
+	return files
+}
+
+// LatestVersion returns the highest version among the versioned migrations in dir, as dbutil.LoadMigrations reads
+// them, or zero if there are none.
+func LatestVersion(fsys fs.FS, dir string) (int, error) {
+	files, err := fs.Sub(fsys, dir)
+	if err != nil {
+		return 0, err
+	}
+	loaded, err := dbutil.LoadMigrations(files)
+	if err != nil {
+		return 0, err
+	}
+	if len(loaded) == 0 {
+		return 0, nil
+	}
+
+	latest := loaded[len(loaded)-1]
+	version, err := strconv.Atoi(latest.Version)
+	if err != nil {
+		return 0, fmt.Errorf("migration %s: %w", latest.Script, err)
+	}
+	return version, nil
+}
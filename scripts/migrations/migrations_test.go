@@ -0,0 +1,62 @@
+package migrations_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nogurenn/cph-wallet/scripts/migrations"
+)
+
+func Test_LatestVersion_Success(t *testing.T) {
+	// given
+	fsys := fstest.MapFS{
+		"wallet/V0001__init.sql":                  {},
+		"wallet/V0012__add_column.sql":            {},
+		"wallet/V0003__create_accounts_table.sql": {},
+		"wallet/R__refresh_views.sql":             {},
+		"wallet/README.md":                        {},
+	}
+
+	// when
+	version, err := migrations.LatestVersion(fsys, "wallet")
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, 12, version)
+}
+
+func Test_LatestVersion_SameVersion(t *testing.T) {
+	// given
+	fsys := fstest.MapFS{
+		"wallet/V0001__init.sql":       {},
+		"wallet/V1__init_again.sql":    {},
+		"wallet/V0002__add_column.sql": {},
+	}
+
+	// when
+	_, err := migrations.LatestVersion(fsys, "wallet")
+
+	// then
+	assert.Error(t, err)
+}
+
+func Test_LatestVersion_Embedded(t *testing.T) {
+	// when
+	version, err := migrations.LatestVersion(migrations.Wallet, migrations.WalletSchema)
+
+	// then
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, version, 8)
+}
+
+func Test_WalletFiles_Embedded(t *testing.T) {
+	// when
+	version, err := migrations.LatestVersion(migrations.WalletFiles(), ".")
+
+	// then
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, version, 8)
+}
@@ -0,0 +1,6 @@
+-- account lifecycle: active -> frozen -> active, active|frozen -> closed
+ALTER TABLE accounts
+    ADD COLUMN status TEXT NOT NULL DEFAULT 'active'
+        CONSTRAINT chk_accounts_status CHECK (status IN ('active', 'frozen', 'closed'));
+
+CREATE INDEX idx_accounts_status ON accounts (status);
@@ -0,0 +1,24 @@
+-- audit trail of account status transitions
+CREATE TABLE account_status_changes
+(
+    id          UUID PRIMARY KEY,
+    account_id  UUID                     NOT NULL,
+    from_status TEXT                     NOT NULL,
+    to_status   TEXT                     NOT NULL,
+    reason      TEXT                     NOT NULL,
+    created_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    updated_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+
+    CONSTRAINT fk_account_status_changes_account_id
+        FOREIGN KEY (account_id) REFERENCES accounts (id)
+            ON UPDATE RESTRICT
+            ON DELETE RESTRICT
+);
+
+CREATE INDEX idx_account_status_changes_account_id ON account_status_changes (account_id);
+
+CREATE TRIGGER set_updated_at_account_status_changes
+    BEFORE UPDATE
+    ON account_status_changes
+    FOR EACH ROW
+EXECUTE FUNCTION set_updated_at_to_now();
@@ -0,0 +1,4 @@
+-- client-supplied key so that retried deposits and payments are recorded at most once
+ALTER TABLE transactions
+    ADD COLUMN idempotency_key TEXT
+        CONSTRAINT uq_transactions_idempotency_key UNIQUE;
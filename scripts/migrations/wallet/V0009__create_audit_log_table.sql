@@ -0,0 +1,41 @@
+-- rejects changes to rows of append-only tables
+CREATE OR REPLACE FUNCTION forbid_modification()
+RETURNS TRIGGER AS $$
+BEGIN
+  RAISE EXCEPTION '% on %.% is forbidden, rows are append-only', TG_OP, TG_TABLE_SCHEMA, TG_TABLE_NAME;
+END;
+$$ LANGUAGE 'plpgsql';
+
+-- who did what: one row per mutating call, hash-chained in seq order so that edits and deletions are detectable
+CREATE TABLE audit_log
+(
+    seq          BIGINT PRIMARY KEY CHECK (seq > 0),
+    actor        TEXT                     NOT NULL,
+    action       TEXT                     NOT NULL,
+    -- username of the account acted upon, and of the other party for payments
+    account      TEXT                     NOT NULL,
+    counterparty TEXT,
+    -- sha256 of the call arguments
+    payload_hash TEXT                     NOT NULL,
+    -- 'ok', or the error the call was rejected with
+    outcome      TEXT                     NOT NULL,
+    created_at   TIMESTAMP WITH TIME ZONE NOT NULL,
+    prev_hash    TEXT                     NOT NULL,
+    hash         TEXT                     NOT NULL
+);
+
+CREATE INDEX idx_audit_log_account ON audit_log (account);
+CREATE INDEX idx_audit_log_counterparty ON audit_log (counterparty);
+CREATE INDEX idx_audit_log_created_at ON audit_log (created_at);
+
+CREATE TRIGGER forbid_modification_audit_log
+    BEFORE UPDATE OR DELETE
+    ON audit_log
+    FOR EACH ROW
+EXECUTE FUNCTION forbid_modification();
+
+CREATE TRIGGER forbid_truncate_audit_log
+    BEFORE TRUNCATE
+    ON audit_log
+    FOR EACH STATEMENT
+EXECUTE FUNCTION forbid_modification();
@@ -0,0 +1,99 @@
+-- per-account hash chain over ledger entries: each entry stores the hash of the entry before it on the same account,
+-- in seq order, and a hash of its own content including that link, so that edits and deletions are detectable
+ALTER TABLE transaction_entries
+    ADD COLUMN seq       BIGINT,
+    ADD COLUMN prev_hash TEXT,
+    ADD COLUMN hash      TEXT;
+
+-- hex-encoded sha256 of the fields of an entry, joined by '|'; transaction/chain.go computes the same to verify it
+CREATE OR REPLACE FUNCTION transaction_entry_hash(entry transaction_entries)
+RETURNS TEXT AS $$
+BEGIN
+  RETURN encode(sha256(convert_to(concat_ws('|',
+    entry.seq,
+    entry.prev_hash,
+    entry.id,
+    entry.transaction_id,
+    entry.account_id,
+    COALESCE(entry.target_account_id::text, ''),
+    entry.name,
+    entry.credit,
+    entry.debit,
+    to_char(entry.created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')
+  ), 'UTF8')), 'hex');
+END;
+$$ LANGUAGE 'plpgsql' IMMUTABLE;
+
+-- entries are not updated anymore once chained below
+DROP TRIGGER set_updated_at_transaction_entries ON transaction_entries;
+
+-- chain existing entries in the order they were recorded
+DO $$
+DECLARE
+  entry        transaction_entries;
+  last_account UUID;
+  last_seq     BIGINT;
+  last_hash    TEXT;
+BEGIN
+  FOR entry IN SELECT * FROM transaction_entries ORDER BY account_id, created_at, id LOOP
+    IF last_account IS DISTINCT FROM entry.account_id THEN
+      last_account := entry.account_id;
+      last_seq := 0;
+      last_hash := repeat('0', 64);
+    END IF;
+
+    entry.seq := last_seq + 1;
+    entry.prev_hash := last_hash;
+    entry.hash := transaction_entry_hash(entry);
+    UPDATE transaction_entries SET seq = entry.seq, prev_hash = entry.prev_hash, hash = entry.hash WHERE id = entry.id;
+
+    last_seq := entry.seq;
+    last_hash := entry.hash;
+  END LOOP;
+END;
+$$;
+
+ALTER TABLE transaction_entries
+    ALTER COLUMN seq SET NOT NULL,
+    ALTER COLUMN prev_hash SET NOT NULL,
+    ALTER COLUMN hash SET NOT NULL,
+    ADD CONSTRAINT uq_transaction_entries_account_id_seq UNIQUE (account_id, seq);
+
+-- links new entries to the last one of their account. Writers lock the account row beforehand, so entries of an
+-- account are inserted one at a time; the unique constraint above rejects any that are not.
+CREATE OR REPLACE FUNCTION chain_transaction_entry()
+RETURNS TRIGGER AS $$
+DECLARE
+  last transaction_entries;
+BEGIN
+  SELECT * INTO last FROM transaction_entries WHERE account_id = NEW.account_id ORDER BY seq DESC LIMIT 1;
+  IF FOUND THEN
+    NEW.seq := last.seq + 1;
+    NEW.prev_hash := last.hash;
+  ELSE
+    NEW.seq := 1;
+    NEW.prev_hash := repeat('0', 64);
+  END IF;
+  NEW.hash := transaction_entry_hash(NEW);
+  RETURN NEW;
+END;
+$$ LANGUAGE 'plpgsql';
+
+CREATE TRIGGER chain_transaction_entries
+    BEFORE INSERT
+    ON transaction_entries
+    FOR EACH ROW
+EXECUTE FUNCTION chain_transaction_entry();
+
+-- entries are append-only: corrections are recorded as new transactions
+CREATE TRIGGER forbid_modification_transaction_entries
+    BEFORE UPDATE OR DELETE
+    ON transaction_entries
+    FOR EACH ROW
+EXECUTE FUNCTION forbid_modification();
+
+CREATE TRIGGER forbid_truncate_transaction_entries
+    BEFORE TRUNCATE
+    ON transaction_entries
+    FOR EACH STATEMENT
+EXECUTE FUNCTION forbid_modification();
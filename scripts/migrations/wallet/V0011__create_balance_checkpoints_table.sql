@@ -0,0 +1,21 @@
+-- balance of every account as of a point in time, so that point-in-time balances only sum the entries recorded
+-- after the latest checkpoint before it
+CREATE TABLE balance_checkpoints
+(
+    account_id UUID                     NOT NULL,
+    as_of      TIMESTAMP WITH TIME ZONE NOT NULL,
+    balance    DECIMAL(32, 8)           NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+
+    PRIMARY KEY (account_id, as_of),
+
+    CONSTRAINT fk_balance_checkpoints_account_id
+        FOREIGN KEY (account_id) REFERENCES accounts (id)
+            ON UPDATE RESTRICT
+            ON DELETE RESTRICT
+);
+
+CREATE INDEX idx_balance_checkpoints_as_of ON balance_checkpoints (as_of);
+
+-- entries of an account within a time range, for point-in-time balances and checkpoints
+CREATE INDEX idx_transaction_entries_account_id_created_at ON transaction_entries (account_id, created_at);
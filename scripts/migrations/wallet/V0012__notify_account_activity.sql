@@ -0,0 +1,15 @@
+-- tells listeners on channel account_activity which accounts have new entries. Notifications are only delivered
+-- once the db transaction commits, and only once per account and db transaction.
+CREATE OR REPLACE FUNCTION notify_account_activity()
+RETURNS TRIGGER AS $$
+BEGIN
+  PERFORM pg_notify('account_activity', NEW.account_id::text);
+  RETURN NULL;
+END;
+$$ LANGUAGE 'plpgsql';
+
+CREATE TRIGGER notify_account_activity
+    AFTER INSERT
+    ON transaction_entries
+    FOR EACH ROW
+EXECUTE FUNCTION notify_account_activity();
@@ -0,0 +1,32 @@
+-- what senders tell about their payments: a free-form memo, a reference into their own systems such as an invoice
+-- number, and arbitrary metadata
+ALTER TABLE transactions
+    ADD COLUMN description        TEXT,
+    ADD COLUMN external_reference TEXT,
+    ADD COLUMN metadata           JSONB,
+    ADD COLUMN sender_id          UUID
+        CONSTRAINT fk_transactions_sender_id
+            REFERENCES accounts (id)
+            ON UPDATE RESTRICT
+            ON DELETE RESTRICT;
+
+-- the sender of a payment owns its outgoing entry. Payments are not changed by this, so they keep their updated_at.
+ALTER TABLE transactions DISABLE TRIGGER set_updated_at_transactions;
+
+UPDATE transactions t
+SET sender_id = te.account_id
+FROM transaction_entries te
+WHERE te.transaction_id = t.id
+  AND t.name = 'payment'
+  AND te.name = 'outgoing';
+
+ALTER TABLE transactions ENABLE TRIGGER set_updated_at_transactions;
+
+-- external references only need to be unique among the payments of one sender
+CREATE UNIQUE INDEX uq_transactions_sender_id_external_reference
+    ON transactions (sender_id, external_reference)
+    WHERE external_reference IS NOT NULL;
+
+CREATE INDEX idx_transactions_external_reference
+    ON transactions (external_reference)
+    WHERE external_reference IS NOT NULL;
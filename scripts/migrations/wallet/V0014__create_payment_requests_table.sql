@@ -0,0 +1,47 @@
+-- requests by one account for another to pay it: pending -> paid|declined|expired|cancelled
+CREATE TABLE payment_requests
+(
+    id             UUID PRIMARY KEY,
+    requester_id   UUID                     NOT NULL,
+    payer_id       UUID                     NOT NULL,
+    amount         DECIMAL(32, 8)           NOT NULL CHECK (amount > 0.0),
+    memo           TEXT,
+    status         TEXT                     NOT NULL DEFAULT 'pending'
+        CONSTRAINT chk_payment_requests_status CHECK (status IN ('pending', 'paid', 'declined', 'expired', 'cancelled')),
+    expires_at     TIMESTAMP WITH TIME ZONE NOT NULL,
+    -- the payment that settled the request, once paid
+    transaction_id UUID,
+    created_at     TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    updated_at     TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+
+    CONSTRAINT chk_payment_requests_parties CHECK (requester_id <> payer_id),
+
+    CONSTRAINT chk_payment_requests_transaction_id CHECK ((status = 'paid') = (transaction_id IS NOT NULL)),
+
+    CONSTRAINT fk_payment_requests_requester_id
+        FOREIGN KEY (requester_id) REFERENCES accounts (id)
+            ON UPDATE RESTRICT
+            ON DELETE RESTRICT,
+
+    CONSTRAINT fk_payment_requests_payer_id
+        FOREIGN KEY (payer_id) REFERENCES accounts (id)
+            ON UPDATE RESTRICT
+            ON DELETE RESTRICT,
+
+    CONSTRAINT fk_payment_requests_transaction_id
+        FOREIGN KEY (transaction_id) REFERENCES transactions (id)
+            ON UPDATE RESTRICT
+            ON DELETE RESTRICT
+);
+
+CREATE INDEX idx_payment_requests_requester_id ON payment_requests (requester_id, created_at);
+CREATE INDEX idx_payment_requests_payer_id ON payment_requests (payer_id, created_at);
+
+-- pending requests by expiry, for the sweeper
+CREATE INDEX idx_payment_requests_expires_at ON payment_requests (expires_at) WHERE status = 'pending';
+
+CREATE TRIGGER set_updated_at_payment_requests
+    BEFORE UPDATE
+    ON payment_requests
+    FOR EACH ROW
+EXECUTE FUNCTION set_updated_at_to_now();
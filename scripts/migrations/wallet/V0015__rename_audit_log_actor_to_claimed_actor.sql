@@ -0,0 +1,3 @@
+-- the actor of a call is whoever its caller claims to act on behalf of, which the service does not verify
+ALTER TABLE audit_log
+    RENAME COLUMN actor TO claimed_actor;
@@ -0,0 +1,86 @@
+// Package seed loads accounts, deposits and payments from a YAML fixtures file through transaction.Service.
+package seed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/shopspring/decimal"
+	"gopkg.in/guregu/null.v4"
+	"gopkg.in/yaml.v3"
+
+	"github.com/nogurenn/cph-wallet/transaction"
+)
+
+// Fixtures describes the data to seed, applied in order: accounts, then deposits, then payments.
+type Fixtures struct {
+	Accounts []string  `yaml:"accounts"`
+	Deposits []Deposit `yaml:"deposits"`
+	Payments []Payment `yaml:"payments"`
+}
+
+type Deposit struct {
+	Account string          `yaml:"account"`
+	Amount  decimal.Decimal `yaml:"amount"`
+}
+
+type Payment struct {
+	From              string          `yaml:"from"`
+	To                string          `yaml:"to"`
+	Amount            decimal.Decimal `yaml:"amount"`
+	Description       string          `yaml:"description"`
+	ExternalReference string          `yaml:"external_reference"`
+}
+
+// Load reads Fixtures from the YAML file at path. Unknown keys are rejected to catch typos.
+func Load(path string) (*Fixtures, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+
+	var fixtures Fixtures
+	if err := decoder.Decode(&fixtures); err != nil {
+		return nil, fmt.Errorf("fixtures %s: %w", path, err)
+	}
+	return &fixtures, nil
+}
+
+// Apply seeds fixtures through s. It is safe to rerun: existing accounts are skipped, and every deposit and payment
+// carries an idempotency key derived from its position in the file, so it is recorded only once. Appending entries
+// is therefore safe, while reordering or removing them makes later entries be recorded again.
+func Apply(ctx context.Context, s transaction.Service, fixtures *Fixtures) error {
+	for _, username := range fixtures.Accounts {
+		err := s.CreateAccount(ctx, username)
+		if err != nil && !errors.Is(err, transaction.ErrAccountAlreadyExists) {
+			return fmt.Errorf("account %s: %w", username, err)
+		}
+	}
+
+	for i, deposit := range fixtures.Deposits {
+		keyCtx := transaction.WithIdempotencyKey(ctx, fmt.Sprintf("seed:deposit:%d", i))
+		err := s.Deposit(keyCtx, deposit.Account, deposit.Amount)
+		if err != nil {
+			return fmt.Errorf("deposit #%d to %s: %w", i, deposit.Account, err)
+		}
+	}
+
+	for i, payment := range fixtures.Payments {
+		keyCtx := transaction.WithIdempotencyKey(ctx, fmt.Sprintf("seed:payment:%d", i))
+		err := s.SendPayment(keyCtx, payment.From, payment.To, payment.Amount, transaction.PaymentDetails{
+			Description:       null.NewString(payment.Description, payment.Description != ""),
+			ExternalReference: null.NewString(payment.ExternalReference, payment.ExternalReference != ""),
+		})
+		if err != nil {
+			return fmt.Errorf("payment #%d from %s to %s: %w", i, payment.From, payment.To, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package seed_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/guregu/null.v4"
+
+	mocktransaction "github.com/nogurenn/cph-wallet/mocks/autogen/transaction"
+	"github.com/nogurenn/cph-wallet/seed"
+	"github.com/nogurenn/cph-wallet/transaction"
+)
+
+func writeFixtures(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "fixtures.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func withIdempotencyKey(key string) interface{} {
+	return mock.MatchedBy(func(ctx context.Context) bool {
+		return transaction.IdempotencyKeyFrom(ctx) == key
+	})
+}
+
+func amountOf(value float64) interface{} {
+	return mock.MatchedBy(func(amount decimal.Decimal) bool {
+		return amount.Equal(decimal.NewFromFloat(value))
+	})
+}
+
+func Test_Load_Success(t *testing.T) {
+	// given
+	path := writeFixtures(t, `
+accounts: [bob123, alice456]
+deposits:
+  - account: bob123
+    amount: "200.00"
+payments:
+  - from: bob123
+    to: alice456
+    amount: 60.41
+`)
+
+	// when
+	fixtures, err := seed.Load(path)
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bob123", "alice456"}, fixtures.Accounts)
+	require.Len(t, fixtures.Deposits, 1)
+	assert.Equal(t, "bob123", fixtures.Deposits[0].Account)
+	assert.True(t, fixtures.Deposits[0].Amount.Equal(decimal.NewFromFloat(200.00)))
+	require.Len(t, fixtures.Payments, 1)
+	assert.Equal(t, "alice456", fixtures.Payments[0].To)
+	assert.True(t, fixtures.Payments[0].Amount.Equal(decimal.NewFromFloat(60.41)))
+}
+
+func Test_Load_UnknownField(t *testing.T) {
+	// given
+	path := writeFixtures(t, `
+payments:
+  - from: bob123
+    target: alice456
+    amount: "60.41"
+`)
+
+	// when
+	fixtures, err := seed.Load(path)
+
+	// then
+	assert.Nil(t, fixtures)
+	assert.Error(t, err)
+}
+
+func Test_Load_DevFixtures(t *testing.T) {
+	// when
+	fixtures, err := seed.Load("../scripts/fixtures/dev.yaml")
+
+	// then
+	require.NoError(t, err)
+	assert.Len(t, fixtures.Accounts, 3)
+	assert.Len(t, fixtures.Deposits, 3)
+	assert.Len(t, fixtures.Payments, 4)
+}
+
+func Test_Apply_Rerun(t *testing.T) {
+	// given
+	fixtures := &seed.Fixtures{
+		Accounts: []string{"bob123", "alice456"},
+		Deposits: []seed.Deposit{{Account: "bob123", Amount: decimal.NewFromFloat(200.00)}},
+		Payments: []seed.Payment{
+			{From: "bob123", To: "alice456", Amount: decimal.NewFromFloat(60.41), Description: "Dinner split"},
+			{From: "alice456", To: "bob123", Amount: decimal.NewFromFloat(10.00)},
+		},
+	}
+
+	s := new(mocktransaction.Service)
+	s.On("CreateAccount", mock.Anything, "bob123").Return(transaction.ErrAccountAlreadyExists).Once()
+	s.On("CreateAccount", mock.Anything, "alice456").Return(transaction.ErrAccountAlreadyExists).Once()
+	s.On("Deposit", withIdempotencyKey("seed:deposit:0"), "bob123", amountOf(200.00)).Return(nil).Once()
+	s.On("SendPayment", withIdempotencyKey("seed:payment:0"), "bob123", "alice456", amountOf(60.41), transaction.PaymentDetails{
+		Description: null.StringFrom("Dinner split"),
+	}).Return(nil).Once()
+	s.On("SendPayment", withIdempotencyKey("seed:payment:1"), "alice456", "bob123", amountOf(10.00), transaction.PaymentDetails{}).Return(nil).Once()
+
+	// when
+	err := seed.Apply(context.Background(), s, fixtures)
+
+	// then
+	assert.NoError(t, err)
+
+	s.AssertExpectations(t)
+}
+
+func Test_Apply_PaymentFailed(t *testing.T) {
+	// given
+	fixtures := &seed.Fixtures{
+		Payments: []seed.Payment{{From: "bob123", To: "alice456", Amount: decimal.NewFromFloat(1000.00)}},
+	}
+
+	s := new(mocktransaction.Service)
+	s.On("SendPayment", mock.Anything, "bob123", "alice456", mock.Anything, transaction.PaymentDetails{}).Return(transaction.ErrBalanceInsufficient).Once()
+
+	// when
+	err := seed.Apply(context.Background(), s, fixtures)
+
+	// then
+	assert.True(t, errors.Is(err, transaction.ErrBalanceInsufficient))
+	assert.Contains(t, err.Error(), "payment #0 from bob123 to alice456")
+
+	s.AssertExpectations(t)
+}
@@ -0,0 +1,49 @@
+package tracing_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+
+	"github.com/nogurenn/cph-wallet/tracing"
+)
+
+func Test_Setup_Stdout(t *testing.T) {
+	// given
+	var out bytes.Buffer
+	shutdown, err := tracing.Setup(context.Background(), tracing.Config{
+		ServiceName: "cph-wallet",
+		Exporter:    tracing.ExporterStdout,
+		Stdout:      &out,
+	})
+	require.NoError(t, err)
+
+	// when
+	ctx, span := otel.Tracer("test").Start(context.Background(), "send_payment")
+	traceID := tracing.TraceID(ctx)
+	span.End()
+	err = shutdown(context.Background())
+
+	// then
+	assert.NoError(t, err)
+	assert.Len(t, traceID, 32)
+	assert.Contains(t, out.String(), `"Name": "send_payment"`)
+	assert.Contains(t, out.String(), traceID)
+}
+
+func Test_Setup_UnknownExporter(t *testing.T) {
+	// when
+	shutdown, err := tracing.Setup(context.Background(), tracing.Config{Exporter: "zipkin"})
+
+	// then
+	assert.Nil(t, shutdown)
+	assert.Error(t, err)
+}
+
+func Test_TraceID_NoSpan(t *testing.T) {
+	assert.Equal(t, "", tracing.TraceID(context.Background()))
+}
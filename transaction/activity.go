@@ -0,0 +1,178 @@
+package transaction
+
+import (
+	"context"
+	"database/sql/driver"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4/stdlib"
+	"github.com/jmoiron/sqlx"
+)
+
+// accountActivityChannel is the channel that the database notifies with the id of an account whenever entries of
+// it are committed.
+const accountActivityChannel = "account_activity"
+
+// activityReconnectDelay is how long ActivityListener waits before listening again on a new connection.
+const activityReconnectDelay = 5 * time.Second
+
+// AccountActivity tells when new entries of an account are committed.
+type AccountActivity interface {
+	// Subscribe returns a channel that receives a value whenever new entries of the account are committed, and a
+	// function that ends the subscription. Notifications that arrive while one is pending are merged into it. The
+	// channel is closed once no more notifications will follow.
+	Subscribe(accountId uuid.UUID) (<-chan struct{}, func())
+}
+
+// ActivityListener is the AccountActivity of the database. It holds one connection of the pool to listen on, and
+// passes each notification on to the subscribers of its account.
+type ActivityListener struct {
+	db     *sqlx.DB
+	logger log.Logger
+
+	state workerState
+
+	mu          sync.Mutex
+	subscribers map[uuid.UUID]map[chan struct{}]struct{}
+	closed      bool
+}
+
+// NewActivityListener returns an ActivityListener that listens on a connection of db once it is Run. db must be
+// the primary, as notifications are not replicated.
+func NewActivityListener(db *sqlx.DB, logger log.Logger) *ActivityListener {
+	return &ActivityListener{db: db, logger: logger, subscribers: map[uuid.UUID]map[chan struct{}]struct{}{}}
+}
+
+func (l *ActivityListener) Subscribe(accountId uuid.UUID) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	if l.subscribers[accountId] == nil {
+		l.subscribers[accountId] = map[chan struct{}]struct{}{}
+	}
+	l.subscribers[accountId][ch] = struct{}{}
+
+	unsubscribe := func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		delete(l.subscribers[accountId], ch)
+		if len(l.subscribers[accountId]) == 0 {
+			delete(l.subscribers, accountId)
+		}
+	}
+	return ch, unsubscribe
+}
+
+// Run listens for notifications until ctx is done, and then closes every subscription. A lost connection is
+// replaced after activityReconnectDelay, and every subscriber is notified once listening again, since
+// notifications sent in between are lost.
+func (l *ActivityListener) Run(ctx context.Context) {
+	defer l.close()
+	defer l.state.stop()
+
+	for {
+		err := l.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		l.state.failed(time.Now(), err)
+		l.logger.Log("msg", "account activity could not be listened to", "err", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(activityReconnectDelay):
+		}
+	}
+}
+
+// listen waits for notifications on a connection of its own until it fails or ctx is done.
+func (l *ActivityListener) listen(ctx context.Context) error {
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var listenErr error
+	conn.Raw(func(driverConn interface{}) error {
+		pgxConn := driverConn.(*stdlib.Conn).Conn()
+		if _, listenErr = pgxConn.Exec(ctx, "LISTEN "+accountActivityChannel); listenErr != nil {
+			return driver.ErrBadConn
+		}
+		l.state.succeeded()
+		l.notifyAll()
+
+		for {
+			notification, err := pgxConn.WaitForNotification(ctx)
+			if err != nil {
+				listenErr = err
+				// the connection must not go back to the pool while it is still listening
+				return driver.ErrBadConn
+			}
+
+			accountId, err := uuid.Parse(notification.Payload)
+			if err != nil {
+				l.logger.Log("msg", "account activity notification is invalid", "payload", notification.Payload)
+				continue
+			}
+			l.notify(accountId)
+		}
+	})
+	return listenErr
+}
+
+// Check fails once Run returned, or when no connection has been listening for two reconnect delays, so that a
+// single reconnect does not make the process unready.
+func (l *ActivityListener) Check(_ context.Context) error {
+	return l.state.check(time.Now(), 2*activityReconnectDelay)
+}
+
+func (l *ActivityListener) notify(accountId uuid.UUID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for ch := range l.subscribers[accountId] {
+		select {
+		case ch <- struct{}{}:
+		default: // a notification is pending already
+		}
+	}
+}
+
+func (l *ActivityListener) notifyAll() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, subscribers := range l.subscribers {
+		for ch := range subscribers {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func (l *ActivityListener) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closed = true
+	for accountId, subscribers := range l.subscribers {
+		for ch := range subscribers {
+			close(ch)
+		}
+		delete(l.subscribers, accountId)
+	}
+}
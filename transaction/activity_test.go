@@ -0,0 +1,74 @@
+//go:build integration
+// +build integration
+
+package transaction_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/google/uuid"
+	"github.com/nogurenn/cph-wallet/dbutil"
+	"github.com/nogurenn/cph-wallet/transaction"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ActivityListener_NotifiedOnCommit(t *testing.T) {
+	// given
+	cfg, err := dbutil.NewConfig()
+	require.NoError(t, err)
+	db, err := dbutil.NewDb(cfg)
+	require.NoError(t, err)
+	pdb := transaction.NewPostgresDb(db)
+
+	listener := transaction.NewActivityListener(db, log.NewNopLogger())
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		listener.Run(ctx)
+		close(stopped)
+	}()
+
+	accountId := uuid.New()
+	notifications, unsubscribe := listener.Subscribe(accountId)
+	defer unsubscribe()
+
+	// the listener notifies every subscriber once it is listening
+	select {
+	case <-notifications:
+	case <-time.After(5 * time.Second):
+		t.Fatal("listener did not start listening")
+	}
+
+	// when
+	// integration tests leave no rows behind, so notifications are sent the way the trigger on entries sends them
+	txn, err := pdb.BeginTxn(context.Background(), nil)
+	require.NoError(t, err)
+	_, err = txn.Exec("SELECT pg_notify('account_activity', $1)", accountId.String())
+	require.NoError(t, err)
+	require.NoError(t, txn.Rollback())
+
+	select {
+	case <-notifications:
+		t.Fatal("notified of a rolled back transaction")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	_, err = db.Exec("SELECT pg_notify('account_activity', $1)", accountId.String())
+	require.NoError(t, err)
+
+	// then
+	select {
+	case <-notifications:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not notified after commit")
+	}
+
+	cancel()
+	<-stopped
+	_, open := <-notifications
+	assert.False(t, open)
+}
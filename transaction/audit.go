@@ -0,0 +1,162 @@
+package transaction
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nogurenn/cph-wallet/dbutil"
+	"gopkg.in/guregu/null.v4"
+)
+
+const (
+	// list of audited actions, one per mutating Service method
+	CreateAccountAuditAction   = "create_account"
+	DepositAuditAction         = "deposit"
+	SendPaymentAuditAction     = "send_payment"
+	FreezeAccountAuditAction   = "freeze_account"
+	UnfreezeAccountAuditAction = "unfreeze_account"
+	CloseAccountAuditAction    = "close_account"
+	ImportAccountsAuditAction  = "import_accounts"
+
+	CreatePaymentRequestAuditAction  = "create_payment_request"
+	AcceptPaymentRequestAuditAction  = "accept_payment_request"
+	DeclinePaymentRequestAuditAction = "decline_payment_request"
+	CancelPaymentRequestAuditAction  = "cancel_payment_request"
+
+	OkAuditOutcome = "ok"
+
+	// anonymousActor is recorded for calls whose context carries no claimed actor.
+	anonymousActor = "anonymous"
+
+	// genesisHash is the PrevHash of the first audit record, and of the first entry of every account.
+	genesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+	auditVerifyBatchSize = 1000
+)
+
+// auditedCall describes a mutating Service call for the audit log.
+type auditedCall struct {
+	action       string
+	account      string
+	counterparty string
+	payload      map[string]interface{}
+}
+
+// runAudited runs fn like TxnRunner.RunInTxn, and records the call in the audit log: in the same transaction if
+// fn succeeds, or in a transaction of its own if fn rejects the call with a domain error.
+func (s *service) runAudited(ctx context.Context, call auditedCall, isolation sql.IsolationLevel, fn func(txn dbutil.Transaction) error) error {
+	err := s.txns.RunInTxn(ctx, isolation, func(txn dbutil.Transaction) error {
+		if err := fn(txn); err != nil {
+			return err
+		}
+		return s.appendAuditRecord(ctx, txn, call, OkAuditOutcome)
+	})
+	if err != nil {
+		return s.rejectAudited(ctx, call, err)
+	}
+	return nil
+}
+
+// rejectAudited records the rejection of call with err in the audit log, if err is a domain error, and returns err.
+// Other errors are not recorded, as the database is likely unable to record anything at that point.
+func (s *service) rejectAudited(ctx context.Context, call auditedCall, err error) error {
+	if !isDomainError(err) {
+		return err
+	}
+
+	// best effort: failing to record a rejection does not change its outcome
+	_ = s.txns.RunInTxn(ctx, sql.LevelReadCommitted, func(txn dbutil.Transaction) error {
+		return s.appendAuditRecord(ctx, txn, call, err.Error())
+	})
+	return err
+}
+
+// appendAuditRecord chains a record of call to the end of the audit log.
+func (s *service) appendAuditRecord(ctx context.Context, txn dbutil.Transaction, call auditedCall, outcome string) error {
+	if err := s.db.LockAuditLog(txn); err != nil {
+		return err
+	}
+
+	last, err := s.db.GetLastAuditRecord(txn)
+	if err != nil {
+		return err
+	}
+
+	record := AuditRecord{
+		Seq:          1,
+		ClaimedActor: ClaimedActorFrom(ctx),
+		Action:       call.action,
+		Account:      strings.TrimSpace(call.account),
+		Counterparty: null.NewString(strings.TrimSpace(call.counterparty), call.counterparty != ""),
+		PayloadHash:  hashAuditPayload(call.payload),
+		Outcome:      outcome,
+		CreatedAt:    time.Now().UTC().Truncate(time.Microsecond), // precision of Postgres timestamps
+		PrevHash:     genesisHash,
+	}
+	if last != nil {
+		record.Seq = last.Seq + 1
+		record.PrevHash = last.Hash
+	}
+	record.Hash = hashAuditRecord(record)
+
+	return s.db.CreateAuditRecord(txn, record)
+}
+
+// verifyAuditRecord checks that record follows prev, which is nil for the first record.
+func verifyAuditRecord(prev *AuditRecord, record AuditRecord) *AuditChainBreak {
+	expectedSeq, expectedPrevHash := int64(1), genesisHash
+	if prev != nil {
+		expectedSeq, expectedPrevHash = prev.Seq+1, prev.Hash
+	}
+
+	switch {
+	case record.Seq != expectedSeq:
+		return &AuditChainBreak{Seq: expectedSeq, Reason: fmt.Sprintf("record is missing, next one is %d", record.Seq)}
+	case record.PrevHash != expectedPrevHash:
+		return &AuditChainBreak{Seq: record.Seq, Reason: "previous hash does not match the previous record"}
+	case record.Hash != hashAuditRecord(record):
+		return &AuditChainBreak{Seq: record.Seq, Reason: "hash does not match the contents of the record"}
+	default:
+		return nil
+	}
+}
+
+// hashAuditRecord returns the hex-encoded sha256 of every field of record but Hash.
+func hashAuditRecord(record AuditRecord) string {
+	content, _ := json.Marshal([]interface{}{
+		record.Seq,
+		record.ClaimedActor,
+		record.Action,
+		record.Account,
+		record.Counterparty,
+		record.PayloadHash,
+		record.Outcome,
+		record.CreatedAt.UTC().Format(time.RFC3339Nano),
+		record.PrevHash,
+	})
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// hashAuditPayload returns the hex-encoded sha256 of payload as JSON, whose object keys are sorted.
+func hashAuditPayload(payload map[string]interface{}) string {
+	content, _ := json.Marshal(payload)
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func isDomainError(err error) bool {
+	for _, domainErr := range domainErrors {
+		if errors.Is(err, domainErr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package transaction
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v4"
+)
+
+func Test_VerifyAuditRecord_IntactChain(t *testing.T) {
+	// given
+	records := makeAuditChain(3)
+
+	// when
+	var breaks []*AuditChainBreak
+	var prev *AuditRecord
+	for i := range records {
+		if chainBreak := verifyAuditRecord(prev, records[i]); chainBreak != nil {
+			breaks = append(breaks, chainBreak)
+		}
+		prev = &records[i]
+	}
+
+	// then
+	assert.Empty(t, breaks)
+}
+
+func Test_VerifyAuditRecord_ContentChanged(t *testing.T) {
+	// given
+	records := makeAuditChain(3)
+	records[1].Outcome = OkAuditOutcome
+	records[1].ClaimedActor = "mallory"
+
+	// when
+	chainBreak := verifyAuditRecord(&records[0], records[1])
+
+	// then
+	if assert.NotNil(t, chainBreak) {
+		assert.Equal(t, int64(2), chainBreak.Seq)
+		assert.Contains(t, chainBreak.Reason, "hash does not match")
+	}
+}
+
+func Test_VerifyAuditRecord_RehashedRecordBreaksNextLink(t *testing.T) {
+	// given
+	records := makeAuditChain(3)
+	records[1].Account = "mallory"
+	records[1].Hash = hashAuditRecord(records[1])
+
+	// when
+	chainBreak := verifyAuditRecord(&records[1], records[2])
+
+	// then
+	if assert.NotNil(t, chainBreak) {
+		assert.Equal(t, int64(3), chainBreak.Seq)
+		assert.Contains(t, chainBreak.Reason, "previous hash")
+	}
+}
+
+func Test_VerifyAuditRecord_RecordRemoved(t *testing.T) {
+	// given
+	records := makeAuditChain(3)
+
+	// when
+	chainBreak := verifyAuditRecord(&records[0], records[2])
+
+	// then
+	if assert.NotNil(t, chainBreak) {
+		assert.Equal(t, int64(2), chainBreak.Seq)
+		assert.Contains(t, chainBreak.Reason, "missing")
+	}
+}
+
+func Test_HashAuditRecord_IgnoresTimeZone(t *testing.T) {
+	// given
+	record := makeAuditChain(1)[0]
+	moved := record
+	moved.CreatedAt = record.CreatedAt.In(time.FixedZone("UTC+8", 8*60*60))
+
+	// when
+	hash, movedHash := hashAuditRecord(record), hashAuditRecord(moved)
+
+	// then
+	assert.Equal(t, hash, movedHash)
+}
+
+// --- helpers
+
+func makeAuditChain(n int) []AuditRecord {
+	records := make([]AuditRecord, 0, n)
+	prevHash := genesisHash
+	for i := 1; i <= n; i++ {
+		record := AuditRecord{
+			Seq:          int64(i),
+			ClaimedActor: "ops@example.com",
+			Action:       SendPaymentAuditAction,
+			Account:      "bob123",
+			Counterparty: null.StringFrom("alice456"),
+			PayloadHash:  hashAuditPayload(map[string]interface{}{"seq": i}),
+			Outcome:      ErrBalanceInsufficient.Error(),
+			CreatedAt:    time.Date(2022, 5, 1, 12, 0, i, 0, time.UTC),
+			PrevHash:     prevHash,
+		}
+		record.Hash = hashAuditRecord(record)
+		prevHash = record.Hash
+		records = append(records, record)
+	}
+	return records
+}
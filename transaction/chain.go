@@ -0,0 +1,104 @@
+package transaction
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/nogurenn/cph-wallet/dbutil"
+)
+
+const (
+	// EntryChainBrokenCheck is the Discrepancy check of entries that do not follow from the entries before them
+	// on the same account. Reference is the username, and Detail names the first broken link.
+	EntryChainBrokenCheck = "entry_chain_broken"
+
+	entryChainVerifyBatchSize = 1000
+
+	// entryHashTimeLayout formats created_at like to_char(..., 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"') in Postgres.
+	entryHashTimeLayout = "2006-01-02T15:04:05.000000Z"
+)
+
+// verifyEntryChains walks the hash chain of every account, which the database extends on every insert into
+// transaction_entries, and returns a Discrepancy for the first broken link of each account.
+func (s *service) verifyEntryChains(txn dbutil.Transaction) ([]Discrepancy, error) {
+	var discrepancies []Discrepancy
+
+	var prev *Entry
+	afterAccountId, afterSeq := uuid.Nil, int64(0)
+	broken := false
+	for {
+		entries, err := s.db.GetChainedEntries(txn, afterAccountId, afterSeq, entryChainVerifyBatchSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range entries {
+			entry := &entries[i]
+			if prev == nil || prev.AccountId != entry.AccountId {
+				prev, broken = nil, false
+			}
+
+			if !broken {
+				if discrepancy := verifyEntryLink(prev, *entry); discrepancy != nil {
+					discrepancies = append(discrepancies, *discrepancy)
+					broken = true
+				}
+			}
+			prev = entry
+		}
+
+		if len(entries) < entryChainVerifyBatchSize {
+			return discrepancies, nil
+		}
+		afterAccountId, afterSeq = prev.AccountId, prev.Seq
+	}
+}
+
+// verifyEntryLink checks that entry follows prev on the same account, where prev is nil for its first entry.
+func verifyEntryLink(prev *Entry, entry Entry) *Discrepancy {
+	expectedSeq, expectedPrevHash := int64(1), genesisHash
+	if prev != nil {
+		expectedSeq, expectedPrevHash = prev.Seq+1, prev.Hash
+	}
+
+	var detail string
+	switch {
+	case entry.Seq != expectedSeq:
+		detail = fmt.Sprintf("entry at seq %d is missing, next one is %s at seq %d", expectedSeq, entry.Id, entry.Seq)
+	case entry.PrevHash != expectedPrevHash:
+		detail = fmt.Sprintf("entry %s at seq %d: previous hash does not match the previous entry", entry.Id, entry.Seq)
+	case entry.Hash != hashEntry(entry):
+		detail = fmt.Sprintf("entry %s at seq %d: hash does not match the contents of the entry", entry.Id, entry.Seq)
+	default:
+		return nil
+	}
+
+	return &Discrepancy{Check: EntryChainBrokenCheck, Reference: entry.AccountName, Detail: detail}
+}
+
+// hashEntry returns the hex-encoded sha256 of entry, as computed by transaction_entry_hash() in the database.
+func hashEntry(entry Entry) string {
+	targetAccountId := ""
+	if entry.TargetAccountId.Valid {
+		targetAccountId = entry.TargetAccountId.UUID.String()
+	}
+
+	content := strings.Join([]string{
+		strconv.FormatInt(entry.Seq, 10),
+		entry.PrevHash,
+		entry.Id.String(),
+		entry.TransactionId.String(),
+		entry.AccountId.String(),
+		targetAccountId,
+		entry.Name,
+		entry.Credit.StringFixed(8), // scale of the credit and debit columns
+		entry.Debit.StringFixed(8),
+		entry.CreatedAt.UTC().Format(entryHashTimeLayout),
+	}, "|")
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,124 @@
+package transaction
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nogurenn/cph-wallet/dbutil"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_VerifyEntryLink_IntactChain(t *testing.T) {
+	// given
+	entries := makeEntryChain(3)
+
+	// when
+	var discrepancies []*Discrepancy
+	var prev *Entry
+	for i := range entries {
+		if discrepancy := verifyEntryLink(prev, entries[i]); discrepancy != nil {
+			discrepancies = append(discrepancies, discrepancy)
+		}
+		prev = &entries[i]
+	}
+
+	// then
+	assert.Empty(t, discrepancies)
+}
+
+func Test_VerifyEntryLink_ContentChanged(t *testing.T) {
+	// given
+	entries := makeEntryChain(3)
+	entries[1].Credit = decimal.NewFromInt(1000)
+
+	// when
+	discrepancy := verifyEntryLink(&entries[0], entries[1])
+
+	// then
+	if assert.NotNil(t, discrepancy) {
+		assert.Equal(t, EntryChainBrokenCheck, discrepancy.Check)
+		assert.Equal(t, "bob123", discrepancy.Reference)
+		assert.Contains(t, discrepancy.Detail, "hash does not match")
+	}
+}
+
+func Test_VerifyEntryLink_RehashedEntryBreaksNextLink(t *testing.T) {
+	// given
+	entries := makeEntryChain(3)
+	entries[1].Credit = decimal.NewFromInt(1000)
+	entries[1].Hash = hashEntry(entries[1])
+
+	// when
+	discrepancy := verifyEntryLink(&entries[1], entries[2])
+
+	// then
+	if assert.NotNil(t, discrepancy) {
+		assert.Contains(t, discrepancy.Detail, entries[2].Id.String())
+		assert.Contains(t, discrepancy.Detail, "previous hash")
+	}
+}
+
+func Test_VerifyEntryLink_EntryRemoved(t *testing.T) {
+	// given
+	entries := makeEntryChain(3)
+
+	// when
+	discrepancy := verifyEntryLink(&entries[0], entries[2])
+
+	// then
+	if assert.NotNil(t, discrepancy) {
+		assert.Contains(t, discrepancy.Detail, "entry at seq 2 is missing")
+	}
+}
+
+func Test_HashEntry_MatchesDatabase(t *testing.T) {
+	// given
+	entry := Entry{
+		Id:            uuid.MustParse("11111111-1111-1111-1111-111111111111"),
+		TransactionId: uuid.MustParse("22222222-2222-2222-2222-222222222222"),
+		AccountId:     uuid.MustParse("33333333-3333-3333-3333-333333333333"),
+		Name:          "deposit",
+		Credit:        decimal.RequireFromString("44.79"),
+		Debit:         decimal.Zero,
+		Timestamps: dbutil.Timestamps{
+			CreatedAt: time.Date(2022, 5, 1, 20, 0, 0, 123456000, time.FixedZone("UTC+8", 8*60*60)),
+		},
+		Seq:      1,
+		PrevHash: genesisHash,
+	}
+
+	// when
+	hash := hashEntry(entry)
+
+	// then
+	// transaction_entry_hash() of the same entry
+	assert.Equal(t, "5dee3776422a5152342b50b547d1ee6939d2d6d7992cf91ecf9668a77a2dd1ba", hash)
+}
+
+// --- helpers
+
+func makeEntryChain(n int) []Entry {
+	accountId := uuid.New()
+	entries := make([]Entry, 0, n)
+	prevHash := genesisHash
+	for i := 1; i <= n; i++ {
+		entry := Entry{
+			Id:            uuid.New(),
+			TransactionId: uuid.New(),
+			AccountId:     accountId,
+			Name:          "deposit",
+			Credit:        decimal.NewFromInt(int64(i)),
+			Debit:         decimal.Zero,
+			Timestamps:    dbutil.Timestamps{CreatedAt: time.Date(2022, 5, 1, 12, 0, i, 0, time.UTC)},
+			Seq:           int64(i),
+			PrevHash:      prevHash,
+			AccountName:   "bob123",
+		}
+		entry.Hash = hashEntry(entry)
+		prevHash = entry.Hash
+		entries = append(entries, entry)
+	}
+	return entries
+}
@@ -0,0 +1,78 @@
+package transaction
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/nogurenn/cph-wallet/dbutil"
+)
+
+// checkpointDelay keeps checkpoints behind the present: entries take created_at from the start of their
+// transaction, so transactions still running may yet commit entries dated shortly before now. A transaction that
+// runs for longer, such as a large import, may still commit entries dated before a checkpoint after it was
+// recorded; Reconcile reports such checkpoints as checkpoint_mismatch.
+const checkpointDelay = 5 * time.Minute
+
+// Checkpointer periodically records the balance of every account in balance_checkpoints, so that point-in-time
+// balances (see Service.GetAccountsAsOf) only sum the entries recorded since the latest checkpoint.
+type Checkpointer struct {
+	db       Repository
+	txns     *dbutil.TxnRunner
+	logger   log.Logger
+	interval time.Duration
+	state    workerState
+}
+
+// NewCheckpointer returns a Checkpointer that records checkpoints at multiples of interval, e.g. at every midnight
+// UTC for 24 hours. Several instances may run at once, as each checkpoint is recorded only once.
+func NewCheckpointer(db Repository, logger log.Logger, interval time.Duration) *Checkpointer {
+	return &Checkpointer{db: db, txns: dbutil.NewTxnRunner(db.BeginTxn), logger: logger, interval: interval}
+}
+
+// Run records the latest checkpoint now and then every interval until ctx is done. Failures are logged, and
+// the checkpoint is recorded on the next run instead.
+func (c *Checkpointer) Run(ctx context.Context) {
+	defer c.state.stop()
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now()
+		asOf, count, err := c.Checkpoint(ctx, now)
+		if err != nil {
+			c.state.failed(now, err)
+			c.logger.Log("msg", "balance checkpoints could not be recorded", "as_of", asOf, "err", err)
+		} else {
+			c.state.succeeded()
+			if count > 0 {
+				c.logger.Log("msg", "balance checkpoints recorded", "as_of", asOf, "count", count)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Checkpoint records the balances of every account at the latest multiple of interval that is at least
+// checkpointDelay before now, and returns that time and how many balances were recorded.
+func (c *Checkpointer) Checkpoint(ctx context.Context, now time.Time) (asOf time.Time, count int64, err error) {
+	asOf = now.Add(-checkpointDelay).Truncate(c.interval).UTC()
+
+	err = c.txns.RunInTxn(ctx, sql.LevelReadCommitted, func(txn dbutil.Transaction) error {
+		count, err = c.db.CreateBalanceCheckpoints(txn, asOf)
+		return err
+	})
+	return asOf, count, err
+}
+
+// Check fails once Run returned, or when checkpoints could not be recorded for two intervals in a row.
+func (c *Checkpointer) Check(_ context.Context) error {
+	return c.state.check(time.Now(), 2*c.interval)
+}
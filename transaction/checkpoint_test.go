@@ -0,0 +1,66 @@
+package transaction_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	mockdbutil "github.com/nogurenn/cph-wallet/mocks/autogen/dbutil"
+	mocktransaction "github.com/nogurenn/cph-wallet/mocks/autogen/transaction"
+	"github.com/nogurenn/cph-wallet/transaction"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_Checkpointer_Checkpoint_Success(t *testing.T) {
+	// given
+	now := time.Date(2022, 5, 1, 0, 3, 0, 0, time.UTC)
+	expectedAsOf := time.Date(2022, 4, 30, 0, 0, 0, 0, time.UTC) // the latest midnight is too recent to checkpoint yet
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Commit").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
+	db.On("CreateBalanceCheckpoints", txn, expectedAsOf).Return(int64(2), nil)
+
+	checkpointer := transaction.NewCheckpointer(db, log.NewNopLogger(), 24*time.Hour)
+
+	// when
+	asOf, count, err := checkpointer.Checkpoint(context.Background(), now)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, expectedAsOf, asOf)
+	assert.Equal(t, int64(2), count)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Checkpointer_Checkpoint_Failure(t *testing.T) {
+	// given
+	now := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	expectedAsOf := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	expectedErr := errors.New("connection reset")
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
+	db.On("CreateBalanceCheckpoints", txn, expectedAsOf).Return(int64(0), expectedErr)
+
+	checkpointer := transaction.NewCheckpointer(db, log.NewNopLogger(), 24*time.Hour)
+
+	// when
+	_, _, err := checkpointer.Checkpoint(context.Background(), now)
+
+	// then
+	assert.Equal(t, expectedErr, err)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
@@ -0,0 +1,66 @@
+package transaction
+
+import (
+	"context"
+
+	"github.com/shopspring/decimal"
+)
+
+type contextKey int
+
+const (
+	idempotencyKeyContextKey contextKey = iota
+	requestIDContextKey
+	claimedActorContextKey
+	paymentObserverContextKey
+)
+
+// WithIdempotencyKey returns a copy of ctx carrying an idempotency key. Deposits and payments recorded under
+// the same key are applied at most once, so callers can safely retry them.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyContextKey, key)
+}
+
+// IdempotencyKeyFrom returns the idempotency key carried by ctx, if any.
+func IdempotencyKeyFrom(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKeyContextKey).(string)
+	return key
+}
+
+// WithRequestID returns a copy of ctx carrying the id that correlates the logs of a single request.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey, id)
+}
+
+// RequestIDFrom returns the request id carried by ctx, if any.
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
+
+// WithClaimedActor returns a copy of ctx carrying the principal on whose behalf calls claim to be made, for the
+// audit log. The service records it as given, without verifying it.
+func WithClaimedActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, claimedActorContextKey, actor)
+}
+
+// ClaimedActorFrom returns the claimed actor carried by ctx, or "anonymous" if there is none.
+func ClaimedActorFrom(ctx context.Context) string {
+	if actor, _ := ctx.Value(claimedActorContextKey).(string); actor != "" {
+		return actor
+	}
+	return anonymousActor
+}
+
+// withPaymentObserver returns a copy of ctx whose calls tell observe of every payment they record. Payments that
+// are replayed under an idempotency key are not told again.
+func withPaymentObserver(ctx context.Context, observe func(amount decimal.Decimal)) context.Context {
+	return context.WithValue(ctx, paymentObserverContextKey, observe)
+}
+
+// observeRecordedPayment tells the payment observer of ctx, if any, that a payment of amount was recorded.
+func observeRecordedPayment(ctx context.Context, amount decimal.Decimal) {
+	if observe, ok := ctx.Value(paymentObserverContextKey).(func(amount decimal.Decimal)); ok {
+		observe(amount)
+	}
+}
@@ -2,13 +2,303 @@ package transaction
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
 
 	"github.com/go-kit/kit/endpoint"
+	"github.com/google/uuid"
 	"github.com/nogurenn/cph-wallet/dbutil"
 	"github.com/shopspring/decimal"
+	"gopkg.in/guregu/null.v4"
 )
 
-type getAccountsRequest struct{}
+// Endpoints collects the endpoints of the transaction service. It implements Service by calling each endpoint,
+// which lets remote instances built with MakeClientEndpoints be used in place of a local Service.
+type Endpoints struct {
+	CreateAccountEndpoint           endpoint.Endpoint
+	GetAccountsEndpoint             endpoint.Endpoint
+	GetAccountEndpoint              endpoint.Endpoint
+	GetPaymentTransactionsEndpoint  endpoint.Endpoint
+	DepositEndpoint                 endpoint.Endpoint
+	SendPaymentEndpoint             endpoint.Endpoint
+	CreatePaymentRequestEndpoint    endpoint.Endpoint
+	GetPaymentRequestsEndpoint      endpoint.Endpoint
+	AcceptPaymentRequestEndpoint    endpoint.Endpoint
+	DeclinePaymentRequestEndpoint   endpoint.Endpoint
+	CancelPaymentRequestEndpoint    endpoint.Endpoint
+	FreezeAccountEndpoint           endpoint.Endpoint
+	UnfreezeAccountEndpoint         endpoint.Endpoint
+	CloseAccountEndpoint            endpoint.Endpoint
+	GetAccountStatusChangesEndpoint endpoint.Endpoint
+	GetAccountStatementEndpoint     endpoint.Endpoint
+	ExportEntriesEndpoint           endpoint.Endpoint
+	StreamAccountEventsEndpoint     endpoint.Endpoint
+	ImportAccountsEndpoint          endpoint.Endpoint
+	ReconcileEndpoint               endpoint.Endpoint
+	GetAuditLogEndpoint             endpoint.Endpoint
+	VerifyAuditLogEndpoint          endpoint.Endpoint
+}
+
+func (e Endpoints) CreateAccount(ctx context.Context, username string) error {
+	response, err := e.CreateAccountEndpoint(ctx, createAccountRequest{Username: username})
+	if err != nil {
+		return err
+	}
+	return response.(createAccountResponse).Err
+}
+
+func (e Endpoints) GetAccounts(ctx context.Context) ([]Account, error) {
+	response, err := e.GetAccountsEndpoint(ctx, getAccountsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	resp := response.(getAccountsResponse)
+	return resp.Accounts, resp.Err
+}
+
+func (e Endpoints) GetAccount(ctx context.Context, username string) (*Account, error) {
+	response, err := e.GetAccountEndpoint(ctx, getAccountRequest{Username: username})
+	if err != nil {
+		return nil, err
+	}
+	resp := response.(getAccountResponse)
+	if resp.Err != nil {
+		return nil, resp.Err
+	}
+	account := mapAccountDetailsToAccount(*resp.Account)
+	return &account, nil
+}
+
+func (e Endpoints) GetAccountsAsOf(ctx context.Context, asOf time.Time) ([]Account, error) {
+	response, err := e.GetAccountsEndpoint(ctx, getAccountsRequest{AsOf: asOf})
+	if err != nil {
+		return nil, err
+	}
+	resp := response.(getAccountsResponse)
+	return resp.Accounts, resp.Err
+}
+
+func (e Endpoints) GetAccountAsOf(ctx context.Context, username string, asOf time.Time) (*Account, error) {
+	response, err := e.GetAccountEndpoint(ctx, getAccountRequest{Username: username, AsOf: asOf})
+	if err != nil {
+		return nil, err
+	}
+	resp := response.(getAccountResponse)
+	if resp.Err != nil {
+		return nil, resp.Err
+	}
+	account := mapAccountDetailsToAccount(*resp.Account)
+	return &account, nil
+}
+
+func (e Endpoints) GetPaymentTransactions(ctx context.Context, filter TransactionFilter) ([]Transaction, error) {
+	response, err := e.GetPaymentTransactionsEndpoint(ctx, getPaymentTransactionsRequest{Filter: filter})
+	if err != nil {
+		return nil, err
+	}
+	resp := response.(getPaymentTransactionsResponse)
+	if resp.Err != nil {
+		return nil, resp.Err
+	}
+
+	var transactions []Transaction
+	for _, payment := range resp.Payments {
+		transactions = append(transactions, mapPaymentToTransaction(payment))
+	}
+	return transactions, nil
+}
+
+func (e Endpoints) Deposit(ctx context.Context, username string, amount decimal.Decimal) error {
+	response, err := e.DepositEndpoint(ctx, depositRequest{Username: username, Amount: amount})
+	if err != nil {
+		return err
+	}
+	return response.(depositResponse).Err
+}
+
+func (e Endpoints) SendPayment(ctx context.Context, fromUsername string, toUsername string, amount decimal.Decimal, details PaymentDetails) error {
+	response, err := e.SendPaymentEndpoint(ctx, sendPaymentRequest{
+		Username:       fromUsername,
+		TargetUsername: toUsername,
+		Amount:         amount,
+		PaymentDetails: details,
+	})
+	if err != nil {
+		return err
+	}
+	return response.(sendPaymentResponse).Err
+}
+
+func (e Endpoints) CreatePaymentRequest(ctx context.Context, requesterUsername string, payerUsername string, amount decimal.Decimal, memo null.String, expiresAt time.Time) (*PaymentRequest, error) {
+	response, err := e.CreatePaymentRequestEndpoint(ctx, createPaymentRequestRequest{
+		Requester: requesterUsername,
+		Payer:     payerUsername,
+		Amount:    amount,
+		Memo:      memo,
+		ExpiresAt: null.NewTime(expiresAt, !expiresAt.IsZero()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp := response.(paymentRequestResponse)
+	return resp.PaymentRequest, resp.Err
+}
+
+func (e Endpoints) GetPaymentRequests(ctx context.Context, filter PaymentRequestFilter) ([]PaymentRequest, error) {
+	response, err := e.GetPaymentRequestsEndpoint(ctx, getPaymentRequestsRequest{Filter: filter})
+	if err != nil {
+		return nil, err
+	}
+	resp := response.(getPaymentRequestsResponse)
+	return resp.PaymentRequests, resp.Err
+}
+
+func (e Endpoints) AcceptPaymentRequest(ctx context.Context, id uuid.UUID, payerUsername string) (*PaymentRequest, error) {
+	return e.respondToPaymentRequest(ctx, e.AcceptPaymentRequestEndpoint, id, payerUsername)
+}
+
+func (e Endpoints) DeclinePaymentRequest(ctx context.Context, id uuid.UUID, payerUsername string) (*PaymentRequest, error) {
+	return e.respondToPaymentRequest(ctx, e.DeclinePaymentRequestEndpoint, id, payerUsername)
+}
+
+func (e Endpoints) CancelPaymentRequest(ctx context.Context, id uuid.UUID, requesterUsername string) (*PaymentRequest, error) {
+	return e.respondToPaymentRequest(ctx, e.CancelPaymentRequestEndpoint, id, requesterUsername)
+}
+
+func (e Endpoints) respondToPaymentRequest(ctx context.Context, respond endpoint.Endpoint, id uuid.UUID, username string) (*PaymentRequest, error) {
+	response, err := respond(ctx, respondToPaymentRequestRequest{Id: id, Username: username})
+	if err != nil {
+		return nil, err
+	}
+	resp := response.(paymentRequestResponse)
+	return resp.PaymentRequest, resp.Err
+}
+
+func (e Endpoints) FreezeAccount(ctx context.Context, username string, reason string) error {
+	response, err := e.FreezeAccountEndpoint(ctx, changeAccountStatusRequest{Username: username, Reason: reason})
+	if err != nil {
+		return err
+	}
+	return response.(changeAccountStatusResponse).Err
+}
+
+func (e Endpoints) UnfreezeAccount(ctx context.Context, username string, reason string) error {
+	response, err := e.UnfreezeAccountEndpoint(ctx, changeAccountStatusRequest{Username: username, Reason: reason})
+	if err != nil {
+		return err
+	}
+	return response.(changeAccountStatusResponse).Err
+}
+
+func (e Endpoints) CloseAccount(ctx context.Context, username string, reason string) error {
+	response, err := e.CloseAccountEndpoint(ctx, changeAccountStatusRequest{Username: username, Reason: reason})
+	if err != nil {
+		return err
+	}
+	return response.(changeAccountStatusResponse).Err
+}
+
+func (e Endpoints) GetAccountStatusChanges(ctx context.Context, username string) ([]AccountStatusChange, error) {
+	response, err := e.GetAccountStatusChangesEndpoint(ctx, getAccountStatusChangesRequest{Username: username})
+	if err != nil {
+		return nil, err
+	}
+	resp := response.(getAccountStatusChangesResponse)
+	return resp.StatusChanges, resp.Err
+}
+
+func (e Endpoints) GetAccountStatement(ctx context.Context, username string) ([]StatementLine, error) {
+	response, err := e.GetAccountStatementEndpoint(ctx, getAccountStatementRequest{Username: username})
+	if err != nil {
+		return nil, err
+	}
+	resp := response.(getAccountStatementResponse)
+	return resp.Statement, resp.Err
+}
+
+func (e Endpoints) ExportEntries(ctx context.Context, filter ExportFilter, fn func(ExportedEntry) error) error {
+	response, err := e.ExportEntriesEndpoint(ctx, exportEntriesRequest{Filter: filter, Format: NDJSONFormat})
+	if err != nil {
+		return err
+	}
+	resp := response.(exportEntriesResponse)
+	if resp.Err != nil {
+		return resp.Err
+	}
+	return resp.Stream(fn)
+}
+
+func (e Endpoints) StreamAccountEvents(ctx context.Context, username string, lastEventId null.Int, fn func(AccountEvent) error) error {
+	response, err := e.StreamAccountEventsEndpoint(ctx, streamAccountEventsRequest{Username: username, LastEventId: lastEventId})
+	if err != nil {
+		return err
+	}
+	resp := response.(streamAccountEventsResponse)
+	if resp.Err != nil {
+		return resp.Err
+	}
+	return resp.Stream(fn)
+}
+
+func (e Endpoints) ImportAccounts(ctx context.Context, rows []ImportRow, dryRun bool) (*ImportReport, error) {
+	response, err := e.ImportAccountsEndpoint(ctx, importAccountsRequest{Rows: rows, DryRun: dryRun})
+	if err != nil {
+		return nil, err
+	}
+	resp := response.(importAccountsResponse)
+	return resp.Report, resp.Err
+}
+
+func (e Endpoints) Reconcile(ctx context.Context) ([]Discrepancy, error) {
+	response, err := e.ReconcileEndpoint(ctx, reconcileRequest{})
+	if err != nil {
+		return nil, err
+	}
+	resp := response.(reconcileResponse)
+	return resp.Discrepancies, resp.Err
+}
+
+func (e Endpoints) GetAuditLog(ctx context.Context, filter AuditFilter) ([]AuditRecord, error) {
+	response, err := e.GetAuditLogEndpoint(ctx, getAuditLogRequest{Filter: filter})
+	if err != nil {
+		return nil, err
+	}
+	resp := response.(getAuditLogResponse)
+	return resp.Records, resp.Err
+}
+
+func (e Endpoints) VerifyAuditLog(ctx context.Context) (*AuditChainBreak, error) {
+	response, err := e.VerifyAuditLogEndpoint(ctx, verifyAuditLogRequest{})
+	if err != nil {
+		return nil, err
+	}
+	resp := response.(verifyAuditLogResponse)
+	return resp.Break, resp.Err
+}
+
+type createAccountRequest struct {
+	Username string `json:"username"`
+}
+
+type createAccountResponse struct {
+	Err error `json:"error"`
+}
+
+func (r createAccountResponse) error() error { return r.Err }
+
+func makeCreateAccountEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(createAccountRequest)
+		err := s.CreateAccount(ctx, req.Username)
+		return createAccountResponse{Err: err}, nil
+	}
+}
+
+type getAccountsRequest struct {
+	AsOf time.Time
+}
 
 type getAccountsResponse struct {
 	Accounts []Account `json:"accounts"`
@@ -18,9 +308,15 @@ type getAccountsResponse struct {
 func (r getAccountsResponse) error() error { return r.Err }
 
 func makeGetAccountsEndpoint(s Service) endpoint.Endpoint {
-	return func(_ context.Context, request interface{}) (interface{}, error) {
-		_ = request.(getAccountsRequest)
-		accounts, err := s.GetAccounts()
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(getAccountsRequest)
+		var accounts []Account
+		var err error
+		if req.AsOf.IsZero() {
+			accounts, err = s.GetAccounts(ctx)
+		} else {
+			accounts, err = s.GetAccountsAsOf(ctx, req.AsOf)
+		}
 		if accounts == nil {
 			accounts = []Account{} // serialize nil slice such that `"accounts": []` instead of null
 		}
@@ -28,7 +324,67 @@ func makeGetAccountsEndpoint(s Service) endpoint.Endpoint {
 	}
 }
 
-type getPaymentTransactionsRequest struct{}
+type getAccountRequest struct {
+	Username    string
+	AsOf        time.Time
+	IfNoneMatch string
+}
+
+type getAccountResponse struct {
+	Account     *AccountDetails `json:"account"`
+	Err         error           `json:"error"`
+	ETag        string          `json:"-"`
+	NotModified bool            `json:"-"`
+}
+
+func (r getAccountResponse) error() error { return r.Err }
+
+func makeGetAccountEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(getAccountRequest)
+		var account *Account
+		var err error
+		if req.AsOf.IsZero() {
+			account, err = s.GetAccount(ctx, req.Username)
+		} else {
+			account, err = s.GetAccountAsOf(ctx, req.Username, req.AsOf)
+		}
+		if err != nil {
+			return getAccountResponse{Err: err}, nil
+		}
+
+		etag := makeAccountETag(*account)
+		if etagMatches(req.IfNoneMatch, etag) {
+			return getAccountResponse{ETag: etag, NotModified: true}, nil
+		}
+
+		details := mapAccountToAccountDetails(*account)
+		return getAccountResponse{Account: &details, ETag: etag}, nil
+	}
+}
+
+type depositRequest struct {
+	Username string          `json:"username"`
+	Amount   decimal.Decimal `json:"amount"`
+}
+
+type depositResponse struct {
+	Err error `json:"error"`
+}
+
+func (r depositResponse) error() error { return r.Err }
+
+func makeDepositEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(depositRequest)
+		err := s.Deposit(ctx, req.Username, req.Amount)
+		return depositResponse{Err: err}, nil
+	}
+}
+
+type getPaymentTransactionsRequest struct {
+	Filter TransactionFilter
+}
 
 type getPaymentTransactionsResponse struct {
 	Payments []Payment `json:"payments"`
@@ -38,9 +394,9 @@ type getPaymentTransactionsResponse struct {
 func (r getPaymentTransactionsResponse) error() error { return r.Err }
 
 func makeGetPaymentTransactionsEndpoint(s Service) endpoint.Endpoint {
-	return func(_ context.Context, request interface{}) (interface{}, error) {
-		_ = request.(getPaymentTransactionsRequest)
-		paymentTransactions, err := s.GetPaymentTransactions()
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(getPaymentTransactionsRequest)
+		paymentTransactions, err := s.GetPaymentTransactions(ctx, req.Filter)
 
 		payments := []Payment{}
 		for _, pt := range paymentTransactions {
@@ -55,26 +411,391 @@ type sendPaymentRequest struct {
 	Username       string          `json:"username"`
 	TargetUsername string          `json:"target_username"`
 	Amount         decimal.Decimal `json:"amount"`
+	PaymentDetails
 }
 
 type sendPaymentResponse struct {
 	Err error `json:"error"`
 }
 
+func (r sendPaymentResponse) error() error { return r.Err }
+
 func makeSendPaymentEndpoint(s Service) endpoint.Endpoint {
-	return func(_ context.Context, request interface{}) (interface{}, error) {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(sendPaymentRequest)
-		err := s.SendPayment(req.Username, req.TargetUsername, req.Amount)
+		err := s.SendPayment(ctx, req.Username, req.TargetUsername, req.Amount, req.PaymentDetails)
 		return sendPaymentResponse{Err: err}, nil
 	}
 }
 
+type createPaymentRequestRequest struct {
+	Requester string          `json:"requester"`
+	Payer     string          `json:"payer"`
+	Amount    decimal.Decimal `json:"amount"`
+	Memo      null.String     `json:"memo"`
+	ExpiresAt null.Time       `json:"expires_at"`
+}
+
+// paymentRequestResponse is the payment request as created by createPaymentRequestRequest, or as left by
+// respondToPaymentRequestRequest.
+type paymentRequestResponse struct {
+	PaymentRequest *PaymentRequest `json:"payment_request"`
+	Err            error           `json:"error"`
+}
+
+func (r paymentRequestResponse) error() error { return r.Err }
+
+func makeCreatePaymentRequestEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(createPaymentRequestRequest)
+		paymentRequest, err := s.CreatePaymentRequest(ctx, req.Requester, req.Payer, req.Amount, req.Memo, req.ExpiresAt.Time)
+		return paymentRequestResponse{PaymentRequest: paymentRequest, Err: err}, nil
+	}
+}
+
+type getPaymentRequestsRequest struct {
+	Filter PaymentRequestFilter
+}
+
+type getPaymentRequestsResponse struct {
+	PaymentRequests []PaymentRequest `json:"payment_requests"`
+	Err             error            `json:"error"`
+}
+
+func (r getPaymentRequestsResponse) error() error { return r.Err }
+
+func makeGetPaymentRequestsEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(getPaymentRequestsRequest)
+		requests, err := s.GetPaymentRequests(ctx, req.Filter)
+		if requests == nil {
+			requests = []PaymentRequest{}
+		}
+		return getPaymentRequestsResponse{PaymentRequests: requests, Err: err}, nil
+	}
+}
+
+type respondToPaymentRequestRequest struct {
+	Id       uuid.UUID `json:"-"`
+	Username string    `json:"username"`
+}
+
+func makeAcceptPaymentRequestEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(respondToPaymentRequestRequest)
+		paymentRequest, err := s.AcceptPaymentRequest(ctx, req.Id, req.Username)
+		return paymentRequestResponse{PaymentRequest: paymentRequest, Err: err}, nil
+	}
+}
+
+func makeDeclinePaymentRequestEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(respondToPaymentRequestRequest)
+		paymentRequest, err := s.DeclinePaymentRequest(ctx, req.Id, req.Username)
+		return paymentRequestResponse{PaymentRequest: paymentRequest, Err: err}, nil
+	}
+}
+
+func makeCancelPaymentRequestEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(respondToPaymentRequestRequest)
+		paymentRequest, err := s.CancelPaymentRequest(ctx, req.Id, req.Username)
+		return paymentRequestResponse{PaymentRequest: paymentRequest, Err: err}, nil
+	}
+}
+
+type changeAccountStatusRequest struct {
+	Username string `json:"-"`
+	Reason   string `json:"reason"`
+}
+
+type changeAccountStatusResponse struct {
+	Err error `json:"error"`
+}
+
+func (r changeAccountStatusResponse) error() error { return r.Err }
+
+func makeFreezeAccountEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(changeAccountStatusRequest)
+		err := s.FreezeAccount(ctx, req.Username, req.Reason)
+		return changeAccountStatusResponse{Err: err}, nil
+	}
+}
+
+func makeUnfreezeAccountEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(changeAccountStatusRequest)
+		err := s.UnfreezeAccount(ctx, req.Username, req.Reason)
+		return changeAccountStatusResponse{Err: err}, nil
+	}
+}
+
+func makeCloseAccountEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(changeAccountStatusRequest)
+		err := s.CloseAccount(ctx, req.Username, req.Reason)
+		return changeAccountStatusResponse{Err: err}, nil
+	}
+}
+
+type getAccountStatusChangesRequest struct {
+	Username string
+}
+
+type getAccountStatusChangesResponse struct {
+	StatusChanges []AccountStatusChange `json:"status_changes"`
+	Err           error                 `json:"error"`
+}
+
+func (r getAccountStatusChangesResponse) error() error { return r.Err }
+
+func makeGetAccountStatusChangesEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(getAccountStatusChangesRequest)
+		changes, err := s.GetAccountStatusChanges(ctx, req.Username)
+		if changes == nil {
+			changes = []AccountStatusChange{}
+		}
+		return getAccountStatusChangesResponse{StatusChanges: changes, Err: err}, nil
+	}
+}
+
+type getAccountStatementRequest struct {
+	Username string
+}
+
+type getAccountStatementResponse struct {
+	Statement []StatementLine `json:"statement"`
+	Err       error           `json:"error"`
+}
+
+func (r getAccountStatementResponse) error() error { return r.Err }
+
+func makeGetAccountStatementEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(getAccountStatementRequest)
+		lines, err := s.GetAccountStatement(ctx, req.Username)
+		if lines == nil {
+			lines = []StatementLine{}
+		}
+		return getAccountStatementResponse{Statement: lines, Err: err}, nil
+	}
+}
+
+type exportEntriesRequest struct {
+	Filter ExportFilter
+	Format string
+}
+
+// exportEntriesResponse defers reading the entries to Stream, which the transport calls while it writes them
+// out, so that no more than one entry is held in memory at a time.
+type exportEntriesResponse struct {
+	Format string
+	Stream func(fn func(ExportedEntry) error) error
+	Err    error
+}
+
+func (r exportEntriesResponse) error() error { return r.Err }
+
+func makeExportEntriesEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(exportEntriesRequest)
+		stream := func(fn func(ExportedEntry) error) error {
+			return s.ExportEntries(ctx, req.Filter, fn)
+		}
+		return exportEntriesResponse{Format: req.Format, Stream: stream}, nil
+	}
+}
+
+type streamAccountEventsRequest struct {
+	Username    string
+	LastEventId null.Int
+}
+
+// streamAccountEventsResponse defers streaming the events to Stream, which the transport calls while it writes them
+// out, like exportEntriesResponse.
+type streamAccountEventsResponse struct {
+	Stream func(fn func(AccountEvent) error) error
+	Err    error
+}
+
+func (r streamAccountEventsResponse) error() error { return r.Err }
+
+func makeStreamAccountEventsEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(streamAccountEventsRequest)
+		stream := func(fn func(AccountEvent) error) error {
+			return s.StreamAccountEvents(ctx, req.Username, req.LastEventId, fn)
+		}
+		return streamAccountEventsResponse{Stream: stream}, nil
+	}
+}
+
+type importAccountsRequest struct {
+	Rows   []ImportRow
+	DryRun bool
+}
+
+type importAccountsResponse struct {
+	Report *ImportReport `json:"report"`
+	Err    error         `json:"error"`
+}
+
+func (r importAccountsResponse) error() error { return r.Err }
+
+func makeImportAccountsEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(importAccountsRequest)
+		report, err := s.ImportAccounts(ctx, req.Rows, req.DryRun)
+		return importAccountsResponse{Report: report, Err: err}, nil
+	}
+}
+
+type reconcileRequest struct{}
+
+type reconcileResponse struct {
+	Discrepancies []Discrepancy `json:"discrepancies"`
+	Err           error         `json:"error"`
+}
+
+func (r reconcileResponse) error() error { return r.Err }
+
+func makeReconcileEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		discrepancies, err := s.Reconcile(ctx)
+		if discrepancies == nil {
+			discrepancies = []Discrepancy{}
+		}
+		return reconcileResponse{Discrepancies: discrepancies, Err: err}, nil
+	}
+}
+
+type getAuditLogRequest struct {
+	Filter AuditFilter
+}
+
+type getAuditLogResponse struct {
+	Records []AuditRecord `json:"records"`
+	Err     error         `json:"error"`
+}
+
+func (r getAuditLogResponse) error() error { return r.Err }
+
+func makeGetAuditLogEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(getAuditLogRequest)
+		records, err := s.GetAuditLog(ctx, req.Filter)
+		if records == nil {
+			records = []AuditRecord{}
+		}
+		return getAuditLogResponse{Records: records, Err: err}, nil
+	}
+}
+
+type verifyAuditLogRequest struct{}
+
+type verifyAuditLogResponse struct {
+	Intact bool             `json:"intact"`
+	Break  *AuditChainBreak `json:"break"`
+	Err    error            `json:"error"`
+}
+
+func (r verifyAuditLogResponse) error() error { return r.Err }
+
+func makeVerifyAuditLogEndpoint(s Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		_ = request.(verifyAuditLogRequest)
+		chainBreak, err := s.VerifyAuditLog(ctx)
+		return verifyAuditLogResponse{Intact: err == nil && chainBreak == nil, Break: chainBreak, Err: err}, nil
+	}
+}
+
 // --- helpers
 
+func mapAccountToAccountDetails(account Account) AccountDetails {
+	return AccountDetails{
+		Username:         account.Username,
+		Balance:          account.Balance,
+		AvailableBalance: account.AvailableBalance(),
+		Currency:         account.Currency,
+		Status:           account.Status,
+		CreatedAt:        account.CreatedAt,
+	}
+}
+
+func mapAccountDetailsToAccount(details AccountDetails) Account {
+	return Account{
+		Username: details.Username,
+		Balance:  details.Balance,
+		Currency: details.Currency,
+		Status:   details.Status,
+		Timestamps: dbutil.Timestamps{
+			CreatedAt: details.CreatedAt,
+		},
+	}
+}
+
+func mapPaymentToTransaction(payment Payment) Transaction {
+	var entries []Entry
+	for _, paymentEntry := range payment.Entries {
+		entry := Entry{
+			TransactionId: payment.Id,
+			Name:          paymentEntry.Direction,
+			AccountName:   paymentEntry.Username,
+		}
+
+		if paymentEntry.Direction == IncomingEntry {
+			entry.Credit = paymentEntry.Amount
+			entry.TargetAccountName = null.StringFrom(paymentEntry.FromAccount)
+		} else {
+			entry.Debit = paymentEntry.Amount.Abs().Neg()
+			entry.TargetAccountName = null.StringFrom(paymentEntry.ToAccount)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return Transaction{
+		Id:             payment.Id,
+		Name:           payment.Name,
+		PaymentDetails: payment.PaymentDetails,
+		Timestamps:     payment.Timestamps,
+		Entries:        entries,
+	}
+}
+
+// makeAccountETag derives a strong entity tag from the latest ledger entry, balance and status of an account,
+// which together determine every field of AccountDetails that can change over time. The latest entry is the last in
+// the hash chain of the account, i.e. the last to commit.
+func makeAccountETag(account Account) string {
+	lastEntryId := "none"
+	if account.LastEntryId.Valid {
+		lastEntryId = account.LastEntryId.UUID.String()
+	}
+	sum := sha256.Sum256([]byte(account.Username + ":" + account.Status + ":" + lastEntryId + ":" + account.Balance.String()))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag, per RFC 7232 weak comparison.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
 func mapTransactionToPayment(transaction Transaction) Payment {
 	return Payment{
-		Id:   transaction.Id,
-		Name: transaction.Name,
+		Id:             transaction.Id,
+		Name:           transaction.Name,
+		PaymentDetails: transaction.PaymentDetails,
 		Timestamps: dbutil.Timestamps{
 			CreatedAt: transaction.CreatedAt,
 			UpdatedAt: transaction.UpdatedAt,
@@ -1,5 +1,101 @@
 package transaction
 
+import (
+	"fmt"
+	"net/http"
+)
+
+// domainErrors lists the errors that are part of the API contract. Clients decode error responses back into these.
+var domainErrors = []error{
+	ErrRequestMalformed,
+	ErrAccountNotFound,
+	ErrAccountAlreadyExists,
+	ErrCreditAmountInvalid,
+	ErrBalanceInsufficient,
+	ErrPaymentSenderReceiverIdentical,
+	ErrIdempotencyKeyReused,
+	ErrAccountFrozen,
+	ErrAccountClosed,
+	ErrAccountStatusTransitionInvalid,
+	ErrAccountStatusReasonMissing,
+	ErrAccountBalanceNotZero,
+	ErrAuditFilterInvalid,
+	ErrAsOfInvalid,
+	ErrExportFilterInvalid,
+	ErrExportFormatNotAcceptable,
+	ErrImportMalformed,
+	ErrImportFormatUnsupported,
+	ErrImportRowsInvalid,
+	ErrLastEventIdInvalid,
+	ErrPaymentDetailsTooLarge,
+	ErrExternalReferenceReused,
+	ErrPaymentRequestNotFound,
+	ErrPaymentRequestNotPending,
+	ErrPaymentRequestExpired,
+	ErrPaymentRequestExpiryInvalid,
+	ErrPaymentRequestFilterInvalid,
+}
+
+// ResponseError is returned by clients for failed responses that do not match any of the domain errors.
+type ResponseError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+type RequestMalformed struct {
+	error
+}
+
+func (e *RequestMalformed) Error() string {
+	return "request is malformed"
+}
+
+var ErrRequestMalformed = &RequestMalformed{}
+
+type AccountNotFound struct {
+	error
+}
+
+func (e *AccountNotFound) Error() string {
+	return "account does not exist"
+}
+
+var ErrAccountNotFound = &AccountNotFound{}
+
+type AccountAlreadyExists struct {
+	error
+}
+
+func (e *AccountAlreadyExists) Error() string {
+	return "account already exists"
+}
+
+var ErrAccountAlreadyExists = &AccountAlreadyExists{}
+
+type TransactionNotFound struct {
+	error
+}
+
+func (e *TransactionNotFound) Error() string {
+	return "transaction does not exist"
+}
+
+var ErrTransactionNotFound = &TransactionNotFound{}
+
+type IdempotencyKeyReused struct {
+	error
+}
+
+func (e *IdempotencyKeyReused) Error() string {
+	return "idempotency key was already used for a different transaction"
+}
+
+var ErrIdempotencyKeyReused = &IdempotencyKeyReused{}
+
 type TransactionEntryMismatch struct {
 	error
 }
@@ -39,3 +135,203 @@ func (e *PaymentSenderReceiverIdentical) Error() string {
 }
 
 var ErrPaymentSenderReceiverIdentical = &PaymentSenderReceiverIdentical{}
+
+type AccountFrozen struct {
+	error
+}
+
+func (e *AccountFrozen) Error() string {
+	return "account is frozen"
+}
+
+var ErrAccountFrozen = &AccountFrozen{}
+
+type AccountClosed struct {
+	error
+}
+
+func (e *AccountClosed) Error() string {
+	return "account is closed"
+}
+
+var ErrAccountClosed = &AccountClosed{}
+
+type AccountStatusTransitionInvalid struct {
+	error
+}
+
+func (e *AccountStatusTransitionInvalid) Error() string {
+	return "account cannot transition to the requested status"
+}
+
+var ErrAccountStatusTransitionInvalid = &AccountStatusTransitionInvalid{}
+
+type AccountStatusReasonMissing struct {
+	error
+}
+
+func (e *AccountStatusReasonMissing) Error() string {
+	return "reason for changing account status is missing"
+}
+
+var ErrAccountStatusReasonMissing = &AccountStatusReasonMissing{}
+
+type AccountBalanceNotZero struct {
+	error
+}
+
+func (e *AccountBalanceNotZero) Error() string {
+	return "account with non-zero balance cannot be closed"
+}
+
+var ErrAccountBalanceNotZero = &AccountBalanceNotZero{}
+
+type AuditFilterInvalid struct {
+	error
+}
+
+func (e *AuditFilterInvalid) Error() string {
+	return "audit log filter is invalid"
+}
+
+var ErrAuditFilterInvalid = &AuditFilterInvalid{}
+
+type AsOfInvalid struct {
+	error
+}
+
+func (e *AsOfInvalid) Error() string {
+	return "as_of is not a valid RFC 3339 timestamp"
+}
+
+var ErrAsOfInvalid = &AsOfInvalid{}
+
+type ExportFilterInvalid struct {
+	error
+}
+
+func (e *ExportFilterInvalid) Error() string {
+	return "export filter is invalid"
+}
+
+var ErrExportFilterInvalid = &ExportFilterInvalid{}
+
+type ExportFormatNotAcceptable struct {
+	error
+}
+
+func (e *ExportFormatNotAcceptable) Error() string {
+	return "exports are available as text/csv or application/x-ndjson only"
+}
+
+var ErrExportFormatNotAcceptable = &ExportFormatNotAcceptable{}
+
+type ImportMalformed struct {
+	error
+}
+
+func (e *ImportMalformed) Error() string {
+	return "import is malformed"
+}
+
+var ErrImportMalformed = &ImportMalformed{}
+
+type ImportFormatUnsupported struct {
+	error
+}
+
+func (e *ImportFormatUnsupported) Error() string {
+	return "imports are accepted as text/csv or application/x-ndjson only"
+}
+
+var ErrImportFormatUnsupported = &ImportFormatUnsupported{}
+
+type ImportRowsInvalid struct {
+	error
+}
+
+func (e *ImportRowsInvalid) Error() string {
+	return "import rows are invalid, see the report for details"
+}
+
+var ErrImportRowsInvalid = &ImportRowsInvalid{}
+
+type LastEventIdInvalid struct {
+	error
+}
+
+func (e *LastEventIdInvalid) Error() string {
+	return "last event id is invalid"
+}
+
+var ErrLastEventIdInvalid = &LastEventIdInvalid{}
+
+type PaymentDetailsTooLarge struct {
+	error
+}
+
+func (e *PaymentDetailsTooLarge) Error() string {
+	return "payment description, external reference or metadata is too large"
+}
+
+var ErrPaymentDetailsTooLarge = &PaymentDetailsTooLarge{}
+
+type ExternalReferenceReused struct {
+	error
+}
+
+func (e *ExternalReferenceReused) Error() string {
+	return "external reference was already used for another payment of the sender"
+}
+
+var ErrExternalReferenceReused = &ExternalReferenceReused{}
+
+type PaymentRequestNotFound struct {
+	error
+}
+
+func (e *PaymentRequestNotFound) Error() string {
+	return "payment request does not exist"
+}
+
+var ErrPaymentRequestNotFound = &PaymentRequestNotFound{}
+
+type PaymentRequestNotPending struct {
+	error
+}
+
+func (e *PaymentRequestNotPending) Error() string {
+	return "payment request was already paid, declined, expired or cancelled"
+}
+
+var ErrPaymentRequestNotPending = &PaymentRequestNotPending{}
+
+type PaymentRequestExpired struct {
+	error
+}
+
+func (e *PaymentRequestExpired) Error() string {
+	return "payment request has expired"
+}
+
+var ErrPaymentRequestExpired = &PaymentRequestExpired{}
+
+type PaymentRequestExpiryInvalid struct {
+	error
+}
+
+func (e *PaymentRequestExpiryInvalid) Error() string {
+	return "payment request expiry must be in the future and within the maximum allowed"
+}
+
+var ErrPaymentRequestExpiryInvalid = &PaymentRequestExpiryInvalid{}
+
+type PaymentRequestFilterInvalid struct {
+	error
+}
+
+func (e *PaymentRequestFilterInvalid) Error() string {
+	return "payment request filter is invalid"
+}
+
+var ErrPaymentRequestFilterInvalid = &PaymentRequestFilterInvalid{}
@@ -0,0 +1,17 @@
+package transaction
+
+import "time"
+
+// SetSSEHeartbeatInterval shortens the heartbeat interval of event streams for a test, and returns a function that
+// restores it.
+func SetSSEHeartbeatInterval(interval time.Duration) func() {
+	previous := sseHeartbeatInterval
+	sseHeartbeatInterval = interval
+	return func() { sseHeartbeatInterval = previous }
+}
+
+// WithPaymentObserver and ObserveRecordedPayment let tests stand in for the service and its instrumentation.
+var (
+	WithPaymentObserver    = withPaymentObserver
+	ObserveRecordedPayment = observeRecordedPayment
+)
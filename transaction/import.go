@@ -0,0 +1,197 @@
+package transaction
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/nogurenn/cph-wallet/dbutil"
+	"github.com/nogurenn/cph-wallet/util"
+	"github.com/shopspring/decimal"
+)
+
+// maxImportRows bounds the rows of a single import, which are held in memory and written in one db transaction.
+const maxImportRows = 100000
+
+// ReadImportRows reads the rows of a bulk import from r in format, either CSV with a header row naming the
+// username and opening_balance columns, or JSON Lines of ImportRow. Input that cannot be parsed is rejected with
+// ErrImportMalformed, naming the first row at fault; rows that parse are validated by Service.ImportAccounts.
+func ReadImportRows(r io.Reader, format string) ([]ImportRow, error) {
+	var rows []ImportRow
+	var err error
+	switch format {
+	case CSVFormat:
+		rows, err = readImportCSV(r)
+	case NDJSONFormat:
+		rows, err = readImportNDJSON(r)
+	default:
+		return nil, ErrImportFormatUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("%w: there are no rows", ErrImportMalformed)
+	}
+	return rows, nil
+}
+
+func readImportCSV(r io.Reader) ([]ImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrImportMalformed, err)
+	}
+
+	usernameColumn, balanceColumn := -1, -1
+	for i, name := range header {
+		// spreadsheets often start CSV files with a byte order mark
+		switch strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")) {
+		case "username":
+			usernameColumn = i
+		case "opening_balance":
+			balanceColumn = i
+		default:
+			return nil, fmt.Errorf("%w: unknown column %q", ErrImportMalformed, name)
+		}
+	}
+	if usernameColumn < 0 {
+		return nil, fmt.Errorf("%w: username column is missing", ErrImportMalformed)
+	}
+
+	var rows []ImportRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrImportMalformed, err)
+		}
+		if len(rows) == maxImportRows {
+			return nil, fmt.Errorf("%w: there are more than %d rows", ErrImportMalformed, maxImportRows)
+		}
+
+		row := ImportRow{Username: record[usernameColumn]}
+		if balanceColumn >= 0 {
+			if row.OpeningBalance, err = parseOpeningBalance(record[balanceColumn]); err != nil {
+				return nil, fmt.Errorf("%w: row %d: opening_balance %q is not a decimal",
+					ErrImportMalformed, len(rows)+1, record[balanceColumn])
+			}
+		}
+		rows = append(rows, row)
+	}
+}
+
+func readImportNDJSON(r io.Reader) ([]ImportRow, error) {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+
+	var rows []ImportRow
+	for {
+		var row ImportRow
+		if err := decoder.Decode(&row); err == io.EOF {
+			return rows, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("%w: row %d: %s", ErrImportMalformed, len(rows)+1, err)
+		}
+		if len(rows) == maxImportRows {
+			return nil, fmt.Errorf("%w: there are more than %d rows", ErrImportMalformed, maxImportRows)
+		}
+		rows = append(rows, row)
+	}
+}
+
+// validateImportRows returns why rows are invalid, if they are, ordered by row: every row needs a username that
+// is neither taken nor repeated by another row, and an opening balance that is not negative.
+func (s *service) validateImportRows(txn dbutil.Transaction, rows []ImportRow) ([]ImportRowError, error) {
+	var rowErrors []ImportRowError
+	reject := func(row int, username string, reason string) {
+		rowErrors = append(rowErrors, ImportRowError{Row: row, Username: username, Error: reason})
+	}
+
+	rowsByUsername := map[string]int{}
+	var usernames []string
+	for i, row := range rows {
+		username := strings.TrimSpace(row.Username)
+		switch {
+		case username == "":
+			reject(i+1, row.Username, "username is missing")
+		case row.OpeningBalance.IsNegative():
+			reject(i+1, username, "opening balance is negative")
+		case rowsByUsername[username] > 0:
+			reject(i+1, username, fmt.Sprintf("username is repeated from row %d", rowsByUsername[username]))
+		default:
+			rowsByUsername[username] = i + 1
+			usernames = append(usernames, username)
+		}
+	}
+
+	taken, err := s.db.GetTakenUsernames(txn, usernames)
+	if err != nil {
+		return nil, err
+	}
+	for _, username := range taken {
+		reject(rowsByUsername[username], username, ErrAccountAlreadyExists.Error())
+	}
+
+	sort.SliceStable(rowErrors, func(i, j int) bool { return rowErrors[i].Row < rowErrors[j].Row })
+	return rowErrors, nil
+}
+
+// createImportedAccounts creates an account for every row, and a deposit for every positive opening balance,
+// holding the lock on transactions once for all of them. rows must have been validated.
+func (s *service) createImportedAccounts(txn dbutil.Transaction, rows []ImportRow) error {
+	accounts := make([]Account, 0, len(rows))
+	var deposits []Transaction
+	var entries []Entry
+	for _, row := range rows {
+		account := Account{
+			Id:       uuid.New(),
+			Username: strings.TrimSpace(row.Username),
+			Currency: defaultAccountCurrency,
+			Status:   ActiveAccountStatus,
+		}
+		accounts = append(accounts, account)
+
+		if row.OpeningBalance.IsPositive() {
+			depositId := uuid.New()
+			deposits = append(deposits, Transaction{Id: depositId, Name: DepositTransaction})
+			entries = append(entries, newCreditEntry(depositId, account.Id, util.NewNullUUID(uuid.Nil), row.OpeningBalance))
+		}
+	}
+
+	if err := s.db.CreateAccounts(txn, accounts); err != nil {
+		return err
+	}
+
+	if err := s.db.LockTransactions(txn); err != nil {
+		return err
+	}
+
+	if err := s.db.CreateTransactions(txn, deposits); err != nil {
+		return err
+	}
+
+	return s.db.CreateEntries(txn, entries)
+}
+
+// --- helpers
+
+func parseOpeningBalance(s string) (decimal.Decimal, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return decimal.Zero, nil
+	}
+	return decimal.NewFromString(s)
+}
@@ -0,0 +1,77 @@
+package transaction_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/nogurenn/cph-wallet/transaction"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ReadImportRows_CSV(t *testing.T) {
+	// given
+	input := "\ufeffopening_balance, username\n150.50,carol789\n,dave000\n"
+
+	// when
+	rows, err := transaction.ReadImportRows(strings.NewReader(input), transaction.CSVFormat)
+
+	// then
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.Equal(t, "carol789", rows[0].Username)
+	assert.True(t, decimal.NewFromFloat(150.5).Equal(rows[0].OpeningBalance))
+	assert.Equal(t, "dave000", rows[1].Username)
+	assert.True(t, rows[1].OpeningBalance.IsZero())
+}
+
+func Test_ReadImportRows_NDJSON(t *testing.T) {
+	// given
+	input := `{"username":"carol789","opening_balance":"150.50"}` + "\n" + `{"username":"dave000"}` + "\n"
+
+	// when
+	rows, err := transaction.ReadImportRows(strings.NewReader(input), transaction.NDJSONFormat)
+
+	// then
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.Equal(t, "carol789", rows[0].Username)
+	assert.True(t, decimal.NewFromFloat(150.5).Equal(rows[0].OpeningBalance))
+	assert.Equal(t, "dave000", rows[1].Username)
+}
+
+func Test_ReadImportRows_Malformed(t *testing.T) {
+	tests := []struct {
+		format string
+		input  string
+		reason string
+	}{
+		{transaction.CSVFormat, "", "there are no rows"},
+		{transaction.CSVFormat, "username\n", "there are no rows"},
+		{transaction.CSVFormat, "name\ncarol789\n", `unknown column "name"`},
+		{transaction.CSVFormat, "opening_balance\n10\n", "username column is missing"},
+		{transaction.CSVFormat, "username,opening_balance\ncarol789,ten\n", `row 1: opening_balance "ten" is not a decimal`},
+		{transaction.CSVFormat, "username,opening_balance\ncarol789\n", "wrong number of fields"},
+		{transaction.NDJSONFormat, `{"username":"carol789","balance":"10"}`, `row 1: json: unknown field "balance"`},
+		{transaction.NDJSONFormat, `{"username":"carol789"}` + "\n" + `{"username":`, "row 2: unexpected EOF"},
+	}
+	for _, test := range tests {
+		// when
+		rows, err := transaction.ReadImportRows(strings.NewReader(test.input), test.format)
+
+		// then
+		assert.True(t, errors.Is(err, transaction.ErrImportMalformed), "%q: %v", test.input, err)
+		assert.Contains(t, err.Error(), test.reason)
+		assert.Nil(t, rows)
+	}
+}
+
+func Test_ReadImportRows_FormatUnsupported(t *testing.T) {
+	// when
+	_, err := transaction.ReadImportRows(strings.NewReader("username\ncarol789\n"), "application/json")
+
+	// then
+	assert.Equal(t, transaction.ErrImportFormatUnsupported, err)
+}
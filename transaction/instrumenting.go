@@ -1,49 +1,279 @@
 package transaction
 
 import (
+	"context"
+	"errors"
+	"strconv"
 	"time"
 
 	"github.com/go-kit/kit/metrics"
+	"github.com/google/uuid"
+	"github.com/nogurenn/cph-wallet/dbutil"
 	"github.com/shopspring/decimal"
+	"gopkg.in/guregu/null.v4"
 )
 
+// ServiceMetrics are the metrics recorded by NewInstrumentingService.
+type ServiceMetrics struct {
+	// RequestCount counts calls by "method" and whether they returned an "error".
+	RequestCount metrics.Counter
+	// RequestLatency observes call durations in seconds by "method" and "error".
+	RequestLatency metrics.Histogram
+	// PaymentVolume sums the amounts of recorded payments by "currency". Idempotent replays are not counted again.
+	PaymentVolume metrics.Counter
+	// PaymentAmount observes the amounts of recorded payments by "currency".
+	PaymentAmount metrics.Histogram
+	// BalanceRejections counts payments rejected with ErrBalanceInsufficient.
+	BalanceRejections metrics.Counter
+}
+
 type instrumentingService struct {
-	requestCount   metrics.Counter
-	requestLatency metrics.Histogram
+	metrics ServiceMetrics
 	Service
 }
 
-func NewInstrumentingService(counter metrics.Counter, latency metrics.Histogram, s Service) Service {
+func NewInstrumentingService(metrics ServiceMetrics, s Service) Service {
 	return &instrumentingService{
-		requestCount:   counter,
-		requestLatency: latency,
-		Service:        s,
+		metrics: metrics,
+		Service: s,
 	}
 }
 
-func (s *instrumentingService) GetAccounts() ([]Account, error) {
+func (s *instrumentingService) CreateAccount(ctx context.Context, username string) (err error) {
+	defer func(begin time.Time) {
+		s.observe("create_account", begin, err)
+	}(time.Now())
+
+	return s.Service.CreateAccount(ctx, username)
+}
+
+func (s *instrumentingService) GetAccounts(ctx context.Context) (accounts []Account, err error) {
+	defer func(begin time.Time) {
+		s.observe("get_accounts", begin, err)
+	}(time.Now())
+
+	return s.Service.GetAccounts(ctx)
+}
+
+func (s *instrumentingService) GetAccount(ctx context.Context, username string) (account *Account, err error) {
+	defer func(begin time.Time) {
+		s.observe("get_account", begin, err)
+	}(time.Now())
+
+	return s.Service.GetAccount(ctx, username)
+}
+
+func (s *instrumentingService) GetAccountsAsOf(ctx context.Context, asOf time.Time) (accounts []Account, err error) {
+	defer func(begin time.Time) {
+		s.observe("get_accounts_as_of", begin, err)
+	}(time.Now())
+
+	return s.Service.GetAccountsAsOf(ctx, asOf)
+}
+
+func (s *instrumentingService) GetAccountAsOf(ctx context.Context, username string, asOf time.Time) (account *Account, err error) {
+	defer func(begin time.Time) {
+		s.observe("get_account_as_of", begin, err)
+	}(time.Now())
+
+	return s.Service.GetAccountAsOf(ctx, username, asOf)
+}
+
+func (s *instrumentingService) GetPaymentTransactions(ctx context.Context, filter TransactionFilter) (transactions []Transaction, err error) {
+	defer func(begin time.Time) {
+		s.observe("get_payment_transactions", begin, err)
+	}(time.Now())
+
+	return s.Service.GetPaymentTransactions(ctx, filter)
+}
+
+func (s *instrumentingService) Deposit(ctx context.Context, username string, amount decimal.Decimal) (err error) {
 	defer func(begin time.Time) {
-		s.requestCount.With("method", "get_accounts").Add(1)
-		s.requestLatency.With("method", "get_accounts").Observe(time.Since(begin).Seconds())
+		s.observe("deposit", begin, err)
 	}(time.Now())
 
-	return s.Service.GetAccounts()
+	return s.Service.Deposit(ctx, username, amount)
 }
 
-func (s *instrumentingService) GetPaymentTransactions() ([]Transaction, error) {
+func (s *instrumentingService) SendPayment(ctx context.Context, username string, targetUsername string, amount decimal.Decimal, details PaymentDetails) (err error) {
 	defer func(begin time.Time) {
-		s.requestCount.With("method", "get_payment_transactions").Add(1)
-		s.requestLatency.With("method", "get_payment_transactions").Observe(time.Since(begin).Seconds())
+		s.observe("send_payment", begin, err)
+		s.observeBalanceRejection(err)
 	}(time.Now())
 
-	return s.Service.GetPaymentTransactions()
+	return s.Service.SendPayment(withPaymentObserver(ctx, s.observePayment), username, targetUsername, amount, details)
 }
 
-func (s *instrumentingService) SendPayment(username string, targetUsername string, amount decimal.Decimal) error {
+func (s *instrumentingService) CreatePaymentRequest(ctx context.Context, requesterUsername string, payerUsername string, amount decimal.Decimal, memo null.String, expiresAt time.Time) (_ *PaymentRequest, err error) {
 	defer func(begin time.Time) {
-		s.requestCount.With("method", "send_payment").Add(1)
-		s.requestLatency.With("method", "send_payment").Observe(time.Since(begin).Seconds())
+		s.observe("create_payment_request", begin, err)
 	}(time.Now())
 
-	return s.Service.SendPayment(username, targetUsername, amount)
+	return s.Service.CreatePaymentRequest(ctx, requesterUsername, payerUsername, amount, memo, expiresAt)
+}
+
+func (s *instrumentingService) GetPaymentRequests(ctx context.Context, filter PaymentRequestFilter) (_ []PaymentRequest, err error) {
+	defer func(begin time.Time) {
+		s.observe("get_payment_requests", begin, err)
+	}(time.Now())
+
+	return s.Service.GetPaymentRequests(ctx, filter)
+}
+
+func (s *instrumentingService) AcceptPaymentRequest(ctx context.Context, id uuid.UUID, payerUsername string) (_ *PaymentRequest, err error) {
+	defer func(begin time.Time) {
+		s.observe("accept_payment_request", begin, err)
+		s.observeBalanceRejection(err)
+	}(time.Now())
+
+	// accepted requests are paid like any other payment
+	return s.Service.AcceptPaymentRequest(withPaymentObserver(ctx, s.observePayment), id, payerUsername)
+}
+
+func (s *instrumentingService) DeclinePaymentRequest(ctx context.Context, id uuid.UUID, payerUsername string) (_ *PaymentRequest, err error) {
+	defer func(begin time.Time) {
+		s.observe("decline_payment_request", begin, err)
+	}(time.Now())
+
+	return s.Service.DeclinePaymentRequest(ctx, id, payerUsername)
+}
+
+func (s *instrumentingService) CancelPaymentRequest(ctx context.Context, id uuid.UUID, requesterUsername string) (_ *PaymentRequest, err error) {
+	defer func(begin time.Time) {
+		s.observe("cancel_payment_request", begin, err)
+	}(time.Now())
+
+	return s.Service.CancelPaymentRequest(ctx, id, requesterUsername)
+}
+
+func (s *instrumentingService) FreezeAccount(ctx context.Context, username string, reason string) (err error) {
+	defer func(begin time.Time) {
+		s.observe("freeze_account", begin, err)
+	}(time.Now())
+
+	return s.Service.FreezeAccount(ctx, username, reason)
+}
+
+func (s *instrumentingService) UnfreezeAccount(ctx context.Context, username string, reason string) (err error) {
+	defer func(begin time.Time) {
+		s.observe("unfreeze_account", begin, err)
+	}(time.Now())
+
+	return s.Service.UnfreezeAccount(ctx, username, reason)
+}
+
+func (s *instrumentingService) CloseAccount(ctx context.Context, username string, reason string) (err error) {
+	defer func(begin time.Time) {
+		s.observe("close_account", begin, err)
+	}(time.Now())
+
+	return s.Service.CloseAccount(ctx, username, reason)
+}
+
+func (s *instrumentingService) GetAccountStatusChanges(ctx context.Context, username string) (changes []AccountStatusChange, err error) {
+	defer func(begin time.Time) {
+		s.observe("get_account_status_changes", begin, err)
+	}(time.Now())
+
+	return s.Service.GetAccountStatusChanges(ctx, username)
+}
+
+func (s *instrumentingService) GetAccountStatement(ctx context.Context, username string) (lines []StatementLine, err error) {
+	defer func(begin time.Time) {
+		s.observe("get_account_statement", begin, err)
+	}(time.Now())
+
+	return s.Service.GetAccountStatement(ctx, username)
+}
+
+func (s *instrumentingService) ExportEntries(ctx context.Context, filter ExportFilter, fn func(ExportedEntry) error) (err error) {
+	defer func(begin time.Time) {
+		s.observe("export_entries", begin, err)
+	}(time.Now())
+
+	return s.Service.ExportEntries(ctx, filter, fn)
+}
+
+func (s *instrumentingService) StreamAccountEvents(ctx context.Context, username string, lastEventId null.Int, fn func(AccountEvent) error) (err error) {
+	defer func(begin time.Time) {
+		s.observe("stream_account_events", begin, err)
+	}(time.Now())
+
+	return s.Service.StreamAccountEvents(ctx, username, lastEventId, fn)
+}
+
+func (s *instrumentingService) ImportAccounts(ctx context.Context, rows []ImportRow, dryRun bool) (_ *ImportReport, err error) {
+	defer func(begin time.Time) {
+		s.observe("import_accounts", begin, err)
+	}(time.Now())
+
+	return s.Service.ImportAccounts(ctx, rows, dryRun)
+}
+
+func (s *instrumentingService) Reconcile(ctx context.Context) (discrepancies []Discrepancy, err error) {
+	defer func(begin time.Time) {
+		s.observe("reconcile", begin, err)
+	}(time.Now())
+
+	return s.Service.Reconcile(ctx)
+}
+
+func (s *instrumentingService) GetAuditLog(ctx context.Context, filter AuditFilter) (records []AuditRecord, err error) {
+	defer func(begin time.Time) {
+		s.observe("get_audit_log", begin, err)
+	}(time.Now())
+
+	return s.Service.GetAuditLog(ctx, filter)
+}
+
+func (s *instrumentingService) VerifyAuditLog(ctx context.Context) (chainBreak *AuditChainBreak, err error) {
+	defer func(begin time.Time) {
+		s.observe("verify_audit_log", begin, err)
+	}(time.Now())
+
+	return s.Service.VerifyAuditLog(ctx)
+}
+
+type instrumentingRepository struct {
+	lockWait metrics.Histogram
+	Repository
+}
+
+// NewInstrumentingRepository returns a Repository that observes in lockWait how many seconds LockTransactions
+// waits for the lock.
+func NewInstrumentingRepository(lockWait metrics.Histogram, r Repository) Repository {
+	return &instrumentingRepository{
+		lockWait:   lockWait,
+		Repository: r,
+	}
+}
+
+func (r *instrumentingRepository) LockTransactions(txn dbutil.Transaction) error {
+	defer func(begin time.Time) {
+		r.lockWait.Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return r.Repository.LockTransactions(txn)
+}
+
+// --- helpers
+
+func (s *instrumentingService) observe(method string, begin time.Time, err error) {
+	lvs := []string{"method", method, "error", strconv.FormatBool(err != nil)}
+	s.metrics.RequestCount.With(lvs...).Add(1)
+	s.metrics.RequestLatency.With(lvs...).Observe(time.Since(begin).Seconds())
+}
+
+// observePayment records a payment of amount that was recorded, as told by the service.
+func (s *instrumentingService) observePayment(amount decimal.Decimal) {
+	// every account is opened in defaultAccountCurrency, so payments cannot be in any other
+	value, _ := amount.Float64()
+	s.metrics.PaymentVolume.With("currency", defaultAccountCurrency).Add(value)
+	s.metrics.PaymentAmount.With("currency", defaultAccountCurrency).Observe(value)
+}
+
+func (s *instrumentingService) observeBalanceRejection(err error) {
+	if errors.Is(err, ErrBalanceInsufficient) {
+		s.metrics.BalanceRejections.Add(1)
+	}
 }
@@ -0,0 +1,59 @@
+package transaction_test
+
+import (
+	"context"
+	"testing"
+
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	mocktransaction "github.com/nogurenn/cph-wallet/mocks/autogen/transaction"
+	"github.com/nogurenn/cph-wallet/transaction"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_InstrumentingService_SendPayment(t *testing.T) {
+	// given
+	requestCount := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "request_count"}, []string{"method", "error"})
+	paymentVolume := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "volume_total"}, []string{"currency"})
+	balanceRejections := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "insufficient_balance_total"}, []string{})
+
+	s := new(mocktransaction.Service)
+	s.On("SendPayment", mock.Anything, "bob123", "alice456", decimal.NewFromFloat(100.0), transaction.PaymentDetails{}).Return(nil).Run(func(args mock.Arguments) {
+		transaction.ObserveRecordedPayment(args.Get(0).(context.Context), args.Get(3).(decimal.Decimal))
+	}).Once()
+	// a retry that is replayed under its idempotency key records nothing
+	s.On("SendPayment", mock.Anything, "bob123", "alice456", decimal.NewFromFloat(100.0), transaction.PaymentDetails{}).Return(nil).Once()
+	s.On("SendPayment", mock.Anything, "bob123", "alice456", decimal.NewFromFloat(900.0), transaction.PaymentDetails{}).Return(transaction.ErrBalanceInsufficient)
+
+	service := transaction.NewInstrumentingService(transaction.ServiceMetrics{
+		RequestCount: kitprometheus.NewCounter(requestCount),
+		RequestLatency: kitprometheus.NewHistogram(prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{Name: "request_latency_seconds"}, []string{"method", "error"},
+		)),
+		PaymentVolume: kitprometheus.NewCounter(paymentVolume),
+		PaymentAmount: kitprometheus.NewHistogram(prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{Name: "amount"}, []string{"currency"},
+		)),
+		BalanceRejections: kitprometheus.NewCounter(balanceRejections),
+	}, s)
+
+	// when
+	okErr := service.SendPayment(context.Background(), "bob123", "alice456", decimal.NewFromFloat(100.0), transaction.PaymentDetails{})
+	replayedErr := service.SendPayment(context.Background(), "bob123", "alice456", decimal.NewFromFloat(100.0), transaction.PaymentDetails{})
+	rejectedErr := service.SendPayment(context.Background(), "bob123", "alice456", decimal.NewFromFloat(900.0), transaction.PaymentDetails{})
+
+	// then
+	assert.NoError(t, okErr)
+	assert.NoError(t, replayedErr)
+	assert.Equal(t, transaction.ErrBalanceInsufficient, rejectedErr)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(requestCount.WithLabelValues("send_payment", "false")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(requestCount.WithLabelValues("send_payment", "true")))
+	assert.Equal(t, 100.0, testutil.ToFloat64(paymentVolume.WithLabelValues("USD")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(balanceRejections.WithLabelValues()))
+
+	s.AssertExpectations(t)
+}
@@ -1,10 +1,14 @@
 package transaction
 
 import (
+	"context"
 	"time"
 
 	"github.com/go-kit/log"
+	"github.com/google/uuid"
+	"github.com/nogurenn/cph-wallet/tracing"
 	"github.com/shopspring/decimal"
+	"gopkg.in/guregu/null.v4"
 )
 
 type loggingService struct {
@@ -16,35 +20,324 @@ func NewLoggingService(logger log.Logger, s Service) Service {
 	return &loggingService{logger, s}
 }
 
-func (s *loggingService) GetAccounts() ([]Account, error) {
+func (s *loggingService) CreateAccount(ctx context.Context, username string) (err error) {
 	defer func(begin time.Time) {
-		s.logger.Log(
+		s.log(ctx, begin, err,
+			"method", "create_account",
+			"username", username,
+		)
+	}(time.Now())
+
+	return s.Service.CreateAccount(ctx, username)
+}
+
+func (s *loggingService) GetAccounts(ctx context.Context) (accounts []Account, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err,
 			"method", "get_accounts",
-			"took", time.Since(begin),
+			"count", len(accounts),
 		)
 	}(time.Now())
 
-	return s.Service.GetAccounts()
+	return s.Service.GetAccounts(ctx)
 }
 
-func (s *loggingService) GetPaymentTransactions() ([]Transaction, error) {
+func (s *loggingService) GetAccount(ctx context.Context, username string) (account *Account, err error) {
 	defer func(begin time.Time) {
-		s.logger.Log(
+		s.log(ctx, begin, err,
+			"method", "get_account",
+			"username", username,
+		)
+	}(time.Now())
+
+	return s.Service.GetAccount(ctx, username)
+}
+
+func (s *loggingService) GetAccountsAsOf(ctx context.Context, asOf time.Time) (accounts []Account, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err,
+			"method", "get_accounts_as_of",
+			"as_of", asOf,
+			"count", len(accounts),
+		)
+	}(time.Now())
+
+	return s.Service.GetAccountsAsOf(ctx, asOf)
+}
+
+func (s *loggingService) GetAccountAsOf(ctx context.Context, username string, asOf time.Time) (account *Account, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err,
+			"method", "get_account_as_of",
+			"username", username,
+			"as_of", asOf,
+		)
+	}(time.Now())
+
+	return s.Service.GetAccountAsOf(ctx, username, asOf)
+}
+
+func (s *loggingService) GetPaymentTransactions(ctx context.Context, filter TransactionFilter) (transactions []Transaction, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err,
 			"method", "get_payment_transactions",
-			"took", time.Since(begin),
+			"external_reference", filter.ExternalReference,
+			"count", len(transactions),
+		)
+	}(time.Now())
+
+	return s.Service.GetPaymentTransactions(ctx, filter)
+}
+
+func (s *loggingService) Deposit(ctx context.Context, username string, amount decimal.Decimal) (err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err,
+			"method", "deposit",
+			"username", username,
+			"amount", amount,
+			"idempotency_key", IdempotencyKeyFrom(ctx),
 		)
 	}(time.Now())
 
-	return s.Service.GetPaymentTransactions()
+	return s.Service.Deposit(ctx, username, amount)
 }
 
-func (s *loggingService) SendPayment(username string, targetUsername string, amount decimal.Decimal) error {
+func (s *loggingService) SendPayment(ctx context.Context, username string, targetUsername string, amount decimal.Decimal, details PaymentDetails) (err error) {
 	defer func(begin time.Time) {
-		s.logger.Log(
+		s.log(ctx, begin, err,
 			"method", "send_payment",
-			"took", time.Since(begin),
+			"username", username,
+			"target_username", targetUsername,
+			"amount", amount,
+			"external_reference", details.ExternalReference.String,
+			"idempotency_key", IdempotencyKeyFrom(ctx),
 		)
 	}(time.Now())
 
-	return s.Service.SendPayment(username, targetUsername, amount)
+	return s.Service.SendPayment(ctx, username, targetUsername, amount, details)
+}
+
+func (s *loggingService) CreatePaymentRequest(ctx context.Context, requesterUsername string, payerUsername string, amount decimal.Decimal, memo null.String, expiresAt time.Time) (request *PaymentRequest, err error) {
+	defer func(begin time.Time) {
+		var id uuid.UUID
+		if request != nil {
+			id = request.Id
+		}
+		s.log(ctx, begin, err,
+			"method", "create_payment_request",
+			"username", requesterUsername,
+			"target_username", payerUsername,
+			"amount", amount,
+			"expires_at", expiresAt,
+			"id", id,
+		)
+	}(time.Now())
+
+	return s.Service.CreatePaymentRequest(ctx, requesterUsername, payerUsername, amount, memo, expiresAt)
+}
+
+func (s *loggingService) GetPaymentRequests(ctx context.Context, filter PaymentRequestFilter) (requests []PaymentRequest, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err,
+			"method", "get_payment_requests",
+			"username", filter.Account,
+			"direction", filter.Direction,
+			"status", filter.Status,
+			"count", len(requests),
+		)
+	}(time.Now())
+
+	return s.Service.GetPaymentRequests(ctx, filter)
+}
+
+func (s *loggingService) AcceptPaymentRequest(ctx context.Context, id uuid.UUID, payerUsername string) (_ *PaymentRequest, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err,
+			"method", "accept_payment_request",
+			"id", id,
+			"username", payerUsername,
+		)
+	}(time.Now())
+
+	return s.Service.AcceptPaymentRequest(ctx, id, payerUsername)
+}
+
+func (s *loggingService) DeclinePaymentRequest(ctx context.Context, id uuid.UUID, payerUsername string) (_ *PaymentRequest, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err,
+			"method", "decline_payment_request",
+			"id", id,
+			"username", payerUsername,
+		)
+	}(time.Now())
+
+	return s.Service.DeclinePaymentRequest(ctx, id, payerUsername)
+}
+
+func (s *loggingService) CancelPaymentRequest(ctx context.Context, id uuid.UUID, requesterUsername string) (_ *PaymentRequest, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err,
+			"method", "cancel_payment_request",
+			"id", id,
+			"username", requesterUsername,
+		)
+	}(time.Now())
+
+	return s.Service.CancelPaymentRequest(ctx, id, requesterUsername)
+}
+
+func (s *loggingService) FreezeAccount(ctx context.Context, username string, reason string) (err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err,
+			"method", "freeze_account",
+			"username", username,
+			"reason", reason,
+		)
+	}(time.Now())
+
+	return s.Service.FreezeAccount(ctx, username, reason)
+}
+
+func (s *loggingService) UnfreezeAccount(ctx context.Context, username string, reason string) (err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err,
+			"method", "unfreeze_account",
+			"username", username,
+			"reason", reason,
+		)
+	}(time.Now())
+
+	return s.Service.UnfreezeAccount(ctx, username, reason)
+}
+
+func (s *loggingService) CloseAccount(ctx context.Context, username string, reason string) (err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err,
+			"method", "close_account",
+			"username", username,
+			"reason", reason,
+		)
+	}(time.Now())
+
+	return s.Service.CloseAccount(ctx, username, reason)
+}
+
+func (s *loggingService) GetAccountStatusChanges(ctx context.Context, username string) (changes []AccountStatusChange, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err,
+			"method", "get_account_status_changes",
+			"username", username,
+			"count", len(changes),
+		)
+	}(time.Now())
+
+	return s.Service.GetAccountStatusChanges(ctx, username)
+}
+
+func (s *loggingService) GetAccountStatement(ctx context.Context, username string) (lines []StatementLine, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err,
+			"method", "get_account_statement",
+			"username", username,
+			"count", len(lines),
+		)
+	}(time.Now())
+
+	return s.Service.GetAccountStatement(ctx, username)
+}
+
+func (s *loggingService) ExportEntries(ctx context.Context, filter ExportFilter, fn func(ExportedEntry) error) (err error) {
+	count := 0
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err,
+			"method", "export_entries",
+			"kind", filter.Kind,
+			"account", filter.Account,
+			"count", count,
+		)
+	}(time.Now())
+
+	return s.Service.ExportEntries(ctx, filter, func(entry ExportedEntry) error {
+		count++
+		return fn(entry)
+	})
+}
+
+func (s *loggingService) StreamAccountEvents(ctx context.Context, username string, lastEventId null.Int, fn func(AccountEvent) error) (err error) {
+	count := 0
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err,
+			"method", "stream_account_events",
+			"username", username,
+			"last_event_id", lastEventId.ValueOrZero(),
+			"count", count,
+		)
+	}(time.Now())
+
+	return s.Service.StreamAccountEvents(ctx, username, lastEventId, func(event AccountEvent) error {
+		count++
+		return fn(event)
+	})
+}
+
+func (s *loggingService) ImportAccounts(ctx context.Context, rows []ImportRow, dryRun bool) (report *ImportReport, err error) {
+	defer func(begin time.Time) {
+		invalid := 0
+		if report != nil {
+			invalid = len(report.Errors)
+		}
+		s.log(ctx, begin, err,
+			"method", "import_accounts",
+			"rows", len(rows),
+			"dry_run", dryRun,
+			"invalid", invalid,
+		)
+	}(time.Now())
+
+	return s.Service.ImportAccounts(ctx, rows, dryRun)
+}
+
+func (s *loggingService) Reconcile(ctx context.Context) (discrepancies []Discrepancy, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err,
+			"method", "reconcile",
+			"count", len(discrepancies),
+		)
+	}(time.Now())
+
+	return s.Service.Reconcile(ctx)
+}
+
+func (s *loggingService) GetAuditLog(ctx context.Context, filter AuditFilter) (records []AuditRecord, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err,
+			"method", "get_audit_log",
+			"claimed_actor", filter.ClaimedActor,
+			"action", filter.Action,
+			"account", filter.Account,
+			"count", len(records),
+		)
+	}(time.Now())
+
+	return s.Service.GetAuditLog(ctx, filter)
+}
+
+func (s *loggingService) VerifyAuditLog(ctx context.Context) (chainBreak *AuditChainBreak, err error) {
+	defer func(begin time.Time) {
+		s.log(ctx, begin, err,
+			"method", "verify_audit_log",
+			"intact", chainBreak == nil,
+		)
+	}(time.Now())
+
+	return s.Service.VerifyAuditLog(ctx)
+}
+
+// --- helpers
+
+// log writes one line per call, with the request and trace ids carried by ctx, keyvals, and how the call ended.
+func (s *loggingService) log(ctx context.Context, begin time.Time, err error, keyvals ...interface{}) {
+	keyvals = append([]interface{}{"request_id", RequestIDFrom(ctx), "trace_id", tracing.TraceID(ctx)}, keyvals...)
+	keyvals = append(keyvals, "took", time.Since(begin), "err", err)
+	s.logger.Log(keyvals...)
 }
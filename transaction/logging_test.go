@@ -0,0 +1,68 @@
+package transaction_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/go-kit/log"
+	mocktransaction "github.com/nogurenn/cph-wallet/mocks/autogen/transaction"
+	"github.com/nogurenn/cph-wallet/transaction"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_LoggingService_SendPayment_LogsArgumentsAndError(t *testing.T) {
+	// given
+	ctx := transaction.WithRequestID(context.Background(), "req-42")
+	amount := decimal.NewFromFloat(12.5)
+
+	s := new(mocktransaction.Service)
+	s.On("SendPayment", mock.Anything, "bob123", "alice456", amount, transaction.PaymentDetails{}).Return(transaction.ErrBalanceInsufficient)
+
+	var buf bytes.Buffer
+	service := transaction.NewLoggingService(log.NewJSONLogger(&buf), s)
+
+	// when
+	err := service.SendPayment(ctx, "bob123", "alice456", amount, transaction.PaymentDetails{})
+
+	// then
+	assert.Equal(t, transaction.ErrBalanceInsufficient, err)
+
+	var line map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "send_payment", line["method"])
+	assert.Equal(t, "req-42", line["request_id"])
+	assert.Equal(t, "bob123", line["username"])
+	assert.Equal(t, "alice456", line["target_username"])
+	assert.Equal(t, "12.5", line["amount"])
+	assert.Equal(t, transaction.ErrBalanceInsufficient.Error(), line["err"])
+	assert.Contains(t, line, "took")
+
+	s.AssertExpectations(t)
+}
+
+func Test_LoggingService_CreateAccount_LogsSuccess(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+	s.On("CreateAccount", mock.Anything, "alice456").Return(nil)
+
+	var buf bytes.Buffer
+	service := transaction.NewLoggingService(log.NewJSONLogger(&buf), s)
+
+	// when
+	err := service.CreateAccount(context.Background(), "alice456")
+
+	// then
+	assert.NoError(t, err)
+
+	var line map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "create_account", line["method"])
+	assert.Equal(t, "alice456", line["username"])
+	assert.Nil(t, line["err"])
+
+	s.AssertExpectations(t)
+}
@@ -1,6 +1,12 @@
 package transaction
 
 import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/nogurenn/cph-wallet/dbutil"
 	"github.com/shopspring/decimal"
@@ -12,16 +18,97 @@ type Account struct {
 	Username          string          `db:"username" json:"id"`
 	Balance           decimal.Decimal `db:"balance" json:"balance"` // decimal.Decimal marshals to string to prevent silent precision loss
 	Currency          string          `db:"currency" json:"currency"`
+	Status            string          `db:"status" json:"status"`
 	dbutil.Timestamps `json:"-"`
+
+	LastEntryId uuid.NullUUID `db:"last_entry_id" json:"-"` // latest ledger entry of the account by Seq, if any
+}
+
+// AvailableBalance is the portion of Balance the account can spend right now.
+// Frozen and closed accounts cannot send funds, so nothing is available to them.
+func (a Account) AvailableBalance() decimal.Decimal {
+	if a.Status != ActiveAccountStatus {
+		return decimal.Zero
+	}
+	return a.Balance
+}
+
+type AccountDetails struct {
+	Username         string          `json:"id"`
+	Balance          decimal.Decimal `json:"balance"`
+	AvailableBalance decimal.Decimal `json:"available_balance"`
+	Currency         string          `json:"currency"`
+	Status           string          `json:"status"`
+	CreatedAt        time.Time       `json:"created_at"`
+}
+
+type AccountStatusChange struct {
+	Id         uuid.UUID `db:"id" json:"-"`
+	AccountId  uuid.UUID `db:"account_id" json:"-"`
+	FromStatus string    `db:"from_status" json:"from_status"`
+	ToStatus   string    `db:"to_status" json:"to_status"`
+	Reason     string    `db:"reason" json:"reason"`
+	dbutil.Timestamps
 }
 
 type Transaction struct {
-	Id                uuid.UUID `db:"id"`
-	Name              string    `db:"name"`
+	Id             uuid.UUID     `db:"id"`
+	Name           string        `db:"name"`
+	IdempotencyKey null.String   `db:"idempotency_key" json:"-"`
+	SenderId       uuid.NullUUID `db:"sender_id" json:"-"` // set for payments only
+	PaymentDetails
 	dbutil.Timestamps `json:"-"`
 	Entries           []Entry `json:"entries"`
 }
 
+// PaymentDetails is what the sender of a payment tells about it, all of which is optional.
+type PaymentDetails struct {
+	Description       null.String `db:"description" json:"description"`
+	ExternalReference null.String `db:"external_reference" json:"external_reference"` // unique among the payments of a sender
+	Metadata          Metadata    `db:"metadata" json:"metadata"`
+}
+
+// Metadata is arbitrary data attached to a payment, stored as a JSON object. Numbers are kept as json.Number so
+// that they round-trip without losing precision.
+type Metadata map[string]interface{}
+
+func (m *Metadata) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var fields map[string]interface{}
+	if err := decoder.Decode(&fields); err != nil {
+		return err
+	}
+	*m = fields
+	return nil
+}
+
+func (m Metadata) Value() (driver.Value, error) {
+	if m == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (m *Metadata) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case []byte:
+		return m.UnmarshalJSON(src)
+	case string:
+		return m.UnmarshalJSON([]byte(src))
+	default:
+		return fmt.Errorf("cannot scan %T into Metadata", src)
+	}
+}
+
 type Entry struct {
 	Id                uuid.UUID       `db:"id"`
 	TransactionId     uuid.UUID       `db:"transaction_id"`
@@ -32,6 +119,11 @@ type Entry struct {
 	Debit             decimal.Decimal `db:"debit"`
 	dbutil.Timestamps `json:"-"`
 
+	// position in the hash chain of AccountId, set by the database on insert
+	Seq      int64  `db:"seq"`
+	PrevHash string `db:"prev_hash"`
+	Hash     string `db:"hash"`
+
 	AccountName       string      `db:"username"`
 	TargetAccountName null.String `db:"target_username"`
 }
@@ -40,6 +132,7 @@ type Payment struct {
 	Id      uuid.UUID      `json:"id"`
 	Name    string         `json:"name"`
 	Entries []PaymentEntry `json:"entries"`
+	PaymentDetails
 	dbutil.Timestamps
 }
 
@@ -50,3 +143,140 @@ type PaymentEntry struct {
 	FromAccount string          `json:"from_account,omitempty"`
 	Direction   string          `json:"direction"`
 }
+
+// PaymentRequest is a request by Requester for Payer to pay it Amount, which Payer may accept until ExpiresAt.
+type PaymentRequest struct {
+	Id            uuid.UUID       `db:"id" json:"id"`
+	RequesterId   uuid.UUID       `db:"requester_id" json:"-"`
+	PayerId       uuid.UUID       `db:"payer_id" json:"-"`
+	Requester     string          `db:"requester" json:"requester"`
+	Payer         string          `db:"payer" json:"payer"`
+	Amount        decimal.Decimal `db:"amount" json:"amount"`
+	Memo          null.String     `db:"memo" json:"memo"`
+	Status        string          `db:"status" json:"status"`
+	ExpiresAt     time.Time       `db:"expires_at" json:"expires_at"`
+	TransactionId uuid.NullUUID   `db:"transaction_id" json:"transaction_id"` // the payment that settled the request, once paid
+	dbutil.Timestamps
+}
+
+// StatementLine is an entry of an account, with the balance of the account right after it.
+type StatementLine struct {
+	EntryId         uuid.UUID       `db:"entry_id" json:"entry_id"`
+	TransactionId   uuid.UUID       `db:"transaction_id" json:"transaction_id"`
+	TransactionName string          `db:"transaction_name" json:"type"`
+	Counterparty    null.String     `db:"counterparty" json:"counterparty"` // absent for deposits
+	Amount          decimal.Decimal `db:"amount" json:"amount"`             // negative for outgoing entries
+	Balance         decimal.Decimal `db:"balance" json:"balance"`
+	CreatedAt       time.Time       `db:"created_at" json:"created_at"`
+}
+
+// AccountEvent is a new entry of an account as pushed to event streams, identified by its position Seq in the
+// entries of the account.
+type AccountEvent struct {
+	Seq             int64           `db:"seq" json:"seq"`
+	EntryId         uuid.UUID       `db:"entry_id" json:"entry_id"`
+	TransactionId   uuid.UUID       `db:"transaction_id" json:"transaction_id"`
+	TransactionName string          `db:"transaction_name" json:"type"`
+	Counterparty    null.String     `db:"counterparty" json:"counterparty"` // absent for deposits
+	Amount          decimal.Decimal `db:"amount" json:"amount"`             // negative for outgoing entries
+	Balance         decimal.Decimal `db:"balance" json:"balance"`           // balance of the account right after the entry
+	CreatedAt       time.Time       `db:"created_at" json:"created_at"`
+}
+
+// ExportedEntry is a ledger entry as exported to spreadsheets and warehouses, one per row.
+type ExportedEntry struct {
+	EntryId         uuid.UUID           `db:"entry_id" json:"entry_id"`
+	TransactionId   uuid.UUID           `db:"transaction_id" json:"transaction_id"`
+	TransactionName string              `db:"transaction_name" json:"type"`
+	Account         string              `db:"account" json:"account"`
+	Counterparty    null.String         `db:"counterparty" json:"counterparty"` // absent for deposits
+	Amount          decimal.Decimal     `db:"amount" json:"amount"`             // negative for outgoing entries
+	Balance         decimal.NullDecimal `db:"balance" json:"balance"`           // balance of Account right after the entry, in statements only
+	CreatedAt       time.Time           `db:"created_at" json:"created_at"`
+}
+
+// ExportFilter selects the entries to export: those of every PaymentTransaction or DepositTransaction, or the
+// statement of a single account for StatementExport.
+type ExportFilter struct {
+	Kind    string
+	Account string // required for StatementExport, and narrows down the others to one account
+	Since   time.Time
+	Until   time.Time // exclusive
+}
+
+// ImportRow is an account to create in a bulk import, with the balance it is opened with. A positive
+// OpeningBalance is recorded as a deposit.
+type ImportRow struct {
+	Username       string          `json:"username"`
+	OpeningBalance decimal.Decimal `json:"opening_balance"`
+}
+
+// ImportReport describes the outcome of a bulk import. Imports are all or nothing: if any row is invalid,
+// Errors lists every invalid row and no account is created.
+type ImportReport struct {
+	DryRun         bool             `json:"dry_run"`
+	Rows           int              `json:"rows"`
+	Accounts       int              `json:"accounts"`        // created, or that would be created by a dry run
+	OpeningBalance decimal.Decimal  `json:"opening_balance"` // sum over every row
+	Errors         []ImportRowError `json:"errors"`
+}
+
+// ImportRowError is why a row of a bulk import is invalid. Row is the 1-based position of the row among the
+// imported rows, not counting the header of CSV files.
+type ImportRowError struct {
+	Row      int    `json:"row"`
+	Username string `json:"username"`
+	Error    string `json:"error"`
+}
+
+// Discrepancy is a violation of a ledger invariant found by reconciliation.
+type Discrepancy struct {
+	Check     string `db:"check_name" json:"check"`
+	Reference string `db:"reference" json:"reference"` // transaction id or username, depending on Check
+	Detail    string `db:"detail" json:"detail"`
+}
+
+// AuditRecord is an entry of the audit log. Hash covers every other field and PrevHash, which is the Hash of the
+// record before it in Seq order, so that changing or removing any record breaks the chain from there on.
+type AuditRecord struct {
+	Seq          int64       `db:"seq" json:"seq"`
+	ClaimedActor string      `db:"claimed_actor" json:"claimed_actor"` // as told by the caller, not verified
+	Action       string      `db:"action" json:"action"`
+	Account      string      `db:"account" json:"account"`
+	Counterparty null.String `db:"counterparty" json:"counterparty"`
+	PayloadHash  string      `db:"payload_hash" json:"payload_hash"`
+	Outcome      string      `db:"outcome" json:"outcome"`
+	CreatedAt    time.Time   `db:"created_at" json:"created_at"`
+	PrevHash     string      `db:"prev_hash" json:"prev_hash"`
+	Hash         string      `db:"hash" json:"hash"`
+}
+
+// TransactionFilter narrows down transaction queries. Zero fields match every transaction.
+type TransactionFilter struct {
+	ExternalReference string
+}
+
+// PaymentRequestFilter narrows down the payment requests of Account. Zero fields other than Account match every
+// request.
+type PaymentRequestFilter struct {
+	Account   string
+	Direction string // IncomingEntry for requests that Account is to pay, OutgoingEntry for those it made
+	Status    string
+}
+
+// AuditFilter narrows down audit log queries. Zero fields match every record.
+type AuditFilter struct {
+	ClaimedActor string
+	Action       string
+	Account      string // matches both Account and Counterparty
+	Since        time.Time
+	Until        time.Time // exclusive
+	AfterSeq     int64
+	Limit        int
+}
+
+// AuditChainBreak is the first audit record that does not follow from the records before it.
+type AuditChainBreak struct {
+	Seq    int64  `json:"seq"`
+	Reason string `json:"reason"`
+}
@@ -0,0 +1,215 @@
+package transaction
+
+import (
+	"encoding"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openAPIBodies lists the JSON request and response body of every route in MakeHandler, keyed by "METHOD path".
+// A nil body means the route has none, or its request is read from the path and headers only. Exports list the
+// type of each line of their JSON Lines body, and so do imports for their request and event streams for the data of
+// their events.
+var openAPIBodies = map[string]struct {
+	request  interface{}
+	response interface{}
+}{
+	"GET /transaction/v1/accounts":                           {nil, getAccountsResponse{}},
+	"POST /transaction/v1/accounts":                          {createAccountRequest{}, createAccountResponse{}},
+	"GET /transaction/v1/accounts/{id}":                      {nil, getAccountResponse{}},
+	"GET /transaction/v1/accounts/{id}/statement":            {nil, getAccountStatementResponse{}},
+	"GET /transaction/v1/accounts/{id}/events":               {nil, AccountEvent{}},
+	"GET /transaction/v1/accounts/{id}/payment-requests":     {nil, getPaymentRequestsResponse{}},
+	"POST /transaction/v1/deposits":                          {depositRequest{}, depositResponse{}},
+	"GET /transaction/v1/payments":                           {nil, getPaymentTransactionsResponse{}},
+	"POST /transaction/v1/payments":                          {sendPaymentRequest{}, sendPaymentResponse{}},
+	"POST /transaction/v1/payment-requests":                  {createPaymentRequestRequest{}, paymentRequestResponse{}},
+	"POST /transaction/v1/payment-requests/{id}/accept":      {respondToPaymentRequestRequest{}, paymentRequestResponse{}},
+	"POST /transaction/v1/payment-requests/{id}/decline":     {respondToPaymentRequestRequest{}, paymentRequestResponse{}},
+	"POST /transaction/v1/payment-requests/{id}/cancel":      {respondToPaymentRequestRequest{}, paymentRequestResponse{}},
+	"GET /transaction/v1/exports/payments":                   {nil, ExportedEntry{}},
+	"GET /transaction/v1/exports/deposits":                   {nil, ExportedEntry{}},
+	"GET /transaction/v1/exports/statements/{id}":            {nil, ExportedEntry{}},
+	"POST /transaction/v1/admin/accounts/{id}/freeze":        {changeAccountStatusRequest{}, changeAccountStatusResponse{}},
+	"POST /transaction/v1/admin/accounts/{id}/unfreeze":      {changeAccountStatusRequest{}, changeAccountStatusResponse{}},
+	"POST /transaction/v1/admin/accounts/{id}/close":         {changeAccountStatusRequest{}, changeAccountStatusResponse{}},
+	"GET /transaction/v1/admin/accounts/{id}/status-changes": {nil, getAccountStatusChangesResponse{}},
+	"GET /transaction/v1/admin/reconciliation":               {nil, reconcileResponse{}},
+	"POST /transaction/v1/admin/imports":                     {ImportRow{}, importAccountsResponse{}},
+	"GET /transaction/v1/admin/audit-log":                    {nil, getAuditLogResponse{}},
+	"GET /transaction/v1/admin/audit-log/verification":       {nil, verifyAuditLogResponse{}},
+	"GET /transaction/v1/openapi.json":                       {nil, nil},
+}
+
+func Test_OpenAPISpec_CoversRoutes(t *testing.T) {
+	// given
+	var spec map[string]interface{}
+	require.NoError(t, json.Unmarshal(openAPISpec, &spec))
+	paths := spec["paths"].(map[string]interface{})
+
+	router := MakeHandler(nil, log.NewNopLogger()).(*mux.Router) // handlers are not called
+
+	// when
+	var routes []string
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return err
+		}
+		for _, method := range methods {
+			routes = append(routes, method+" "+path)
+		}
+		return nil
+	})
+
+	// then
+	require.NoError(t, err)
+	require.NotEmpty(t, routes)
+
+	for _, route := range routes {
+		bodies, ok := openAPIBodies[route]
+		if !assert.True(t, ok, "%s has no entry in openAPIBodies", route) {
+			continue
+		}
+
+		parts := strings.SplitN(route, " ", 2)
+		pathItem, _ := paths[parts[1]].(map[string]interface{})
+		operation, ok := pathItem[strings.ToLower(parts[0])].(map[string]interface{})
+		if !assert.True(t, ok, "%s is missing from openapi.json", route) {
+			continue
+		}
+
+		if bodies.request != nil {
+			schema := lookup(spec, operation, "requestBody", "content", "application/json", "schema")
+			if schema == nil {
+				schema = lookup(spec, operation, "requestBody", "content", NDJSONFormat, "schema")
+			}
+			assertSchemaCovers(t, spec, route+" request", schema, reflect.TypeOf(bodies.request))
+		}
+		if bodies.response != nil {
+			responses := operation["responses"].(map[string]interface{})
+			var schema interface{}
+			for _, code := range []string{"200", "201"} {
+				if response, ok := responses[code]; ok {
+					schema = lookup(spec, response, "content", "application/json", "schema")
+					if schema == nil {
+						schema = lookup(spec, response, "content", NDJSONFormat, "schema")
+					}
+					if schema == nil {
+						schema = lookup(spec, response, "content", eventStreamFormat, "schema")
+					}
+				}
+			}
+			assertSchemaCovers(t, spec, route+" response", schema, reflect.TypeOf(bodies.response))
+		}
+	}
+}
+
+func Test_OpenAPISpec_Served(t *testing.T) {
+	// given
+	handler := MakeHandler(nil, log.NewNopLogger()) // handlers are not called
+	req := httptest.NewRequest(http.MethodGet, "/transaction/v1/openapi.json", nil)
+	rec := httptest.NewRecorder()
+
+	// when
+	handler.ServeHTTP(rec, req)
+
+	// then
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, string(openAPISpec), rec.Body.String())
+}
+
+// --- helpers
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// assertSchemaCovers fails when a JSON field of typ, or of a struct nested in it, has no property in schema.
+func assertSchemaCovers(t *testing.T, spec map[string]interface{}, where string, schema interface{}, typ reflect.Type) {
+	t.Helper()
+
+	for typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Slice {
+		typ = typ.Elem()
+		if items := lookup(spec, schema, "items"); items != nil {
+			schema = items
+		}
+	}
+	if typ.Kind() != reflect.Struct || typ.Implements(jsonMarshalerType) || typ.Implements(textMarshalerType) {
+		return
+	}
+
+	properties, ok := lookup(spec, schema, "properties").(map[string]interface{})
+	if !assert.True(t, ok, "%s: no object schema for %s", where, typ) {
+		return
+	}
+
+	for name, field := range jsonFields(typ) {
+		property, ok := properties[name]
+		if assert.True(t, ok, "%s: %s.%s is missing from openapi.json", where, typ.Name(), name) {
+			assertSchemaCovers(t, spec, where, property, field)
+		}
+	}
+}
+
+// jsonFields returns the JSON names of the fields encoding/json would marshal for typ, including promoted ones.
+func jsonFields(typ reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || (field.PkgPath != "" && !field.Anonymous) {
+			continue
+		}
+
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			for promoted, promotedType := range jsonFields(field.Type) {
+				fields[promoted] = promotedType
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// lookup walks keys from node, following $ref pointers into spec along the way. It returns nil if a key is missing.
+func lookup(spec map[string]interface{}, node interface{}, keys ...string) interface{} {
+	for _, key := range append(keys, "") {
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		if ref, ok := m["$ref"].(string); ok {
+			var target interface{} = spec
+			for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+				target = target.(map[string]interface{})[part]
+			}
+			m, _ = target.(map[string]interface{})
+		}
+		if key == "" {
+			return m
+		}
+		node = m[key]
+	}
+	return node
+}
@@ -1,6 +1,11 @@
 package transaction
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	"github.com/nogurenn/cph-wallet/dbutil"
@@ -12,42 +17,127 @@ const transactionSchemaName = "wallet"
 
 type Repository interface {
 	// BeginTxn creates a transaction object to be used by queries and commands representing a single transaction.
-	BeginTxn() (dbutil.Transaction, error)
+	BeginTxn(ctx context.Context, opts *sql.TxOptions) (dbutil.Transaction, error)
+	// BeginReplicaTxn creates a read-only REPEATABLE READ transaction on the replica, which may trail the primary
+	// by up to the configured staleness. It falls back to the primary if there is no replica or it trails further.
+	BeginReplicaTxn(ctx context.Context) (dbutil.Transaction, error)
 	// GetAccounts retrieves a slice of Account instances.
 	GetAccounts(txn dbutil.Transaction) ([]Account, error)
-	// GetAccountByUsername retrieves an Account by username.
+	// GetAccountByUsername retrieves an Account by username, or ErrAccountNotFound if there is none.
 	GetAccountByUsername(txn dbutil.Transaction, username string) (*Account, error)
-	// CreateAccount creates an Account in the storage.
+	// GetAccountsAsOf retrieves the Account instances that existed at asOf, with their balance and status at asOf.
+	GetAccountsAsOf(txn dbutil.Transaction, asOf time.Time) ([]Account, error)
+	// GetAccountByUsernameAsOf retrieves an Account with its balance and status at asOf, or ErrAccountNotFound
+	// if it did not exist at asOf.
+	GetAccountByUsernameAsOf(txn dbutil.Transaction, username string, asOf time.Time) (*Account, error)
+	// CreateBalanceCheckpoints records the balance of every account at asOf, unless already recorded, and returns
+	// how many were recorded. asOf should be far enough in the past that no entries before it are still uncommitted.
+	CreateBalanceCheckpoints(txn dbutil.Transaction, asOf time.Time) (int64, error)
+	// CreateAccount creates an Account in the storage, or returns ErrAccountAlreadyExists if the username is taken.
 	CreateAccount(txn dbutil.Transaction, account Account) error
-	// GetTransactionsByName retrieves all transactions with name `name` and their respective entries.
-	GetTransactionsByName(txn dbutil.Transaction, name string) ([]Transaction, error)
+	// GetTakenUsernames retrieves those of usernames that are taken by an Account.
+	GetTakenUsernames(txn dbutil.Transaction, usernames []string) ([]string, error)
+	// CreateAccounts creates many Account instances in batches, or returns ErrAccountAlreadyExists if any
+	// username is taken.
+	CreateAccounts(txn dbutil.Transaction, accounts []Account) error
+	// GetAccountStatusForUpdate retrieves the status of an Account and locks its row until the transaction ends.
+	GetAccountStatusForUpdate(txn dbutil.Transaction, accountId uuid.UUID) (string, error)
+	// UpdateAccountStatus sets the status of an Account.
+	UpdateAccountStatus(txn dbutil.Transaction, accountId uuid.UUID, status string) error
+	// CreateAccountStatusChange records a status transition of an Account.
+	CreateAccountStatusChange(txn dbutil.Transaction, change AccountStatusChange) error
+	// GetAccountStatusChanges retrieves the status transitions of an Account, latest first.
+	GetAccountStatusChanges(txn dbutil.Transaction, accountId uuid.UUID) ([]AccountStatusChange, error)
+	// GetStatementLines retrieves the entries of an Account with running balances, oldest first.
+	GetStatementLines(txn dbutil.Transaction, accountId uuid.UUID) ([]StatementLine, error)
+	// GetLastEntrySeq retrieves the Seq of the latest entry of an Account, or 0 if it has none.
+	GetLastEntrySeq(txn dbutil.Transaction, accountId uuid.UUID) (int64, error)
+	// GetAccountEvents retrieves up to limit entries of an Account after afterSeq as AccountEvent instances, with
+	// the balance after each, ordered by Seq.
+	GetAccountEvents(txn dbutil.Transaction, accountId uuid.UUID, afterSeq int64, limit int) ([]AccountEvent, error)
+	// StreamExportedEntries calls fn with each entry selected by filter, oldest first, as it is read from the
+	// database cursor. It stops at the first error fn returns, and returns it.
+	StreamExportedEntries(txn dbutil.Transaction, filter ExportFilter, fn func(ExportedEntry) error) error
+	// GetLedgerDiscrepancies checks ledger invariants across all accounts and transactions.
+	GetLedgerDiscrepancies(txn dbutil.Transaction) ([]Discrepancy, error)
+	// GetChainedEntries retrieves up to limit entries with their hash chain links, ordered by account and Seq,
+	// starting after the entry at afterSeq of afterAccountId.
+	GetChainedEntries(txn dbutil.Transaction, afterAccountId uuid.UUID, afterSeq int64, limit int) ([]Entry, error)
+	// GetTransactionsByName retrieves all transactions with name `name` that match filter, and their respective entries.
+	GetTransactionsByName(txn dbutil.Transaction, name string, filter TransactionFilter) ([]Transaction, error)
+	// GetTransactionByIdempotencyKey retrieves a Transaction and its entries by idempotency key, or ErrTransactionNotFound if there is none.
+	GetTransactionByIdempotencyKey(txn dbutil.Transaction, key string) (*Transaction, error)
 	// LockTransactions acquires a lock for transactions to be used in conjunction with CreateTransaction.
 	LockTransactions(txn dbutil.Transaction) error
 	// CreateTransaction creates a Transaction in the storage, and should be used only after LockTransactions.
+	// ErrExternalReferenceReused is returned if its sender already made a payment with the same external reference.
+	// A transaction whose idempotency key was committed concurrently fails with an error that dbutil.IsRetryable.
 	CreateTransaction(txn dbutil.Transaction, transaction Transaction) error
 	// CreateEntriesForTransactionId creates multiple entries under a given Transaction.
 	CreateEntriesForTransactionId(txn dbutil.Transaction, transactionId uuid.UUID, entries []Entry) error
+	// CreateTransactions creates many transactions without entries in batches, and should be used only after
+	// LockTransactions.
+	CreateTransactions(txn dbutil.Transaction, transactions []Transaction) error
+	// CreateEntries creates many entries of any transactions in batches.
+	CreateEntries(txn dbutil.Transaction, entries []Entry) error
+	// CreatePaymentRequest creates a PaymentRequest in the storage.
+	CreatePaymentRequest(txn dbutil.Transaction, request PaymentRequest) error
+	// GetPaymentRequestForUpdate retrieves a PaymentRequest and locks its row until the transaction ends, or returns
+	// ErrPaymentRequestNotFound if there is none.
+	GetPaymentRequestForUpdate(txn dbutil.Transaction, id uuid.UUID) (*PaymentRequest, error)
+	// GetPaymentRequests retrieves the PaymentRequest instances matching filter, latest first. Pending requests
+	// past their expiry are retrieved as expired, whether or not ExpirePaymentRequests has marked them yet.
+	GetPaymentRequests(txn dbutil.Transaction, filter PaymentRequestFilter) ([]PaymentRequest, error)
+	// UpdatePaymentRequestStatus sets the status of a PaymentRequest, and the payment that settled it if paid.
+	UpdatePaymentRequestStatus(txn dbutil.Transaction, id uuid.UUID, status string, transactionId uuid.NullUUID) error
+	// ExpirePaymentRequests marks the pending PaymentRequest instances whose expiry is at or before now as expired,
+	// and returns how many were marked.
+	ExpirePaymentRequests(txn dbutil.Transaction, now time.Time) (int64, error)
+	// LockAuditLog acquires a lock for the audit log to be used in conjunction with CreateAuditRecord.
+	LockAuditLog(txn dbutil.Transaction) error
+	// GetLastAuditRecord retrieves the AuditRecord with the highest Seq, or nil if the audit log is empty.
+	GetLastAuditRecord(txn dbutil.Transaction) (*AuditRecord, error)
+	// CreateAuditRecord appends an AuditRecord to the audit log, and should be used only after LockAuditLog.
+	CreateAuditRecord(txn dbutil.Transaction, record AuditRecord) error
+	// GetAuditRecords retrieves the AuditRecord instances matching filter, in Seq order.
+	GetAuditRecords(txn dbutil.Transaction, filter AuditFilter) ([]AuditRecord, error)
 }
 
+var replicaTxnOptions = &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}
+
 type postgresDb struct {
 	*sqlx.DB
+	replica      *sqlx.DB
+	maxStaleness time.Duration
 }
 
 func NewPostgresDb(db *sqlx.DB) Repository {
-	return &postgresDb{db}
+	return &postgresDb{DB: db}
 }
 
-func (db *postgresDb) BeginTxn() (dbutil.Transaction, error) {
-	txn, err := db.Beginx()
-	if err != nil {
-		return nil, err
+// NewPostgresDbWithReplica returns a Repository that serves BeginReplicaTxn from replica while it trails primary
+// by at most maxStaleness, or by any amount if maxStaleness is zero.
+func NewPostgresDbWithReplica(primary *sqlx.DB, replica *sqlx.DB, maxStaleness time.Duration) Repository {
+	return &postgresDb{DB: primary, replica: replica, maxStaleness: maxStaleness}
+}
+
+func (db *postgresDb) BeginTxn(ctx context.Context, opts *sql.TxOptions) (dbutil.Transaction, error) {
+	return beginTxn(ctx, db.DB, opts)
+}
+
+func (db *postgresDb) BeginReplicaTxn(ctx context.Context) (dbutil.Transaction, error) {
+	if db.replica == nil {
+		return db.BeginTxn(ctx, replicaTxnOptions)
 	}
-	if err := dbutil.SwitchSchema(txn, transactionSchemaName); err != nil {
-		txn.Rollback()
-		return nil, err
+
+	if db.maxStaleness > 0 {
+		lag, err := dbutil.ReplicationLag(ctx, db.replica)
+		if err != nil || lag > db.maxStaleness {
+			return db.BeginTxn(ctx, replicaTxnOptions)
+		}
 	}
 
-	return txn, nil
+	return beginTxn(ctx, db.replica, replicaTxnOptions)
 }
 
 const sqlGetAccounts = `
@@ -55,6 +145,9 @@ SELECT
 	a.id,
 	a.username,
 	a.currency,
+	a.status,
+	a.created_at,
+	a.updated_at,
 	COALESCE(SUM(te.credit + te.debit), 0.0) AS balance
 FROM accounts a LEFT JOIN transaction_entries te ON a.id = te.account_id
 GROUP BY a.id
@@ -74,7 +167,11 @@ SELECT
 	a.id,
 	a.username,
 	a.currency,
-	COALESCE(SUM(te.credit + te.debit), 0.0) AS balance
+	a.status,
+	a.created_at,
+	a.updated_at,
+	COALESCE(SUM(te.credit + te.debit), 0.0) AS balance,
+	(ARRAY_AGG(te.id ORDER BY te.seq DESC))[1] AS last_entry_id
 FROM accounts a LEFT JOIN transaction_entries te ON a.id = te.account_id
 WHERE a.username = $1
 GROUP BY a.id
@@ -83,24 +180,449 @@ GROUP BY a.id
 func (db *postgresDb) GetAccountByUsername(txn dbutil.Transaction, username string) (*Account, error) {
 	account := new(Account)
 	if err := txn.Get(account, sqlGetAccountByUsername, username); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrAccountNotFound
+		}
+		return nil, err
+	}
+	return account, nil
+}
+
+// sqlBalancesAsOf defines the balances CTE: the balance at $1 of every account created by then, which is the
+// latest balance checkpoint at or before $1 plus the entries recorded after that checkpoint up to $1.
+const sqlBalancesAsOf = `
+latest_checkpoints AS (
+	SELECT DISTINCT ON (account_id) account_id, as_of, balance
+	FROM balance_checkpoints
+	WHERE as_of <= $1
+	ORDER BY account_id, as_of DESC
+),
+balances AS (
+	SELECT
+		a.id AS account_id,
+		COALESCE(c.balance, 0.0) + COALESCE((
+			SELECT SUM(te.credit + te.debit)
+			FROM transaction_entries te
+			WHERE te.account_id = a.id AND te.created_at > COALESCE(c.as_of, '-infinity') AND te.created_at <= $1
+		), 0.0) AS balance
+	FROM accounts a LEFT JOIN latest_checkpoints c ON a.id = c.account_id
+	WHERE a.created_at <= $1
+)
+`
+
+// sqlAccountColumnsAsOf selects an Account from accounts a joined with balances b, where the status of an account
+// at $1 is the target of its latest status change by then.
+const sqlAccountColumnsAsOf = `
+	a.id,
+	a.username,
+	a.currency,
+	COALESCE((
+		SELECT sc.to_status
+		FROM account_status_changes sc
+		WHERE sc.account_id = a.id AND sc.created_at <= $1
+		ORDER BY sc.created_at DESC
+		LIMIT 1
+	), 'active') AS status,
+	a.created_at,
+	a.updated_at,
+	b.balance`
+
+const sqlGetAccountsAsOf = `
+WITH` + sqlBalancesAsOf + `
+SELECT` + sqlAccountColumnsAsOf + `
+FROM accounts a JOIN balances b ON a.id = b.account_id
+ORDER BY a.username
+`
+
+func (db *postgresDb) GetAccountsAsOf(txn dbutil.Transaction, asOf time.Time) ([]Account, error) {
+	var accounts []Account
+	if err := txn.Select(&accounts, sqlGetAccountsAsOf, asOf); err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+const sqlGetAccountByUsernameAsOf = `
+WITH` + sqlBalancesAsOf + `
+SELECT` + sqlAccountColumnsAsOf + `,
+	(
+		SELECT te.id
+		FROM transaction_entries te
+		WHERE te.account_id = a.id AND te.created_at <= $1
+		ORDER BY te.seq DESC
+		LIMIT 1
+	) AS last_entry_id
+FROM accounts a JOIN balances b ON a.id = b.account_id
+WHERE a.username = $2
+`
+
+func (db *postgresDb) GetAccountByUsernameAsOf(txn dbutil.Transaction, username string, asOf time.Time) (*Account, error) {
+	account := new(Account)
+	if err := txn.Get(account, sqlGetAccountByUsernameAsOf, asOf, username); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrAccountNotFound
+		}
 		return nil, err
 	}
 	return account, nil
 }
 
+const sqlCreateBalanceCheckpoints = `
+WITH` + sqlBalancesAsOf + `
+INSERT INTO balance_checkpoints (account_id, as_of, balance)
+SELECT account_id, $1, balance FROM balances
+ON CONFLICT (account_id, as_of) DO NOTHING
+`
+
+func (db *postgresDb) CreateBalanceCheckpoints(txn dbutil.Transaction, asOf time.Time) (int64, error) {
+	result, err := txn.Exec(sqlCreateBalanceCheckpoints, asOf)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const sqlCreateAccount = `
-INSERT INTO accounts (id, username, currency) VALUES (:id, :username, :currency)
+INSERT INTO accounts (id, username, currency, status) VALUES (:id, :username, :currency, :status)
 `
 
 func (db *postgresDb) CreateAccount(txn dbutil.Transaction, account Account) error {
 	_, err := txn.NamedExec(sqlCreateAccount, account)
+	if dbutil.IsUniqueViolation(err) {
+		return ErrAccountAlreadyExists
+	}
+	return err
+}
+
+const sqlGetTakenUsernames = `
+SELECT username FROM accounts WHERE username = ANY($1) ORDER BY username
+`
+
+func (db *postgresDb) GetTakenUsernames(txn dbutil.Transaction, usernames []string) ([]string, error) {
+	taken := []string{}
+	if len(usernames) == 0 {
+		return taken, nil
+	}
+	if err := txn.Select(&taken, sqlGetTakenUsernames, usernames); err != nil {
+		return nil, err
+	}
+	return taken, nil
+}
+
+func (db *postgresDb) CreateAccounts(txn dbutil.Transaction, accounts []Account) error {
+	return inBatches(len(accounts), func(from int, to int) error {
+		_, err := txn.NamedExec(sqlCreateAccount, accounts[from:to])
+		if dbutil.IsUniqueViolation(err) {
+			return ErrAccountAlreadyExists
+		}
+		return err
+	})
+}
+
+// row-level lock so that status changes and ledger writes on the same account are serialized
+const sqlGetAccountStatusForUpdate = `
+SELECT status FROM accounts WHERE id = $1 FOR UPDATE
+`
+
+func (db *postgresDb) GetAccountStatusForUpdate(txn dbutil.Transaction, accountId uuid.UUID) (string, error) {
+	var status string
+	if err := txn.Get(&status, sqlGetAccountStatusForUpdate, accountId); err != nil {
+		return "", err
+	}
+	return status, nil
+}
+
+const sqlUpdateAccountStatus = `
+UPDATE accounts SET status = $2 WHERE id = $1
+`
+
+func (db *postgresDb) UpdateAccountStatus(txn dbutil.Transaction, accountId uuid.UUID, status string) error {
+	_, err := txn.Exec(sqlUpdateAccountStatus, accountId, status)
 	return err
 }
 
+const sqlCreateAccountStatusChange = `
+INSERT INTO account_status_changes (
+	id,
+	account_id,
+	from_status,
+	to_status,
+	reason
+) VALUES (
+	:id,
+	:account_id,
+	:from_status,
+	:to_status,
+	:reason
+)
+`
+
+func (db *postgresDb) CreateAccountStatusChange(txn dbutil.Transaction, change AccountStatusChange) error {
+	_, err := txn.NamedExec(sqlCreateAccountStatusChange, change)
+	return err
+}
+
+const sqlGetAccountStatusChanges = `
+SELECT
+	id,
+	account_id,
+	from_status,
+	to_status,
+	reason,
+	created_at,
+	updated_at
+FROM account_status_changes
+WHERE account_id = $1
+ORDER BY created_at DESC
+`
+
+func (db *postgresDb) GetAccountStatusChanges(txn dbutil.Transaction, accountId uuid.UUID) ([]AccountStatusChange, error) {
+	var changes []AccountStatusChange
+	if err := txn.Select(&changes, sqlGetAccountStatusChanges, accountId); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+const sqlGetStatementLines = `
+SELECT
+	te.id AS entry_id,
+	te.transaction_id,
+	t.name AS transaction_name,
+	a.username AS counterparty,
+	te.credit + te.debit AS amount,
+	SUM(te.credit + te.debit) OVER (ORDER BY te.created_at, te.id) AS balance,
+	te.created_at
+FROM transaction_entries te
+INNER JOIN transactions t ON te.transaction_id = t.id
+LEFT OUTER JOIN accounts a ON te.target_account_id = a.id
+WHERE te.account_id = $1
+ORDER BY te.created_at, te.id
+`
+
+func (db *postgresDb) GetStatementLines(txn dbutil.Transaction, accountId uuid.UUID) ([]StatementLine, error) {
+	var lines []StatementLine
+	if err := txn.Select(&lines, sqlGetStatementLines, accountId); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+const sqlGetLastEntrySeq = `
+SELECT COALESCE(MAX(seq), 0) FROM transaction_entries WHERE account_id = $1
+`
+
+func (db *postgresDb) GetLastEntrySeq(txn dbutil.Transaction, accountId uuid.UUID) (int64, error) {
+	var seq int64
+	if err := txn.Get(&seq, sqlGetLastEntrySeq, accountId); err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
+
+// sqlGetAccountEvents adds the running balance of the entries after $2 to the balance up to $2.
+const sqlGetAccountEvents = `
+SELECT
+	te.seq,
+	te.id AS entry_id,
+	te.transaction_id,
+	t.name AS transaction_name,
+	a.username AS counterparty,
+	te.credit + te.debit AS amount,
+	(
+		SELECT COALESCE(SUM(prev.credit + prev.debit), 0.0)
+		FROM transaction_entries prev
+		WHERE prev.account_id = $1 AND prev.seq <= $2
+	) + SUM(te.credit + te.debit) OVER (ORDER BY te.seq) AS balance,
+	te.created_at
+FROM transaction_entries te
+INNER JOIN transactions t ON te.transaction_id = t.id
+LEFT OUTER JOIN accounts a ON te.target_account_id = a.id
+WHERE te.account_id = $1 AND te.seq > $2
+ORDER BY te.seq
+LIMIT $3
+`
+
+func (db *postgresDb) GetAccountEvents(txn dbutil.Transaction, accountId uuid.UUID, afterSeq int64, limit int) ([]AccountEvent, error) {
+	var events []AccountEvent
+	if err := txn.Select(&events, sqlGetAccountEvents, accountId, afterSeq, limit); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+const sqlStreamTransactionEntries = `
+SELECT
+	te.id AS entry_id,
+	te.transaction_id,
+	t.name AS transaction_name,
+	a.username AS account,
+	ta.username AS counterparty,
+	te.credit + te.debit AS amount,
+	NULL::DECIMAL AS balance,
+	te.created_at
+FROM transaction_entries te
+INNER JOIN transactions t ON te.transaction_id = t.id
+INNER JOIN accounts a ON te.account_id = a.id
+LEFT OUTER JOIN accounts ta ON te.target_account_id = ta.id
+WHERE t.name = $1
+	AND ($2 = '' OR a.username = $2)
+	AND ($3::TIMESTAMPTZ IS NULL OR te.created_at >= $3)
+	AND ($4::TIMESTAMPTZ IS NULL OR te.created_at < $4)
+ORDER BY te.created_at, te.transaction_id, te.id
+`
+
+// running balances add up every entry of the account, including those before the requested range
+const sqlStreamStatementEntries = `
+SELECT * FROM (
+	SELECT
+		te.id AS entry_id,
+		te.transaction_id,
+		t.name AS transaction_name,
+		a.username AS account,
+		ta.username AS counterparty,
+		te.credit + te.debit AS amount,
+		SUM(te.credit + te.debit) OVER (ORDER BY te.created_at, te.id) AS balance,
+		te.created_at
+	FROM transaction_entries te
+	INNER JOIN transactions t ON te.transaction_id = t.id
+	INNER JOIN accounts a ON te.account_id = a.id
+	LEFT OUTER JOIN accounts ta ON te.target_account_id = ta.id
+	WHERE a.username = $1
+		AND ($3::TIMESTAMPTZ IS NULL OR te.created_at < $3)
+) lines
+WHERE $2::TIMESTAMPTZ IS NULL OR created_at >= $2
+ORDER BY created_at, entry_id
+`
+
+func (db *postgresDb) StreamExportedEntries(txn dbutil.Transaction, filter ExportFilter, fn func(ExportedEntry) error) error {
+	since := null.NewTime(filter.Since, !filter.Since.IsZero())
+	until := null.NewTime(filter.Until, !filter.Until.IsZero())
+
+	var rows *sqlx.Rows
+	var err error
+	if filter.Kind == StatementExport {
+		rows, err = txn.Queryx(sqlStreamStatementEntries, filter.Account, since, until)
+	} else {
+		rows, err = txn.Queryx(sqlStreamTransactionEntries, filter.Kind, filter.Account, since, until)
+	}
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry ExportedEntry
+		if err := rows.StructScan(&entry); err != nil {
+			return err
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// every payment moves funds between exactly two entries that cancel out, every deposit credits exactly one entry,
+// no account is overdrawn, closed accounts stay empty, and every balance checkpoint matches the entries up to it
+const sqlGetLedgerDiscrepancies = `
+SELECT
+	'payment_unbalanced' AS check_name,
+	t.id::text AS reference,
+	COUNT(te.id) || ' entries summing to ' || TRIM_SCALE(COALESCE(SUM(te.credit + te.debit), 0)) AS detail
+FROM transactions t LEFT JOIN transaction_entries te ON t.id = te.transaction_id
+WHERE t.name = $1
+GROUP BY t.id
+HAVING COUNT(te.id) <> 2 OR COALESCE(SUM(te.credit + te.debit), 0) <> 0
+UNION ALL
+SELECT
+	'deposit_invalid',
+	t.id::text,
+	COUNT(te.id) || ' entries summing to ' || TRIM_SCALE(COALESCE(SUM(te.credit + te.debit), 0))
+FROM transactions t LEFT JOIN transaction_entries te ON t.id = te.transaction_id
+WHERE t.name = $2
+GROUP BY t.id
+HAVING COUNT(te.id) <> 1 OR COALESCE(SUM(te.credit + te.debit), 0) <= 0
+UNION ALL
+SELECT
+	'balance_negative',
+	a.username,
+	'balance is ' || TRIM_SCALE(SUM(te.credit + te.debit))
+FROM accounts a INNER JOIN transaction_entries te ON a.id = te.account_id
+GROUP BY a.id
+HAVING SUM(te.credit + te.debit) < 0
+UNION ALL
+SELECT
+	'closed_balance_not_zero',
+	a.username,
+	'balance is ' || TRIM_SCALE(SUM(te.credit + te.debit))
+FROM accounts a INNER JOIN transaction_entries te ON a.id = te.account_id
+WHERE a.status = $3
+GROUP BY a.id
+HAVING SUM(te.credit + te.debit) <> 0
+UNION ALL
+SELECT
+	'checkpoint_mismatch',
+	a.username,
+	'checkpoint at ' || to_char(c.as_of AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"') || ' is ' || TRIM_SCALE(c.balance)
+		|| ' but entries up to it sum to ' || TRIM_SCALE(e.balance)
+FROM balance_checkpoints c
+	INNER JOIN accounts a ON a.id = c.account_id
+	CROSS JOIN LATERAL (
+		SELECT COALESCE(SUM(te.credit + te.debit), 0) AS balance
+		FROM transaction_entries te
+		WHERE te.account_id = c.account_id AND te.created_at <= c.as_of
+	) e
+WHERE e.balance <> c.balance
+ORDER BY check_name, reference
+`
+
+func (db *postgresDb) GetLedgerDiscrepancies(txn dbutil.Transaction) ([]Discrepancy, error) {
+	var discrepancies []Discrepancy
+	err := txn.Select(&discrepancies, sqlGetLedgerDiscrepancies, PaymentTransaction, DepositTransaction, ClosedAccountStatus)
+	if err != nil {
+		return nil, err
+	}
+	return discrepancies, nil
+}
+
+const sqlGetChainedEntries = `
+SELECT
+	te.id,
+	te.transaction_id,
+	te.account_id,
+	te.target_account_id,
+	te.name,
+	te.credit,
+	te.debit,
+	te.created_at,
+	te.updated_at,
+	te.seq,
+	te.prev_hash,
+	te.hash,
+	a.username
+FROM transaction_entries te
+INNER JOIN accounts a ON te.account_id = a.id
+WHERE (te.account_id, te.seq) > ($1, $2)
+ORDER BY te.account_id, te.seq
+LIMIT $3
+`
+
+func (db *postgresDb) GetChainedEntries(txn dbutil.Transaction, afterAccountId uuid.UUID, afterSeq int64, limit int) ([]Entry, error) {
+	var entries []Entry
+	if err := txn.Select(&entries, sqlGetChainedEntries, afterAccountId, afterSeq, limit); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
 const sqlGetTransactionsByName = `
 SELECT
 	t.id,
 	t.name,
+	t.description,
+	t.external_reference,
+	t.metadata,
 	t.created_at,
 	t.updated_at,
 	te.account_id,
@@ -115,6 +637,7 @@ INNER JOIN transaction_entries te ON t.id = te.transaction_id
 INNER JOIN accounts a1 ON te.account_id = a1.id
 LEFT OUTER JOIN accounts a2 ON te.target_account_id = a2.id
 WHERE t.name = $1
+	AND ($2 = '' OR t.external_reference = $2)
 GROUP BY 
 	t.id,
 	te.account_id,
@@ -131,6 +654,7 @@ type transactionJoinEntry struct {
 	// Transaction
 	Id   uuid.UUID `db:"id"`
 	Name string    `db:"name"`
+	PaymentDetails
 	dbutil.Timestamps
 
 	// Entry
@@ -145,9 +669,9 @@ type transactionJoinEntry struct {
 	TargetAccountName null.String `db:"target_username"`
 }
 
-func (db *postgresDb) GetTransactionsByName(txn dbutil.Transaction, name string) ([]Transaction, error) {
+func (db *postgresDb) GetTransactionsByName(txn dbutil.Transaction, name string, filter TransactionFilter) ([]Transaction, error) {
 	var transactionWithEntryRows []transactionJoinEntry
-	if err := txn.Select(&transactionWithEntryRows, sqlGetTransactionsByName, name); err != nil {
+	if err := txn.Select(&transactionWithEntryRows, sqlGetTransactionsByName, name, filter.ExternalReference); err != nil {
 		return nil, err
 	}
 	if transactionWithEntryRows == nil {
@@ -187,6 +711,48 @@ func (db *postgresDb) GetTransactionsByName(txn dbutil.Transaction, name string)
 	return transactions, nil
 }
 
+const sqlGetTransactionByIdempotencyKey = `
+SELECT
+	id,
+	name,
+	idempotency_key,
+	created_at,
+	updated_at
+FROM transactions
+WHERE idempotency_key = $1
+`
+
+const sqlGetEntriesByTransactionId = `
+SELECT
+	id,
+	transaction_id,
+	account_id,
+	target_account_id,
+	name,
+	credit,
+	debit,
+	created_at,
+	updated_at
+FROM transaction_entries
+WHERE transaction_id = $1
+ORDER BY name
+`
+
+func (db *postgresDb) GetTransactionByIdempotencyKey(txn dbutil.Transaction, key string) (*Transaction, error) {
+	transaction := new(Transaction)
+	if err := txn.Get(transaction, sqlGetTransactionByIdempotencyKey, key); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTransactionNotFound
+		}
+		return nil, err
+	}
+
+	if err := txn.Select(&transaction.Entries, sqlGetEntriesByTransactionId, transaction.Id); err != nil {
+		return nil, err
+	}
+	return transaction, nil
+}
+
 // row-level lock to prevent concurrent inserts/updates
 const sqlLockTransactions = `
 SELECT transactions.id FROM transactions FOR UPDATE
@@ -198,11 +764,41 @@ func (db *postgresDb) LockTransactions(txn dbutil.Transaction) error {
 }
 
 const sqlCreateTransaction = `
-INSERT INTO transactions (id, name) VALUES (:id, :name)
+INSERT INTO transactions (
+	id,
+	name,
+	idempotency_key,
+	sender_id,
+	description,
+	external_reference,
+	metadata
+) VALUES (
+	:id,
+	:name,
+	:idempotency_key,
+	:sender_id,
+	:description,
+	:external_reference,
+	:metadata
+)
 `
 
+// uniqueExternalReferenceIndex keeps external references unique among the payments of a sender.
+const uniqueExternalReferenceIndex = "uq_transactions_sender_id_external_reference"
+
+// uniqueIdempotencyKeyConstraint keeps idempotency keys unique among all transactions.
+const uniqueIdempotencyKeyConstraint = "uq_transactions_idempotency_key"
+
 func (db *postgresDb) CreateTransaction(txn dbutil.Transaction, transaction Transaction) error {
 	_, err := txn.NamedExec(sqlCreateTransaction, transaction)
+	if dbutil.IsUniqueViolationOf(err, uniqueExternalReferenceIndex) {
+		return ErrExternalReferenceReused
+	}
+	// LockTransactions has nothing to lock while there are no transactions yet, so two calls with the same key can
+	// both get here. Running the loser again lets it see the winner's transaction.
+	if dbutil.IsUniqueViolationOf(err, uniqueIdempotencyKeyConstraint) {
+		return dbutil.Retryable(err)
+	}
 	return err
 }
 
@@ -238,12 +834,298 @@ func (db *postgresDb) CreateEntriesForTransactionId(txn dbutil.Transaction, tran
 	return err
 }
 
+func (db *postgresDb) CreateTransactions(txn dbutil.Transaction, transactions []Transaction) error {
+	return inBatches(len(transactions), func(from int, to int) error {
+		_, err := txn.NamedExec(sqlCreateTransaction, transactions[from:to])
+		return err
+	})
+}
+
+func (db *postgresDb) CreateEntries(txn dbutil.Transaction, entries []Entry) error {
+	return inBatches(len(entries), func(from int, to int) error {
+		_, err := txn.NamedExec(sqlCreateEntriesForTransactionId, entries[from:to])
+		return err
+	})
+}
+
+const sqlCreatePaymentRequest = `
+INSERT INTO payment_requests (
+	id,
+	requester_id,
+	payer_id,
+	amount,
+	memo,
+	expires_at
+) VALUES (
+	:id,
+	:requester_id,
+	:payer_id,
+	:amount,
+	:memo,
+	:expires_at
+)
+`
+
+func (db *postgresDb) CreatePaymentRequest(txn dbutil.Transaction, request PaymentRequest) error {
+	_, err := txn.NamedExec(sqlCreatePaymentRequest, request)
+	return err
+}
+
+// row-level lock so that a request is responded to at most once, and not while it is being expired
+const sqlGetPaymentRequestForUpdate = `
+SELECT
+	pr.id,
+	pr.requester_id,
+	pr.payer_id,
+	requester.username AS requester,
+	payer.username AS payer,
+	pr.amount,
+	pr.memo,
+	pr.status,
+	pr.expires_at,
+	pr.transaction_id,
+	pr.created_at,
+	pr.updated_at
+FROM payment_requests pr
+INNER JOIN accounts requester ON pr.requester_id = requester.id
+INNER JOIN accounts payer ON pr.payer_id = payer.id
+WHERE pr.id = $1
+FOR UPDATE OF pr
+`
+
+func (db *postgresDb) GetPaymentRequestForUpdate(txn dbutil.Transaction, id uuid.UUID) (*PaymentRequest, error) {
+	request := new(PaymentRequest)
+	if err := txn.Get(request, sqlGetPaymentRequestForUpdate, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrPaymentRequestNotFound
+		}
+		return nil, err
+	}
+	return request, nil
+}
+
+// empty filter values match every row. The status of pending requests past their expiry is told as expired, and
+// filtered as such, so that listings do not depend on when the sweeper last ran.
+const sqlGetPaymentRequests = `
+SELECT
+	id,
+	requester_id,
+	payer_id,
+	requester,
+	payer,
+	amount,
+	memo,
+	status,
+	expires_at,
+	transaction_id,
+	created_at,
+	updated_at
+FROM (
+	SELECT
+		pr.id,
+		pr.requester_id,
+		pr.payer_id,
+		requester.username AS requester,
+		payer.username AS payer,
+		pr.amount,
+		pr.memo,
+		CASE WHEN pr.status = 'pending' AND pr.expires_at <= now() THEN 'expired' ELSE pr.status END AS status,
+		pr.expires_at,
+		pr.transaction_id,
+		pr.created_at,
+		pr.updated_at
+	FROM payment_requests pr
+	INNER JOIN accounts requester ON pr.requester_id = requester.id
+	INNER JOIN accounts payer ON pr.payer_id = payer.id
+	WHERE ($2 IN ('', 'outgoing') AND requester.username = $1)
+		OR ($2 IN ('', 'incoming') AND payer.username = $1)
+) requests
+WHERE $3 = '' OR status = $3
+ORDER BY created_at DESC, id
+`
+
+func (db *postgresDb) GetPaymentRequests(txn dbutil.Transaction, filter PaymentRequestFilter) ([]PaymentRequest, error) {
+	var requests []PaymentRequest
+	if err := txn.Select(&requests, sqlGetPaymentRequests, filter.Account, filter.Direction, filter.Status); err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+const sqlUpdatePaymentRequestStatus = `
+UPDATE payment_requests SET status = $2, transaction_id = $3 WHERE id = $1
+`
+
+func (db *postgresDb) UpdatePaymentRequestStatus(txn dbutil.Transaction, id uuid.UUID, status string, transactionId uuid.NullUUID) error {
+	_, err := txn.Exec(sqlUpdatePaymentRequestStatus, id, status, transactionId)
+	return err
+}
+
+// requests locked by a response in progress are left for the next sweep, which sees how they were responded to
+const sqlExpirePaymentRequests = `
+UPDATE payment_requests SET status = 'expired'
+WHERE id IN (
+	SELECT id FROM payment_requests
+	WHERE status = 'pending' AND expires_at <= $1
+	FOR UPDATE SKIP LOCKED
+)
+`
+
+func (db *postgresDb) ExpirePaymentRequests(txn dbutil.Transaction, now time.Time) (int64, error) {
+	result, err := txn.Exec(sqlExpirePaymentRequests, now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// transaction-level advisory lock, as appending needs the last record and there may be no row to lock yet
+const sqlLockAuditLog = `
+SELECT pg_advisory_xact_lock(hashtext('wallet.audit_log'))
+`
+
+func (db *postgresDb) LockAuditLog(txn dbutil.Transaction) error {
+	_, err := txn.Exec(sqlLockAuditLog)
+	return err
+}
+
+const sqlGetLastAuditRecord = `
+SELECT
+	seq,
+	claimed_actor,
+	action,
+	account,
+	counterparty,
+	payload_hash,
+	outcome,
+	created_at,
+	prev_hash,
+	hash
+FROM audit_log
+ORDER BY seq DESC
+LIMIT 1
+`
+
+func (db *postgresDb) GetLastAuditRecord(txn dbutil.Transaction) (*AuditRecord, error) {
+	record := new(AuditRecord)
+	if err := txn.Get(record, sqlGetLastAuditRecord); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return record, nil
+}
+
+const sqlCreateAuditRecord = `
+INSERT INTO audit_log (
+	seq,
+	claimed_actor,
+	action,
+	account,
+	counterparty,
+	payload_hash,
+	outcome,
+	created_at,
+	prev_hash,
+	hash
+) VALUES (
+	:seq,
+	:claimed_actor,
+	:action,
+	:account,
+	:counterparty,
+	:payload_hash,
+	:outcome,
+	:created_at,
+	:prev_hash,
+	:hash
+)
+`
+
+func (db *postgresDb) CreateAuditRecord(txn dbutil.Transaction, record AuditRecord) error {
+	_, err := txn.NamedExec(sqlCreateAuditRecord, record)
+	return err
+}
+
+// empty filter values match every row, and a NULL limit returns every row
+const sqlGetAuditRecords = `
+SELECT
+	seq,
+	claimed_actor,
+	action,
+	account,
+	counterparty,
+	payload_hash,
+	outcome,
+	created_at,
+	prev_hash,
+	hash
+FROM audit_log
+WHERE ($1 = '' OR claimed_actor = $1)
+	AND ($2 = '' OR action = $2)
+	AND ($3 = '' OR account = $3 OR counterparty = $3)
+	AND ($4::TIMESTAMPTZ IS NULL OR created_at >= $4)
+	AND ($5::TIMESTAMPTZ IS NULL OR created_at < $5)
+	AND seq > $6
+ORDER BY seq
+LIMIT NULLIF($7, 0)
+`
+
+func (db *postgresDb) GetAuditRecords(txn dbutil.Transaction, filter AuditFilter) ([]AuditRecord, error) {
+	var records []AuditRecord
+	err := txn.Select(&records, sqlGetAuditRecords,
+		filter.ClaimedActor,
+		filter.Action,
+		filter.Account,
+		null.NewTime(filter.Since, !filter.Since.IsZero()),
+		null.NewTime(filter.Until, !filter.Until.IsZero()),
+		filter.AfterSeq,
+		filter.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
 // --- helpers
 
+// insertBatchSize bounds the rows of multi-row inserts, as a statement takes at most 65535 parameters.
+const insertBatchSize = 1000
+
+// inBatches calls fn with consecutive ranges of up to insertBatchSize out of n items.
+func inBatches(n int, fn func(from int, to int) error) error {
+	for from := 0; from < n; from += insertBatchSize {
+		to := from + insertBatchSize
+		if to > n {
+			to = n
+		}
+		if err := fn(from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func beginTxn(ctx context.Context, pool *sqlx.DB, opts *sql.TxOptions) (dbutil.Transaction, error) {
+	txn, err := pool.BeginTxx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := dbutil.SwitchSchema(txn, transactionSchemaName); err != nil {
+		txn.Rollback()
+		return nil, err
+	}
+
+	return txn, nil
+}
+
 func mapRowToTransaction(row transactionJoinEntry) Transaction {
 	return Transaction{
-		Id:   row.Id,
-		Name: row.Name,
+		Id:             row.Id,
+		Name:           row.Name,
+		PaymentDetails: row.PaymentDetails,
 		Timestamps: dbutil.Timestamps{
 			CreatedAt: row.CreatedAt,
 			UpdatedAt: row.UpdatedAt,
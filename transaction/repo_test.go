@@ -4,7 +4,11 @@
 package transaction_test
 
 import (
+	"context"
+	"encoding/json"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/nogurenn/cph-wallet/dbutil"
@@ -12,20 +16,22 @@ import (
 	"github.com/nogurenn/cph-wallet/util"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v4"
 )
 
 func Test_PostgresDb_GetAccounts(t *testing.T) {
 	// given
-	cfg := dbutil.NewConfig()
+	cfg, err := dbutil.NewConfig()
+	assert.NoError(t, err)
 	db, err := dbutil.NewDb(cfg)
 	assert.NoError(t, err)
 	pdb := transaction.NewPostgresDb(db)
 
-	alice := transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD"}
-	bob := transaction.Account{Id: uuid.New(), Username: "bob123", Currency: "USD"}
+	alice := transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Status: transaction.ActiveAccountStatus}
+	bob := transaction.Account{Id: uuid.New(), Username: "bob123", Currency: "USD", Status: transaction.ActiveAccountStatus}
 
 	// when
-	txn, err := pdb.BeginTxn()
+	txn, err := pdb.BeginTxn(context.Background(), nil)
 	assert.NoError(t, err)
 
 	err = pdb.CreateAccount(txn, bob)
@@ -52,16 +58,17 @@ func Test_PostgresDb_GetAccounts(t *testing.T) {
 
 func Test_PostgresDb_GetAccountByUsername(t *testing.T) {
 	// given
-	cfg := dbutil.NewConfig()
+	cfg, err := dbutil.NewConfig()
+	assert.NoError(t, err)
 	db, err := dbutil.NewDb(cfg)
 	assert.NoError(t, err)
 	pdb := transaction.NewPostgresDb(db)
 
-	alice := transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD"}
-	bob := transaction.Account{Id: uuid.New(), Username: "bob123", Currency: "USD"}
+	alice := transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Status: transaction.ActiveAccountStatus}
+	bob := transaction.Account{Id: uuid.New(), Username: "bob123", Currency: "USD", Status: transaction.ActiveAccountStatus}
 
 	// when
-	txn, err := pdb.BeginTxn()
+	txn, err := pdb.BeginTxn(context.Background(), nil)
 	assert.NoError(t, err)
 
 	err = pdb.CreateAccount(txn, bob)
@@ -72,22 +79,31 @@ func Test_PostgresDb_GetAccountByUsername(t *testing.T) {
 	fetched, err := pdb.GetAccountByUsername(txn, alice.Username)
 	assert.NoError(t, err)
 
+	missing, missingErr := pdb.GetAccountByUsername(txn, "nobody")
+
 	txn.Rollback()
 
 	// then
 	assert.Equal(t, alice.Id, fetched.Id)
 	assert.Equal(t, alice.Username, fetched.Username)
+	assert.Equal(t, transaction.ActiveAccountStatus, fetched.Status)
+	assert.False(t, fetched.CreatedAt.IsZero())
+	assert.False(t, fetched.LastEntryId.Valid)
+
+	assert.Nil(t, missing)
+	assert.Equal(t, transaction.ErrAccountNotFound, missingErr)
 }
 
 func Test_PostgresDb_CreateAndGetTransactionsByName(t *testing.T) {
 	// given
-	cfg := dbutil.NewConfig()
+	cfg, err := dbutil.NewConfig()
+	assert.NoError(t, err)
 	db, err := dbutil.NewDb(cfg)
 	assert.NoError(t, err)
 	pdb := transaction.NewPostgresDb(db)
 
-	alice := transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD"}
-	bob := transaction.Account{Id: uuid.New(), Username: "bob123", Currency: "USD"}
+	alice := transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Status: transaction.ActiveAccountStatus}
+	bob := transaction.Account{Id: uuid.New(), Username: "bob123", Currency: "USD", Status: transaction.ActiveAccountStatus}
 
 	// initial balances
 	aliceInitialBalanceId := uuid.New()
@@ -144,7 +160,7 @@ func Test_PostgresDb_CreateAndGetTransactionsByName(t *testing.T) {
 	}
 
 	// when
-	txn, err := pdb.BeginTxn()
+	txn, err := pdb.BeginTxn(context.Background(), nil)
 	assert.NoError(t, err)
 
 	err = pdb.CreateAccount(txn, bob)
@@ -173,7 +189,10 @@ func Test_PostgresDb_CreateAndGetTransactionsByName(t *testing.T) {
 	accounts, err := pdb.GetAccounts(txn)
 	assert.NoError(t, err)
 
-	payments, err := pdb.GetTransactionsByName(txn, transaction.PaymentTransaction)
+	fetchedAlice, err := pdb.GetAccountByUsername(txn, alice.Username)
+	assert.NoError(t, err)
+
+	payments, err := pdb.GetTransactionsByName(txn, transaction.PaymentTransaction, transaction.TransactionFilter{})
 	assert.NoError(t, err)
 
 	txn.Rollback()
@@ -185,6 +204,10 @@ func Test_PostgresDb_CreateAndGetTransactionsByName(t *testing.T) {
 	assert.Equal(t, bob.Username, accounts[1].Username)
 	assert.True(t, accounts[1].Balance.Equal(decimal.NewFromFloat(100.00)))
 
+	// both of alice's entries share the transaction timestamp, so the latest is told by its place in the chain
+	assert.True(t, fetchedAlice.LastEntryId.Valid)
+	assert.Equal(t, toAlice.Id, fetchedAlice.LastEntryId.UUID)
+
 	assert.Len(t, payments, 1)
 	assert.Equal(t, payment.Id, payments[0].Id)
 	assert.Len(t, payments[0].Entries, 2)
@@ -205,3 +228,932 @@ func Test_PostgresDb_CreateAndGetTransactionsByName(t *testing.T) {
 	assert.Equal(t, 1, foundIncoming)
 	assert.Equal(t, 1, foundOutgoing)
 }
+
+func Test_PostgresDb_UpdateAccountStatusAndGetStatusChanges(t *testing.T) {
+	// given
+	cfg, err := dbutil.NewConfig()
+	assert.NoError(t, err)
+	db, err := dbutil.NewDb(cfg)
+	assert.NoError(t, err)
+	pdb := transaction.NewPostgresDb(db)
+
+	alice := transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Status: transaction.ActiveAccountStatus}
+	change := transaction.AccountStatusChange{
+		Id:         uuid.New(),
+		AccountId:  alice.Id,
+		FromStatus: transaction.ActiveAccountStatus,
+		ToStatus:   transaction.FrozenAccountStatus,
+		Reason:     "suspected fraud",
+	}
+
+	// when
+	txn, err := pdb.BeginTxn(context.Background(), nil)
+	assert.NoError(t, err)
+
+	err = pdb.CreateAccount(txn, alice)
+	assert.NoError(t, err)
+
+	initialStatus, err := pdb.GetAccountStatusForUpdate(txn, alice.Id)
+	assert.NoError(t, err)
+
+	err = pdb.UpdateAccountStatus(txn, alice.Id, transaction.FrozenAccountStatus)
+	assert.NoError(t, err)
+	err = pdb.CreateAccountStatusChange(txn, change)
+	assert.NoError(t, err)
+
+	fetched, err := pdb.GetAccountByUsername(txn, alice.Username)
+	assert.NoError(t, err)
+
+	changes, err := pdb.GetAccountStatusChanges(txn, alice.Id)
+	assert.NoError(t, err)
+
+	txn.Rollback()
+
+	// then
+	assert.Equal(t, transaction.ActiveAccountStatus, initialStatus)
+	assert.Equal(t, transaction.FrozenAccountStatus, fetched.Status)
+
+	assert.Len(t, changes, 1)
+	assert.Equal(t, change.Id, changes[0].Id)
+	assert.Equal(t, change.FromStatus, changes[0].FromStatus)
+	assert.Equal(t, change.ToStatus, changes[0].ToStatus)
+	assert.Equal(t, change.Reason, changes[0].Reason)
+}
+
+func Test_PostgresDb_GetTransactionByIdempotencyKey(t *testing.T) {
+	// given
+	cfg, err := dbutil.NewConfig()
+	assert.NoError(t, err)
+	db, err := dbutil.NewDb(cfg)
+	assert.NoError(t, err)
+	pdb := transaction.NewPostgresDb(db)
+
+	alice := transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Status: transaction.ActiveAccountStatus}
+	key := uuid.New().String()
+	depositId := uuid.New()
+	deposit := transaction.Transaction{
+		Id:             depositId,
+		Name:           transaction.DepositTransaction,
+		IdempotencyKey: null.StringFrom(key),
+		Entries: []transaction.Entry{
+			{
+				Id:              uuid.New(),
+				TransactionId:   depositId,
+				AccountId:       alice.Id,
+				TargetAccountId: util.NewNullUUID(uuid.Nil),
+				Name:            transaction.IncomingEntry,
+				Credit:          decimal.NewFromFloat(50.00),
+			},
+		},
+	}
+
+	// when
+	txn, err := pdb.BeginTxn(context.Background(), nil)
+	assert.NoError(t, err)
+
+	err = pdb.CreateAccount(txn, alice)
+	assert.NoError(t, err)
+	err = pdb.LockTransactions(txn)
+	assert.NoError(t, err)
+	err = pdb.CreateTransaction(txn, deposit)
+	assert.NoError(t, err)
+	err = pdb.CreateEntriesForTransactionId(txn, deposit.Id, deposit.Entries)
+	assert.NoError(t, err)
+
+	fetched, err := pdb.GetTransactionByIdempotencyKey(txn, key)
+	assert.NoError(t, err)
+
+	missing, missingErr := pdb.GetTransactionByIdempotencyKey(txn, uuid.New().String())
+
+	// the violation aborts the db transaction, so it goes last
+	reusedErr := pdb.CreateTransaction(txn, transaction.Transaction{Id: uuid.New(), Name: transaction.DepositTransaction, IdempotencyKey: null.StringFrom(key)})
+
+	txn.Rollback()
+
+	// then
+	assert.Equal(t, deposit.Id, fetched.Id)
+	assert.Equal(t, deposit.Name, fetched.Name)
+	assert.Equal(t, deposit.IdempotencyKey, fetched.IdempotencyKey)
+	assert.Len(t, fetched.Entries, 1)
+	assert.Equal(t, alice.Id, fetched.Entries[0].AccountId)
+	assert.True(t, deposit.Entries[0].Credit.Equal(fetched.Entries[0].Credit))
+
+	assert.Nil(t, missing)
+	assert.Equal(t, transaction.ErrTransactionNotFound, missingErr)
+
+	// a concurrent call with the same key is run again, and then finds the transaction
+	assert.True(t, dbutil.IsRetryable(reusedErr))
+}
+
+func Test_PostgresDb_CreateTransaction_ExternalReference(t *testing.T) {
+	// given
+	cfg, err := dbutil.NewConfig()
+	assert.NoError(t, err)
+	db, err := dbutil.NewDb(cfg)
+	assert.NoError(t, err)
+	pdb := transaction.NewPostgresDb(db)
+
+	alice := transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Status: transaction.ActiveAccountStatus}
+	bob := transaction.Account{Id: uuid.New(), Username: "bob123", Currency: "USD", Status: transaction.ActiveAccountStatus}
+
+	newPayment := func(sender transaction.Account, receiver transaction.Account, reference string) transaction.Transaction {
+		paymentId := uuid.New()
+		return transaction.Transaction{
+			Id:       paymentId,
+			Name:     transaction.PaymentTransaction,
+			SenderId: util.NewNullUUID(sender.Id),
+			PaymentDetails: transaction.PaymentDetails{
+				Description:       null.StringFrom("March rent"),
+				ExternalReference: null.StringFrom(reference),
+				Metadata:          transaction.Metadata{"invoice": map[string]interface{}{"lines": json.Number("2")}},
+			},
+			Entries: []transaction.Entry{
+				{
+					Id:              uuid.New(),
+					TransactionId:   paymentId,
+					AccountId:       sender.Id,
+					TargetAccountId: util.NewNullUUID(receiver.Id),
+					Name:            transaction.OutgoingEntry,
+					Debit:           decimal.NewFromFloat(-10.00),
+				},
+				{
+					Id:              uuid.New(),
+					TransactionId:   paymentId,
+					AccountId:       receiver.Id,
+					TargetAccountId: util.NewNullUUID(sender.Id),
+					Name:            transaction.IncomingEntry,
+					Credit:          decimal.NewFromFloat(10.00),
+				},
+			},
+		}
+	}
+	fromBob := newPayment(bob, alice, "INV-0001")
+	fromAlice := newPayment(alice, bob, "INV-0001")
+	otherFromBob := newPayment(bob, alice, "INV-0002")
+
+	// when
+	txn, err := pdb.BeginTxn(context.Background(), nil)
+	assert.NoError(t, err)
+
+	err = pdb.CreateAccount(txn, bob)
+	assert.NoError(t, err)
+	err = pdb.CreateAccount(txn, alice)
+	assert.NoError(t, err)
+
+	err = pdb.LockTransactions(txn)
+	assert.NoError(t, err)
+	for _, payment := range []transaction.Transaction{fromBob, fromAlice, otherFromBob} {
+		err = pdb.CreateTransaction(txn, payment)
+		assert.NoError(t, err)
+		err = pdb.CreateEntriesForTransactionId(txn, payment.Id, payment.Entries)
+		assert.NoError(t, err)
+	}
+
+	referenced, err := pdb.GetTransactionsByName(txn, transaction.PaymentTransaction, transaction.TransactionFilter{ExternalReference: "INV-0002"})
+	assert.NoError(t, err)
+
+	// the violation aborts the db transaction, so it goes last
+	reusedErr := pdb.CreateTransaction(txn, newPayment(bob, alice, "INV-0001"))
+
+	txn.Rollback()
+
+	// then
+	assert.Len(t, referenced, 1)
+	assert.Equal(t, otherFromBob.Id, referenced[0].Id)
+	assert.Equal(t, otherFromBob.PaymentDetails, referenced[0].PaymentDetails)
+	assert.Len(t, referenced[0].Entries, 2)
+
+	assert.Equal(t, transaction.ErrExternalReferenceReused, reusedErr)
+}
+
+func Test_PostgresDb_BeginReplicaTxn_ReadOnly(t *testing.T) {
+	// given
+	cfg, err := dbutil.NewConfig()
+	assert.NoError(t, err)
+	db, err := dbutil.NewDb(cfg)
+	assert.NoError(t, err)
+	pdb := transaction.NewPostgresDbWithReplica(db, db, time.Second)
+
+	alice := transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Status: transaction.ActiveAccountStatus}
+
+	// when
+	txn, err := pdb.BeginReplicaTxn(context.Background())
+	assert.NoError(t, err)
+
+	_, getErr := pdb.GetAccounts(txn)
+	createErr := pdb.CreateAccount(txn, alice)
+
+	txn.Rollback()
+
+	// then
+	assert.NoError(t, getErr)
+	assert.Error(t, createErr)
+}
+
+func Test_PostgresDb_GetAccountByUsernameAsOf_LastEntryBySeq(t *testing.T) {
+	// given
+	cfg, err := dbutil.NewConfig()
+	assert.NoError(t, err)
+	db, err := dbutil.NewDb(cfg)
+	assert.NoError(t, err)
+	pdb := transaction.NewPostgresDb(db)
+
+	alice := transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Status: transaction.ActiveAccountStatus}
+	firstId, secondId := uuid.New(), uuid.New()
+	firstEntryId, secondEntryId := uuid.New(), uuid.New()
+
+	// when
+	txn, err := pdb.BeginTxn(context.Background(), nil)
+	assert.NoError(t, err)
+	defer txn.Rollback()
+
+	err = pdb.CreateAccount(txn, alice)
+	assert.NoError(t, err)
+	err = pdb.LockTransactions(txn)
+	assert.NoError(t, err)
+	for _, transactionId := range []uuid.UUID{firstId, secondId} {
+		err = pdb.CreateTransaction(txn, transaction.Transaction{Id: transactionId, Name: transaction.DepositTransaction})
+		assert.NoError(t, err)
+	}
+	err = pdb.CreateEntriesForTransactionId(txn, firstId, []transaction.Entry{
+		{Id: firstEntryId, TransactionId: firstId, AccountId: alice.Id, Name: transaction.IncomingEntry, Credit: decimal.NewFromFloat(10)},
+	})
+	assert.NoError(t, err)
+
+	// chained after the first entry but dated before it, like a deposit that started earlier and committed later
+	_, err = txn.Exec(`
+		INSERT INTO transaction_entries (id, transaction_id, account_id, name, credit, debit, created_at)
+		VALUES ($1, $2, $3, $4, 5, 0, now() - INTERVAL '1 minute')`,
+		secondEntryId, secondId, alice.Id, transaction.IncomingEntry,
+	)
+	assert.NoError(t, err)
+
+	live, err := pdb.GetAccountByUsername(txn, alice.Username)
+	assert.NoError(t, err)
+	asOf, err := pdb.GetAccountByUsernameAsOf(txn, alice.Username, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+
+	// then
+	if assert.NotNil(t, live) && assert.NotNil(t, asOf) {
+		assert.Equal(t, secondEntryId, live.LastEntryId.UUID)
+		assert.Equal(t, secondEntryId, asOf.LastEntryId.UUID)
+	}
+}
+
+func Test_PostgresDb_GetAccountsAsOfAndCreateBalanceCheckpoints(t *testing.T) {
+	// given
+	cfg, err := dbutil.NewConfig()
+	assert.NoError(t, err)
+	db, err := dbutil.NewDb(cfg)
+	assert.NoError(t, err)
+	pdb := transaction.NewPostgresDb(db)
+
+	alice := transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Status: transaction.ActiveAccountStatus}
+	depositId := uuid.New()
+
+	// when
+	txn, err := pdb.BeginTxn(context.Background(), nil)
+	assert.NoError(t, err)
+	defer txn.Rollback()
+
+	// every row written in this transaction is created at its start
+	var now time.Time
+	err = txn.Get(&now, "SELECT now()")
+	assert.NoError(t, err)
+
+	err = pdb.CreateAccount(txn, alice)
+	assert.NoError(t, err)
+	err = pdb.LockTransactions(txn)
+	assert.NoError(t, err)
+	err = pdb.CreateTransaction(txn, transaction.Transaction{Id: depositId, Name: transaction.DepositTransaction})
+	assert.NoError(t, err)
+	err = pdb.CreateEntriesForTransactionId(txn, depositId, []transaction.Entry{
+		{Id: uuid.New(), TransactionId: depositId, AccountId: alice.Id, Name: transaction.IncomingEntry, Credit: decimal.NewFromFloat(44.79)},
+	})
+	assert.NoError(t, err)
+
+	before, err := pdb.GetAccountsAsOf(txn, now.Add(-time.Second))
+	assert.NoError(t, err)
+	_, notFoundErr := pdb.GetAccountByUsernameAsOf(txn, alice.Username, now.Add(-time.Second))
+
+	recorded, err := pdb.CreateBalanceCheckpoints(txn, now)
+	assert.NoError(t, err)
+	rerecorded, err := pdb.CreateBalanceCheckpoints(txn, now)
+	assert.NoError(t, err)
+
+	after, err := pdb.GetAccountByUsernameAsOf(txn, alice.Username, now.Add(time.Hour))
+	assert.NoError(t, err)
+
+	// then
+	for _, account := range before {
+		assert.NotEqual(t, alice.Id, account.Id)
+	}
+	assert.Equal(t, transaction.ErrAccountNotFound, notFoundErr)
+	assert.GreaterOrEqual(t, recorded, int64(1))
+	assert.Equal(t, int64(0), rerecorded)
+	if assert.NotNil(t, after) {
+		assert.Equal(t, "44.79", after.Balance.String())
+		assert.Equal(t, transaction.ActiveAccountStatus, after.Status)
+		assert.True(t, after.LastEntryId.Valid)
+	}
+}
+
+func Test_PostgresDb_CreateAccount_AccountAlreadyExists(t *testing.T) {
+	// given
+	cfg, err := dbutil.NewConfig()
+	assert.NoError(t, err)
+	db, err := dbutil.NewDb(cfg)
+	assert.NoError(t, err)
+	pdb := transaction.NewPostgresDb(db)
+
+	alice := transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Status: transaction.ActiveAccountStatus}
+	aliceAgain := transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Status: transaction.ActiveAccountStatus}
+
+	// when
+	txn, err := pdb.BeginTxn(context.Background(), nil)
+	assert.NoError(t, err)
+
+	err = pdb.CreateAccount(txn, alice)
+	assert.NoError(t, err)
+	err = pdb.CreateAccount(txn, aliceAgain)
+
+	txn.Rollback()
+
+	// then
+	assert.Equal(t, transaction.ErrAccountAlreadyExists, err)
+}
+
+func Test_PostgresDb_CreateAccountsInBulk(t *testing.T) {
+	// given
+	cfg, err := dbutil.NewConfig()
+	assert.NoError(t, err)
+	db, err := dbutil.NewDb(cfg)
+	assert.NoError(t, err)
+	pdb := transaction.NewPostgresDb(db)
+
+	alice := transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Status: transaction.ActiveAccountStatus}
+	bob := transaction.Account{Id: uuid.New(), Username: "bob123", Currency: "USD", Status: transaction.ActiveAccountStatus}
+
+	var deposits []transaction.Transaction
+	var entries []transaction.Entry
+	for _, account := range []transaction.Account{alice, bob} {
+		depositId := uuid.New()
+		deposits = append(deposits, transaction.Transaction{Id: depositId, Name: transaction.DepositTransaction})
+		entries = append(entries, transaction.Entry{
+			Id: uuid.New(), TransactionId: depositId, AccountId: account.Id, Name: transaction.IncomingEntry, Credit: decimal.NewFromFloat(10.50),
+		})
+	}
+
+	// when
+	txn, err := pdb.BeginTxn(context.Background(), nil)
+	assert.NoError(t, err)
+
+	takenBefore, err := pdb.GetTakenUsernames(txn, []string{alice.Username, bob.Username})
+	assert.NoError(t, err)
+
+	err = pdb.CreateAccounts(txn, []transaction.Account{alice, bob})
+	assert.NoError(t, err)
+	err = pdb.LockTransactions(txn)
+	assert.NoError(t, err)
+	err = pdb.CreateTransactions(txn, deposits)
+	assert.NoError(t, err)
+	err = pdb.CreateEntries(txn, entries)
+	assert.NoError(t, err)
+
+	takenAfter, err := pdb.GetTakenUsernames(txn, []string{alice.Username, "carol789"})
+	assert.NoError(t, err)
+	accounts, err := pdb.GetAccounts(txn)
+	assert.NoError(t, err)
+
+	txn.Rollback()
+
+	// then
+	assert.Empty(t, takenBefore)
+	assert.Equal(t, []string{alice.Username}, takenAfter)
+
+	assert.Len(t, accounts, 2)
+	for _, account := range accounts {
+		assert.True(t, account.Balance.Equal(decimal.NewFromFloat(10.50)), account.Username)
+	}
+}
+
+func Test_PostgresDb_GetStatementLines(t *testing.T) {
+	// given
+	cfg, err := dbutil.NewConfig()
+	assert.NoError(t, err)
+	db, err := dbutil.NewDb(cfg)
+	assert.NoError(t, err)
+	pdb := transaction.NewPostgresDb(db)
+
+	alice := transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Status: transaction.ActiveAccountStatus}
+	bob := transaction.Account{Id: uuid.New(), Username: "bob123", Currency: "USD", Status: transaction.ActiveAccountStatus}
+
+	depositId := uuid.New()
+	paymentId := uuid.New()
+
+	// when
+	txn, err := pdb.BeginTxn(context.Background(), nil)
+	assert.NoError(t, err)
+
+	err = pdb.CreateAccount(txn, bob)
+	assert.NoError(t, err)
+	err = pdb.CreateAccount(txn, alice)
+	assert.NoError(t, err)
+
+	err = pdb.LockTransactions(txn)
+	assert.NoError(t, err)
+	err = pdb.CreateTransaction(txn, transaction.Transaction{Id: depositId, Name: transaction.DepositTransaction})
+	assert.NoError(t, err)
+	err = pdb.CreateEntriesForTransactionId(txn, depositId, []transaction.Entry{
+		{Id: uuid.New(), TransactionId: depositId, AccountId: bob.Id, Name: transaction.IncomingEntry, Credit: decimal.NewFromFloat(200.00)},
+	})
+	assert.NoError(t, err)
+	err = pdb.CreateTransaction(txn, transaction.Transaction{Id: paymentId, Name: transaction.PaymentTransaction})
+	assert.NoError(t, err)
+	err = pdb.CreateEntriesForTransactionId(txn, paymentId, []transaction.Entry{
+		{Id: uuid.New(), TransactionId: paymentId, AccountId: bob.Id, TargetAccountId: util.NewNullUUID(alice.Id), Name: transaction.OutgoingEntry, Debit: decimal.NewFromFloat(-60.41)},
+		{Id: uuid.New(), TransactionId: paymentId, AccountId: alice.Id, TargetAccountId: util.NewNullUUID(bob.Id), Name: transaction.IncomingEntry, Credit: decimal.NewFromFloat(60.41)},
+	})
+	assert.NoError(t, err)
+
+	lines, err := pdb.GetStatementLines(txn, bob.Id)
+	assert.NoError(t, err)
+
+	txn.Rollback()
+
+	// then
+	// entries written in the same db transaction share created_at, so only the final running balance is fixed
+	assert.Len(t, lines, 2)
+	assert.True(t, lines[1].Balance.Equal(decimal.NewFromFloat(139.59)))
+
+	for _, line := range lines {
+		switch line.TransactionId {
+		case depositId:
+			assert.Equal(t, transaction.DepositTransaction, line.TransactionName)
+			assert.False(t, line.Counterparty.Valid)
+			assert.True(t, line.Amount.Equal(decimal.NewFromFloat(200.00)))
+		case paymentId:
+			assert.Equal(t, transaction.PaymentTransaction, line.TransactionName)
+			assert.Equal(t, alice.Username, line.Counterparty.String)
+			assert.True(t, line.Amount.Equal(decimal.NewFromFloat(-60.41)))
+		default:
+			t.Errorf("unexpected transaction %s", line.TransactionId)
+		}
+	}
+}
+
+func Test_PostgresDb_GetAccountEvents(t *testing.T) {
+	// given
+	cfg, err := dbutil.NewConfig()
+	assert.NoError(t, err)
+	db, err := dbutil.NewDb(cfg)
+	assert.NoError(t, err)
+	pdb := transaction.NewPostgresDb(db)
+
+	alice := transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Status: transaction.ActiveAccountStatus}
+	bob := transaction.Account{Id: uuid.New(), Username: "bob123", Currency: "USD", Status: transaction.ActiveAccountStatus}
+
+	depositId := uuid.New()
+	paymentId := uuid.New()
+
+	// when
+	txn, err := pdb.BeginTxn(context.Background(), nil)
+	assert.NoError(t, err)
+
+	err = pdb.CreateAccount(txn, bob)
+	assert.NoError(t, err)
+	err = pdb.CreateAccount(txn, alice)
+	assert.NoError(t, err)
+
+	seqBefore, err := pdb.GetLastEntrySeq(txn, bob.Id)
+	assert.NoError(t, err)
+
+	err = pdb.LockTransactions(txn)
+	assert.NoError(t, err)
+	err = pdb.CreateTransaction(txn, transaction.Transaction{Id: depositId, Name: transaction.DepositTransaction})
+	assert.NoError(t, err)
+	err = pdb.CreateEntriesForTransactionId(txn, depositId, []transaction.Entry{
+		{Id: uuid.New(), TransactionId: depositId, AccountId: bob.Id, Name: transaction.IncomingEntry, Credit: decimal.NewFromFloat(200.00)},
+	})
+	assert.NoError(t, err)
+	err = pdb.CreateTransaction(txn, transaction.Transaction{Id: paymentId, Name: transaction.PaymentTransaction})
+	assert.NoError(t, err)
+	err = pdb.CreateEntriesForTransactionId(txn, paymentId, []transaction.Entry{
+		{Id: uuid.New(), TransactionId: paymentId, AccountId: bob.Id, TargetAccountId: util.NewNullUUID(alice.Id), Name: transaction.OutgoingEntry, Debit: decimal.NewFromFloat(-60.41)},
+		{Id: uuid.New(), TransactionId: paymentId, AccountId: alice.Id, TargetAccountId: util.NewNullUUID(bob.Id), Name: transaction.IncomingEntry, Credit: decimal.NewFromFloat(60.41)},
+	})
+	assert.NoError(t, err)
+
+	seqAfter, err := pdb.GetLastEntrySeq(txn, bob.Id)
+	assert.NoError(t, err)
+	all, err := pdb.GetAccountEvents(txn, bob.Id, 0, 10)
+	assert.NoError(t, err)
+	resumed, err := pdb.GetAccountEvents(txn, bob.Id, 1, 10)
+	assert.NoError(t, err)
+	limited, err := pdb.GetAccountEvents(txn, bob.Id, 0, 1)
+	assert.NoError(t, err)
+
+	txn.Rollback()
+
+	// then
+	assert.Equal(t, int64(0), seqBefore)
+	assert.Equal(t, int64(2), seqAfter)
+
+	if assert.Len(t, all, 2) {
+		assert.Equal(t, int64(1), all[0].Seq)
+		assert.Equal(t, transaction.DepositTransaction, all[0].TransactionName)
+		assert.True(t, all[0].Balance.Equal(decimal.NewFromFloat(200.00)))
+		assert.Equal(t, int64(2), all[1].Seq)
+		assert.Equal(t, alice.Username, all[1].Counterparty.String)
+		assert.True(t, all[1].Amount.Equal(decimal.NewFromFloat(-60.41)))
+		assert.True(t, all[1].Balance.Equal(decimal.NewFromFloat(139.59)))
+	}
+	if assert.Len(t, resumed, 1) {
+		assert.Equal(t, int64(2), resumed[0].Seq)
+		assert.True(t, resumed[0].Balance.Equal(decimal.NewFromFloat(139.59)))
+	}
+	assert.Len(t, limited, 1)
+}
+
+func Test_PostgresDb_StreamExportedEntries(t *testing.T) {
+	// given
+	cfg, err := dbutil.NewConfig()
+	assert.NoError(t, err)
+	db, err := dbutil.NewDb(cfg)
+	assert.NoError(t, err)
+	pdb := transaction.NewPostgresDb(db)
+
+	alice := transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Status: transaction.ActiveAccountStatus}
+	bob := transaction.Account{Id: uuid.New(), Username: "bob123", Currency: "USD", Status: transaction.ActiveAccountStatus}
+
+	depositId := uuid.New()
+	paymentId := uuid.New()
+
+	// when
+	txn, err := pdb.BeginTxn(context.Background(), nil)
+	assert.NoError(t, err)
+	defer txn.Rollback()
+
+	var now time.Time
+	err = txn.Get(&now, "SELECT now()")
+	assert.NoError(t, err)
+
+	err = pdb.CreateAccount(txn, bob)
+	assert.NoError(t, err)
+	err = pdb.CreateAccount(txn, alice)
+	assert.NoError(t, err)
+
+	err = pdb.LockTransactions(txn)
+	assert.NoError(t, err)
+	err = pdb.CreateTransaction(txn, transaction.Transaction{Id: depositId, Name: transaction.DepositTransaction})
+	assert.NoError(t, err)
+	err = pdb.CreateEntriesForTransactionId(txn, depositId, []transaction.Entry{
+		{Id: uuid.New(), TransactionId: depositId, AccountId: bob.Id, Name: transaction.IncomingEntry, Credit: decimal.NewFromFloat(200.00)},
+	})
+	assert.NoError(t, err)
+	err = pdb.CreateTransaction(txn, transaction.Transaction{Id: paymentId, Name: transaction.PaymentTransaction})
+	assert.NoError(t, err)
+	err = pdb.CreateEntriesForTransactionId(txn, paymentId, []transaction.Entry{
+		{Id: uuid.New(), TransactionId: paymentId, AccountId: bob.Id, TargetAccountId: util.NewNullUUID(alice.Id), Name: transaction.OutgoingEntry, Debit: decimal.NewFromFloat(-60.41)},
+		{Id: uuid.New(), TransactionId: paymentId, AccountId: alice.Id, TargetAccountId: util.NewNullUUID(bob.Id), Name: transaction.IncomingEntry, Credit: decimal.NewFromFloat(60.41)},
+	})
+	assert.NoError(t, err)
+
+	collect := func(filter transaction.ExportFilter) []transaction.ExportedEntry {
+		var entries []transaction.ExportedEntry
+		err := pdb.StreamExportedEntries(txn, filter, func(entry transaction.ExportedEntry) error {
+			entries = append(entries, entry)
+			return nil
+		})
+		assert.NoError(t, err)
+		return entries
+	}
+
+	payments := collect(transaction.ExportFilter{Kind: transaction.PaymentTransaction, Account: alice.Username, Since: now})
+	deposits := collect(transaction.ExportFilter{Kind: transaction.DepositTransaction, Account: bob.Username})
+	statement := collect(transaction.ExportFilter{Kind: transaction.StatementExport, Account: bob.Username})
+	later := collect(transaction.ExportFilter{Kind: transaction.StatementExport, Account: bob.Username, Since: now.Add(time.Second)})
+	earlier := collect(transaction.ExportFilter{Kind: transaction.PaymentTransaction, Until: now})
+
+	// then
+	if assert.Len(t, payments, 1) {
+		assert.Equal(t, paymentId, payments[0].TransactionId)
+		assert.Equal(t, bob.Username, payments[0].Counterparty.String)
+		assert.True(t, payments[0].Amount.Equal(decimal.NewFromFloat(60.41)))
+		assert.False(t, payments[0].Balance.Valid)
+	}
+	if assert.Len(t, deposits, 1) {
+		assert.Equal(t, depositId, deposits[0].TransactionId)
+		assert.False(t, deposits[0].Counterparty.Valid)
+	}
+	// entries written in the same db transaction share created_at, so only the final running balance is fixed
+	if assert.Len(t, statement, 2) {
+		assert.True(t, statement[1].Balance.Decimal.Equal(decimal.NewFromFloat(139.59)))
+	}
+	assert.Empty(t, later)
+	for _, entry := range earlier {
+		assert.NotEqual(t, paymentId, entry.TransactionId)
+	}
+}
+
+func Test_PostgresDb_GetLedgerDiscrepancies(t *testing.T) {
+	// given
+	cfg, err := dbutil.NewConfig()
+	assert.NoError(t, err)
+	db, err := dbutil.NewDb(cfg)
+	assert.NoError(t, err)
+	pdb := transaction.NewPostgresDb(db)
+
+	alice := transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Status: transaction.ActiveAccountStatus}
+
+	// a payment missing its incoming entry, which also overdraws alice
+	paymentId := uuid.New()
+
+	// when
+	txn, err := pdb.BeginTxn(context.Background(), nil)
+	assert.NoError(t, err)
+
+	err = pdb.CreateAccount(txn, alice)
+	assert.NoError(t, err)
+
+	err = pdb.LockTransactions(txn)
+	assert.NoError(t, err)
+	err = pdb.CreateTransaction(txn, transaction.Transaction{Id: paymentId, Name: transaction.PaymentTransaction})
+	assert.NoError(t, err)
+	err = pdb.CreateEntriesForTransactionId(txn, paymentId, []transaction.Entry{
+		{Id: uuid.New(), TransactionId: paymentId, AccountId: alice.Id, Name: transaction.OutgoingEntry, Debit: decimal.NewFromFloat(-44.79)},
+	})
+	assert.NoError(t, err)
+
+	discrepancies, err := pdb.GetLedgerDiscrepancies(txn)
+	assert.NoError(t, err)
+
+	txn.Rollback()
+
+	// then
+	assert.Contains(t, discrepancies, transaction.Discrepancy{
+		Check:     "payment_unbalanced",
+		Reference: paymentId.String(),
+		Detail:    "1 entries summing to -44.79",
+	})
+	assert.Contains(t, discrepancies, transaction.Discrepancy{
+		Check:     "balance_negative",
+		Reference: alice.Username,
+		Detail:    "balance is -44.79",
+	})
+}
+
+func Test_PostgresDb_GetLedgerDiscrepancies_CheckpointMismatch(t *testing.T) {
+	// given
+	cfg, err := dbutil.NewConfig()
+	assert.NoError(t, err)
+	db, err := dbutil.NewDb(cfg)
+	assert.NoError(t, err)
+	pdb := transaction.NewPostgresDb(db)
+
+	alice := transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Status: transaction.ActiveAccountStatus}
+	asOf := time.Now().Add(time.Hour).Truncate(time.Second).UTC()
+
+	// a deposit dated before a checkpoint that was recorded without it, like one committed late by a long transaction
+	depositId := uuid.New()
+
+	// when
+	txn, err := pdb.BeginTxn(context.Background(), nil)
+	assert.NoError(t, err)
+	defer txn.Rollback()
+
+	err = pdb.CreateAccount(txn, alice)
+	assert.NoError(t, err)
+
+	_, err = pdb.CreateBalanceCheckpoints(txn, asOf)
+	assert.NoError(t, err)
+
+	err = pdb.LockTransactions(txn)
+	assert.NoError(t, err)
+	err = pdb.CreateTransaction(txn, transaction.Transaction{Id: depositId, Name: transaction.DepositTransaction})
+	assert.NoError(t, err)
+	err = pdb.CreateEntriesForTransactionId(txn, depositId, []transaction.Entry{
+		{Id: uuid.New(), TransactionId: depositId, AccountId: alice.Id, Name: transaction.IncomingEntry, Credit: decimal.NewFromFloat(10.5)},
+	})
+	assert.NoError(t, err)
+
+	discrepancies, err := pdb.GetLedgerDiscrepancies(txn)
+	assert.NoError(t, err)
+
+	// then
+	assert.Contains(t, discrepancies, transaction.Discrepancy{
+		Check:     "checkpoint_mismatch",
+		Reference: alice.Username,
+		Detail:    "checkpoint at " + asOf.Format(time.RFC3339) + " is 0 but entries up to it sum to 10.5",
+	})
+}
+
+func Test_PostgresDb_GetChainedEntries(t *testing.T) {
+	// given
+	cfg, err := dbutil.NewConfig()
+	assert.NoError(t, err)
+	db, err := dbutil.NewDb(cfg)
+	assert.NoError(t, err)
+	pdb := transaction.NewPostgresDb(db)
+
+	alice := transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Status: transaction.ActiveAccountStatus}
+	firstId, secondId := uuid.New(), uuid.New()
+
+	// when
+	txn, err := pdb.BeginTxn(context.Background(), nil)
+	assert.NoError(t, err)
+	defer txn.Rollback()
+
+	err = pdb.CreateAccount(txn, alice)
+	assert.NoError(t, err)
+
+	err = pdb.LockTransactions(txn)
+	assert.NoError(t, err)
+	for _, transactionId := range []uuid.UUID{firstId, secondId} {
+		err = pdb.CreateTransaction(txn, transaction.Transaction{Id: transactionId, Name: transaction.DepositTransaction})
+		assert.NoError(t, err)
+		err = pdb.CreateEntriesForTransactionId(txn, transactionId, []transaction.Entry{
+			{Id: uuid.New(), TransactionId: transactionId, AccountId: alice.Id, Name: transaction.IncomingEntry, Credit: decimal.NewFromFloat(44.79)},
+		})
+		assert.NoError(t, err)
+	}
+
+	fetched, err := pdb.GetChainedEntries(txn, alice.Id, 0, 2)
+	assert.NoError(t, err)
+
+	_, updateErr := txn.Exec("UPDATE transaction_entries SET credit = 1000 WHERE account_id = $1", alice.Id)
+
+	// then
+	if assert.Len(t, fetched, 2) {
+		assert.Equal(t, firstId, fetched[0].TransactionId)
+		assert.Equal(t, int64(1), fetched[0].Seq)
+		assert.Equal(t, strings.Repeat("0", 64), fetched[0].PrevHash)
+		assert.Equal(t, alice.Username, fetched[0].AccountName)
+		assert.Equal(t, secondId, fetched[1].TransactionId)
+		assert.Equal(t, int64(2), fetched[1].Seq)
+		assert.Equal(t, fetched[0].Hash, fetched[1].PrevHash)
+		assert.Len(t, fetched[1].Hash, 64)
+	}
+	assert.Error(t, updateErr)
+}
+
+func Test_PostgresDb_CreateAndGetAuditRecords(t *testing.T) {
+	// given
+	cfg, err := dbutil.NewConfig()
+	assert.NoError(t, err)
+	db, err := dbutil.NewDb(cfg)
+	assert.NoError(t, err)
+	pdb := transaction.NewPostgresDb(db)
+
+	createdAt := time.Now().UTC().Truncate(time.Microsecond)
+
+	// when
+	txn, err := pdb.BeginTxn(context.Background(), nil)
+	assert.NoError(t, err)
+	defer txn.Rollback()
+
+	err = pdb.LockAuditLog(txn)
+	assert.NoError(t, err)
+
+	last, err := pdb.GetLastAuditRecord(txn)
+	assert.NoError(t, err)
+	var seq int64
+	if last != nil {
+		seq = last.Seq
+	}
+
+	deposit := transaction.AuditRecord{
+		Seq:          seq + 1,
+		ClaimedActor: "ops@example.com",
+		Action:       transaction.DepositAuditAction,
+		Account:      "alice456",
+		PayloadHash:  "payload",
+		Outcome:      transaction.OkAuditOutcome,
+		CreatedAt:    createdAt,
+		PrevHash:     "prev",
+		Hash:         "deposit",
+	}
+	payment := transaction.AuditRecord{
+		Seq:          seq + 2,
+		ClaimedActor: "anonymous",
+		Action:       transaction.SendPaymentAuditAction,
+		Account:      "bob123",
+		Counterparty: null.StringFrom("alice456"),
+		PayloadHash:  "payload",
+		Outcome:      transaction.ErrBalanceInsufficient.Error(),
+		CreatedAt:    createdAt,
+		PrevHash:     "deposit",
+		Hash:         "payment",
+	}
+	err = pdb.CreateAuditRecord(txn, deposit)
+	assert.NoError(t, err)
+	err = pdb.CreateAuditRecord(txn, payment)
+	assert.NoError(t, err)
+
+	fetched, err := pdb.GetAuditRecords(txn, transaction.AuditFilter{Account: "alice456", AfterSeq: seq})
+	assert.NoError(t, err)
+	byActor, err := pdb.GetAuditRecords(txn, transaction.AuditFilter{ClaimedActor: "ops@example.com", AfterSeq: seq, Since: createdAt})
+	assert.NoError(t, err)
+	limited, err := pdb.GetAuditRecords(txn, transaction.AuditFilter{AfterSeq: seq, Limit: 1})
+	assert.NoError(t, err)
+	newLast, err := pdb.GetLastAuditRecord(txn)
+	assert.NoError(t, err)
+
+	_, updateErr := txn.Exec("UPDATE audit_log SET outcome = 'ok' WHERE seq = $1", payment.Seq)
+
+	// then
+	if assert.Len(t, fetched, 2) {
+		assert.Equal(t, deposit.Hash, fetched[0].Hash)
+		assert.True(t, deposit.CreatedAt.Equal(fetched[0].CreatedAt))
+		assert.Equal(t, payment.Counterparty, fetched[1].Counterparty)
+	}
+	if assert.Len(t, byActor, 1) {
+		assert.Equal(t, deposit.Seq, byActor[0].Seq)
+	}
+	assert.Len(t, limited, 1)
+	if assert.NotNil(t, newLast) {
+		assert.Equal(t, payment.Seq, newLast.Seq)
+	}
+	assert.Error(t, updateErr)
+}
+
+func Test_PostgresDb_CreateAndExpirePaymentRequests(t *testing.T) {
+	// given
+	cfg, err := dbutil.NewConfig()
+	assert.NoError(t, err)
+	db, err := dbutil.NewDb(cfg)
+	assert.NoError(t, err)
+	pdb := transaction.NewPostgresDb(db)
+
+	alice := transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Status: transaction.ActiveAccountStatus}
+	bob := transaction.Account{Id: uuid.New(), Username: "bob123", Currency: "USD", Status: transaction.ActiveAccountStatus}
+
+	now := time.Now()
+	declined := transaction.PaymentRequest{
+		Id:          uuid.New(),
+		RequesterId: alice.Id,
+		PayerId:     bob.Id,
+		Amount:      decimal.RequireFromString("25.00"),
+		Memo:        null.StringFrom("dinner"),
+		Status:      transaction.PendingPaymentRequestStatus,
+		ExpiresAt:   now.Add(time.Hour),
+	}
+	stale := transaction.PaymentRequest{
+		Id:          uuid.New(),
+		RequesterId: alice.Id,
+		PayerId:     bob.Id,
+		Amount:      decimal.RequireFromString("10.00"),
+		Status:      transaction.PendingPaymentRequestStatus,
+		ExpiresAt:   now.Add(-time.Hour),
+	}
+
+	// when
+	txn, err := pdb.BeginTxn(context.Background(), nil)
+	assert.NoError(t, err)
+
+	err = pdb.CreateAccount(txn, alice)
+	assert.NoError(t, err)
+	err = pdb.CreateAccount(txn, bob)
+	assert.NoError(t, err)
+	err = pdb.CreatePaymentRequest(txn, declined)
+	assert.NoError(t, err)
+	err = pdb.CreatePaymentRequest(txn, stale)
+	assert.NoError(t, err)
+
+	incoming, err := pdb.GetPaymentRequests(txn, transaction.PaymentRequestFilter{Account: bob.Username, Direction: transaction.IncomingEntry})
+	assert.NoError(t, err)
+	outgoing, err := pdb.GetPaymentRequests(txn, transaction.PaymentRequestFilter{Account: bob.Username, Direction: transaction.OutgoingEntry})
+	assert.NoError(t, err)
+	expiredBeforeSweep, err := pdb.GetPaymentRequests(txn, transaction.PaymentRequestFilter{Account: alice.Username, Status: transaction.ExpiredPaymentRequestStatus})
+	assert.NoError(t, err)
+
+	err = pdb.UpdatePaymentRequestStatus(txn, declined.Id, transaction.DeclinedPaymentRequestStatus, uuid.NullUUID{})
+	assert.NoError(t, err)
+	fetched, err := pdb.GetPaymentRequestForUpdate(txn, declined.Id)
+	assert.NoError(t, err)
+
+	expired, err := pdb.ExpirePaymentRequests(txn, now)
+	assert.NoError(t, err)
+	pending, err := pdb.GetPaymentRequests(txn, transaction.PaymentRequestFilter{Account: alice.Username, Status: transaction.PendingPaymentRequestStatus})
+	assert.NoError(t, err)
+
+	txn.Rollback()
+
+	// then
+	// bob is asked to pay both requests, and has asked for none
+	assert.Len(t, incoming, 2)
+	assert.Len(t, outgoing, 0)
+
+	// a request past its expiry is listed as expired before it is swept
+	assert.Len(t, expiredBeforeSweep, 1)
+	assert.Equal(t, stale.Id, expiredBeforeSweep[0].Id)
+
+	assert.Equal(t, transaction.DeclinedPaymentRequestStatus, fetched.Status)
+	assert.Equal(t, alice.Username, fetched.Requester)
+	assert.Equal(t, bob.Username, fetched.Payer)
+	assert.True(t, declined.Amount.Equal(fetched.Amount))
+	assert.Equal(t, declined.Memo, fetched.Memo)
+
+	assert.Equal(t, int64(1), expired)
+	assert.Len(t, pending, 0)
+}
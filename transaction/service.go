@@ -1,32 +1,101 @@
 package transaction
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sort"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
+	"github.com/nogurenn/cph-wallet/dbutil"
 	"github.com/nogurenn/cph-wallet/util"
 	"github.com/shopspring/decimal"
+	"gopkg.in/guregu/null.v4"
 )
 
 type Service interface {
 	// CreateAccount creates a new user account.
-	CreateAccount(username string) error
+	CreateAccount(ctx context.Context, username string) error
 	// GetAccounts fetches all accounts and their respective balances.
-	GetAccounts() ([]Account, error)
-	// GetPaymentTransactions fetches all transactions with name PaymentTransaction.
-	GetPaymentTransactions() ([]Transaction, error)
+	GetAccounts(ctx context.Context) ([]Account, error)
+	// GetAccount fetches a single account and its balance by username.
+	GetAccount(ctx context.Context, username string) (*Account, error)
+	// GetAccountsAsOf fetches the accounts that existed at asOf, with their balances and statuses at asOf.
+	GetAccountsAsOf(ctx context.Context, asOf time.Time) ([]Account, error)
+	// GetAccountAsOf fetches a single account by username with its balance and status at asOf, or
+	// ErrAccountNotFound if it did not exist at asOf.
+	GetAccountAsOf(ctx context.Context, username string, asOf time.Time) (*Account, error)
+	// GetPaymentTransactions fetches all transactions with name PaymentTransaction that match filter.
+	GetPaymentTransactions(ctx context.Context, filter TransactionFilter) ([]Transaction, error)
 	// Deposit records a deposit transaction for the given username, if the account exists.
-	Deposit(username string, amount decimal.Decimal) error
-	// SendPayment records a fund transfer from one account to another.
-	SendPayment(fromUsername string, toUsername string, amount decimal.Decimal) error
+	// Deposits retried under the same idempotency key (see WithIdempotencyKey) are recorded once.
+	Deposit(ctx context.Context, username string, amount decimal.Decimal) error
+	// SendPayment records a fund transfer from one account to another, along with what the sender tells about it.
+	// Payments retried under the same idempotency key (see WithIdempotencyKey) are recorded once.
+	SendPayment(ctx context.Context, fromUsername string, toUsername string, amount decimal.Decimal, details PaymentDetails) error
+	// CreatePaymentRequest records a request by one account for another to pay it, which the payer may accept
+	// until expiresAt, or until the default expiry if expiresAt is zero.
+	CreatePaymentRequest(ctx context.Context, requesterUsername string, payerUsername string, amount decimal.Decimal, memo null.String, expiresAt time.Time) (*PaymentRequest, error)
+	// GetPaymentRequests fetches the payment requests made or received by an account that match filter, latest first.
+	GetPaymentRequests(ctx context.Context, filter PaymentRequestFilter) ([]PaymentRequest, error)
+	// AcceptPaymentRequest pays a pending payment request on behalf of its payer, recording the payment and marking
+	// the request as paid at once.
+	AcceptPaymentRequest(ctx context.Context, id uuid.UUID, payerUsername string) (*PaymentRequest, error)
+	// DeclinePaymentRequest refuses a pending payment request on behalf of its payer.
+	DeclinePaymentRequest(ctx context.Context, id uuid.UUID, payerUsername string) (*PaymentRequest, error)
+	// CancelPaymentRequest withdraws a pending payment request on behalf of its requester.
+	CancelPaymentRequest(ctx context.Context, id uuid.UUID, requesterUsername string) (*PaymentRequest, error)
+	// FreezeAccount blocks an active account from sending or receiving funds.
+	FreezeAccount(ctx context.Context, username string, reason string) error
+	// UnfreezeAccount reactivates a frozen account.
+	UnfreezeAccount(ctx context.Context, username string, reason string) error
+	// CloseAccount permanently closes an active or frozen account with zero balance.
+	CloseAccount(ctx context.Context, username string, reason string) error
+	// GetAccountStatusChanges fetches the status transitions of an account, latest first.
+	GetAccountStatusChanges(ctx context.Context, username string) ([]AccountStatusChange, error)
+	// GetAccountStatement fetches every entry of an account with the running balance after it, oldest first.
+	GetAccountStatement(ctx context.Context, username string) ([]StatementLine, error)
+	// ExportEntries calls fn with every entry selected by filter, oldest first, as it is read from the database,
+	// so that exports of any size take constant memory. It stops at the first error fn returns, and returns it.
+	ExportEntries(ctx context.Context, filter ExportFilter, fn func(ExportedEntry) error) error
+	// StreamAccountEvents calls fn with every entry of an account after the one at lastEventId, or with its latest
+	// entry if lastEventId is null, and then with each new entry as it is committed, until ctx is done, new entries
+	// will no longer be told, or fn fails. Errors of fn are returned.
+	StreamAccountEvents(ctx context.Context, username string, lastEventId null.Int, fn func(AccountEvent) error) error
+	// ImportAccounts creates an account for each row, opened with a deposit of its opening balance, all in one
+	// db transaction. Rows are validated first, and if any is invalid none is imported and ErrImportRowsInvalid is
+	// returned along with a report of every invalid row. A dry run validates the rows without importing them.
+	ImportAccounts(ctx context.Context, rows []ImportRow, dryRun bool) (*ImportReport, error)
+	// Reconcile checks the ledger for entries that violate double-entry bookkeeping or account rules, or that were
+	// changed or removed after being recorded. An empty result means the ledger is consistent.
+	Reconcile(ctx context.Context) ([]Discrepancy, error)
+	// GetAuditLog fetches the audit records matching filter, oldest first. Every mutating call above is recorded,
+	// along with the claimed actor carried by ctx (see WithClaimedActor) and whether it succeeded.
+	GetAuditLog(ctx context.Context, filter AuditFilter) ([]AuditRecord, error)
+	// VerifyAuditLog walks the hash chain of the audit log, and returns the first record that does not follow
+	// from the ones before it. A nil result means the log has not been tampered with.
+	VerifyAuditLog(ctx context.Context) (*AuditChainBreak, error)
 }
 
 type service struct {
-	db Repository
+	db       Repository
+	txns     *dbutil.TxnRunner
+	activity AccountActivity
 }
 
+// NewService returns a Service whose account event streams look for new entries every
+// accountEventsPollInterval.
 func NewService(db Repository) Service {
-	return &service{db: db}
+	return &service{db: db, txns: dbutil.NewTxnRunner(db.BeginTxn)}
+}
+
+// NewServiceWithActivity returns a Service whose account event streams are told of new entries by activity.
+func NewServiceWithActivity(db Repository, activity AccountActivity) Service {
+	return &service{db: db, txns: dbutil.NewTxnRunner(db.BeginTxn), activity: activity}
 }
 
 const (
@@ -39,154 +108,901 @@ const (
 	// list of valid entry names
 	IncomingEntry = "incoming"
 	OutgoingEntry = "outgoing"
+
+	// StatementExport is the ExportFilter kind of the statement of an account, next to the transaction names.
+	StatementExport = "statement"
+
+	// list of valid account statuses
+	ActiveAccountStatus = "active"
+	FrozenAccountStatus = "frozen"
+	ClosedAccountStatus = "closed"
+
+	// list of valid payment request statuses
+	PendingPaymentRequestStatus   = "pending"
+	PaidPaymentRequestStatus      = "paid"
+	DeclinedPaymentRequestStatus  = "declined"
+	ExpiredPaymentRequestStatus   = "expired"
+	CancelledPaymentRequestStatus = "cancelled"
+)
+
+// accountStatusTransitions lists the statuses an account may move to from a given status.
+var accountStatusTransitions = map[string][]string{
+	ActiveAccountStatus: {FrozenAccountStatus, ClosedAccountStatus},
+	FrozenAccountStatus: {ActiveAccountStatus, ClosedAccountStatus},
+	ClosedAccountStatus: {},
+}
+
+const (
+	// accountEventsBatchSize bounds the entries read at once by account event streams that are catching up.
+	accountEventsBatchSize = 500
+	// accountEventsPollInterval is how often account event streams look for new entries without being told, in
+	// case they were not.
+	accountEventsPollInterval = 30 * time.Second
 )
 
-func (s *service) CreateAccount(username string) error {
-	txn, err := s.db.BeginTxn()
+const (
+	// maxPaymentDescriptionLength bounds the characters of payment descriptions.
+	maxPaymentDescriptionLength = 500
+	// maxExternalReferenceLength bounds the characters of external references of payments.
+	maxExternalReferenceLength = 128
+	// maxPaymentMetadataSize bounds the bytes of payment metadata encoded as JSON.
+	maxPaymentMetadataSize = 4096
+)
+
+const (
+	// defaultPaymentRequestTTL is how long payment requests created without an expiry can be accepted.
+	defaultPaymentRequestTTL = 7 * 24 * time.Hour
+	// maxPaymentRequestTTL bounds how far in the future payment requests can expire.
+	maxPaymentRequestTTL = 90 * 24 * time.Hour
+)
+
+// snapshotTxnOptions is used by queries that must see the latest data, so that queries spanning several
+// statements see a single consistent snapshot.
+var snapshotTxnOptions = &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}
+
+func (s *service) CreateAccount(ctx context.Context, username string) error {
+	call := auditedCall{
+		action:  CreateAccountAuditAction,
+		account: username,
+		payload: map[string]interface{}{"username": username},
+	}
+
+	return s.runAudited(ctx, call, sql.LevelReadCommitted, func(txn dbutil.Transaction) error {
+		return s.db.CreateAccount(txn, Account{
+			Id:       uuid.New(),
+			Username: username,
+			Currency: defaultAccountCurrency,
+			Status:   ActiveAccountStatus,
+		})
+	})
+}
+
+func (s *service) GetAccounts(ctx context.Context) ([]Account, error) {
+	txn, err := s.db.BeginReplicaTxn(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer txn.Rollback()
+
+	return s.db.GetAccounts(txn)
+}
 
-	newAccount := Account{
-		Id:       uuid.New(),
-		Username: username,
-		Currency: defaultAccountCurrency,
+func (s *service) GetAccount(ctx context.Context, username string) (*Account, error) {
+	txn, err := s.db.BeginTxn(ctx, snapshotTxnOptions)
+	if err != nil {
+		return nil, err
 	}
+	defer txn.Rollback()
 
-	if err = s.db.CreateAccount(txn, newAccount); err != nil {
-		txn.Rollback()
-		return err
+	return s.db.GetAccountByUsername(txn, strings.TrimSpace(username))
+}
+
+func (s *service) GetAccountsAsOf(ctx context.Context, asOf time.Time) ([]Account, error) {
+	txn, err := s.db.BeginReplicaTxn(ctx)
+	if err != nil {
+		return nil, err
 	}
+	defer txn.Rollback()
 
-	return txn.Commit()
+	return s.db.GetAccountsAsOf(txn, asOf)
 }
 
-func (s *service) GetAccounts() ([]Account, error) {
-	txn, err := s.db.BeginTxn()
+func (s *service) GetAccountAsOf(ctx context.Context, username string, asOf time.Time) (*Account, error) {
+	txn, err := s.db.BeginReplicaTxn(ctx)
 	if err != nil {
 		return nil, err
 	}
 	defer txn.Rollback()
 
-	return s.db.GetAccounts(txn)
+	return s.db.GetAccountByUsernameAsOf(txn, strings.TrimSpace(username), asOf)
 }
 
-func (s *service) GetPaymentTransactions() ([]Transaction, error) {
-	txn, err := s.db.BeginTxn()
+func (s *service) GetPaymentTransactions(ctx context.Context, filter TransactionFilter) ([]Transaction, error) {
+	txn, err := s.db.BeginReplicaTxn(ctx)
 	if err != nil {
 		return nil, err
 	}
 	defer txn.Rollback()
 
-	return s.db.GetTransactionsByName(txn, PaymentTransaction)
+	filter.ExternalReference = strings.TrimSpace(filter.ExternalReference)
+	return s.db.GetTransactionsByName(txn, PaymentTransaction, filter)
+}
+
+func (s *service) Deposit(ctx context.Context, username string, amount decimal.Decimal) error {
+	call := auditedCall{
+		action:  DepositAuditAction,
+		account: username,
+		payload: map[string]interface{}{"username": username, "amount": amount, "idempotency_key": IdempotencyKeyFrom(ctx)},
+	}
+
+	if amount.IsNegative() || amount.IsZero() {
+		return s.rejectAudited(ctx, call, ErrCreditAmountInvalid)
+	}
+
+	return s.runAudited(ctx, call, sql.LevelReadCommitted, func(txn dbutil.Transaction) error {
+		account, err := s.db.GetAccountByUsername(txn, username)
+		if err != nil {
+			return err
+		}
+
+		if err := s.db.LockTransactions(txn); err != nil {
+			return err
+		}
+
+		depositId := uuid.New()
+		entries := []Entry{
+			newCreditEntry(depositId, account.Id, util.NewNullUUID(uuid.Nil), amount),
+		}
+
+		replayed, err := s.isIdempotentReplay(txn, newNullIdempotencyKey(ctx), DepositTransaction, entries)
+		if err != nil || replayed {
+			return err
+		}
+
+		if err := s.ensureAccountActive(txn, account.Id); err != nil {
+			return err
+		}
+
+		err = s.db.CreateTransaction(txn, Transaction{
+			Id:             depositId,
+			Name:           DepositTransaction,
+			IdempotencyKey: newNullIdempotencyKey(ctx),
+		})
+		if err != nil {
+			return err
+		}
+
+		return s.db.CreateEntriesForTransactionId(txn, depositId, entries)
+	})
 }
 
-func (s *service) Deposit(username string, amount decimal.Decimal) error {
-	txn, err := s.db.BeginTxn()
+func (s *service) SendPayment(ctx context.Context, fromUsername string, toUsername string, amount decimal.Decimal, details PaymentDetails) error {
+	call := auditedCall{
+		action:       SendPaymentAuditAction,
+		account:      fromUsername,
+		counterparty: toUsername,
+		payload: map[string]interface{}{
+			"from_username":      fromUsername,
+			"to_username":        toUsername,
+			"amount":             amount,
+			"idempotency_key":    IdempotencyKeyFrom(ctx),
+			"description":        details.Description,
+			"external_reference": details.ExternalReference,
+			"metadata":           details.Metadata,
+		},
+	}
+
+	if amount.IsNegative() || amount.IsZero() {
+		return s.rejectAudited(ctx, call, ErrCreditAmountInvalid)
+	}
+
+	details, err := sanitizePaymentDetails(details)
+	if err != nil {
+		return s.rejectAudited(ctx, call, err)
+	}
+
+	sanitizedFromUsername := strings.TrimSpace(fromUsername)
+	sanitizedToUsername := strings.TrimSpace(toUsername)
+	if sanitizedFromUsername == sanitizedToUsername {
+		return s.rejectAudited(ctx, call, ErrPaymentSenderReceiverIdentical)
+	}
+
+	var paymentId uuid.UUID
+	err = s.runAudited(ctx, call, sql.LevelReadCommitted, func(txn dbutil.Transaction) error {
+		var err error
+		paymentId, err = s.recordPayment(txn, sanitizedFromUsername, sanitizedToUsername, amount, details, newNullIdempotencyKey(ctx))
+		return err
+	})
 	if err != nil {
 		return err
 	}
 
+	if paymentId != uuid.Nil {
+		observeRecordedPayment(ctx, amount)
+	}
+	return nil
+}
+
+func (s *service) CreatePaymentRequest(ctx context.Context, requesterUsername string, payerUsername string, amount decimal.Decimal, memo null.String, expiresAt time.Time) (*PaymentRequest, error) {
+	call := auditedCall{
+		action:       CreatePaymentRequestAuditAction,
+		account:      requesterUsername,
+		counterparty: payerUsername,
+		payload: map[string]interface{}{
+			"requester_username": requesterUsername,
+			"payer_username":     payerUsername,
+			"amount":             amount,
+			"memo":               memo,
+			"expires_at":         expiresAt,
+		},
+	}
+
 	if amount.IsNegative() || amount.IsZero() {
-		txn.Rollback()
-		return ErrCreditAmountInvalid
+		return nil, s.rejectAudited(ctx, call, ErrCreditAmountInvalid)
 	}
 
+	sanitizedMemo := trimNullString(memo)
+	if utf8.RuneCountInString(sanitizedMemo.String) > maxPaymentDescriptionLength {
+		return nil, s.rejectAudited(ctx, call, ErrPaymentDetailsTooLarge)
+	}
+
+	now := time.Now()
+	if expiresAt.IsZero() {
+		expiresAt = now.Add(defaultPaymentRequestTTL)
+	}
+	if !expiresAt.After(now) || expiresAt.After(now.Add(maxPaymentRequestTTL)) {
+		return nil, s.rejectAudited(ctx, call, ErrPaymentRequestExpiryInvalid)
+	}
+
+	sanitizedRequesterUsername := strings.TrimSpace(requesterUsername)
+	sanitizedPayerUsername := strings.TrimSpace(payerUsername)
+	if sanitizedRequesterUsername == sanitizedPayerUsername {
+		return nil, s.rejectAudited(ctx, call, ErrPaymentSenderReceiverIdentical)
+	}
+
+	var request *PaymentRequest
+	err := s.runAudited(ctx, call, sql.LevelReadCommitted, func(txn dbutil.Transaction) error {
+		requester, err := s.db.GetAccountByUsername(txn, sanitizedRequesterUsername)
+		if err != nil {
+			return err
+		}
+
+		payer, err := s.db.GetAccountByUsername(txn, sanitizedPayerUsername)
+		if err != nil {
+			return err
+		}
+
+		// frozen accounts may be unfrozen before the request expires, but closed ones can never settle it
+		if requester.Status == ClosedAccountStatus || payer.Status == ClosedAccountStatus {
+			return ErrAccountClosed
+		}
+
+		requestId := uuid.New()
+		err = s.db.CreatePaymentRequest(txn, PaymentRequest{
+			Id:          requestId,
+			RequesterId: requester.Id,
+			PayerId:     payer.Id,
+			Amount:      amount,
+			Memo:        sanitizedMemo,
+			ExpiresAt:   expiresAt,
+		})
+		if err != nil {
+			return err
+		}
+
+		request, err = s.db.GetPaymentRequestForUpdate(txn, requestId)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func (s *service) GetPaymentRequests(ctx context.Context, filter PaymentRequestFilter) ([]PaymentRequest, error) {
+	filter.Account = strings.TrimSpace(filter.Account)
+	if !isValidPaymentRequestFilter(filter) {
+		return nil, ErrPaymentRequestFilterInvalid
+	}
+
+	txn, err := s.db.BeginReplicaTxn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer txn.Rollback()
+
+	// an unknown account is reported as such, rather than as having no requests
+	if _, err := s.db.GetAccountByUsername(txn, filter.Account); err != nil {
+		return nil, err
+	}
+
+	return s.db.GetPaymentRequests(txn, filter)
+}
+
+func (s *service) AcceptPaymentRequest(ctx context.Context, id uuid.UUID, payerUsername string) (*PaymentRequest, error) {
+	return s.respondToPaymentRequest(ctx, AcceptPaymentRequestAuditAction, id, payerUsername, PaidPaymentRequestStatus)
+}
+
+func (s *service) DeclinePaymentRequest(ctx context.Context, id uuid.UUID, payerUsername string) (*PaymentRequest, error) {
+	return s.respondToPaymentRequest(ctx, DeclinePaymentRequestAuditAction, id, payerUsername, DeclinedPaymentRequestStatus)
+}
+
+func (s *service) CancelPaymentRequest(ctx context.Context, id uuid.UUID, requesterUsername string) (*PaymentRequest, error) {
+	return s.respondToPaymentRequest(ctx, CancelPaymentRequestAuditAction, id, requesterUsername, CancelledPaymentRequestStatus)
+}
+
+func (s *service) FreezeAccount(ctx context.Context, username string, reason string) error {
+	return s.changeAccountStatus(ctx, FreezeAccountAuditAction, username, FrozenAccountStatus, reason)
+}
+
+func (s *service) UnfreezeAccount(ctx context.Context, username string, reason string) error {
+	return s.changeAccountStatus(ctx, UnfreezeAccountAuditAction, username, ActiveAccountStatus, reason)
+}
+
+func (s *service) CloseAccount(ctx context.Context, username string, reason string) error {
+	return s.changeAccountStatus(ctx, CloseAccountAuditAction, username, ClosedAccountStatus, reason)
+}
+
+func (s *service) GetAccountStatusChanges(ctx context.Context, username string) ([]AccountStatusChange, error) {
+	txn, err := s.db.BeginTxn(ctx, snapshotTxnOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer txn.Rollback()
+
 	account, err := s.db.GetAccountByUsername(txn, username)
 	if err != nil {
-		txn.Rollback()
-		return err
+		return nil, err
 	}
 
-	err = s.db.LockTransactions(txn)
+	return s.db.GetAccountStatusChanges(txn, account.Id)
+}
+
+func (s *service) GetAccountStatement(ctx context.Context, username string) ([]StatementLine, error) {
+	txn, err := s.db.BeginReplicaTxn(ctx)
 	if err != nil {
-		txn.Rollback()
-		return err
+		return nil, err
 	}
+	defer txn.Rollback()
 
-	depositId := uuid.New()
-	err = s.db.CreateTransaction(txn, Transaction{
-		Id:   depositId,
-		Name: DepositTransaction,
-	})
+	account, err := s.db.GetAccountByUsername(txn, strings.TrimSpace(username))
+	if err != nil {
+		return nil, err
+	}
+
+	return s.db.GetStatementLines(txn, account.Id)
+}
+
+func (s *service) ExportEntries(ctx context.Context, filter ExportFilter, fn func(ExportedEntry) error) error {
+	filter.Account = strings.TrimSpace(filter.Account)
+	if !isValidExportFilter(filter) {
+		return ErrExportFilterInvalid
+	}
+
+	txn, err := s.db.BeginReplicaTxn(ctx)
 	if err != nil {
-		txn.Rollback()
 		return err
 	}
+	defer txn.Rollback()
+
+	// an unknown account is reported as such, rather than as an empty export
+	if filter.Account != "" {
+		if _, err := s.db.GetAccountByUsername(txn, filter.Account); err != nil {
+			return err
+		}
+	}
+
+	return s.db.StreamExportedEntries(txn, filter, fn)
+}
 
-	err = s.db.CreateEntriesForTransactionId(txn, depositId, []Entry{
-		newCreditEntry(depositId, account.Id, util.NewNullUUID(uuid.Nil), amount),
+func (s *service) StreamAccountEvents(ctx context.Context, username string, lastEventId null.Int, fn func(AccountEvent) error) error {
+	var account *Account
+	afterSeq := lastEventId.Int64
+	err := s.runReadOnly(ctx, func(txn dbutil.Transaction) error {
+		var err error
+		account, err = s.db.GetAccountByUsername(txn, strings.TrimSpace(username))
+		if err != nil || lastEventId.Valid {
+			return err
+		}
+
+		lastSeq, err := s.db.GetLastEntrySeq(txn, account.Id)
+		if lastSeq > 0 {
+			afterSeq = lastSeq - 1
+		}
+		return err
 	})
 	if err != nil {
-		txn.Rollback()
 		return err
 	}
 
-	return txn.Commit()
+	// subscribe before catching up, so that no entry committed in between goes untold
+	var notifications <-chan struct{}
+	if s.activity != nil {
+		var unsubscribe func()
+		notifications, unsubscribe = s.activity.Subscribe(account.Id)
+		defer unsubscribe()
+	}
+	poll := time.NewTicker(accountEventsPollInterval)
+	defer poll.Stop()
+
+	for {
+		for caughtUp := false; !caughtUp; {
+			var events []AccountEvent
+			err := s.runReadOnly(ctx, func(txn dbutil.Transaction) error {
+				var err error
+				events, err = s.db.GetAccountEvents(txn, account.Id, afterSeq, accountEventsBatchSize)
+				return err
+			})
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			for _, event := range events {
+				if err := fn(event); err != nil {
+					return err
+				}
+				afterSeq = event.Seq
+			}
+			caughtUp = len(events) < accountEventsBatchSize
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case _, ok := <-notifications:
+			if !ok {
+				return nil
+			}
+		case <-poll.C:
+		}
+	}
 }
 
-func (s *service) SendPayment(fromUsername string, toUsername string, amount decimal.Decimal) error {
-	if amount.IsNegative() || amount.IsZero() {
-		return ErrCreditAmountInvalid
+func (s *service) ImportAccounts(ctx context.Context, rows []ImportRow, dryRun bool) (*ImportReport, error) {
+	report := &ImportReport{DryRun: dryRun, Rows: len(rows), OpeningBalance: decimal.Zero}
+	for _, row := range rows {
+		report.OpeningBalance = report.OpeningBalance.Add(row.OpeningBalance)
 	}
 
-	sanitizedFromUsername := strings.TrimSpace(fromUsername)
-	sanitizedToUsername := strings.TrimSpace(toUsername)
-	if sanitizedFromUsername == sanitizedToUsername {
-		return ErrPaymentSenderReceiverIdentical
+	importRows := func(txn dbutil.Transaction) error {
+		rowErrors, err := s.validateImportRows(txn, rows)
+		if err != nil {
+			return err
+		}
+		report.Errors = rowErrors
+		if len(rowErrors) > 0 {
+			return ErrImportRowsInvalid
+		}
+
+		report.Accounts = len(rows)
+		if dryRun {
+			return nil
+		}
+		return s.createImportedAccounts(txn, rows)
+	}
+
+	var err error
+	if dryRun {
+		err = s.runReadOnly(ctx, importRows)
+	} else {
+		call := auditedCall{
+			action:  ImportAccountsAuditAction,
+			payload: map[string]interface{}{"rows": rows},
+		}
+		err = s.runAudited(ctx, call, sql.LevelReadCommitted, importRows)
 	}
 
-	txn, err := s.db.BeginTxn()
+	if errors.Is(err, ErrImportRowsInvalid) {
+		report.Accounts = 0
+		return report, err
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return report, nil
+}
 
-	sender, err := s.db.GetAccountByUsername(txn, sanitizedFromUsername)
+func (s *service) Reconcile(ctx context.Context) ([]Discrepancy, error) {
+	txn, err := s.db.BeginTxn(ctx, snapshotTxnOptions)
 	if err != nil {
-		txn.Rollback()
-		return err
+		return nil, err
 	}
+	defer txn.Rollback()
 
-	receiver, err := s.db.GetAccountByUsername(txn, sanitizedToUsername)
+	discrepancies, err := s.db.GetLedgerDiscrepancies(txn)
 	if err != nil {
-		txn.Rollback()
-		return err
+		return nil, err
 	}
 
-	if sender.Balance.LessThan(amount) {
-		txn.Rollback()
-		return ErrBalanceInsufficient
+	broken, err := s.verifyEntryChains(txn)
+	if err != nil {
+		return nil, err
+	}
+	if len(broken) == 0 {
+		return discrepancies, nil
+	}
+
+	discrepancies = append(discrepancies, broken...)
+	sort.SliceStable(discrepancies, func(i, j int) bool {
+		if discrepancies[i].Check != discrepancies[j].Check {
+			return discrepancies[i].Check < discrepancies[j].Check
+		}
+		return discrepancies[i].Reference < discrepancies[j].Reference
+	})
+	return discrepancies, nil
+}
+
+func (s *service) GetAuditLog(ctx context.Context, filter AuditFilter) ([]AuditRecord, error) {
+	txn, err := s.db.BeginTxn(ctx, snapshotTxnOptions)
+	if err != nil {
+		return nil, err
 	}
+	defer txn.Rollback()
 
-	err = s.db.LockTransactions(txn)
+	return s.db.GetAuditRecords(txn, filter)
+}
+
+func (s *service) VerifyAuditLog(ctx context.Context) (*AuditChainBreak, error) {
+	txn, err := s.db.BeginTxn(ctx, snapshotTxnOptions)
 	if err != nil {
-		txn.Rollback()
+		return nil, err
+	}
+	defer txn.Rollback()
+
+	var prev *AuditRecord
+	for {
+		filter := AuditFilter{Limit: auditVerifyBatchSize}
+		if prev != nil {
+			filter.AfterSeq = prev.Seq
+		}
+
+		records, err := s.db.GetAuditRecords(txn, filter)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range records {
+			if chainBreak := verifyAuditRecord(prev, records[i]); chainBreak != nil {
+				return chainBreak, nil
+			}
+			prev = &records[i]
+		}
+
+		if len(records) < auditVerifyBatchSize {
+			return nil, nil
+		}
+	}
+}
+
+// --- helpers
+
+// changeAccountStatus moves an account to status `to` while holding the same locks as ledger writes,
+// so that no deposit or payment can slip in between the checks and the update.
+func (s *service) changeAccountStatus(ctx context.Context, action string, username string, to string, reason string) error {
+	call := auditedCall{
+		action:  action,
+		account: username,
+		payload: map[string]interface{}{"username": username, "reason": reason},
+	}
+
+	sanitizedReason := strings.TrimSpace(reason)
+	if sanitizedReason == "" {
+		return s.rejectAudited(ctx, call, ErrAccountStatusReasonMissing)
+	}
+
+	return s.runAudited(ctx, call, sql.LevelReadCommitted, func(txn dbutil.Transaction) error {
+		if err := s.db.LockTransactions(txn); err != nil {
+			return err
+		}
+
+		// balance is read after acquiring the lock so it cannot change until commit
+		account, err := s.db.GetAccountByUsername(txn, strings.TrimSpace(username))
+		if err != nil {
+			return err
+		}
+
+		from, err := s.db.GetAccountStatusForUpdate(txn, account.Id)
+		if err != nil {
+			return err
+		}
+
+		if !canTransitionAccountStatus(from, to) {
+			return ErrAccountStatusTransitionInvalid
+		}
+
+		if to == ClosedAccountStatus && !account.Balance.IsZero() {
+			return ErrAccountBalanceNotZero
+		}
+
+		if err := s.db.UpdateAccountStatus(txn, account.Id, to); err != nil {
+			return err
+		}
+
+		return s.db.CreateAccountStatusChange(txn, AccountStatusChange{
+			Id:         uuid.New(),
+			AccountId:  account.Id,
+			FromStatus: from,
+			ToStatus:   to,
+			Reason:     sanitizedReason,
+		})
+	})
+}
+
+// respondToPaymentRequest moves a pending payment request to status `to` on behalf of username, who must be its
+// requester to cancel it and its payer otherwise. Requests that are not the party's own are reported as not found,
+// so that their existence is not told to others. Paying a request records the payment in the same db transaction.
+func (s *service) respondToPaymentRequest(ctx context.Context, action string, id uuid.UUID, username string, to string) (*PaymentRequest, error) {
+	call := auditedCall{
+		action:  action,
+		account: username,
+		payload: map[string]interface{}{"id": id, "username": username},
+	}
+
+	var request *PaymentRequest
+	err := s.runAudited(ctx, call, sql.LevelReadCommitted, func(txn dbutil.Transaction) error {
+		var err error
+		request, err = s.db.GetPaymentRequestForUpdate(txn, id)
+		if err != nil {
+			return err
+		}
+
+		party := request.Payer
+		if to == CancelledPaymentRequestStatus {
+			party = request.Requester
+		}
+		if party != strings.TrimSpace(username) {
+			return ErrPaymentRequestNotFound
+		}
+
+		if request.Status != PendingPaymentRequestStatus {
+			return ErrPaymentRequestNotPending
+		}
+		if !request.ExpiresAt.After(time.Now()) {
+			return ErrPaymentRequestExpired
+		}
+
+		var transactionId uuid.NullUUID
+		if to == PaidPaymentRequestStatus {
+			paymentId, err := s.recordPayment(txn, request.Payer, request.Requester, request.Amount, PaymentDetails{Description: request.Memo}, null.String{})
+			if err != nil {
+				return err
+			}
+			transactionId = util.NewNullUUID(paymentId)
+		}
+
+		if err := s.db.UpdatePaymentRequestStatus(txn, request.Id, to, transactionId); err != nil {
+			return err
+		}
+
+		request, err = s.db.GetPaymentRequestForUpdate(txn, request.Id)
 		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if to == PaidPaymentRequestStatus {
+		observeRecordedPayment(ctx, request.Amount)
+	}
+	return request, nil
+}
+
+// recordPayment records a payment of amount from one account to another, and returns its id. Usernames should be
+// sanitized already, and details as well. A payment already recorded under idempotencyKey is not recorded again,
+// and uuid.Nil is returned for it.
+func (s *service) recordPayment(txn dbutil.Transaction, fromUsername string, toUsername string, amount decimal.Decimal, details PaymentDetails, idempotencyKey null.String) (uuid.UUID, error) {
+	if err := s.db.LockTransactions(txn); err != nil {
+		return uuid.Nil, err
+	}
+
+	// balance is read after acquiring the lock so it cannot change until commit
+	sender, err := s.db.GetAccountByUsername(txn, fromUsername)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	receiver, err := s.db.GetAccountByUsername(txn, toUsername)
+	if err != nil {
+		return uuid.Nil, err
 	}
 
 	paymentId := uuid.New()
+	entries := []Entry{
+		newDebitEntry(paymentId, sender.Id, util.NewNullUUID(receiver.Id), amount),
+		newCreditEntry(paymentId, receiver.Id, util.NewNullUUID(sender.Id), amount),
+	}
+
+	// a replayed payment may have spent the balance it needed, so it is looked for first
+	replayed, err := s.isIdempotentReplay(txn, idempotencyKey, PaymentTransaction, entries)
+	if err != nil || replayed {
+		return uuid.Nil, err
+	}
+
+	if sender.Balance.LessThan(amount) {
+		return uuid.Nil, ErrBalanceInsufficient
+	}
+
+	if err := s.ensureAccountActive(txn, sender.Id); err != nil {
+		return uuid.Nil, err
+	}
+
+	if err := s.ensureAccountActive(txn, receiver.Id); err != nil {
+		return uuid.Nil, err
+	}
+
 	err = s.db.CreateTransaction(txn, Transaction{
-		Id:   paymentId,
-		Name: PaymentTransaction,
+		Id:             paymentId,
+		Name:           PaymentTransaction,
+		IdempotencyKey: idempotencyKey,
+		SenderId:       util.NewNullUUID(sender.Id),
+		PaymentDetails: details,
 	})
 	if err != nil {
-		txn.Rollback()
+		return uuid.Nil, err
+	}
+
+	if err := s.db.CreateEntriesForTransactionId(txn, paymentId, entries); err != nil {
+		return uuid.Nil, err
+	}
+	return paymentId, nil
+}
+
+// runReadOnly runs fn in a read-only snapshot of the primary.
+func (s *service) runReadOnly(ctx context.Context, fn func(txn dbutil.Transaction) error) error {
+	txn, err := s.db.BeginTxn(ctx, snapshotTxnOptions)
+	if err != nil {
 		return err
 	}
+	defer txn.Rollback()
 
-	err = s.db.CreateEntriesForTransactionId(txn, paymentId, []Entry{
-		newDebitEntry(paymentId, sender.Id, util.NewNullUUID(receiver.Id), amount),
-		newCreditEntry(paymentId, receiver.Id, util.NewNullUUID(sender.Id), amount),
-	})
+	return fn(txn)
+}
+
+// ensureAccountActive locks the account row and rejects frozen or closed accounts.
+// It should be called only after LockTransactions.
+func (s *service) ensureAccountActive(txn dbutil.Transaction, accountId uuid.UUID) error {
+	status, err := s.db.GetAccountStatusForUpdate(txn, accountId)
 	if err != nil {
-		txn.Rollback()
 		return err
 	}
 
-	return txn.Commit()
+	switch status {
+	case FrozenAccountStatus:
+		return ErrAccountFrozen
+	case ClosedAccountStatus:
+		return ErrAccountClosed
+	}
+
+	return nil
 }
 
-// --- helpers
+// isIdempotentReplay reports whether a transaction named `name` with entries was already recorded under
+// idempotencyKey. It should be called only after LockTransactions, so that concurrent calls with the same key see
+// each other. Reusing a key for a different kind of transaction, or for other accounts or amounts, is rejected with
+// ErrIdempotencyKeyReused.
+func (s *service) isIdempotentReplay(txn dbutil.Transaction, idempotencyKey null.String, name string, entries []Entry) (bool, error) {
+	if !idempotencyKey.Valid {
+		return false, nil
+	}
+
+	existing, err := s.db.GetTransactionByIdempotencyKey(txn, idempotencyKey.String)
+	if errors.Is(err, ErrTransactionNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if existing.Name != name || !haveSameMovements(existing.Entries, entries) {
+		return false, ErrIdempotencyKeyReused
+	}
+	return true, nil
+}
+
+// haveSameMovements reports whether the entries of two transactions move the same amounts in and out of the same
+// accounts, regardless of their ids and order.
+func haveSameMovements(a []Entry, b []Entry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	matched := make([]bool, len(b))
+	for _, x := range a {
+		found := false
+		for i, y := range b {
+			if !matched[i] && x.AccountId == y.AccountId && x.Name == y.Name && x.Credit.Equal(y.Credit) && x.Debit.Equal(y.Debit) {
+				matched[i] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func newNullIdempotencyKey(ctx context.Context) null.String {
+	key := IdempotencyKeyFrom(ctx)
+	return null.NewString(key, key != "")
+}
+
+// sanitizePaymentDetails trims the description and external reference of a payment, leaving out blank ones, and
+// rejects details that exceed their size limits with ErrPaymentDetailsTooLarge.
+func sanitizePaymentDetails(details PaymentDetails) (PaymentDetails, error) {
+	details.Description = trimNullString(details.Description)
+	details.ExternalReference = trimNullString(details.ExternalReference)
+	if len(details.Metadata) == 0 {
+		details.Metadata = nil
+	}
+
+	if utf8.RuneCountInString(details.Description.String) > maxPaymentDescriptionLength ||
+		utf8.RuneCountInString(details.ExternalReference.String) > maxExternalReferenceLength {
+		return PaymentDetails{}, ErrPaymentDetailsTooLarge
+	}
+
+	if details.Metadata != nil {
+		b, err := json.Marshal(details.Metadata)
+		if err != nil {
+			return PaymentDetails{}, err
+		}
+		if len(b) > maxPaymentMetadataSize {
+			return PaymentDetails{}, ErrPaymentDetailsTooLarge
+		}
+	}
+	return details, nil
+}
+
+func trimNullString(s null.String) null.String {
+	trimmed := strings.TrimSpace(s.String)
+	return null.NewString(trimmed, s.Valid && trimmed != "")
+}
+
+func isValidExportFilter(filter ExportFilter) bool {
+	switch filter.Kind {
+	case PaymentTransaction, DepositTransaction:
+	case StatementExport:
+		if filter.Account == "" {
+			return false
+		}
+	default:
+		return false
+	}
+	return filter.Since.IsZero() || filter.Until.IsZero() || filter.Since.Before(filter.Until)
+}
+
+func isValidPaymentRequestFilter(filter PaymentRequestFilter) bool {
+	if filter.Account == "" {
+		return false
+	}
+
+	switch filter.Direction {
+	case "", IncomingEntry, OutgoingEntry:
+	default:
+		return false
+	}
+
+	switch filter.Status {
+	case "", PendingPaymentRequestStatus, PaidPaymentRequestStatus, DeclinedPaymentRequestStatus,
+		ExpiredPaymentRequestStatus, CancelledPaymentRequestStatus:
+		return true
+	default:
+		return false
+	}
+}
+
+func canTransitionAccountStatus(from string, to string) bool {
+	for _, status := range accountStatusTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
 
 func newCreditEntry(transactionId uuid.UUID, accountId uuid.UUID, targetAccountId uuid.NullUUID, amount decimal.Decimal) Entry {
 	return Entry{
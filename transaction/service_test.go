@@ -1,9 +1,15 @@
 package transaction_test
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
 	mockdbutil "github.com/nogurenn/cph-wallet/mocks/autogen/dbutil"
 	mocktransaction "github.com/nogurenn/cph-wallet/mocks/autogen/transaction"
 	"github.com/nogurenn/cph-wallet/transaction"
@@ -11,6 +17,7 @@ import (
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"gopkg.in/guregu/null.v4"
 )
 
@@ -22,20 +29,22 @@ func Test_Service_CreateAccount_Success(t *testing.T) {
 	txn.On("Commit").Return(nil)
 
 	db := new(mocktransaction.Repository)
-	db.On("BeginTxn").Return(txn, nil)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
 	db.On("CreateAccount",
 		txn,
 		mock.MatchedBy(func(account transaction.Account) bool {
 			return assert.Equal(t, username, account.Username) &&
 				assert.NotNil(t, account.Id) &&
-				assert.Equal(t, "USD", account.Currency)
+				assert.Equal(t, "USD", account.Currency) &&
+				assert.Equal(t, transaction.ActiveAccountStatus, account.Status)
 		}),
 	).Return(nil)
+	expectAuditRecord(t, db, txn, transaction.CreateAccountAuditAction, transaction.OkAuditOutcome)
 
 	service := transaction.NewService(db)
 
 	// when
-	err := service.CreateAccount(username)
+	err := service.CreateAccount(context.Background(), username)
 
 	// then
 	assert.NoError(t, err)
@@ -54,13 +63,13 @@ func Test_Service_GetAccounts_Success(t *testing.T) {
 	txn.On("Rollback").Return(nil)
 
 	db := new(mocktransaction.Repository)
-	db.On("BeginTxn").Return(txn, nil)
+	db.On("BeginReplicaTxn", mock.Anything).Return(txn, nil)
 	db.On("GetAccounts", txn).Return(accounts, nil)
 
 	service := transaction.NewService(db)
 
 	// when
-	fetched, err := service.GetAccounts()
+	fetched, err := service.GetAccounts(context.Background())
 	assert.NoError(t, err)
 
 	// then
@@ -111,13 +120,13 @@ func Test_Service_GetPaymentTransactions_Success(t *testing.T) {
 	txn.On("Rollback").Return(nil)
 
 	db := new(mocktransaction.Repository)
-	db.On("BeginTxn").Return(txn, nil)
-	db.On("GetTransactionsByName", txn, transaction.PaymentTransaction).Return([]transaction.Transaction{payment}, nil)
+	db.On("BeginReplicaTxn", mock.Anything).Return(txn, nil)
+	db.On("GetTransactionsByName", txn, transaction.PaymentTransaction, transaction.TransactionFilter{}).Return([]transaction.Transaction{payment}, nil)
 
 	service := transaction.NewService(db)
 
 	// when
-	fetched, err := service.GetPaymentTransactions()
+	fetched, err := service.GetPaymentTransactions(context.Background(), transaction.TransactionFilter{})
 	assert.NoError(t, err)
 
 	// then
@@ -152,9 +161,10 @@ func Test_Service_Deposit_Success(t *testing.T) {
 	txn.On("Commit").Return(nil)
 
 	db := new(mocktransaction.Repository)
-	db.On("BeginTxn").Return(txn, nil)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
 	db.On("GetAccountByUsername", txn, alice.Username).Return(alice, nil)
 	db.On("LockTransactions", txn).Return(nil)
+	db.On("GetAccountStatusForUpdate", txn, alice.Id).Return(transaction.ActiveAccountStatus, nil)
 	db.On("CreateTransaction", txn, mock.MatchedBy(func(tr transaction.Transaction) bool {
 		return assert.NotEqual(t, uuid.Nil, tr.Id) &&
 			assert.Equal(t, transaction.DepositTransaction, tr.Name)
@@ -174,11 +184,12 @@ func Test_Service_Deposit_Success(t *testing.T) {
 				assert.True(t, entries[0].Credit.Equal(amount))
 		}),
 	).Return(nil)
+	expectAuditRecord(t, db, txn, transaction.DepositAuditAction, transaction.OkAuditOutcome)
 
 	service := transaction.NewService(db)
 
 	// when
-	err := service.Deposit(alice.Username, amount)
+	err := service.Deposit(context.Background(), alice.Username, amount)
 
 	// then
 	assert.NoError(t, err)
@@ -187,6 +198,35 @@ func Test_Service_Deposit_Success(t *testing.T) {
 	db.AssertExpectations(t)
 }
 
+func Test_Service_GetPaymentTransactions_ByExternalReference(t *testing.T) {
+	// given
+	payment := transaction.Transaction{
+		Id:             uuid.New(),
+		Name:           transaction.PaymentTransaction,
+		PaymentDetails: transaction.PaymentDetails{ExternalReference: null.StringFrom("INV-0001")},
+	}
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginReplicaTxn", mock.Anything).Return(txn, nil)
+	db.On("GetTransactionsByName", txn, transaction.PaymentTransaction, transaction.TransactionFilter{ExternalReference: "INV-0001"}).
+		Return([]transaction.Transaction{payment}, nil)
+
+	service := transaction.NewService(db)
+
+	// when
+	fetched, err := service.GetPaymentTransactions(context.Background(), transaction.TransactionFilter{ExternalReference: " INV-0001 "})
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, []transaction.Transaction{payment}, fetched)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
 func Test_Service_SendPayment_Success(t *testing.T) {
 	// given
 	aliceUsername := "alice456"
@@ -199,10 +239,12 @@ func Test_Service_SendPayment_Success(t *testing.T) {
 	txn.On("Commit").Return(nil)
 
 	db := new(mocktransaction.Repository)
-	db.On("BeginTxn").Return(txn, nil)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
 	db.On("GetAccountByUsername", txn, aliceUsername).Return(alice, nil).Once()
 	db.On("GetAccountByUsername", txn, bobUsername).Return(bob, nil).Once()
 	db.On("LockTransactions", txn).Return(nil)
+	db.On("GetAccountStatusForUpdate", txn, alice.Id).Return(transaction.ActiveAccountStatus, nil)
+	db.On("GetAccountStatusForUpdate", txn, bob.Id).Return(transaction.ActiveAccountStatus, nil)
 	db.On("CreateTransaction", txn, mock.MatchedBy(func(tr transaction.Transaction) bool {
 		return assert.NotEqual(t, uuid.Nil, tr.Id) &&
 			assert.Equal(t, transaction.PaymentTransaction, tr.Name)
@@ -248,15 +290,53 @@ func Test_Service_SendPayment_Success(t *testing.T) {
 			return true
 		}),
 	).Return(nil)
+	expectAuditRecord(t, db, txn, transaction.SendPaymentAuditAction, transaction.OkAuditOutcome)
+
+	service := transaction.NewService(db)
+
+	// when
+	err := service.SendPayment(context.Background(), bobUsername, aliceUsername, amount, transaction.PaymentDetails{})
+
+	// then
+	assert.NoError(t, err)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_SendPayment_RetriedOnSerializationFailure(t *testing.T) {
+	// given
+	alice := &transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Balance: decimal.NewFromFloat(200.0)}
+	bob := &transaction.Account{Id: uuid.New(), Username: "bob123", Currency: "USD", Balance: decimal.NewFromFloat(200.0)}
+	amount := decimal.NewFromFloat(100.0)
+
+	conflicted := new(mockdbutil.Transaction)
+	conflicted.On("Commit").Return(&pgconn.PgError{Code: "40001"})
+	txn := new(mockdbutil.Transaction)
+	txn.On("Commit").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, &sql.TxOptions{Isolation: sql.LevelReadCommitted}).Return(conflicted, nil).Once()
+	db.On("BeginTxn", mock.Anything, &sql.TxOptions{Isolation: sql.LevelReadCommitted}).Return(txn, nil).Once()
+	for _, tx := range []*mockdbutil.Transaction{conflicted, txn} {
+		db.On("GetAccountByUsername", tx, alice.Username).Return(alice, nil).Once()
+		db.On("GetAccountByUsername", tx, bob.Username).Return(bob, nil).Once()
+		db.On("LockTransactions", tx).Return(nil).Once()
+		db.On("GetAccountStatusForUpdate", tx, mock.Anything).Return(transaction.ActiveAccountStatus, nil).Twice()
+		db.On("CreateTransaction", tx, mock.Anything).Return(nil).Once()
+		db.On("CreateEntriesForTransactionId", tx, mock.Anything, mock.Anything).Return(nil).Once()
+		expectAuditRecord(t, db, tx, transaction.SendPaymentAuditAction, transaction.OkAuditOutcome)
+	}
 
 	service := transaction.NewService(db)
 
 	// when
-	err := service.SendPayment(bobUsername, aliceUsername, amount)
+	err := service.SendPayment(context.Background(), bob.Username, alice.Username, amount, transaction.PaymentDetails{})
 
 	// then
 	assert.NoError(t, err)
 
+	conflicted.AssertExpectations(t)
 	txn.AssertExpectations(t)
 	db.AssertExpectations(t)
 }
@@ -265,16 +345,22 @@ func Test_Service_SendPayment_PaymentSenderReceiverIdentical(t *testing.T) {
 	// given
 	amount := decimal.NewFromFloat(201.0)
 
+	txn := new(mockdbutil.Transaction)
+	txn.On("Commit").Return(nil)
+
 	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
+	expectAuditRecord(t, db, txn, transaction.SendPaymentAuditAction, transaction.ErrPaymentSenderReceiverIdentical.Error())
 
 	service := transaction.NewService(db)
 
 	// when
-	err := service.SendPayment("alice456 ", " alice456  ", amount)
+	err := service.SendPayment(context.Background(), "alice456 ", " alice456  ", amount, transaction.PaymentDetails{})
 
 	// then
 	assert.Equal(t, transaction.ErrPaymentSenderReceiverIdentical, err)
 
+	txn.AssertExpectations(t)
 	db.AssertExpectations(t)
 }
 
@@ -282,16 +368,22 @@ func Test_Service_SendPayment_CreditAmountInvalid(t *testing.T) {
 	// given
 	amount := decimal.NewFromFloat(100.0)
 
+	txn := new(mockdbutil.Transaction)
+	txn.On("Commit").Return(nil)
+
 	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
+	expectAuditRecord(t, db, txn, transaction.SendPaymentAuditAction, transaction.ErrCreditAmountInvalid.Error())
 
 	service := transaction.NewService(db)
 
 	// when
-	err := service.SendPayment("bob123", "alice456", amount.Neg())
+	err := service.SendPayment(context.Background(), "bob123", "alice456", amount.Neg(), transaction.PaymentDetails{})
 
 	// then
 	assert.Equal(t, transaction.ErrCreditAmountInvalid, err)
 
+	txn.AssertExpectations(t)
 	db.AssertExpectations(t)
 }
 
@@ -305,20 +397,1647 @@ func Test_Service_SendPayment_InsufficientBalance(t *testing.T) {
 
 	txn := new(mockdbutil.Transaction)
 	txn.On("Rollback").Return(nil)
+	auditTxn := new(mockdbutil.Transaction)
+	auditTxn.On("Commit").Return(nil)
 
 	db := new(mocktransaction.Repository)
-	db.On("BeginTxn").Return(txn, nil)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil).Once()
+	db.On("LockTransactions", txn).Return(nil)
 	db.On("GetAccountByUsername", txn, aliceUsername).Return(alice, nil).Once()
 	db.On("GetAccountByUsername", txn, bobUsername).Return(bob, nil).Once()
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(auditTxn, nil).Once()
+	expectAuditRecord(t, db, auditTxn, transaction.SendPaymentAuditAction, transaction.ErrBalanceInsufficient.Error())
 
 	service := transaction.NewService(db)
 
 	// when
-	err := service.SendPayment(bobUsername, aliceUsername, amount)
+	err := service.SendPayment(context.Background(), bobUsername, aliceUsername, amount, transaction.PaymentDetails{})
 
 	// then
 	assert.Equal(t, transaction.ErrBalanceInsufficient, err)
 
 	txn.AssertExpectations(t)
+	auditTxn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_SendPayment_BalanceReadAfterLock(t *testing.T) {
+	// given
+	alice := &transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD"}
+	bob := &transaction.Account{Id: uuid.New(), Username: "bob123", Currency: "USD", Balance: decimal.NewFromFloat(200.0)}
+	locked := false
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Commit").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
+	db.On("LockTransactions", txn).Return(nil).Run(func(mock.Arguments) {
+		locked = true
+	})
+	db.On("GetAccountByUsername", txn, bob.Username).Return(bob, nil).Run(func(mock.Arguments) {
+		// a balance read before the lock could be spent by a concurrent payment
+		assert.True(t, locked)
+	}).Once()
+	db.On("GetAccountByUsername", txn, alice.Username).Return(alice, nil).Once()
+	db.On("GetAccountStatusForUpdate", txn, mock.Anything).Return(transaction.ActiveAccountStatus, nil)
+	db.On("CreateTransaction", txn, mock.Anything).Return(nil)
+	db.On("CreateEntriesForTransactionId", txn, mock.Anything, mock.Anything).Return(nil)
+	expectAuditRecord(t, db, txn, transaction.SendPaymentAuditAction, transaction.OkAuditOutcome)
+
+	service := transaction.NewService(db)
+
+	// when
+	err := service.SendPayment(context.Background(), bob.Username, alice.Username, decimal.NewFromFloat(100.0), transaction.PaymentDetails{})
+
+	// then
+	assert.NoError(t, err)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_SendPayment_WithPaymentDetails(t *testing.T) {
+	// given
+	alice := &transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Balance: decimal.NewFromFloat(200.0)}
+	bob := &transaction.Account{Id: uuid.New(), Username: "bob123", Currency: "USD", Balance: decimal.NewFromFloat(200.0)}
+	details := transaction.PaymentDetails{
+		Description:       null.StringFrom("  March rent "),
+		ExternalReference: null.StringFrom(" INV-0001"),
+		Metadata:          transaction.Metadata{"unit": "4B"},
+	}
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Commit").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
+	db.On("GetAccountByUsername", txn, alice.Username).Return(alice, nil).Once()
+	db.On("GetAccountByUsername", txn, bob.Username).Return(bob, nil).Once()
+	db.On("LockTransactions", txn).Return(nil)
+	db.On("GetAccountStatusForUpdate", txn, mock.Anything).Return(transaction.ActiveAccountStatus, nil).Twice()
+	db.On("CreateTransaction", txn, mock.MatchedBy(func(tr transaction.Transaction) bool {
+		return assert.Equal(t, util.NewNullUUID(bob.Id), tr.SenderId) &&
+			assert.Equal(t, null.StringFrom("March rent"), tr.Description) &&
+			assert.Equal(t, null.StringFrom("INV-0001"), tr.ExternalReference) &&
+			assert.Equal(t, details.Metadata, tr.Metadata)
+	})).Return(nil)
+	db.On("CreateEntriesForTransactionId", txn, mock.Anything, mock.Anything).Return(nil)
+	expectAuditRecord(t, db, txn, transaction.SendPaymentAuditAction, transaction.OkAuditOutcome)
+
+	service := transaction.NewService(db)
+
+	// when
+	err := service.SendPayment(context.Background(), bob.Username, alice.Username, decimal.NewFromFloat(100.0), details)
+
+	// then
+	assert.NoError(t, err)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_SendPayment_PaymentDetailsTooLarge(t *testing.T) {
+	tests := map[string]transaction.PaymentDetails{
+		"description":        {Description: null.StringFrom(strings.Repeat("é", 501))},
+		"external reference": {ExternalReference: null.StringFrom(strings.Repeat("x", 129))},
+		"metadata":           {Metadata: transaction.Metadata{"notes": strings.Repeat("x", 4096)}},
+	}
+	for name, details := range tests {
+		t.Run(name, func(t *testing.T) {
+			// given
+			txn := new(mockdbutil.Transaction)
+			txn.On("Commit").Return(nil)
+
+			db := new(mocktransaction.Repository)
+			db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
+			expectAuditRecord(t, db, txn, transaction.SendPaymentAuditAction, transaction.ErrPaymentDetailsTooLarge.Error())
+
+			service := transaction.NewService(db)
+
+			// when
+			err := service.SendPayment(context.Background(), "bob123", "alice456", decimal.NewFromFloat(100.0), details)
+
+			// then
+			assert.Equal(t, transaction.ErrPaymentDetailsTooLarge, err)
+
+			txn.AssertExpectations(t)
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_Service_SendPayment_ExternalReferenceReused(t *testing.T) {
+	// given
+	alice := &transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Balance: decimal.NewFromFloat(200.0)}
+	bob := &transaction.Account{Id: uuid.New(), Username: "bob123", Currency: "USD", Balance: decimal.NewFromFloat(200.0)}
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+	auditTxn := new(mockdbutil.Transaction)
+	auditTxn.On("Commit").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil).Once()
+	db.On("GetAccountByUsername", txn, alice.Username).Return(alice, nil).Once()
+	db.On("GetAccountByUsername", txn, bob.Username).Return(bob, nil).Once()
+	db.On("LockTransactions", txn).Return(nil)
+	db.On("GetAccountStatusForUpdate", txn, mock.Anything).Return(transaction.ActiveAccountStatus, nil).Twice()
+	db.On("CreateTransaction", txn, mock.Anything).Return(transaction.ErrExternalReferenceReused)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(auditTxn, nil).Once()
+	expectAuditRecord(t, db, auditTxn, transaction.SendPaymentAuditAction, transaction.ErrExternalReferenceReused.Error())
+
+	service := transaction.NewService(db)
+
+	// when
+	err := service.SendPayment(context.Background(), bob.Username, alice.Username, decimal.NewFromFloat(100.0), transaction.PaymentDetails{
+		ExternalReference: null.StringFrom("INV-0001"),
+	})
+
+	// then
+	assert.Equal(t, transaction.ErrExternalReferenceReused, err)
+
+	txn.AssertExpectations(t)
+	auditTxn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_Deposit_AccountFrozen(t *testing.T) {
+	// given
+	alice := &transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD"}
+	amount := decimal.NewFromFloat(50.0)
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+	auditTxn := new(mockdbutil.Transaction)
+	auditTxn.On("Commit").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil).Once()
+	db.On("GetAccountByUsername", txn, alice.Username).Return(alice, nil)
+	db.On("LockTransactions", txn).Return(nil)
+	db.On("GetAccountStatusForUpdate", txn, alice.Id).Return(transaction.FrozenAccountStatus, nil)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(auditTxn, nil).Once()
+	expectAuditRecord(t, db, auditTxn, transaction.DepositAuditAction, transaction.ErrAccountFrozen.Error())
+
+	service := transaction.NewService(db)
+
+	// when
+	err := service.Deposit(context.Background(), alice.Username, amount)
+
+	// then
+	assert.Equal(t, transaction.ErrAccountFrozen, err)
+
+	txn.AssertExpectations(t)
+	auditTxn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_SendPayment_ReceiverClosed(t *testing.T) {
+	// given
+	aliceUsername := "alice456"
+	bobUsername := "bob123"
+	alice := &transaction.Account{Id: uuid.New(), Username: aliceUsername, Currency: "USD"}
+	bob := &transaction.Account{Id: uuid.New(), Username: bobUsername, Currency: "USD", Balance: decimal.NewFromFloat(200.0)}
+	amount := decimal.NewFromFloat(100.0)
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+	auditTxn := new(mockdbutil.Transaction)
+	auditTxn.On("Commit").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil).Once()
+	db.On("GetAccountByUsername", txn, aliceUsername).Return(alice, nil).Once()
+	db.On("GetAccountByUsername", txn, bobUsername).Return(bob, nil).Once()
+	db.On("LockTransactions", txn).Return(nil)
+	db.On("GetAccountStatusForUpdate", txn, bob.Id).Return(transaction.ActiveAccountStatus, nil)
+	db.On("GetAccountStatusForUpdate", txn, alice.Id).Return(transaction.ClosedAccountStatus, nil)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(auditTxn, nil).Once()
+	expectAuditRecord(t, db, auditTxn, transaction.SendPaymentAuditAction, transaction.ErrAccountClosed.Error())
+
+	service := transaction.NewService(db)
+
+	// when
+	err := service.SendPayment(context.Background(), bobUsername, aliceUsername, amount, transaction.PaymentDetails{})
+
+	// then
+	assert.Equal(t, transaction.ErrAccountClosed, err)
+
+	txn.AssertExpectations(t)
+	auditTxn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_FreezeAccount_Success(t *testing.T) {
+	// given
+	alice := &transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Balance: decimal.NewFromFloat(200.0)}
+	reason := "suspected fraud"
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Commit").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
+	db.On("LockTransactions", txn).Return(nil)
+	db.On("GetAccountByUsername", txn, alice.Username).Return(alice, nil)
+	db.On("GetAccountStatusForUpdate", txn, alice.Id).Return(transaction.ActiveAccountStatus, nil)
+	db.On("UpdateAccountStatus", txn, alice.Id, transaction.FrozenAccountStatus).Return(nil)
+	db.On("CreateAccountStatusChange", txn, mock.MatchedBy(func(change transaction.AccountStatusChange) bool {
+		return assert.NotEqual(t, uuid.Nil, change.Id) &&
+			assert.Equal(t, alice.Id, change.AccountId) &&
+			assert.Equal(t, transaction.ActiveAccountStatus, change.FromStatus) &&
+			assert.Equal(t, transaction.FrozenAccountStatus, change.ToStatus) &&
+			assert.Equal(t, reason, change.Reason)
+	})).Return(nil)
+	expectAuditRecord(t, db, txn, transaction.FreezeAccountAuditAction, transaction.OkAuditOutcome)
+
+	service := transaction.NewService(db)
+
+	// when
+	err := service.FreezeAccount(context.Background(), alice.Username, " "+reason+" ")
+
+	// then
+	assert.NoError(t, err)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_FreezeAccount_ReasonMissing(t *testing.T) {
+	// given
+	txn := new(mockdbutil.Transaction)
+	txn.On("Commit").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
+	expectAuditRecord(t, db, txn, transaction.FreezeAccountAuditAction, transaction.ErrAccountStatusReasonMissing.Error())
+
+	service := transaction.NewService(db)
+
+	// when
+	err := service.FreezeAccount(context.Background(), "alice456", "  ")
+
+	// then
+	assert.Equal(t, transaction.ErrAccountStatusReasonMissing, err)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_UnfreezeAccount_StatusTransitionInvalid(t *testing.T) {
+	// given
+	alice := &transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD"}
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+	auditTxn := new(mockdbutil.Transaction)
+	auditTxn.On("Commit").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil).Once()
+	db.On("LockTransactions", txn).Return(nil)
+	db.On("GetAccountByUsername", txn, alice.Username).Return(alice, nil)
+	db.On("GetAccountStatusForUpdate", txn, alice.Id).Return(transaction.ClosedAccountStatus, nil)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(auditTxn, nil).Once()
+	expectAuditRecord(t, db, auditTxn, transaction.UnfreezeAccountAuditAction, transaction.ErrAccountStatusTransitionInvalid.Error())
+
+	service := transaction.NewService(db)
+
+	// when
+	err := service.UnfreezeAccount(context.Background(), alice.Username, "closed by mistake")
+
+	// then
+	assert.Equal(t, transaction.ErrAccountStatusTransitionInvalid, err)
+
+	txn.AssertExpectations(t)
+	auditTxn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_CloseAccount_BalanceNotZero(t *testing.T) {
+	// given
+	alice := &transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Balance: decimal.NewFromFloat(0.01)}
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+	auditTxn := new(mockdbutil.Transaction)
+	auditTxn.On("Commit").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil).Once()
+	db.On("LockTransactions", txn).Return(nil)
+	db.On("GetAccountByUsername", txn, alice.Username).Return(alice, nil)
+	db.On("GetAccountStatusForUpdate", txn, alice.Id).Return(transaction.FrozenAccountStatus, nil)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(auditTxn, nil).Once()
+	expectAuditRecord(t, db, auditTxn, transaction.CloseAccountAuditAction, transaction.ErrAccountBalanceNotZero.Error())
+
+	service := transaction.NewService(db)
+
+	// when
+	err := service.CloseAccount(context.Background(), alice.Username, "customer request")
+
+	// then
+	assert.Equal(t, transaction.ErrAccountBalanceNotZero, err)
+
+	txn.AssertExpectations(t)
+	auditTxn.AssertExpectations(t)
 	db.AssertExpectations(t)
 }
+
+func Test_Service_GetAccount_Success(t *testing.T) {
+	// given
+	alice := &transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Status: transaction.ActiveAccountStatus}
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
+	db.On("GetAccountByUsername", txn, alice.Username).Return(alice, nil)
+
+	service := transaction.NewService(db)
+
+	// when
+	fetched, err := service.GetAccount(context.Background(), " alice456 ")
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, alice, fetched)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_GetAccount_AccountNotFound(t *testing.T) {
+	// given
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
+	db.On("GetAccountByUsername", txn, "nobody").Return(nil, transaction.ErrAccountNotFound)
+
+	service := transaction.NewService(db)
+
+	// when
+	fetched, err := service.GetAccount(context.Background(), "nobody")
+
+	// then
+	assert.Nil(t, fetched)
+	assert.Equal(t, transaction.ErrAccountNotFound, err)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_GetAccountsAsOf_Success(t *testing.T) {
+	// given
+	asOf := time.Date(2022, 4, 30, 23, 59, 59, 0, time.UTC)
+	alice := transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Balance: decimal.NewFromInt(10)}
+	accounts := []transaction.Account{alice}
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginReplicaTxn", mock.Anything).Return(txn, nil)
+	db.On("GetAccountsAsOf", txn, asOf).Return(accounts, nil)
+
+	service := transaction.NewService(db)
+
+	// when
+	fetched, err := service.GetAccountsAsOf(context.Background(), asOf)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, accounts, fetched)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_GetAccountAsOf_AccountNotFound(t *testing.T) {
+	// given
+	asOf := time.Date(2022, 4, 30, 23, 59, 59, 0, time.UTC)
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginReplicaTxn", mock.Anything).Return(txn, nil)
+	db.On("GetAccountByUsernameAsOf", txn, "alice456", asOf).Return(nil, transaction.ErrAccountNotFound)
+
+	service := transaction.NewService(db)
+
+	// when
+	fetched, err := service.GetAccountAsOf(context.Background(), " alice456 ", asOf)
+
+	// then
+	assert.Nil(t, fetched)
+	assert.Equal(t, transaction.ErrAccountNotFound, err)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_GetAccountStatement_Success(t *testing.T) {
+	// given
+	alice := &transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Status: transaction.ActiveAccountStatus}
+	lines := []transaction.StatementLine{
+		{
+			EntryId:         uuid.New(),
+			TransactionId:   uuid.New(),
+			TransactionName: transaction.DepositTransaction,
+			Amount:          decimal.NewFromFloat(200.00),
+			Balance:         decimal.NewFromFloat(200.00),
+		},
+	}
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginReplicaTxn", mock.Anything).Return(txn, nil)
+	db.On("GetAccountByUsername", txn, alice.Username).Return(alice, nil)
+	db.On("GetStatementLines", txn, alice.Id).Return(lines, nil)
+
+	service := transaction.NewService(db)
+
+	// when
+	fetched, err := service.GetAccountStatement(context.Background(), " alice456 ")
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, lines, fetched)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_GetAccountStatement_AccountNotFound(t *testing.T) {
+	// given
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginReplicaTxn", mock.Anything).Return(txn, nil)
+	db.On("GetAccountByUsername", txn, "nobody").Return(nil, transaction.ErrAccountNotFound)
+
+	service := transaction.NewService(db)
+
+	// when
+	fetched, err := service.GetAccountStatement(context.Background(), "nobody")
+
+	// then
+	assert.Nil(t, fetched)
+	assert.Equal(t, transaction.ErrAccountNotFound, err)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_ExportEntries_Success(t *testing.T) {
+	// given
+	alice := &transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Status: transaction.ActiveAccountStatus}
+	filter := transaction.ExportFilter{
+		Kind:    transaction.PaymentTransaction,
+		Account: alice.Username,
+		Since:   time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC),
+		Until:   time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC),
+	}
+	entries := []transaction.ExportedEntry{
+		{EntryId: uuid.New(), TransactionName: transaction.PaymentTransaction, Account: alice.Username, Amount: decimal.NewFromInt(-5)},
+		{EntryId: uuid.New(), TransactionName: transaction.PaymentTransaction, Account: alice.Username, Amount: decimal.NewFromInt(7)},
+	}
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginReplicaTxn", mock.Anything).Return(txn, nil)
+	db.On("GetAccountByUsername", txn, alice.Username).Return(alice, nil)
+	db.On("StreamExportedEntries", txn, filter, mock.Anything).
+		Run(func(args mock.Arguments) {
+			fn := args.Get(2).(func(transaction.ExportedEntry) error)
+			for _, entry := range entries {
+				assert.NoError(t, fn(entry))
+			}
+		}).
+		Return(nil)
+
+	service := transaction.NewService(db)
+
+	// when
+	var exported []transaction.ExportedEntry
+	exportFilter := filter
+	exportFilter.Account = " alice456 "
+	err := service.ExportEntries(context.Background(), exportFilter, func(entry transaction.ExportedEntry) error {
+		exported = append(exported, entry)
+		return nil
+	})
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, entries, exported)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_ExportEntries_FilterInvalid(t *testing.T) {
+	// given
+	since := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	filters := []transaction.ExportFilter{
+		{Kind: "refund"},
+		{Kind: transaction.StatementExport},
+		{Kind: transaction.DepositTransaction, Since: since, Until: since},
+	}
+
+	db := new(mocktransaction.Repository)
+
+	service := transaction.NewService(db)
+
+	for _, filter := range filters {
+		// when
+		err := service.ExportEntries(context.Background(), filter, func(transaction.ExportedEntry) error { return nil })
+
+		// then
+		assert.Equal(t, transaction.ErrExportFilterInvalid, err, "%+v", filter)
+	}
+
+	db.AssertExpectations(t)
+}
+
+func Test_Service_ExportEntries_AccountNotFound(t *testing.T) {
+	// given
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginReplicaTxn", mock.Anything).Return(txn, nil)
+	db.On("GetAccountByUsername", txn, "nobody").Return(nil, transaction.ErrAccountNotFound)
+
+	service := transaction.NewService(db)
+
+	// when
+	filter := transaction.ExportFilter{Kind: transaction.StatementExport, Account: "nobody"}
+	err := service.ExportEntries(context.Background(), filter, func(transaction.ExportedEntry) error { return nil })
+
+	// then
+	assert.Equal(t, transaction.ErrAccountNotFound, err)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_StreamAccountEvents_FromLatestEntry(t *testing.T) {
+	// given
+	alice := &transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Status: transaction.ActiveAccountStatus}
+	latest := transaction.AccountEvent{Seq: 7, TransactionName: transaction.DepositTransaction, Amount: decimal.NewFromInt(10), Balance: decimal.NewFromInt(30)}
+	next := transaction.AccountEvent{Seq: 8, TransactionName: transaction.PaymentTransaction, Amount: decimal.NewFromInt(-5), Balance: decimal.NewFromInt(25)}
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
+	db.On("GetAccountByUsername", txn, alice.Username).Return(alice, nil)
+	db.On("GetLastEntrySeq", txn, alice.Id).Return(int64(7), nil)
+	db.On("GetAccountEvents", txn, alice.Id, int64(6), mock.Anything).Return([]transaction.AccountEvent{latest}, nil)
+	db.On("GetAccountEvents", txn, alice.Id, int64(7), mock.Anything).Return([]transaction.AccountEvent{next}, nil)
+
+	notifications := make(chan struct{}, 1)
+	unsubscribed := false
+	activity := new(mocktransaction.AccountActivity)
+	activity.On("Subscribe", alice.Id).Return((<-chan struct{})(notifications), func() { unsubscribed = true })
+
+	service := transaction.NewServiceWithActivity(db, activity)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// when
+	var streamed []transaction.AccountEvent
+	err := service.StreamAccountEvents(ctx, " alice456 ", null.Int{}, func(event transaction.AccountEvent) error {
+		streamed = append(streamed, event)
+		if event.Seq == latest.Seq {
+			notifications <- struct{}{}
+		} else {
+			cancel()
+		}
+		return nil
+	})
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, []transaction.AccountEvent{latest, next}, streamed)
+	assert.True(t, unsubscribed)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+	activity.AssertExpectations(t)
+}
+
+func Test_Service_StreamAccountEvents_ResumedUntilActivityEnds(t *testing.T) {
+	// given
+	alice := &transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Status: transaction.ActiveAccountStatus}
+	missed := transaction.AccountEvent{Seq: 4, TransactionName: transaction.DepositTransaction, Amount: decimal.NewFromInt(10), Balance: decimal.NewFromInt(10)}
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
+	db.On("GetAccountByUsername", txn, alice.Username).Return(alice, nil)
+	db.On("GetAccountEvents", txn, alice.Id, int64(3), mock.Anything).Return([]transaction.AccountEvent{missed}, nil)
+
+	notifications := make(chan struct{})
+	close(notifications)
+	activity := new(mocktransaction.AccountActivity)
+	activity.On("Subscribe", alice.Id).Return((<-chan struct{})(notifications), func() {})
+
+	service := transaction.NewServiceWithActivity(db, activity)
+
+	// when
+	var streamed []transaction.AccountEvent
+	err := service.StreamAccountEvents(context.Background(), alice.Username, null.IntFrom(3), func(event transaction.AccountEvent) error {
+		streamed = append(streamed, event)
+		return nil
+	})
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, []transaction.AccountEvent{missed}, streamed)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+	db.AssertNotCalled(t, "GetLastEntrySeq", mock.Anything, mock.Anything)
+	activity.AssertExpectations(t)
+}
+
+func Test_Service_StreamAccountEvents_AccountNotFound(t *testing.T) {
+	// given
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
+	db.On("GetAccountByUsername", txn, "nobody").Return(nil, transaction.ErrAccountNotFound)
+
+	activity := new(mocktransaction.AccountActivity)
+
+	service := transaction.NewServiceWithActivity(db, activity)
+
+	// when
+	err := service.StreamAccountEvents(context.Background(), "nobody", null.Int{}, func(transaction.AccountEvent) error {
+		t.Fatal("no event expected")
+		return nil
+	})
+
+	// then
+	assert.Equal(t, transaction.ErrAccountNotFound, err)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+	activity.AssertExpectations(t)
+}
+
+func Test_Service_StreamAccountEvents_StoppedByFn(t *testing.T) {
+	// given
+	alice := &transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Status: transaction.ActiveAccountStatus}
+	stopped := errors.New("client went away")
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
+	db.On("GetAccountByUsername", txn, alice.Username).Return(alice, nil)
+	db.On("GetAccountEvents", txn, alice.Id, int64(0), mock.Anything).Return([]transaction.AccountEvent{{Seq: 1}, {Seq: 2}}, nil)
+
+	service := transaction.NewService(db)
+
+	// when
+	calls := 0
+	err := service.StreamAccountEvents(context.Background(), alice.Username, null.IntFrom(0), func(transaction.AccountEvent) error {
+		calls++
+		return stopped
+	})
+
+	// then
+	assert.Equal(t, stopped, err)
+	assert.Equal(t, 1, calls)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_ImportAccounts_Success(t *testing.T) {
+	// given
+	rows := []transaction.ImportRow{
+		{Username: " carol789 ", OpeningBalance: decimal.NewFromFloat(150.5)},
+		{Username: "dave000", OpeningBalance: decimal.Zero},
+	}
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Commit").Return(nil)
+
+	var carolId uuid.UUID
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
+	db.On("GetTakenUsernames", txn, []string{"carol789", "dave000"}).Return(nil, nil)
+	db.On("CreateAccounts", txn, mock.MatchedBy(func(accounts []transaction.Account) bool {
+		if !assert.Len(t, accounts, 2) {
+			return false
+		}
+		carolId = accounts[0].Id
+		return assert.Equal(t, "carol789", accounts[0].Username) &&
+			assert.Equal(t, "dave000", accounts[1].Username) &&
+			assert.Equal(t, "USD", accounts[1].Currency) &&
+			assert.Equal(t, transaction.ActiveAccountStatus, accounts[1].Status)
+	})).Return(nil)
+	db.On("LockTransactions", txn).Return(nil)
+	db.On("CreateTransactions", txn, mock.MatchedBy(func(deposits []transaction.Transaction) bool {
+		return assert.Len(t, deposits, 1) && assert.Equal(t, transaction.DepositTransaction, deposits[0].Name)
+	})).Return(nil)
+	db.On("CreateEntries", txn, mock.MatchedBy(func(entries []transaction.Entry) bool {
+		return assert.Len(t, entries, 1) &&
+			assert.Equal(t, carolId, entries[0].AccountId) &&
+			assert.True(t, decimal.NewFromFloat(150.5).Equal(entries[0].Credit))
+	})).Return(nil)
+	expectAuditRecord(t, db, txn, transaction.ImportAccountsAuditAction, transaction.OkAuditOutcome)
+
+	service := transaction.NewService(db)
+
+	// when
+	report, err := service.ImportAccounts(context.Background(), rows, false)
+
+	// then
+	assert.NoError(t, err)
+	assert.False(t, report.DryRun)
+	assert.Equal(t, 2, report.Rows)
+	assert.Equal(t, 2, report.Accounts)
+	assert.True(t, decimal.NewFromFloat(150.5).Equal(report.OpeningBalance))
+	assert.Empty(t, report.Errors)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_ImportAccounts_RowsInvalid(t *testing.T) {
+	// given
+	rows := []transaction.ImportRow{
+		{Username: "bob123", OpeningBalance: decimal.NewFromInt(10)},
+		{Username: " ", OpeningBalance: decimal.Zero},
+		{Username: "carol789", OpeningBalance: decimal.NewFromInt(-1)},
+		{Username: "dave000", OpeningBalance: decimal.Zero},
+		{Username: "dave000", OpeningBalance: decimal.Zero},
+	}
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+	auditTxn := new(mockdbutil.Transaction)
+	auditTxn.On("Commit").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil).Once()
+	db.On("GetTakenUsernames", txn, []string{"bob123", "dave000"}).Return([]string{"bob123"}, nil)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(auditTxn, nil).Once()
+	expectAuditRecord(t, db, auditTxn, transaction.ImportAccountsAuditAction, transaction.ErrImportRowsInvalid.Error())
+
+	service := transaction.NewService(db)
+
+	// when
+	report, err := service.ImportAccounts(context.Background(), rows, false)
+
+	// then
+	assert.Equal(t, transaction.ErrImportRowsInvalid, err)
+	require.NotNil(t, report)
+	assert.Equal(t, 5, report.Rows)
+	assert.Zero(t, report.Accounts)
+	assert.Equal(t, []transaction.ImportRowError{
+		{Row: 1, Username: "bob123", Error: transaction.ErrAccountAlreadyExists.Error()},
+		{Row: 2, Username: " ", Error: "username is missing"},
+		{Row: 3, Username: "carol789", Error: "opening balance is negative"},
+		{Row: 5, Username: "dave000", Error: "username is repeated from row 4"},
+	}, report.Errors)
+
+	txn.AssertExpectations(t)
+	auditTxn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_ImportAccounts_DryRun(t *testing.T) {
+	// given
+	rows := []transaction.ImportRow{{Username: "carol789", OpeningBalance: decimal.NewFromInt(10)}}
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
+	db.On("GetTakenUsernames", txn, []string{"carol789"}).Return(nil, nil)
+
+	service := transaction.NewService(db)
+
+	// when
+	report, err := service.ImportAccounts(context.Background(), rows, true)
+
+	// then
+	assert.NoError(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 1, report.Accounts)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+	db.AssertNotCalled(t, "CreateAccounts", mock.Anything, mock.Anything)
+}
+
+func Test_Service_Reconcile_Success(t *testing.T) {
+	// given
+	discrepancies := []transaction.Discrepancy{
+		{Check: "balance_negative", Reference: "alice456", Detail: "balance is -44.79"},
+	}
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
+	db.On("GetLedgerDiscrepancies", txn).Return(discrepancies, nil)
+	db.On("GetChainedEntries", txn, uuid.Nil, int64(0), mock.Anything).Return(nil, nil)
+
+	service := transaction.NewService(db)
+
+	// when
+	fetched, err := service.Reconcile(context.Background())
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, discrepancies, fetched)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_Reconcile_EntryChainBroken(t *testing.T) {
+	// given
+	aliceId, bobId := uuid.New(), uuid.New()
+	discrepancies := []transaction.Discrepancy{
+		{Check: "balance_negative", Reference: "alice456", Detail: "balance is -44.79"},
+		{Check: "payment_unbalanced", Reference: uuid.New().String(), Detail: "1 entries summing to -44.79"},
+	}
+	entries := []transaction.Entry{
+		{Id: uuid.New(), AccountId: aliceId, AccountName: "alice456", Seq: 2},
+		{Id: uuid.New(), AccountId: aliceId, AccountName: "alice456", Seq: 3},
+		{Id: uuid.New(), AccountId: bobId, AccountName: "bob123", Seq: 1, PrevHash: "tampered"},
+	}
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
+	db.On("GetLedgerDiscrepancies", txn).Return(discrepancies, nil)
+	db.On("GetChainedEntries", txn, uuid.Nil, int64(0), mock.Anything).Return(entries, nil)
+
+	service := transaction.NewService(db)
+
+	// when
+	fetched, err := service.Reconcile(context.Background())
+
+	// then
+	assert.NoError(t, err)
+	if assert.Len(t, fetched, 4) {
+		assert.Equal(t, discrepancies[0], fetched[0])
+		assert.Equal(t, transaction.EntryChainBrokenCheck, fetched[1].Check)
+		assert.Equal(t, "alice456", fetched[1].Reference)
+		assert.Contains(t, fetched[1].Detail, "entry at seq 1 is missing")
+		assert.Equal(t, transaction.EntryChainBrokenCheck, fetched[2].Check)
+		assert.Equal(t, "bob123", fetched[2].Reference)
+		assert.Contains(t, fetched[2].Detail, "previous hash")
+		assert.Equal(t, discrepancies[1], fetched[3])
+	}
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_Deposit_IdempotentReplay(t *testing.T) {
+	// given
+	key := uuid.New().String()
+	ctx := transaction.WithIdempotencyKey(context.Background(), key)
+	alice := &transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD"}
+	existing := &transaction.Transaction{
+		Id:   uuid.New(),
+		Name: transaction.DepositTransaction,
+		Entries: []transaction.Entry{
+			{AccountId: alice.Id, Name: transaction.IncomingEntry, Credit: decimal.RequireFromString("50.00")},
+		},
+	}
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Commit").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
+	db.On("GetAccountByUsername", txn, alice.Username).Return(alice, nil)
+	db.On("LockTransactions", txn).Return(nil)
+	db.On("GetTransactionByIdempotencyKey", txn, key).Return(existing, nil)
+	expectAuditRecord(t, db, txn, transaction.DepositAuditAction, transaction.OkAuditOutcome)
+
+	service := transaction.NewService(db)
+
+	// when
+	err := service.Deposit(ctx, alice.Username, decimal.NewFromFloat(50.0))
+
+	// then
+	assert.NoError(t, err)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_Deposit_IdempotencyKeyReusedForOtherAmount(t *testing.T) {
+	// given
+	key := uuid.New().String()
+	ctx := transaction.WithIdempotencyKey(context.Background(), key)
+	alice := &transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD"}
+	existing := &transaction.Transaction{
+		Id:   uuid.New(),
+		Name: transaction.DepositTransaction,
+		Entries: []transaction.Entry{
+			{AccountId: alice.Id, Name: transaction.IncomingEntry, Credit: decimal.RequireFromString("50.00")},
+		},
+	}
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+	auditTxn := new(mockdbutil.Transaction)
+	auditTxn.On("Commit").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil).Once()
+	db.On("GetAccountByUsername", txn, alice.Username).Return(alice, nil)
+	db.On("LockTransactions", txn).Return(nil)
+	db.On("GetTransactionByIdempotencyKey", txn, key).Return(existing, nil)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(auditTxn, nil).Once()
+	expectAuditRecord(t, db, auditTxn, transaction.DepositAuditAction, transaction.ErrIdempotencyKeyReused.Error())
+
+	service := transaction.NewService(db)
+
+	// when
+	err := service.Deposit(ctx, alice.Username, decimal.NewFromFloat(60.0))
+
+	// then
+	assert.Equal(t, transaction.ErrIdempotencyKeyReused, err)
+
+	txn.AssertExpectations(t)
+	auditTxn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_SendPayment_IdempotencyKeyRecorded(t *testing.T) {
+	// given
+	key := uuid.New().String()
+	ctx := transaction.WithIdempotencyKey(context.Background(), key)
+	alice := &transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD"}
+	bob := &transaction.Account{Id: uuid.New(), Username: "bob123", Currency: "USD", Balance: decimal.NewFromFloat(200.0)}
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Commit").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
+	db.On("LockTransactions", txn).Return(nil)
+	db.On("GetAccountByUsername", txn, alice.Username).Return(alice, nil).Once()
+	db.On("GetAccountByUsername", txn, bob.Username).Return(bob, nil).Once()
+	db.On("GetTransactionByIdempotencyKey", txn, key).Return(nil, transaction.ErrTransactionNotFound)
+	db.On("GetAccountStatusForUpdate", txn, mock.Anything).Return(transaction.ActiveAccountStatus, nil)
+	db.On("CreateTransaction", txn, mock.MatchedBy(func(tr transaction.Transaction) bool {
+		return assert.Equal(t, null.StringFrom(key), tr.IdempotencyKey)
+	})).Return(nil)
+	db.On("CreateEntriesForTransactionId", txn, mock.Anything, mock.Anything).Return(nil)
+	expectAuditRecord(t, db, txn, transaction.SendPaymentAuditAction, transaction.OkAuditOutcome)
+
+	service := transaction.NewService(db)
+
+	var observed []decimal.Decimal
+	ctx = transaction.WithPaymentObserver(ctx, func(amount decimal.Decimal) {
+		observed = append(observed, amount)
+	})
+
+	// when
+	err := service.SendPayment(ctx, bob.Username, alice.Username, decimal.NewFromFloat(100.0), transaction.PaymentDetails{})
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, []decimal.Decimal{decimal.NewFromFloat(100.0)}, observed)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_SendPayment_IdempotentReplayAfterBalanceSpent(t *testing.T) {
+	// given
+	key := uuid.New().String()
+	ctx := transaction.WithIdempotencyKey(context.Background(), key)
+	alice := &transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Balance: decimal.NewFromFloat(100.0)}
+	bob := &transaction.Account{Id: uuid.New(), Username: "bob123", Currency: "USD"}
+	existing := &transaction.Transaction{
+		Id:   uuid.New(),
+		Name: transaction.PaymentTransaction,
+		Entries: []transaction.Entry{
+			{AccountId: alice.Id, Name: transaction.IncomingEntry, Credit: decimal.RequireFromString("100.00")},
+			{AccountId: bob.Id, Name: transaction.OutgoingEntry, Debit: decimal.RequireFromString("-100.00")},
+		},
+	}
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Commit").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
+	db.On("LockTransactions", txn).Return(nil)
+	db.On("GetAccountByUsername", txn, alice.Username).Return(alice, nil).Once()
+	db.On("GetAccountByUsername", txn, bob.Username).Return(bob, nil).Once()
+	db.On("GetTransactionByIdempotencyKey", txn, key).Return(existing, nil)
+	expectAuditRecord(t, db, txn, transaction.SendPaymentAuditAction, transaction.OkAuditOutcome)
+
+	service := transaction.NewService(db)
+
+	var observed []decimal.Decimal
+	ctx = transaction.WithPaymentObserver(ctx, func(amount decimal.Decimal) {
+		observed = append(observed, amount)
+	})
+
+	// when
+	err := service.SendPayment(ctx, bob.Username, alice.Username, decimal.NewFromFloat(100.0), transaction.PaymentDetails{})
+
+	// then
+	assert.NoError(t, err)
+	assert.Empty(t, observed)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_SendPayment_IdempotencyKeyReused(t *testing.T) {
+	// given
+	key := uuid.New().String()
+	ctx := transaction.WithIdempotencyKey(context.Background(), key)
+	alice := &transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD"}
+	bob := &transaction.Account{Id: uuid.New(), Username: "bob123", Currency: "USD", Balance: decimal.NewFromFloat(200.0)}
+	karen := &transaction.Account{Id: uuid.New(), Username: "karen789", Currency: "USD"}
+	deposit := &transaction.Transaction{Id: uuid.New(), Name: transaction.DepositTransaction}
+	toKaren := &transaction.Transaction{
+		Id:   uuid.New(),
+		Name: transaction.PaymentTransaction,
+		Entries: []transaction.Entry{
+			{AccountId: karen.Id, Name: transaction.IncomingEntry, Credit: decimal.RequireFromString("100.00")},
+			{AccountId: bob.Id, Name: transaction.OutgoingEntry, Debit: decimal.RequireFromString("-100.00")},
+		},
+	}
+
+	for name, existing := range map[string]*transaction.Transaction{"other kind": deposit, "other receiver": toKaren} {
+		t.Run(name, func(t *testing.T) {
+			txn := new(mockdbutil.Transaction)
+			txn.On("Rollback").Return(nil)
+			auditTxn := new(mockdbutil.Transaction)
+			auditTxn.On("Commit").Return(nil)
+
+			db := new(mocktransaction.Repository)
+			db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil).Once()
+			db.On("LockTransactions", txn).Return(nil)
+			db.On("GetAccountByUsername", txn, alice.Username).Return(alice, nil).Once()
+			db.On("GetAccountByUsername", txn, bob.Username).Return(bob, nil).Once()
+			db.On("GetTransactionByIdempotencyKey", txn, key).Return(existing, nil)
+			db.On("BeginTxn", mock.Anything, mock.Anything).Return(auditTxn, nil).Once()
+			expectAuditRecord(t, db, auditTxn, transaction.SendPaymentAuditAction, transaction.ErrIdempotencyKeyReused.Error())
+
+			service := transaction.NewService(db)
+
+			// when
+			err := service.SendPayment(ctx, bob.Username, alice.Username, decimal.NewFromFloat(100.0), transaction.PaymentDetails{})
+
+			// then
+			assert.Equal(t, transaction.ErrIdempotencyKeyReused, err)
+
+			txn.AssertExpectations(t)
+			auditTxn.AssertExpectations(t)
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_Service_CreateAccount_AuditsClaimedActor(t *testing.T) {
+	// given
+	ctx := transaction.WithClaimedActor(context.Background(), "ops@example.com")
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Commit").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
+	db.On("CreateAccount", txn, mock.Anything).Return(nil)
+	db.On("LockAuditLog", txn).Return(nil)
+	db.On("GetLastAuditRecord", txn).Return(nil, nil)
+	db.On("CreateAuditRecord", txn, mock.MatchedBy(func(record transaction.AuditRecord) bool {
+		return assert.Equal(t, int64(1), record.Seq) &&
+			assert.Equal(t, "ops@example.com", record.ClaimedActor) &&
+			assert.Equal(t, "alice456", record.Account) &&
+			assert.False(t, record.Counterparty.Valid) &&
+			assert.Equal(t, strings.Repeat("0", 64), record.PrevHash) &&
+			assert.Len(t, record.PayloadHash, 64) &&
+			assert.Len(t, record.Hash, 64)
+	})).Return(nil)
+
+	service := transaction.NewService(db)
+
+	// when
+	err := service.CreateAccount(ctx, "alice456")
+
+	// then
+	assert.NoError(t, err)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_SendPayment_AuditRecordFailureRollsBack(t *testing.T) {
+	// given
+	alice := &transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD"}
+	bob := &transaction.Account{Id: uuid.New(), Username: "bob123", Currency: "USD", Balance: decimal.NewFromFloat(200.0)}
+	auditErr := errors.New("connection reset")
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil).Once()
+	db.On("GetAccountByUsername", txn, alice.Username).Return(alice, nil).Once()
+	db.On("GetAccountByUsername", txn, bob.Username).Return(bob, nil).Once()
+	db.On("LockTransactions", txn).Return(nil)
+	db.On("GetAccountStatusForUpdate", txn, mock.Anything).Return(transaction.ActiveAccountStatus, nil)
+	db.On("CreateTransaction", txn, mock.Anything).Return(nil)
+	db.On("CreateEntriesForTransactionId", txn, mock.Anything, mock.Anything).Return(nil)
+	db.On("LockAuditLog", txn).Return(auditErr)
+
+	service := transaction.NewService(db)
+
+	// when
+	err := service.SendPayment(context.Background(), bob.Username, alice.Username, decimal.NewFromFloat(100.0), transaction.PaymentDetails{})
+
+	// then
+	assert.Equal(t, auditErr, err)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_GetAuditLog_Success(t *testing.T) {
+	// given
+	filter := transaction.AuditFilter{Account: "alice456", Limit: 10}
+	records := []transaction.AuditRecord{
+		{Seq: 1, Action: transaction.CreateAccountAuditAction, Account: "alice456", Outcome: transaction.OkAuditOutcome},
+	}
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}).Return(txn, nil)
+	db.On("GetAuditRecords", txn, filter).Return(records, nil)
+
+	service := transaction.NewService(db)
+
+	// when
+	fetched, err := service.GetAuditLog(context.Background(), filter)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, records, fetched)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_VerifyAuditLog_Empty(t *testing.T) {
+	// given
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
+	db.On("GetAuditRecords", txn, mock.MatchedBy(func(filter transaction.AuditFilter) bool {
+		return assert.Zero(t, filter.AfterSeq) && assert.Positive(t, filter.Limit)
+	})).Return(nil, nil).Once()
+
+	service := transaction.NewService(db)
+
+	// when
+	chainBreak, err := service.VerifyAuditLog(context.Background())
+
+	// then
+	assert.NoError(t, err)
+	assert.Nil(t, chainBreak)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_VerifyAuditLog_RecordMissing(t *testing.T) {
+	// given
+	records := []transaction.AuditRecord{{Seq: 2}}
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
+	db.On("GetAuditRecords", txn, mock.Anything).Return(records, nil).Once()
+
+	service := transaction.NewService(db)
+
+	// when
+	chainBreak, err := service.VerifyAuditLog(context.Background())
+
+	// then
+	assert.NoError(t, err)
+	if assert.NotNil(t, chainBreak) {
+		assert.Equal(t, int64(1), chainBreak.Seq)
+	}
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_CreatePaymentRequest_Success(t *testing.T) {
+	// given
+	alice := &transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Status: transaction.ActiveAccountStatus}
+	bob := &transaction.Account{Id: uuid.New(), Username: "bob123", Currency: "USD", Status: transaction.FrozenAccountStatus}
+	amount := decimal.NewFromFloat(25.0)
+	created := &transaction.PaymentRequest{Id: uuid.New(), Requester: alice.Username, Payer: bob.Username}
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Commit").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
+	db.On("GetAccountByUsername", txn, alice.Username).Return(alice, nil).Once()
+	db.On("GetAccountByUsername", txn, bob.Username).Return(bob, nil).Once()
+	db.On("CreatePaymentRequest", txn, mock.MatchedBy(func(request transaction.PaymentRequest) bool {
+		return assert.Equal(t, alice.Id, request.RequesterId) &&
+			assert.Equal(t, bob.Id, request.PayerId) &&
+			assert.Equal(t, amount, request.Amount) &&
+			assert.Equal(t, null.StringFrom("dinner"), request.Memo) &&
+			assert.WithinDuration(t, time.Now().Add(7*24*time.Hour), request.ExpiresAt, time.Minute)
+	})).Return(nil)
+	db.On("GetPaymentRequestForUpdate", txn, mock.Anything).Return(created, nil)
+	expectAuditRecord(t, db, txn, transaction.CreatePaymentRequestAuditAction, transaction.OkAuditOutcome)
+
+	service := transaction.NewService(db)
+
+	// when
+	request, err := service.CreatePaymentRequest(context.Background(), " alice456", "bob123 ", amount, null.StringFrom(" dinner "), time.Time{})
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, created, request)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_CreatePaymentRequest_ExpiryInvalid(t *testing.T) {
+	tests := map[string]time.Time{
+		"past":        time.Now().Add(-time.Minute),
+		"too distant": time.Now().Add(91 * 24 * time.Hour),
+	}
+	for name, expiresAt := range tests {
+		t.Run(name, func(t *testing.T) {
+			// given
+			txn := new(mockdbutil.Transaction)
+			txn.On("Commit").Return(nil)
+
+			db := new(mocktransaction.Repository)
+			db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
+			expectAuditRecord(t, db, txn, transaction.CreatePaymentRequestAuditAction, transaction.ErrPaymentRequestExpiryInvalid.Error())
+
+			service := transaction.NewService(db)
+
+			// when
+			_, err := service.CreatePaymentRequest(context.Background(), "alice456", "bob123", decimal.NewFromFloat(25.0), null.String{}, expiresAt)
+
+			// then
+			assert.Equal(t, transaction.ErrPaymentRequestExpiryInvalid, err)
+
+			txn.AssertExpectations(t)
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_Service_CreatePaymentRequest_PayerClosed(t *testing.T) {
+	// given
+	alice := &transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD", Status: transaction.ActiveAccountStatus}
+	bob := &transaction.Account{Id: uuid.New(), Username: "bob123", Currency: "USD", Status: transaction.ClosedAccountStatus}
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+	auditTxn := new(mockdbutil.Transaction)
+	auditTxn.On("Commit").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil).Once()
+	db.On("GetAccountByUsername", txn, alice.Username).Return(alice, nil).Once()
+	db.On("GetAccountByUsername", txn, bob.Username).Return(bob, nil).Once()
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(auditTxn, nil).Once()
+	expectAuditRecord(t, db, auditTxn, transaction.CreatePaymentRequestAuditAction, transaction.ErrAccountClosed.Error())
+
+	service := transaction.NewService(db)
+
+	// when
+	_, err := service.CreatePaymentRequest(context.Background(), alice.Username, bob.Username, decimal.NewFromFloat(25.0), null.String{}, time.Time{})
+
+	// then
+	assert.Equal(t, transaction.ErrAccountClosed, err)
+
+	txn.AssertExpectations(t)
+	auditTxn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_GetPaymentRequests_Success(t *testing.T) {
+	// given
+	alice := &transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD"}
+	filter := transaction.PaymentRequestFilter{Account: alice.Username, Direction: transaction.IncomingEntry, Status: transaction.PendingPaymentRequestStatus}
+	expected := []transaction.PaymentRequest{{Id: uuid.New(), Requester: "bob123", Payer: alice.Username}}
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginReplicaTxn", mock.Anything).Return(txn, nil)
+	db.On("GetAccountByUsername", txn, alice.Username).Return(alice, nil)
+	db.On("GetPaymentRequests", txn, filter).Return(expected, nil)
+
+	service := transaction.NewService(db)
+
+	// when
+	requests, err := service.GetPaymentRequests(context.Background(), filter)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, expected, requests)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_GetPaymentRequests_FilterInvalid(t *testing.T) {
+	tests := map[string]transaction.PaymentRequestFilter{
+		"missing account": {Direction: transaction.IncomingEntry},
+		"direction":       {Account: "alice456", Direction: "sideways"},
+		"status":          {Account: "alice456", Status: "settled"},
+	}
+	for name, filter := range tests {
+		t.Run(name, func(t *testing.T) {
+			// given
+			db := new(mocktransaction.Repository)
+			service := transaction.NewService(db)
+
+			// when
+			_, err := service.GetPaymentRequests(context.Background(), filter)
+
+			// then
+			assert.Equal(t, transaction.ErrPaymentRequestFilterInvalid, err)
+
+			db.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_Service_AcceptPaymentRequest_Success(t *testing.T) {
+	// given
+	alice := &transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD"}
+	bob := &transaction.Account{Id: uuid.New(), Username: "bob123", Currency: "USD", Balance: decimal.NewFromFloat(200.0)}
+	pending := &transaction.PaymentRequest{
+		Id:          uuid.New(),
+		RequesterId: alice.Id,
+		PayerId:     bob.Id,
+		Requester:   alice.Username,
+		Payer:       bob.Username,
+		Amount:      decimal.NewFromFloat(25.0),
+		Memo:        null.StringFrom("dinner"),
+		Status:      transaction.PendingPaymentRequestStatus,
+		ExpiresAt:   time.Now().Add(time.Hour),
+	}
+	paid := &transaction.PaymentRequest{Id: pending.Id, Status: transaction.PaidPaymentRequestStatus}
+
+	var paymentId uuid.UUID
+	txn := new(mockdbutil.Transaction)
+	txn.On("Commit").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
+	db.On("GetPaymentRequestForUpdate", txn, pending.Id).Return(pending, nil).Once()
+	db.On("GetAccountByUsername", txn, bob.Username).Return(bob, nil).Once()
+	db.On("GetAccountByUsername", txn, alice.Username).Return(alice, nil).Once()
+	db.On("LockTransactions", txn).Return(nil)
+	db.On("GetAccountStatusForUpdate", txn, mock.Anything).Return(transaction.ActiveAccountStatus, nil).Twice()
+	db.On("CreateTransaction", txn, mock.MatchedBy(func(tr transaction.Transaction) bool {
+		paymentId = tr.Id
+		return assert.Equal(t, transaction.PaymentTransaction, tr.Name) &&
+			assert.Equal(t, util.NewNullUUID(bob.Id), tr.SenderId) &&
+			assert.Equal(t, pending.Memo, tr.Description)
+	})).Return(nil)
+	db.On("CreateEntriesForTransactionId", txn, mock.Anything, mock.MatchedBy(func(entries []transaction.Entry) bool {
+		return assert.Len(t, entries, 2) &&
+			assert.Equal(t, bob.Id, entries[0].AccountId) &&
+			assert.True(t, pending.Amount.Neg().Equal(entries[0].Debit)) &&
+			assert.Equal(t, alice.Id, entries[1].AccountId) &&
+			assert.True(t, pending.Amount.Equal(entries[1].Credit))
+	})).Return(nil)
+	db.On("UpdatePaymentRequestStatus", txn, pending.Id, transaction.PaidPaymentRequestStatus, mock.MatchedBy(func(id uuid.NullUUID) bool {
+		return assert.Equal(t, util.NewNullUUID(paymentId), id)
+	})).Return(nil)
+	db.On("GetPaymentRequestForUpdate", txn, pending.Id).Return(paid, nil).Once()
+	expectAuditRecord(t, db, txn, transaction.AcceptPaymentRequestAuditAction, transaction.OkAuditOutcome)
+
+	service := transaction.NewService(db)
+
+	var observed []decimal.Decimal
+	ctx := transaction.WithPaymentObserver(context.Background(), func(amount decimal.Decimal) {
+		observed = append(observed, amount)
+	})
+
+	// when
+	request, err := service.AcceptPaymentRequest(ctx, pending.Id, bob.Username)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, paid, request)
+	assert.Equal(t, []decimal.Decimal{paid.Amount}, observed)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_AcceptPaymentRequest_NotPayer(t *testing.T) {
+	// given
+	pending := &transaction.PaymentRequest{
+		Id:        uuid.New(),
+		Requester: "alice456",
+		Payer:     "bob123",
+		Amount:    decimal.NewFromFloat(25.0),
+		Status:    transaction.PendingPaymentRequestStatus,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+	auditTxn := new(mockdbutil.Transaction)
+	auditTxn.On("Commit").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil).Once()
+	db.On("GetPaymentRequestForUpdate", txn, pending.Id).Return(pending, nil)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(auditTxn, nil).Once()
+	expectAuditRecord(t, db, auditTxn, transaction.AcceptPaymentRequestAuditAction, transaction.ErrPaymentRequestNotFound.Error())
+
+	service := transaction.NewService(db)
+
+	// when
+	_, err := service.AcceptPaymentRequest(context.Background(), pending.Id, pending.Requester)
+
+	// then
+	assert.Equal(t, transaction.ErrPaymentRequestNotFound, err)
+
+	txn.AssertExpectations(t)
+	auditTxn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_AcceptPaymentRequest_Expired(t *testing.T) {
+	// given
+	pending := &transaction.PaymentRequest{
+		Id:        uuid.New(),
+		Requester: "alice456",
+		Payer:     "bob123",
+		Amount:    decimal.NewFromFloat(25.0),
+		Status:    transaction.PendingPaymentRequestStatus,
+		ExpiresAt: time.Now().Add(-time.Second), // not swept yet
+	}
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+	auditTxn := new(mockdbutil.Transaction)
+	auditTxn.On("Commit").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil).Once()
+	db.On("GetPaymentRequestForUpdate", txn, pending.Id).Return(pending, nil)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(auditTxn, nil).Once()
+	expectAuditRecord(t, db, auditTxn, transaction.AcceptPaymentRequestAuditAction, transaction.ErrPaymentRequestExpired.Error())
+
+	service := transaction.NewService(db)
+
+	// when
+	_, err := service.AcceptPaymentRequest(context.Background(), pending.Id, pending.Payer)
+
+	// then
+	assert.Equal(t, transaction.ErrPaymentRequestExpired, err)
+
+	txn.AssertExpectations(t)
+	auditTxn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_DeclinePaymentRequest_NotPending(t *testing.T) {
+	// given
+	cancelled := &transaction.PaymentRequest{
+		Id:        uuid.New(),
+		Requester: "alice456",
+		Payer:     "bob123",
+		Amount:    decimal.NewFromFloat(25.0),
+		Status:    transaction.CancelledPaymentRequestStatus,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+	auditTxn := new(mockdbutil.Transaction)
+	auditTxn.On("Commit").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil).Once()
+	db.On("GetPaymentRequestForUpdate", txn, cancelled.Id).Return(cancelled, nil)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(auditTxn, nil).Once()
+	expectAuditRecord(t, db, auditTxn, transaction.DeclinePaymentRequestAuditAction, transaction.ErrPaymentRequestNotPending.Error())
+
+	service := transaction.NewService(db)
+
+	// when
+	_, err := service.DeclinePaymentRequest(context.Background(), cancelled.Id, cancelled.Payer)
+
+	// then
+	assert.Equal(t, transaction.ErrPaymentRequestNotPending, err)
+
+	txn.AssertExpectations(t)
+	auditTxn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Service_CancelPaymentRequest_Success(t *testing.T) {
+	// given
+	pending := &transaction.PaymentRequest{
+		Id:        uuid.New(),
+		Requester: "alice456",
+		Payer:     "bob123",
+		Amount:    decimal.NewFromFloat(25.0),
+		Status:    transaction.PendingPaymentRequestStatus,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	cancelled := &transaction.PaymentRequest{Id: pending.Id, Status: transaction.CancelledPaymentRequestStatus}
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Commit").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
+	db.On("GetPaymentRequestForUpdate", txn, pending.Id).Return(pending, nil).Once()
+	db.On("UpdatePaymentRequestStatus", txn, pending.Id, transaction.CancelledPaymentRequestStatus, uuid.NullUUID{}).Return(nil)
+	db.On("GetPaymentRequestForUpdate", txn, pending.Id).Return(cancelled, nil).Once()
+	expectAuditRecord(t, db, txn, transaction.CancelPaymentRequestAuditAction, transaction.OkAuditOutcome)
+
+	service := transaction.NewService(db)
+
+	// when
+	request, err := service.CancelPaymentRequest(context.Background(), pending.Id, pending.Requester)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, cancelled, request)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+// --- helpers
+
+// expectAuditRecord expects action to be appended to the audit log in txn, after an existing record, with outcome.
+func expectAuditRecord(t *testing.T, db *mocktransaction.Repository, txn *mockdbutil.Transaction, action string, outcome string) {
+	last := &transaction.AuditRecord{Seq: 41, Hash: strings.Repeat("ab", 32)}
+
+	db.On("LockAuditLog", txn).Return(nil)
+	db.On("GetLastAuditRecord", txn).Return(last, nil)
+	db.On("CreateAuditRecord", txn, mock.MatchedBy(func(record transaction.AuditRecord) bool {
+		return assert.Equal(t, last.Seq+1, record.Seq) &&
+			assert.Equal(t, last.Hash, record.PrevHash) &&
+			assert.Equal(t, action, record.Action) &&
+			assert.Equal(t, outcome, record.Outcome) &&
+			assert.NotEmpty(t, record.Hash)
+	})).Return(nil)
+}
@@ -0,0 +1,70 @@
+package transaction
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/nogurenn/cph-wallet/dbutil"
+)
+
+// PaymentRequestSweeper periodically marks pending payment requests past their expiry as expired. Requests cannot
+// be accepted past their expiry either way; sweeping only records that they expired.
+type PaymentRequestSweeper struct {
+	db       Repository
+	txns     *dbutil.TxnRunner
+	logger   log.Logger
+	interval time.Duration
+	state    workerState
+}
+
+// NewPaymentRequestSweeper returns a PaymentRequestSweeper that sweeps every interval. Several instances may run at
+// once, as each sweep skips the requests that another one holds.
+func NewPaymentRequestSweeper(db Repository, logger log.Logger, interval time.Duration) *PaymentRequestSweeper {
+	return &PaymentRequestSweeper{db: db, txns: dbutil.NewTxnRunner(db.BeginTxn), logger: logger, interval: interval}
+}
+
+// Run sweeps now and then every interval until ctx is done. Failures are logged, and the requests are swept on the
+// next run instead.
+func (s *PaymentRequestSweeper) Run(ctx context.Context) {
+	defer s.state.stop()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now()
+		count, err := s.Sweep(ctx, now)
+		if err != nil {
+			s.state.failed(now, err)
+			s.logger.Log("msg", "payment requests could not be expired", "err", err)
+		} else {
+			s.state.succeeded()
+			if count > 0 {
+				s.logger.Log("msg", "payment requests expired", "count", count)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep marks the pending payment requests whose expiry is at or before now as expired, and returns how many were
+// marked.
+func (s *PaymentRequestSweeper) Sweep(ctx context.Context, now time.Time) (count int64, err error) {
+	err = s.txns.RunInTxn(ctx, sql.LevelReadCommitted, func(txn dbutil.Transaction) error {
+		count, err = s.db.ExpirePaymentRequests(txn, now)
+		return err
+	})
+	return count, err
+}
+
+// Check fails once Run returned, or when payment requests could not be swept for two intervals in a row.
+func (s *PaymentRequestSweeper) Check(_ context.Context) error {
+	return s.state.check(time.Now(), 2*s.interval)
+}
@@ -0,0 +1,91 @@
+package transaction_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	mockdbutil "github.com/nogurenn/cph-wallet/mocks/autogen/dbutil"
+	mocktransaction "github.com/nogurenn/cph-wallet/mocks/autogen/transaction"
+	"github.com/nogurenn/cph-wallet/transaction"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func Test_PaymentRequestSweeper_Sweep_Success(t *testing.T) {
+	// given
+	now := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Commit").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
+	db.On("ExpirePaymentRequests", txn, now).Return(int64(3), nil)
+
+	sweeper := transaction.NewPaymentRequestSweeper(db, log.NewNopLogger(), time.Minute)
+
+	// when
+	count, err := sweeper.Sweep(context.Background(), now)
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_PaymentRequestSweeper_Sweep_Failure(t *testing.T) {
+	// given
+	now := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	expectedErr := errors.New("connection reset")
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Rollback").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
+	db.On("ExpirePaymentRequests", txn, now).Return(int64(0), expectedErr)
+
+	sweeper := transaction.NewPaymentRequestSweeper(db, log.NewNopLogger(), time.Minute)
+
+	// when
+	_, err := sweeper.Sweep(context.Background(), now)
+
+	// then
+	assert.Equal(t, expectedErr, err)
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_PaymentRequestSweeper_Check(t *testing.T) {
+	// given
+	expectedErr := errors.New("connection refused")
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(nil, expectedErr)
+
+	sweeper := transaction.NewPaymentRequestSweeper(db, log.NewNopLogger(), 10*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	// when
+	go func() {
+		defer close(done)
+		sweeper.Run(ctx)
+	}()
+
+	// then
+	assert.Eventually(t, func() bool {
+		err := sweeper.Check(context.Background())
+		return errors.Is(err, expectedErr)
+	}, time.Second, 5*time.Millisecond)
+
+	cancel()
+	<-done
+	assert.EqualError(t, sweeper.Check(context.Background()), "not running")
+}
@@ -0,0 +1,549 @@
+package transaction
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/google/uuid"
+	"github.com/nogurenn/cph-wallet/dbutil"
+	"github.com/shopspring/decimal"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/guregu/null.v4"
+)
+
+const tracerName = "github.com/nogurenn/cph-wallet/transaction"
+
+// traceEndpoint wraps an endpoint in a span named after it. Errors carried in responses are recorded too.
+func traceEndpoint(name string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			ctx, span := otel.Tracer(tracerName).Start(ctx, "endpoint."+name)
+			defer func() {
+				if e, ok := response.(errorer); ok && err == nil {
+					endSpan(span, e.error())
+				} else {
+					endSpan(span, err)
+				}
+			}()
+
+			return next(ctx, request)
+		}
+	}
+}
+
+type tracingService struct {
+	tracer trace.Tracer
+	Service
+}
+
+// NewTracingService returns a Service that wraps every call in a span.
+func NewTracingService(s Service) Service {
+	return &tracingService{
+		tracer:  otel.Tracer(tracerName),
+		Service: s,
+	}
+}
+
+func (s *tracingService) CreateAccount(ctx context.Context, username string) (err error) {
+	ctx, span := s.tracer.Start(ctx, "service.CreateAccount")
+	defer func() { endSpan(span, err) }()
+
+	return s.Service.CreateAccount(ctx, username)
+}
+
+func (s *tracingService) GetAccounts(ctx context.Context) (_ []Account, err error) {
+	ctx, span := s.tracer.Start(ctx, "service.GetAccounts")
+	defer func() { endSpan(span, err) }()
+
+	return s.Service.GetAccounts(ctx)
+}
+
+func (s *tracingService) GetAccount(ctx context.Context, username string) (_ *Account, err error) {
+	ctx, span := s.tracer.Start(ctx, "service.GetAccount")
+	defer func() { endSpan(span, err) }()
+
+	return s.Service.GetAccount(ctx, username)
+}
+
+func (s *tracingService) GetAccountsAsOf(ctx context.Context, asOf time.Time) (_ []Account, err error) {
+	ctx, span := s.tracer.Start(ctx, "service.GetAccountsAsOf")
+	defer func() { endSpan(span, err) }()
+
+	return s.Service.GetAccountsAsOf(ctx, asOf)
+}
+
+func (s *tracingService) GetAccountAsOf(ctx context.Context, username string, asOf time.Time) (_ *Account, err error) {
+	ctx, span := s.tracer.Start(ctx, "service.GetAccountAsOf")
+	defer func() { endSpan(span, err) }()
+
+	return s.Service.GetAccountAsOf(ctx, username, asOf)
+}
+
+func (s *tracingService) GetPaymentTransactions(ctx context.Context, filter TransactionFilter) (_ []Transaction, err error) {
+	ctx, span := s.tracer.Start(ctx, "service.GetPaymentTransactions")
+	defer func() { endSpan(span, err) }()
+
+	return s.Service.GetPaymentTransactions(ctx, filter)
+}
+
+func (s *tracingService) Deposit(ctx context.Context, username string, amount decimal.Decimal) (err error) {
+	ctx, span := s.tracer.Start(ctx, "service.Deposit")
+	defer func() { endSpan(span, err) }()
+
+	return s.Service.Deposit(ctx, username, amount)
+}
+
+func (s *tracingService) SendPayment(ctx context.Context, fromUsername string, toUsername string, amount decimal.Decimal, details PaymentDetails) (err error) {
+	ctx, span := s.tracer.Start(ctx, "service.SendPayment")
+	defer func() { endSpan(span, err) }()
+
+	return s.Service.SendPayment(ctx, fromUsername, toUsername, amount, details)
+}
+
+func (s *tracingService) CreatePaymentRequest(ctx context.Context, requesterUsername string, payerUsername string, amount decimal.Decimal, memo null.String, expiresAt time.Time) (_ *PaymentRequest, err error) {
+	ctx, span := s.tracer.Start(ctx, "service.CreatePaymentRequest")
+	defer func() { endSpan(span, err) }()
+
+	return s.Service.CreatePaymentRequest(ctx, requesterUsername, payerUsername, amount, memo, expiresAt)
+}
+
+func (s *tracingService) GetPaymentRequests(ctx context.Context, filter PaymentRequestFilter) (_ []PaymentRequest, err error) {
+	ctx, span := s.tracer.Start(ctx, "service.GetPaymentRequests")
+	defer func() { endSpan(span, err) }()
+
+	return s.Service.GetPaymentRequests(ctx, filter)
+}
+
+func (s *tracingService) AcceptPaymentRequest(ctx context.Context, id uuid.UUID, payerUsername string) (_ *PaymentRequest, err error) {
+	ctx, span := s.tracer.Start(ctx, "service.AcceptPaymentRequest")
+	defer func() { endSpan(span, err) }()
+
+	return s.Service.AcceptPaymentRequest(ctx, id, payerUsername)
+}
+
+func (s *tracingService) DeclinePaymentRequest(ctx context.Context, id uuid.UUID, payerUsername string) (_ *PaymentRequest, err error) {
+	ctx, span := s.tracer.Start(ctx, "service.DeclinePaymentRequest")
+	defer func() { endSpan(span, err) }()
+
+	return s.Service.DeclinePaymentRequest(ctx, id, payerUsername)
+}
+
+func (s *tracingService) CancelPaymentRequest(ctx context.Context, id uuid.UUID, requesterUsername string) (_ *PaymentRequest, err error) {
+	ctx, span := s.tracer.Start(ctx, "service.CancelPaymentRequest")
+	defer func() { endSpan(span, err) }()
+
+	return s.Service.CancelPaymentRequest(ctx, id, requesterUsername)
+}
+
+func (s *tracingService) FreezeAccount(ctx context.Context, username string, reason string) (err error) {
+	ctx, span := s.tracer.Start(ctx, "service.FreezeAccount")
+	defer func() { endSpan(span, err) }()
+
+	return s.Service.FreezeAccount(ctx, username, reason)
+}
+
+func (s *tracingService) UnfreezeAccount(ctx context.Context, username string, reason string) (err error) {
+	ctx, span := s.tracer.Start(ctx, "service.UnfreezeAccount")
+	defer func() { endSpan(span, err) }()
+
+	return s.Service.UnfreezeAccount(ctx, username, reason)
+}
+
+func (s *tracingService) CloseAccount(ctx context.Context, username string, reason string) (err error) {
+	ctx, span := s.tracer.Start(ctx, "service.CloseAccount")
+	defer func() { endSpan(span, err) }()
+
+	return s.Service.CloseAccount(ctx, username, reason)
+}
+
+func (s *tracingService) GetAccountStatusChanges(ctx context.Context, username string) (_ []AccountStatusChange, err error) {
+	ctx, span := s.tracer.Start(ctx, "service.GetAccountStatusChanges")
+	defer func() { endSpan(span, err) }()
+
+	return s.Service.GetAccountStatusChanges(ctx, username)
+}
+
+func (s *tracingService) GetAccountStatement(ctx context.Context, username string) (_ []StatementLine, err error) {
+	ctx, span := s.tracer.Start(ctx, "service.GetAccountStatement")
+	defer func() { endSpan(span, err) }()
+
+	return s.Service.GetAccountStatement(ctx, username)
+}
+
+func (s *tracingService) ExportEntries(ctx context.Context, filter ExportFilter, fn func(ExportedEntry) error) (err error) {
+	ctx, span := s.tracer.Start(ctx, "service.ExportEntries")
+	defer func() { endSpan(span, err) }()
+
+	return s.Service.ExportEntries(ctx, filter, fn)
+}
+
+func (s *tracingService) StreamAccountEvents(ctx context.Context, username string, lastEventId null.Int, fn func(AccountEvent) error) (err error) {
+	ctx, span := s.tracer.Start(ctx, "service.StreamAccountEvents")
+	defer func() { endSpan(span, err) }()
+
+	return s.Service.StreamAccountEvents(ctx, username, lastEventId, fn)
+}
+
+func (s *tracingService) ImportAccounts(ctx context.Context, rows []ImportRow, dryRun bool) (_ *ImportReport, err error) {
+	ctx, span := s.tracer.Start(ctx, "service.ImportAccounts")
+	defer func() { endSpan(span, err) }()
+
+	return s.Service.ImportAccounts(ctx, rows, dryRun)
+}
+
+func (s *tracingService) Reconcile(ctx context.Context) (_ []Discrepancy, err error) {
+	ctx, span := s.tracer.Start(ctx, "service.Reconcile")
+	defer func() { endSpan(span, err) }()
+
+	return s.Service.Reconcile(ctx)
+}
+
+func (s *tracingService) GetAuditLog(ctx context.Context, filter AuditFilter) (_ []AuditRecord, err error) {
+	ctx, span := s.tracer.Start(ctx, "service.GetAuditLog")
+	defer func() { endSpan(span, err) }()
+
+	return s.Service.GetAuditLog(ctx, filter)
+}
+
+func (s *tracingService) VerifyAuditLog(ctx context.Context) (_ *AuditChainBreak, err error) {
+	ctx, span := s.tracer.Start(ctx, "service.VerifyAuditLog")
+	defer func() { endSpan(span, err) }()
+
+	return s.Service.VerifyAuditLog(ctx)
+}
+
+type tracingRepository struct {
+	tracer trace.Tracer
+	Repository
+}
+
+// NewTracingRepository returns a Repository that wraps every transaction and every query in it in a span.
+// Queries are traced only in transactions begun by the returned Repository, as they carry the span context.
+func NewTracingRepository(r Repository) Repository {
+	return &tracingRepository{
+		tracer:     otel.Tracer(tracerName),
+		Repository: r,
+	}
+}
+
+func (r *tracingRepository) BeginTxn(ctx context.Context, opts *sql.TxOptions) (dbutil.Transaction, error) {
+	ctx, span := r.tracer.Start(ctx, "db.transaction", trace.WithAttributes(attribute.String("db.system", "postgresql")))
+	if opts != nil {
+		span.SetAttributes(
+			attribute.String("db.isolation_level", opts.Isolation.String()),
+			attribute.Bool("db.read_only", opts.ReadOnly),
+		)
+	}
+
+	txn, err := r.Repository.BeginTxn(ctx, opts)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	return &tracedTransaction{Transaction: txn, ctx: ctx, span: span}, nil
+}
+
+func (r *tracingRepository) BeginReplicaTxn(ctx context.Context) (dbutil.Transaction, error) {
+	ctx, span := r.tracer.Start(ctx, "db.transaction", trace.WithAttributes(
+		attribute.String("db.system", "postgresql"),
+		attribute.Bool("db.replica", true),
+	))
+
+	txn, err := r.Repository.BeginReplicaTxn(ctx)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	return &tracedTransaction{Transaction: txn, ctx: ctx, span: span}, nil
+}
+
+func (r *tracingRepository) GetAccounts(txn dbutil.Transaction) (_ []Account, err error) {
+	span := r.startQuery(txn, "GetAccounts")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.GetAccounts(txn)
+}
+
+func (r *tracingRepository) GetAccountByUsername(txn dbutil.Transaction, username string) (_ *Account, err error) {
+	span := r.startQuery(txn, "GetAccountByUsername")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.GetAccountByUsername(txn, username)
+}
+
+func (r *tracingRepository) GetAccountsAsOf(txn dbutil.Transaction, asOf time.Time) (_ []Account, err error) {
+	span := r.startQuery(txn, "GetAccountsAsOf")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.GetAccountsAsOf(txn, asOf)
+}
+
+func (r *tracingRepository) GetAccountByUsernameAsOf(txn dbutil.Transaction, username string, asOf time.Time) (_ *Account, err error) {
+	span := r.startQuery(txn, "GetAccountByUsernameAsOf")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.GetAccountByUsernameAsOf(txn, username, asOf)
+}
+
+func (r *tracingRepository) CreateBalanceCheckpoints(txn dbutil.Transaction, asOf time.Time) (_ int64, err error) {
+	span := r.startQuery(txn, "CreateBalanceCheckpoints")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.CreateBalanceCheckpoints(txn, asOf)
+}
+
+func (r *tracingRepository) CreateAccount(txn dbutil.Transaction, account Account) (err error) {
+	span := r.startQuery(txn, "CreateAccount")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.CreateAccount(txn, account)
+}
+
+func (r *tracingRepository) GetTakenUsernames(txn dbutil.Transaction, usernames []string) (_ []string, err error) {
+	span := r.startQuery(txn, "GetTakenUsernames")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.GetTakenUsernames(txn, usernames)
+}
+
+func (r *tracingRepository) CreateAccounts(txn dbutil.Transaction, accounts []Account) (err error) {
+	span := r.startQuery(txn, "CreateAccounts")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.CreateAccounts(txn, accounts)
+}
+
+func (r *tracingRepository) GetAccountStatusForUpdate(txn dbutil.Transaction, accountId uuid.UUID) (_ string, err error) {
+	span := r.startQuery(txn, "GetAccountStatusForUpdate")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.GetAccountStatusForUpdate(txn, accountId)
+}
+
+func (r *tracingRepository) UpdateAccountStatus(txn dbutil.Transaction, accountId uuid.UUID, status string) (err error) {
+	span := r.startQuery(txn, "UpdateAccountStatus")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.UpdateAccountStatus(txn, accountId, status)
+}
+
+func (r *tracingRepository) CreateAccountStatusChange(txn dbutil.Transaction, change AccountStatusChange) (err error) {
+	span := r.startQuery(txn, "CreateAccountStatusChange")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.CreateAccountStatusChange(txn, change)
+}
+
+func (r *tracingRepository) GetAccountStatusChanges(txn dbutil.Transaction, accountId uuid.UUID) (_ []AccountStatusChange, err error) {
+	span := r.startQuery(txn, "GetAccountStatusChanges")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.GetAccountStatusChanges(txn, accountId)
+}
+
+func (r *tracingRepository) GetStatementLines(txn dbutil.Transaction, accountId uuid.UUID) (_ []StatementLine, err error) {
+	span := r.startQuery(txn, "GetStatementLines")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.GetStatementLines(txn, accountId)
+}
+
+func (r *tracingRepository) GetLastEntrySeq(txn dbutil.Transaction, accountId uuid.UUID) (_ int64, err error) {
+	span := r.startQuery(txn, "GetLastEntrySeq")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.GetLastEntrySeq(txn, accountId)
+}
+
+func (r *tracingRepository) GetAccountEvents(txn dbutil.Transaction, accountId uuid.UUID, afterSeq int64, limit int) (_ []AccountEvent, err error) {
+	span := r.startQuery(txn, "GetAccountEvents")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.GetAccountEvents(txn, accountId, afterSeq, limit)
+}
+
+func (r *tracingRepository) StreamExportedEntries(txn dbutil.Transaction, filter ExportFilter, fn func(ExportedEntry) error) (err error) {
+	span := r.startQuery(txn, "StreamExportedEntries")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.StreamExportedEntries(txn, filter, fn)
+}
+
+func (r *tracingRepository) GetLedgerDiscrepancies(txn dbutil.Transaction) (_ []Discrepancy, err error) {
+	span := r.startQuery(txn, "GetLedgerDiscrepancies")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.GetLedgerDiscrepancies(txn)
+}
+
+func (r *tracingRepository) GetChainedEntries(txn dbutil.Transaction, afterAccountId uuid.UUID, afterSeq int64, limit int) (_ []Entry, err error) {
+	span := r.startQuery(txn, "GetChainedEntries")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.GetChainedEntries(txn, afterAccountId, afterSeq, limit)
+}
+
+func (r *tracingRepository) GetTransactionsByName(txn dbutil.Transaction, name string, filter TransactionFilter) (_ []Transaction, err error) {
+	span := r.startQuery(txn, "GetTransactionsByName")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.GetTransactionsByName(txn, name, filter)
+}
+
+func (r *tracingRepository) GetTransactionByIdempotencyKey(txn dbutil.Transaction, key string) (_ *Transaction, err error) {
+	span := r.startQuery(txn, "GetTransactionByIdempotencyKey")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.GetTransactionByIdempotencyKey(txn, key)
+}
+
+func (r *tracingRepository) LockTransactions(txn dbutil.Transaction) (err error) {
+	span := r.startQuery(txn, "LockTransactions")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.LockTransactions(txn)
+}
+
+func (r *tracingRepository) CreateTransaction(txn dbutil.Transaction, transaction Transaction) (err error) {
+	span := r.startQuery(txn, "CreateTransaction")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.CreateTransaction(txn, transaction)
+}
+
+func (r *tracingRepository) CreateEntriesForTransactionId(txn dbutil.Transaction, transactionId uuid.UUID, entries []Entry) (err error) {
+	span := r.startQuery(txn, "CreateEntriesForTransactionId")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.CreateEntriesForTransactionId(txn, transactionId, entries)
+}
+
+func (r *tracingRepository) CreateTransactions(txn dbutil.Transaction, transactions []Transaction) (err error) {
+	span := r.startQuery(txn, "CreateTransactions")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.CreateTransactions(txn, transactions)
+}
+
+func (r *tracingRepository) CreateEntries(txn dbutil.Transaction, entries []Entry) (err error) {
+	span := r.startQuery(txn, "CreateEntries")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.CreateEntries(txn, entries)
+}
+
+func (r *tracingRepository) CreatePaymentRequest(txn dbutil.Transaction, request PaymentRequest) (err error) {
+	span := r.startQuery(txn, "CreatePaymentRequest")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.CreatePaymentRequest(txn, request)
+}
+
+func (r *tracingRepository) GetPaymentRequestForUpdate(txn dbutil.Transaction, id uuid.UUID) (_ *PaymentRequest, err error) {
+	span := r.startQuery(txn, "GetPaymentRequestForUpdate")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.GetPaymentRequestForUpdate(txn, id)
+}
+
+func (r *tracingRepository) GetPaymentRequests(txn dbutil.Transaction, filter PaymentRequestFilter) (_ []PaymentRequest, err error) {
+	span := r.startQuery(txn, "GetPaymentRequests")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.GetPaymentRequests(txn, filter)
+}
+
+func (r *tracingRepository) UpdatePaymentRequestStatus(txn dbutil.Transaction, id uuid.UUID, status string, transactionId uuid.NullUUID) (err error) {
+	span := r.startQuery(txn, "UpdatePaymentRequestStatus")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.UpdatePaymentRequestStatus(txn, id, status, transactionId)
+}
+
+func (r *tracingRepository) ExpirePaymentRequests(txn dbutil.Transaction, now time.Time) (_ int64, err error) {
+	span := r.startQuery(txn, "ExpirePaymentRequests")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.ExpirePaymentRequests(txn, now)
+}
+
+func (r *tracingRepository) LockAuditLog(txn dbutil.Transaction) (err error) {
+	span := r.startQuery(txn, "LockAuditLog")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.LockAuditLog(txn)
+}
+
+func (r *tracingRepository) GetLastAuditRecord(txn dbutil.Transaction) (_ *AuditRecord, err error) {
+	span := r.startQuery(txn, "GetLastAuditRecord")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.GetLastAuditRecord(txn)
+}
+
+func (r *tracingRepository) CreateAuditRecord(txn dbutil.Transaction, record AuditRecord) (err error) {
+	span := r.startQuery(txn, "CreateAuditRecord")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.CreateAuditRecord(txn, record)
+}
+
+func (r *tracingRepository) GetAuditRecords(txn dbutil.Transaction, filter AuditFilter) (_ []AuditRecord, err error) {
+	span := r.startQuery(txn, "GetAuditRecords")
+	defer func() { endSpan(span, err) }()
+
+	return r.Repository.GetAuditRecords(txn, filter)
+}
+
+// tracedTransaction carries the span of a transaction, for the spans of its queries to be children of,
+// and ends it on commit or rollback.
+type tracedTransaction struct {
+	dbutil.Transaction
+	ctx  context.Context
+	span trace.Span
+}
+
+func (t *tracedTransaction) Commit() error {
+	err := t.Transaction.Commit()
+	t.span.SetAttributes(attribute.String("db.outcome", "commit"))
+	endSpan(t.span, err)
+	return err
+}
+
+func (t *tracedTransaction) Rollback() error {
+	err := t.Transaction.Rollback()
+	if err != sql.ErrTxDone {
+		t.span.SetAttributes(attribute.String("db.outcome", "rollback"))
+		endSpan(t.span, err)
+	}
+	return err
+}
+
+// --- helpers
+
+// startQuery starts the span of a query named after the SQL it runs, under the span of txn if it has one.
+func (r *tracingRepository) startQuery(txn dbutil.Transaction, name string) trace.Span {
+	ctx := context.Background()
+	if traced, ok := txn.(*tracedTransaction); ok {
+		ctx = traced.ctx
+	}
+
+	_, span := r.tracer.Start(ctx, "db.query."+name, trace.WithAttributes(
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.operation", name),
+	))
+	return span
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
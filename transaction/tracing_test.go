@@ -0,0 +1,119 @@
+package transaction_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/google/uuid"
+	mockdbutil "github.com/nogurenn/cph-wallet/mocks/autogen/dbutil"
+	mocktransaction "github.com/nogurenn/cph-wallet/mocks/autogen/transaction"
+	"github.com/nogurenn/cph-wallet/transaction"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func Test_Tracing_Deposit_SpansNested(t *testing.T) {
+	// given
+	recorder := setTracerProvider(t)
+	alice := &transaction.Account{Id: uuid.New(), Username: "alice456", Currency: "USD"}
+
+	txn := new(mockdbutil.Transaction)
+	txn.On("Commit").Return(nil)
+
+	db := new(mocktransaction.Repository)
+	db.On("BeginTxn", mock.Anything, mock.Anything).Return(txn, nil)
+	db.On("GetAccountByUsername", mock.Anything, alice.Username).Return(alice, nil)
+	db.On("LockTransactions", mock.Anything).Return(nil)
+	db.On("GetAccountStatusForUpdate", mock.Anything, alice.Id).Return(transaction.ActiveAccountStatus, nil)
+	db.On("CreateTransaction", mock.Anything, mock.Anything).Return(nil)
+	db.On("CreateEntriesForTransactionId", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	db.On("LockAuditLog", mock.Anything).Return(nil)
+	db.On("GetLastAuditRecord", mock.Anything).Return(nil, nil)
+	db.On("CreateAuditRecord", mock.Anything, mock.Anything).Return(nil)
+
+	service := transaction.NewTracingService(transaction.NewService(transaction.NewTracingRepository(db)))
+
+	// when
+	err := service.Deposit(context.Background(), alice.Username, decimal.NewFromFloat(50.0))
+
+	// then
+	assert.NoError(t, err)
+
+	spans := spansByName(recorder)
+	if assert.Contains(t, spans, "service.Deposit") && assert.Contains(t, spans, "db.transaction") {
+		assert.Equal(t, spans["service.Deposit"].SpanContext().SpanID(), spans["db.transaction"].Parent().SpanID())
+		for _, query := range []string{"db.query.GetAccountByUsername", "db.query.LockTransactions", "db.query.CreateTransaction"} {
+			if assert.Contains(t, spans, query) {
+				assert.Equal(t, spans["db.transaction"].SpanContext().SpanID(), spans[query].Parent().SpanID(), query)
+			}
+		}
+	}
+
+	txn.AssertExpectations(t)
+	db.AssertExpectations(t)
+}
+
+func Test_Tracing_MakeHandler_ContinuesTraceparent(t *testing.T) {
+	// given
+	recorder := setTracerProvider(t)
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	s := new(mocktransaction.Service)
+	s.On("GetAccounts", mock.Anything).Return([]transaction.Account{}, nil)
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	// when
+	req := httptest.NewRequest(http.MethodGet, "/transaction/v1/accounts", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	// then
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	spans := spansByName(recorder)
+	if assert.Contains(t, spans, "GET /transaction/v1/accounts") && assert.Contains(t, spans, "endpoint.get_accounts") {
+		server := spans["GET /transaction/v1/accounts"]
+		assert.Equal(t, traceID, server.SpanContext().TraceID().String())
+		assert.Equal(t, trace.SpanKindServer, server.SpanKind())
+		assert.Equal(t, server.SpanContext().SpanID(), spans["endpoint.get_accounts"].Parent().SpanID())
+	}
+
+	s.AssertExpectations(t)
+}
+
+// --- helpers
+
+// setTracerProvider records the spans of the test, and restores the global tracer provider and propagator after it.
+func setTracerProvider(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	provider, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagator)
+	})
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return recorder
+}
+
+func spansByName(recorder *tracetest.SpanRecorder) map[string]sdktrace.ReadOnlySpan {
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	return spans
+}
@@ -2,56 +2,336 @@ package transaction
 
 import (
 	"context"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-kit/kit/transport"
 	kithttp "github.com/go-kit/kit/transport/http"
 	"github.com/go-kit/log"
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/nogurenn/cph-wallet/tracing"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"gopkg.in/guregu/null.v4"
 )
 
 func MakeHandler(s Service, logger log.Logger) http.Handler {
 	opts := []kithttp.ServerOption{
-		kithttp.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
+		kithttp.ServerErrorHandler(transport.ErrorHandlerFunc(func(ctx context.Context, err error) {
+			logger.Log("request_id", RequestIDFrom(ctx), "trace_id", tracing.TraceID(ctx), "err", err)
+		})),
 		kithttp.ServerErrorEncoder(encodeError),
+		kithttp.ServerBefore(idempotencyKeyFromHTTPHeader, claimedActorFromHTTPHeader),
 	}
 
+	createAccountHandler := kithttp.NewServer(
+		traceEndpoint("create_account")(makeCreateAccountEndpoint(s)),
+		decodeCreateAccountRequest,
+		encodeCreatedResponse,
+		opts...,
+	)
 	getAccountsHandler := kithttp.NewServer(
-		makeGetAccountsEndpoint(s),
+		traceEndpoint("get_accounts")(makeGetAccountsEndpoint(s)),
 		decodeGetAccountsRequest,
 		encodeResponse,
 		opts...,
 	)
+	getAccountHandler := kithttp.NewServer(
+		traceEndpoint("get_account")(makeGetAccountEndpoint(s)),
+		decodeGetAccountRequest,
+		encodeGetAccountResponse,
+		opts...,
+	)
+	getAccountStatementHandler := kithttp.NewServer(
+		traceEndpoint("get_account_statement")(makeGetAccountStatementEndpoint(s)),
+		decodeGetAccountStatementRequest,
+		encodeResponse,
+		opts...,
+	)
+	depositHandler := kithttp.NewServer(
+		traceEndpoint("deposit")(makeDepositEndpoint(s)),
+		decodeDepositRequest,
+		encodeCreatedResponse,
+		opts...,
+	)
 	getPaymentTransactionsHandler := kithttp.NewServer(
-		makeGetPaymentTransactionsEndpoint(s),
+		traceEndpoint("get_payment_transactions")(makeGetPaymentTransactionsEndpoint(s)),
 		decodeGetPaymentTransactionsRequest,
 		encodeResponse,
 		opts...,
 	)
 	sendPaymentHandler := kithttp.NewServer(
-		makeSendPaymentEndpoint(s),
+		traceEndpoint("send_payment")(makeSendPaymentEndpoint(s)),
 		decodeSendPaymentRequest,
-		encodeSendPaymentResponse,
+		encodeCreatedResponse,
+		opts...,
+	)
+	createPaymentRequestHandler := kithttp.NewServer(
+		traceEndpoint("create_payment_request")(makeCreatePaymentRequestEndpoint(s)),
+		decodeCreatePaymentRequestRequest,
+		encodeCreatedResponse,
+		opts...,
+	)
+	getPaymentRequestsHandler := kithttp.NewServer(
+		traceEndpoint("get_payment_requests")(makeGetPaymentRequestsEndpoint(s)),
+		decodeGetPaymentRequestsRequest,
+		encodeResponse,
+		opts...,
+	)
+	acceptPaymentRequestHandler := kithttp.NewServer(
+		traceEndpoint("accept_payment_request")(makeAcceptPaymentRequestEndpoint(s)),
+		decodeRespondToPaymentRequestRequest,
+		encodeResponse,
+		opts...,
+	)
+	declinePaymentRequestHandler := kithttp.NewServer(
+		traceEndpoint("decline_payment_request")(makeDeclinePaymentRequestEndpoint(s)),
+		decodeRespondToPaymentRequestRequest,
+		encodeResponse,
+		opts...,
+	)
+	cancelPaymentRequestHandler := kithttp.NewServer(
+		traceEndpoint("cancel_payment_request")(makeCancelPaymentRequestEndpoint(s)),
+		decodeRespondToPaymentRequestRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	freezeAccountHandler := kithttp.NewServer(
+		traceEndpoint("freeze_account")(makeFreezeAccountEndpoint(s)),
+		decodeChangeAccountStatusRequest,
+		encodeResponse,
+		opts...,
+	)
+	unfreezeAccountHandler := kithttp.NewServer(
+		traceEndpoint("unfreeze_account")(makeUnfreezeAccountEndpoint(s)),
+		decodeChangeAccountStatusRequest,
+		encodeResponse,
+		opts...,
+	)
+	closeAccountHandler := kithttp.NewServer(
+		traceEndpoint("close_account")(makeCloseAccountEndpoint(s)),
+		decodeChangeAccountStatusRequest,
+		encodeResponse,
+		opts...,
+	)
+	getAccountStatusChangesHandler := kithttp.NewServer(
+		traceEndpoint("get_account_status_changes")(makeGetAccountStatusChangesEndpoint(s)),
+		decodeGetAccountStatusChangesRequest,
+		encodeResponse,
+		opts...,
+	)
+	exportPaymentsHandler := kithttp.NewServer(
+		traceEndpoint("export_payments")(makeExportEntriesEndpoint(s)),
+		decodeExportEntriesRequest(PaymentTransaction),
+		encodeExportEntriesResponse,
+		opts...,
+	)
+	exportDepositsHandler := kithttp.NewServer(
+		traceEndpoint("export_deposits")(makeExportEntriesEndpoint(s)),
+		decodeExportEntriesRequest(DepositTransaction),
+		encodeExportEntriesResponse,
+		opts...,
+	)
+	exportStatementHandler := kithttp.NewServer(
+		traceEndpoint("export_statement")(makeExportEntriesEndpoint(s)),
+		decodeExportEntriesRequest(StatementExport),
+		encodeExportEntriesResponse,
+		opts...,
+	)
+	streamAccountEventsHandler := kithttp.NewServer(
+		traceEndpoint("stream_account_events")(makeStreamAccountEventsEndpoint(s)),
+		decodeStreamAccountEventsRequest,
+		encodeStreamAccountEventsResponse,
+		opts...,
+	)
+	importAccountsHandler := kithttp.NewServer(
+		traceEndpoint("import_accounts")(makeImportAccountsEndpoint(s)),
+		decodeImportAccountsRequest,
+		encodeImportAccountsResponse,
+		opts...,
+	)
+	reconcileHandler := kithttp.NewServer(
+		traceEndpoint("reconcile")(makeReconcileEndpoint(s)),
+		decodeReconcileRequest,
+		encodeResponse,
+		opts...,
+	)
+	getAuditLogHandler := kithttp.NewServer(
+		traceEndpoint("get_audit_log")(makeGetAuditLogEndpoint(s)),
+		decodeGetAuditLogRequest,
+		encodeResponse,
+		opts...,
+	)
+	verifyAuditLogHandler := kithttp.NewServer(
+		traceEndpoint("verify_audit_log")(makeVerifyAuditLogEndpoint(s)),
+		decodeVerifyAuditLogRequest,
+		encodeResponse,
 		opts...,
 	)
 
 	r := mux.NewRouter()
+	r.Use(traceHTTP, withRequestID)
 
 	r.Handle("/transaction/v1/accounts", getAccountsHandler).Methods("GET")
+	r.Handle("/transaction/v1/accounts", createAccountHandler).Methods("POST")
+	r.Handle("/transaction/v1/accounts/{id}", getAccountHandler).Methods("GET")
+	r.Handle("/transaction/v1/accounts/{id}/statement", getAccountStatementHandler).Methods("GET")
+	r.Handle("/transaction/v1/accounts/{id}/events", streamAccountEventsHandler).Methods("GET")
+	r.Handle("/transaction/v1/accounts/{id}/payment-requests", getPaymentRequestsHandler).Methods("GET")
+	r.Handle("/transaction/v1/deposits", depositHandler).Methods("POST")
 	r.Handle("/transaction/v1/payments", getPaymentTransactionsHandler).Methods("GET")
 	r.Handle("/transaction/v1/payments", sendPaymentHandler).Methods("POST")
+	r.Handle("/transaction/v1/payment-requests", createPaymentRequestHandler).Methods("POST")
+	r.Handle("/transaction/v1/payment-requests/{id}/accept", acceptPaymentRequestHandler).Methods("POST")
+	r.Handle("/transaction/v1/payment-requests/{id}/decline", declinePaymentRequestHandler).Methods("POST")
+	r.Handle("/transaction/v1/payment-requests/{id}/cancel", cancelPaymentRequestHandler).Methods("POST")
+	r.Handle("/transaction/v1/exports/payments", exportPaymentsHandler).Methods("GET")
+	r.Handle("/transaction/v1/exports/deposits", exportDepositsHandler).Methods("GET")
+	r.Handle("/transaction/v1/exports/statements/{id}", exportStatementHandler).Methods("GET")
+
+	// admin
+	r.Handle("/transaction/v1/admin/accounts/{id}/freeze", freezeAccountHandler).Methods("POST")
+	r.Handle("/transaction/v1/admin/accounts/{id}/unfreeze", unfreezeAccountHandler).Methods("POST")
+	r.Handle("/transaction/v1/admin/accounts/{id}/close", closeAccountHandler).Methods("POST")
+	r.Handle("/transaction/v1/admin/accounts/{id}/status-changes", getAccountStatusChangesHandler).Methods("GET")
+	r.Handle("/transaction/v1/admin/imports", importAccountsHandler).Methods("POST")
+	r.Handle("/transaction/v1/admin/reconciliation", reconcileHandler).Methods("GET")
+	r.Handle("/transaction/v1/admin/audit-log", getAuditLogHandler).Methods("GET")
+	r.Handle("/transaction/v1/admin/audit-log/verification", verifyAuditLogHandler).Methods("GET")
+
+	r.HandleFunc("/transaction/v1/openapi.json", serveOpenAPISpec).Methods("GET")
 
 	return r
 }
 
-func decodeGetAccountsRequest(_ context.Context, _ *http.Request) (interface{}, error) {
-	return getAccountsRequest{}, nil
+// traceHTTP wraps matched requests in a server span named after their route, continuing the trace of the
+// traceparent header if given.
+func traceHTTP(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "http.server", otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+		if template, err := mux.CurrentRoute(r).GetPathTemplate(); err == nil {
+			return r.Method + " " + template
+		}
+		return r.Method
+	}))
+}
+
+// maxRequestIDLength bounds the X-Request-ID values accepted from clients, as they end up in every log line.
+const maxRequestIDLength = 128
+
+// withRequestID puts the X-Request-ID header, or a new id if the client sent none, into the request context for
+// logging, and echoes it back in the response.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" || len(id) > maxRequestIDLength {
+			id = uuid.New().String()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), id)))
+	})
+}
+
+// idempotencyKeyFromHTTPHeader moves the Idempotency-Key header into the request context for Service to pick up.
+func idempotencyKeyFromHTTPHeader(ctx context.Context, r *http.Request) context.Context {
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		return WithIdempotencyKey(ctx, key)
+	}
+	return ctx
+}
+
+// maxActorLength bounds the X-Actor values accepted from clients, as they end up in the audit log.
+const maxActorLength = 128
+
+// claimedActorFromHTTPHeader moves the X-Actor header into the request context for the audit log. The service does
+// not authenticate callers, so the header is recorded as a claim: it can only be trusted if a proxy in front of the
+// service sets it and strips it from client requests. Oversized values are ignored.
+func claimedActorFromHTTPHeader(ctx context.Context, r *http.Request) context.Context {
+	if actor := r.Header.Get("X-Actor"); actor != "" && len(actor) <= maxActorLength {
+		return WithClaimedActor(ctx, actor)
+	}
+	return ctx
+}
+
+func decodeCreateAccountRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var req createAccountRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRequestMalformed, err)
+	}
+
+	return req, nil
+}
+
+func decodeGetAccountsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	asOf, err := decodeAsOfQuery(r)
+	if err != nil {
+		return nil, err
+	}
+	return getAccountsRequest{AsOf: asOf}, nil
+}
+
+func decodeGetAccountRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	asOf, err := decodeAsOfQuery(r)
+	if err != nil {
+		return nil, err
+	}
+	return getAccountRequest{
+		Username:    mux.Vars(r)["id"],
+		AsOf:        asOf,
+		IfNoneMatch: r.Header.Get("If-None-Match"),
+	}, nil
+}
+
+// decodeAsOfQuery reads the optional as_of query parameter, and returns the zero time if it is absent.
+func decodeAsOfQuery(r *http.Request) (time.Time, error) {
+	v := r.URL.Query().Get("as_of")
+	if v == "" {
+		return time.Time{}, nil
+	}
+	asOf, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return time.Time{}, ErrAsOfInvalid
+	}
+	return asOf, nil
 }
 
-func decodeGetPaymentTransactionsRequest(_ context.Context, _ *http.Request) (interface{}, error) {
-	return getPaymentTransactionsRequest{}, nil
+func decodeGetAccountStatementRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return getAccountStatementRequest{Username: mux.Vars(r)["id"]}, nil
+}
+
+func decodeGetPaymentTransactionsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	filter := TransactionFilter{ExternalReference: r.URL.Query().Get("external_reference")}
+	return getPaymentTransactionsRequest{Filter: filter}, nil
+}
+
+func decodeDepositRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var req depositRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRequestMalformed, err)
+	}
+
+	return req, nil
 }
 
 func decodeSendPaymentRequest(_ context.Context, r *http.Request) (interface{}, error) {
@@ -62,13 +342,209 @@ func decodeSendPaymentRequest(_ context.Context, r *http.Request) (interface{},
 
 	var req sendPaymentRequest
 	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRequestMalformed, err)
+	}
+
+	return req, nil
+}
+
+func decodeCreatePaymentRequestRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		return nil, err
 	}
 
+	var req createPaymentRequestRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRequestMalformed, err)
+	}
+
 	return req, nil
 }
 
+func decodeGetPaymentRequestsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	query := r.URL.Query()
+	filter := PaymentRequestFilter{
+		Account:   mux.Vars(r)["id"],
+		Direction: query.Get("direction"),
+		Status:    query.Get("status"),
+	}
+	return getPaymentRequestsRequest{Filter: filter}, nil
+}
+
+// decodeRespondToPaymentRequestRequest reads who responds from the body. Ids that are not UUIDs cannot name any
+// payment request, so they are reported as not found.
+func decodeRespondToPaymentRequestRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		return nil, ErrPaymentRequestNotFound
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var req respondToPaymentRequestRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRequestMalformed, err)
+	}
+	req.Id = id
+
+	return req, nil
+}
+
+func decodeChangeAccountStatusRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var req changeAccountStatusRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRequestMalformed, err)
+	}
+	req.Username = mux.Vars(r)["id"]
+
+	return req, nil
+}
+
+func decodeGetAccountStatusChangesRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return getAccountStatusChangesRequest{Username: mux.Vars(r)["id"]}, nil
+}
+
+// decodeExportEntriesRequest reads the filter of an export of kind from the query, and picks its format from the
+// Accept header.
+func decodeExportEntriesRequest(kind string) kithttp.DecodeRequestFunc {
+	return func(_ context.Context, r *http.Request) (interface{}, error) {
+		format, err := negotiateExportFormat(r.Header.Get("Accept"))
+		if err != nil {
+			return nil, err
+		}
+
+		query := r.URL.Query()
+		filter := ExportFilter{Kind: kind, Account: query.Get("account")}
+		if kind == StatementExport {
+			filter.Account = mux.Vars(r)["id"]
+		}
+		if v := query.Get("since"); v != "" {
+			if filter.Since, err = time.Parse(time.RFC3339, v); err != nil {
+				return nil, ErrExportFilterInvalid
+			}
+		}
+		if v := query.Get("until"); v != "" {
+			if filter.Until, err = time.Parse(time.RFC3339, v); err != nil {
+				return nil, ErrExportFilterInvalid
+			}
+		}
+
+		return exportEntriesRequest{Filter: filter, Format: format}, nil
+	}
+}
+
+// negotiateExportFormat returns the first export format accepted by an Accept header value, and JSON Lines if
+// there is none. Quality values are not weighed.
+func negotiateExportFormat(accept string) (string, error) {
+	if strings.TrimSpace(accept) == "" {
+		return NDJSONFormat, nil
+	}
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(mediaRange)
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case NDJSONFormat, "application/*", "*/*":
+			return NDJSONFormat, nil
+		case CSVFormat, "text/*":
+			return CSVFormat, nil
+		}
+	}
+	return "", ErrExportFormatNotAcceptable
+}
+
+// decodeStreamAccountEventsRequest reads where to resume the stream from the Last-Event-ID header, which clients
+// send when they reconnect.
+func decodeStreamAccountEventsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	req := streamAccountEventsRequest{Username: mux.Vars(r)["id"]}
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		seq, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || seq < 0 {
+			return nil, ErrLastEventIdInvalid
+		}
+		req.LastEventId = null.IntFrom(seq)
+	}
+	return req, nil
+}
+
+// decodeImportAccountsRequest reads the rows in the format of the Content-Type header, and whether to only validate
+// them from the dry_run query parameter.
+func decodeImportAccountsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var dryRun bool
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("%w: dry_run %q is not a boolean", ErrImportMalformed, v)
+		}
+	}
+
+	format, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, ErrImportFormatUnsupported
+	}
+	rows, err := ReadImportRows(r.Body, format)
+	if err != nil {
+		return nil, err
+	}
+
+	return importAccountsRequest{Rows: rows, DryRun: dryRun}, nil
+}
+
+func decodeReconcileRequest(_ context.Context, _ *http.Request) (interface{}, error) {
+	return reconcileRequest{}, nil
+}
+
+func decodeGetAuditLogRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	query := r.URL.Query()
+	filter := AuditFilter{
+		ClaimedActor: query.Get("claimed_actor"),
+		Action:       query.Get("action"),
+		Account:      query.Get("account"),
+	}
+
+	var err error
+	if v := query.Get("since"); v != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, ErrAuditFilterInvalid
+		}
+	}
+	if v := query.Get("until"); v != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, ErrAuditFilterInvalid
+		}
+	}
+	if v := query.Get("after"); v != "" {
+		if filter.AfterSeq, err = strconv.ParseInt(v, 10, 64); err != nil || filter.AfterSeq < 0 {
+			return nil, ErrAuditFilterInvalid
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit < 0 {
+			return nil, ErrAuditFilterInvalid
+		}
+	}
+
+	return getAuditLogRequest{Filter: filter}, nil
+}
+
+func decodeVerifyAuditLogRequest(_ context.Context, _ *http.Request) (interface{}, error) {
+	return verifyAuditLogRequest{}, nil
+}
+
 func encodeResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
 	if e, ok := response.(errorer); ok && e.error() != nil {
 		encodeError(ctx, e.error(), w)
@@ -78,7 +554,23 @@ func encodeResponse(ctx context.Context, w http.ResponseWriter, response interfa
 	return json.NewEncoder(w).Encode(response)
 }
 
-func encodeSendPaymentResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+func encodeGetAccountResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	if e, ok := response.(errorer); ok && e.error() != nil {
+		encodeError(ctx, e.error(), w)
+		return nil
+	}
+	resp := response.(getAccountResponse)
+	w.Header().Set("ETag", resp.ETag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if resp.NotModified {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	return json.NewEncoder(w).Encode(response)
+}
+
+func encodeCreatedResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
 	if e, ok := response.(errorer); ok && e.error() != nil {
 		encodeError(ctx, e.error(), w)
 		return nil
@@ -88,14 +580,251 @@ func encodeSendPaymentResponse(ctx context.Context, w http.ResponseWriter, respo
 	return json.NewEncoder(w).Encode(response)
 }
 
+const (
+	// list of media types of bulk exports and imports
+	CSVFormat    = "text/csv"
+	NDJSONFormat = "application/x-ndjson"
+)
+
+// exportCSVHeader names the columns of CSV exports, in the order of exportedEntryCSVRecord.
+var exportCSVHeader = []string{
+	"entry_id", "transaction_id", "type", "account", "counterparty", "amount", "balance", "created_at",
+}
+
+// encodeExportEntriesResponse writes each entry out as soon as it is read. The status line is held back until the
+// first entry, so that errors before it get a regular error response; a failure past that point aborts the
+// response, which leaves clients with a truncated body instead of one that looks complete.
+func encodeExportEntriesResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	if e, ok := response.(errorer); ok && e.error() != nil {
+		encodeError(ctx, e.error(), w)
+		return nil
+	}
+	resp := response.(exportEntriesResponse)
+
+	var csvWriter *csv.Writer
+	var jsonEncoder *json.Encoder
+	started := false
+	start := func() error {
+		started = true
+		w.Header().Set("Content-Type", resp.Format+"; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		if resp.Format == CSVFormat {
+			csvWriter = csv.NewWriter(w)
+			return csvWriter.Write(exportCSVHeader)
+		}
+		jsonEncoder = json.NewEncoder(w)
+		return nil
+	}
+
+	err := resp.Stream(func(entry ExportedEntry) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		if csvWriter != nil {
+			return csvWriter.Write(exportedEntryCSVRecord(entry))
+		}
+		return jsonEncoder.Encode(entry)
+	})
+	if err == nil && !started {
+		err = start()
+	}
+	if err == nil && csvWriter != nil {
+		csvWriter.Flush()
+		err = csvWriter.Error()
+	}
+
+	if err != nil {
+		if !started {
+			encodeError(ctx, err, w)
+			return nil
+		}
+		panic(http.ErrAbortHandler)
+	}
+	return nil
+}
+
+// eventStreamFormat is the media type of server-sent events.
+const eventStreamFormat = "text/event-stream"
+
+// sseHeartbeatInterval is how often event streams send a comment, so that idle streams are not taken for dead by
+// proxies and clients.
+var sseHeartbeatInterval = 15 * time.Second
+
+// encodeStreamAccountEventsResponse writes each event out as a server-sent event as soon as it is committed, with
+// its Seq as event id, and a comment as heartbeat every sseHeartbeatInterval. As with exports, the status line is
+// held back until the first event or heartbeat, so that errors before it get a regular error response, while
+// later failures abort the response.
+func encodeStreamAccountEventsResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	if e, ok := response.(errorer); ok && e.error() != nil {
+		encodeError(ctx, e.error(), w)
+		return nil
+	}
+	resp := response.(streamAccountEventsResponse)
+
+	// events are written from this goroutine only, in between heartbeats
+	events := make(chan AccountEvent)
+	done := make(chan error, 1)
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		done <- resp.Stream(func(event AccountEvent) error {
+			select {
+			case events <- event:
+				return nil
+			case <-stopped:
+				return http.ErrAbortHandler
+			}
+		})
+	}()
+
+	started := false
+	start := func() {
+		started = true
+		w.Header().Set("Content-Type", eventStreamFormat)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no") // for nginx
+		w.WriteHeader(http.StatusOK)
+	}
+	flusher, _ := w.(http.Flusher)
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		var err error
+		select {
+		case event := <-events:
+			if !started {
+				start()
+			}
+			err = writeAccountEvent(w, event)
+		case <-heartbeat.C:
+			if !started {
+				start()
+			}
+			_, err = io.WriteString(w, ": heartbeat\n\n")
+		case err = <-done:
+			if err == nil {
+				if !started {
+					start()
+				}
+				return nil
+			}
+			if !started {
+				encodeError(ctx, err, w)
+				return nil
+			}
+		}
+		if err != nil {
+			panic(http.ErrAbortHandler)
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+// encodeImportAccountsResponse answers 201 for imports and 200 for dry runs. The report of invalid rows comes along
+// with the error.
+func encodeImportAccountsResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	resp := response.(importAccountsResponse)
+	if resp.Err != nil && resp.Report == nil {
+		encodeError(ctx, resp.Err, w)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if resp.Err != nil {
+		w.WriteHeader(codeFrom(resp.Err))
+		return json.NewEncoder(w).Encode(map[string]interface{}{
+			"report": resp.Report,
+			"error":  resp.Err.Error(),
+		})
+	}
+	if !resp.Report.DryRun {
+		w.WriteHeader(http.StatusCreated)
+	}
+	return json.NewEncoder(w).Encode(resp)
+}
+
 type errorer interface {
 	error() error
 }
 
 func encodeError(_ context.Context, err error, w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(http.StatusInternalServerError)
+	w.WriteHeader(codeFrom(err))
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"error": err.Error(),
 	})
 }
+
+// --- helpers
+
+func exportedEntryCSVRecord(entry ExportedEntry) []string {
+	balance := ""
+	if entry.Balance.Valid {
+		balance = entry.Balance.Decimal.String()
+	}
+	return []string{
+		entry.EntryId.String(),
+		entry.TransactionId.String(),
+		entry.TransactionName,
+		entry.Account,
+		entry.Counterparty.String,
+		entry.Amount.String(),
+		balance,
+		entry.CreatedAt.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+func writeAccountEvent(w io.Writer, event AccountEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", event.Seq, data)
+	return err
+}
+
+// codeFrom maps domain errors to HTTP status codes. Anything unknown is treated as a server fault.
+func codeFrom(err error) int {
+	switch {
+	case errors.Is(err, ErrAccountNotFound),
+		errors.Is(err, ErrPaymentRequestNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrRequestMalformed),
+		errors.Is(err, ErrCreditAmountInvalid),
+		errors.Is(err, ErrPaymentSenderReceiverIdentical),
+		errors.Is(err, ErrAccountStatusReasonMissing),
+		errors.Is(err, ErrAuditFilterInvalid),
+		errors.Is(err, ErrAsOfInvalid),
+		errors.Is(err, ErrExportFilterInvalid),
+		errors.Is(err, ErrImportMalformed),
+		errors.Is(err, ErrLastEventIdInvalid),
+		errors.Is(err, ErrPaymentDetailsTooLarge),
+		errors.Is(err, ErrPaymentRequestExpiryInvalid),
+		errors.Is(err, ErrPaymentRequestFilterInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrExportFormatNotAcceptable):
+		return http.StatusNotAcceptable
+	case errors.Is(err, ErrImportFormatUnsupported):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, ErrImportRowsInvalid):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ErrBalanceInsufficient),
+		errors.Is(err, ErrAccountFrozen),
+		errors.Is(err, ErrAccountClosed),
+		errors.Is(err, ErrAccountStatusTransitionInvalid),
+		errors.Is(err, ErrAccountBalanceNotZero),
+		errors.Is(err, ErrAccountAlreadyExists),
+		errors.Is(err, ErrIdempotencyKeyReused),
+		errors.Is(err, ErrExternalReferenceReused),
+		errors.Is(err, ErrPaymentRequestNotPending),
+		errors.Is(err, ErrPaymentRequestExpired):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,482 @@
+package transaction
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	kithttp "github.com/go-kit/kit/transport/http"
+)
+
+// MakeClientEndpoints returns Endpoints that call a remote instance of the /transaction/v1 HTTP API,
+// e.g. "localhost:8080" or "https://wallet.internal".
+func MakeClientEndpoints(instance string, options ...kithttp.ClientOption) (Endpoints, error) {
+	if !strings.HasPrefix(instance, "http") {
+		instance = "http://" + instance
+	}
+	tgt, err := url.Parse(instance)
+	if err != nil {
+		return Endpoints{}, err
+	}
+	tgt.Path = ""
+
+	options = append([]kithttp.ClientOption{kithttp.ClientBefore(idempotencyKeyToHTTPHeader, claimedActorToHTTPHeader)}, options...)
+
+	return Endpoints{
+		CreateAccountEndpoint: kithttp.NewClient(
+			"POST", tgt, encodeHTTPClientRequest("/transaction/v1/accounts"), decodeCreateAccountResponse, options...,
+		).Endpoint(),
+		GetAccountsEndpoint: kithttp.NewClient(
+			"GET", tgt, encodeGetAccountsRequest, decodeGetAccountsResponse, options...,
+		).Endpoint(),
+		GetAccountEndpoint: kithttp.NewClient(
+			"GET", tgt, encodeGetAccountRequest, decodeGetAccountResponse, options...,
+		).Endpoint(),
+		GetPaymentTransactionsEndpoint: kithttp.NewClient(
+			"GET", tgt, encodeGetPaymentTransactionsRequest, decodeGetPaymentTransactionsResponse, options...,
+		).Endpoint(),
+		DepositEndpoint: kithttp.NewClient(
+			"POST", tgt, encodeHTTPClientRequest("/transaction/v1/deposits"), decodeDepositResponse, options...,
+		).Endpoint(),
+		SendPaymentEndpoint: kithttp.NewClient(
+			"POST", tgt, encodeHTTPClientRequest("/transaction/v1/payments"), decodeSendPaymentResponse, options...,
+		).Endpoint(),
+		CreatePaymentRequestEndpoint: kithttp.NewClient(
+			"POST", tgt, encodeHTTPClientRequest("/transaction/v1/payment-requests"), decodePaymentRequestResponse, options...,
+		).Endpoint(),
+		GetPaymentRequestsEndpoint: kithttp.NewClient(
+			"GET", tgt, encodeGetPaymentRequestsRequest, decodeGetPaymentRequestsResponse, options...,
+		).Endpoint(),
+		AcceptPaymentRequestEndpoint: kithttp.NewClient(
+			"POST", tgt, encodeRespondToPaymentRequestRequest("accept"), decodePaymentRequestResponse, options...,
+		).Endpoint(),
+		DeclinePaymentRequestEndpoint: kithttp.NewClient(
+			"POST", tgt, encodeRespondToPaymentRequestRequest("decline"), decodePaymentRequestResponse, options...,
+		).Endpoint(),
+		CancelPaymentRequestEndpoint: kithttp.NewClient(
+			"POST", tgt, encodeRespondToPaymentRequestRequest("cancel"), decodePaymentRequestResponse, options...,
+		).Endpoint(),
+		FreezeAccountEndpoint: kithttp.NewClient(
+			"POST", tgt, encodeChangeAccountStatusRequest("freeze"), decodeChangeAccountStatusResponse, options...,
+		).Endpoint(),
+		UnfreezeAccountEndpoint: kithttp.NewClient(
+			"POST", tgt, encodeChangeAccountStatusRequest("unfreeze"), decodeChangeAccountStatusResponse, options...,
+		).Endpoint(),
+		CloseAccountEndpoint: kithttp.NewClient(
+			"POST", tgt, encodeChangeAccountStatusRequest("close"), decodeChangeAccountStatusResponse, options...,
+		).Endpoint(),
+		GetAccountStatusChangesEndpoint: kithttp.NewClient(
+			"GET", tgt, encodeGetAccountStatusChangesRequest, decodeGetAccountStatusChangesResponse, options...,
+		).Endpoint(),
+		GetAccountStatementEndpoint: kithttp.NewClient(
+			"GET", tgt, encodeGetAccountStatementRequest, decodeGetAccountStatementResponse, options...,
+		).Endpoint(),
+		ExportEntriesEndpoint: kithttp.NewClient(
+			"GET", tgt, encodeExportEntriesRequest, decodeExportEntriesResponse,
+			append(options[:len(options):len(options)], kithttp.BufferedStream(true))...,
+		).Endpoint(),
+		StreamAccountEventsEndpoint: kithttp.NewClient(
+			"GET", tgt, encodeStreamAccountEventsRequest, decodeStreamAccountEventsResponse,
+			append(options[:len(options):len(options)], kithttp.BufferedStream(true))...,
+		).Endpoint(),
+		ImportAccountsEndpoint: kithttp.NewClient(
+			"POST", tgt, encodeImportAccountsRequest, decodeImportAccountsResponse, options...,
+		).Endpoint(),
+		ReconcileEndpoint: kithttp.NewClient(
+			"GET", tgt, encodeHTTPClientRequest("/transaction/v1/admin/reconciliation"), decodeReconcileResponse, options...,
+		).Endpoint(),
+		GetAuditLogEndpoint: kithttp.NewClient(
+			"GET", tgt, encodeGetAuditLogRequest, decodeGetAuditLogResponse, options...,
+		).Endpoint(),
+		VerifyAuditLogEndpoint: kithttp.NewClient(
+			"GET", tgt, encodeHTTPClientRequest("/transaction/v1/admin/audit-log/verification"), decodeVerifyAuditLogResponse, options...,
+		).Endpoint(),
+	}, nil
+}
+
+// idempotencyKeyToHTTPHeader is the client-side counterpart of idempotencyKeyFromHTTPHeader.
+func idempotencyKeyToHTTPHeader(ctx context.Context, r *http.Request) context.Context {
+	if key := IdempotencyKeyFrom(ctx); key != "" {
+		r.Header.Set("Idempotency-Key", key)
+	}
+	return ctx
+}
+
+// claimedActorToHTTPHeader is the client-side counterpart of claimedActorFromHTTPHeader.
+func claimedActorToHTTPHeader(ctx context.Context, r *http.Request) context.Context {
+	if actor, _ := ctx.Value(claimedActorContextKey).(string); actor != "" {
+		r.Header.Set("X-Actor", actor)
+	}
+	return ctx
+}
+
+// encodeHTTPClientRequest sends requests to path, with the request as JSON body for methods other than GET.
+func encodeHTTPClientRequest(path string) kithttp.EncodeRequestFunc {
+	return func(_ context.Context, r *http.Request, request interface{}) error {
+		r.URL.Path = path
+		if r.Method == http.MethodGet {
+			return nil
+		}
+		return encodeJSONBody(r, request)
+	}
+}
+
+func encodeGetAccountsRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(getAccountsRequest)
+	r.URL.Path = "/transaction/v1/accounts"
+	r.URL.RawQuery = encodeAsOfQuery(req.AsOf)
+	return nil
+}
+
+func encodeGetAccountRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(getAccountRequest)
+	r.URL.Path = "/transaction/v1/accounts/" + url.PathEscape(req.Username)
+	r.URL.RawQuery = encodeAsOfQuery(req.AsOf)
+	return nil
+}
+
+func encodeGetPaymentTransactionsRequest(_ context.Context, r *http.Request, request interface{}) error {
+	filter := request.(getPaymentTransactionsRequest).Filter
+	r.URL.Path = "/transaction/v1/payments"
+	if filter.ExternalReference != "" {
+		r.URL.RawQuery = url.Values{"external_reference": {filter.ExternalReference}}.Encode()
+	}
+	return nil
+}
+
+// encodeAsOfQuery is the client-side counterpart of decodeAsOfQuery.
+func encodeAsOfQuery(asOf time.Time) string {
+	if asOf.IsZero() {
+		return ""
+	}
+	return url.Values{"as_of": {asOf.Format(time.RFC3339Nano)}}.Encode()
+}
+
+func encodeGetPaymentRequestsRequest(_ context.Context, r *http.Request, request interface{}) error {
+	filter := request.(getPaymentRequestsRequest).Filter
+	r.URL.Path = "/transaction/v1/accounts/" + url.PathEscape(filter.Account) + "/payment-requests"
+
+	query := url.Values{}
+	if filter.Direction != "" {
+		query.Set("direction", filter.Direction)
+	}
+	if filter.Status != "" {
+		query.Set("status", filter.Status)
+	}
+	r.URL.RawQuery = query.Encode()
+	return nil
+}
+
+func encodeRespondToPaymentRequestRequest(action string) kithttp.EncodeRequestFunc {
+	return func(_ context.Context, r *http.Request, request interface{}) error {
+		req := request.(respondToPaymentRequestRequest)
+		r.URL.Path = "/transaction/v1/payment-requests/" + req.Id.String() + "/" + action
+		return encodeJSONBody(r, req)
+	}
+}
+
+func encodeChangeAccountStatusRequest(action string) kithttp.EncodeRequestFunc {
+	return func(_ context.Context, r *http.Request, request interface{}) error {
+		req := request.(changeAccountStatusRequest)
+		r.URL.Path = "/transaction/v1/admin/accounts/" + url.PathEscape(req.Username) + "/" + action
+		return encodeJSONBody(r, req)
+	}
+}
+
+func encodeGetAccountStatusChangesRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(getAccountStatusChangesRequest)
+	r.URL.Path = "/transaction/v1/admin/accounts/" + url.PathEscape(req.Username) + "/status-changes"
+	return nil
+}
+
+func encodeGetAccountStatementRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(getAccountStatementRequest)
+	r.URL.Path = "/transaction/v1/accounts/" + url.PathEscape(req.Username) + "/statement"
+	return nil
+}
+
+func encodeExportEntriesRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(exportEntriesRequest)
+	filter := req.Filter
+
+	query := url.Values{}
+	if filter.Kind == StatementExport {
+		r.URL.Path = "/transaction/v1/exports/statements/" + url.PathEscape(filter.Account)
+	} else {
+		r.URL.Path = "/transaction/v1/exports/" + url.PathEscape(filter.Kind) + "s"
+		if filter.Account != "" {
+			query.Set("account", filter.Account)
+		}
+	}
+	if !filter.Since.IsZero() {
+		query.Set("since", filter.Since.Format(time.RFC3339Nano))
+	}
+	if !filter.Until.IsZero() {
+		query.Set("until", filter.Until.Format(time.RFC3339Nano))
+	}
+	r.URL.RawQuery = query.Encode()
+	r.Header.Set("Accept", req.Format)
+	return nil
+}
+
+func encodeStreamAccountEventsRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(streamAccountEventsRequest)
+	r.URL.Path = "/transaction/v1/accounts/" + url.PathEscape(req.Username) + "/events"
+	r.Header.Set("Accept", eventStreamFormat)
+	if req.LastEventId.Valid {
+		r.Header.Set("Last-Event-ID", strconv.FormatInt(req.LastEventId.Int64, 10))
+	}
+	return nil
+}
+
+// encodeImportAccountsRequest sends the rows as JSON Lines.
+func encodeImportAccountsRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(importAccountsRequest)
+	r.URL.Path = "/transaction/v1/admin/imports"
+	if req.DryRun {
+		r.URL.RawQuery = url.Values{"dry_run": {"true"}}.Encode()
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, row := range req.Rows {
+		if err := encoder.Encode(row); err != nil {
+			return err
+		}
+	}
+	r.Header.Set("Content-Type", NDJSONFormat)
+	r.ContentLength = int64(buf.Len())
+	r.Body = ioutil.NopCloser(&buf)
+	return nil
+}
+
+func encodeGetAuditLogRequest(_ context.Context, r *http.Request, request interface{}) error {
+	filter := request.(getAuditLogRequest).Filter
+	r.URL.Path = "/transaction/v1/admin/audit-log"
+
+	query := url.Values{}
+	if filter.ClaimedActor != "" {
+		query.Set("claimed_actor", filter.ClaimedActor)
+	}
+	if filter.Action != "" {
+		query.Set("action", filter.Action)
+	}
+	if filter.Account != "" {
+		query.Set("account", filter.Account)
+	}
+	if !filter.Since.IsZero() {
+		query.Set("since", filter.Since.Format(time.RFC3339))
+	}
+	if !filter.Until.IsZero() {
+		query.Set("until", filter.Until.Format(time.RFC3339))
+	}
+	if filter.AfterSeq > 0 {
+		query.Set("after", strconv.FormatInt(filter.AfterSeq, 10))
+	}
+	if filter.Limit > 0 {
+		query.Set("limit", strconv.Itoa(filter.Limit))
+	}
+	r.URL.RawQuery = query.Encode()
+	return nil
+}
+
+func decodeCreateAccountResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var resp createAccountResponse
+	return resp, decodeHTTPClientResponse(r, &resp)
+}
+
+func decodeGetAccountsResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var resp getAccountsResponse
+	return resp, decodeHTTPClientResponse(r, &resp)
+}
+
+func decodeGetAccountResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var resp getAccountResponse
+	return resp, decodeHTTPClientResponse(r, &resp)
+}
+
+func decodeGetPaymentTransactionsResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var resp getPaymentTransactionsResponse
+	return resp, decodeHTTPClientResponse(r, &resp)
+}
+
+func decodeDepositResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var resp depositResponse
+	return resp, decodeHTTPClientResponse(r, &resp)
+}
+
+func decodeSendPaymentResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var resp sendPaymentResponse
+	return resp, decodeHTTPClientResponse(r, &resp)
+}
+
+func decodePaymentRequestResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var resp paymentRequestResponse
+	return resp, decodeHTTPClientResponse(r, &resp)
+}
+
+func decodeGetPaymentRequestsResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var resp getPaymentRequestsResponse
+	return resp, decodeHTTPClientResponse(r, &resp)
+}
+
+func decodeChangeAccountStatusResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var resp changeAccountStatusResponse
+	return resp, decodeHTTPClientResponse(r, &resp)
+}
+
+func decodeGetAccountStatusChangesResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var resp getAccountStatusChangesResponse
+	return resp, decodeHTTPClientResponse(r, &resp)
+}
+
+func decodeGetAccountStatementResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var resp getAccountStatementResponse
+	return resp, decodeHTTPClientResponse(r, &resp)
+}
+
+// decodeExportEntriesResponse leaves the body open for Stream to read the entries from, one line at a time.
+func decodeExportEntriesResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		defer r.Body.Close()
+		return nil, decodeHTTPClientError(r)
+	}
+
+	stream := func(fn func(ExportedEntry) error) error {
+		defer r.Body.Close()
+
+		decoder := json.NewDecoder(r.Body)
+		for {
+			var entry ExportedEntry
+			if err := decoder.Decode(&entry); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+	}
+	return exportEntriesResponse{Format: NDJSONFormat, Stream: stream}, nil
+}
+
+// decodeStreamAccountEventsResponse leaves the body open for Stream to read the events from as they arrive,
+// skipping heartbeats.
+func decodeStreamAccountEventsResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		defer r.Body.Close()
+		return nil, decodeHTTPClientError(r)
+	}
+
+	stream := func(fn func(AccountEvent) error) error {
+		defer r.Body.Close()
+
+		var data []byte
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			line := scanner.Bytes()
+			switch {
+			case len(line) == 0 && len(data) > 0:
+				var event AccountEvent
+				if err := json.Unmarshal(data, &event); err != nil {
+					return err
+				}
+				if err := fn(event); err != nil {
+					return err
+				}
+				data = data[:0]
+			case bytes.HasPrefix(line, []byte("data:")):
+				data = append(data, bytes.TrimPrefix(bytes.TrimPrefix(line, []byte("data:")), []byte(" "))...)
+			}
+		}
+		return scanner.Err()
+	}
+	return streamAccountEventsResponse{Stream: stream}, nil
+}
+
+// decodeImportAccountsResponse keeps the report that comes along with ErrImportRowsInvalid.
+func decodeImportAccountsResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusUnprocessableEntity {
+		var resp importAccountsResponse
+		return resp, decodeHTTPClientResponse(r, &resp)
+	}
+
+	var body struct {
+		Report *ImportReport `json:"report"`
+		Error  string        `json:"error"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	if body.Error != ErrImportRowsInvalid.Error() {
+		return nil, &ResponseError{StatusCode: r.StatusCode, Message: body.Error}
+	}
+	return importAccountsResponse{Report: body.Report, Err: ErrImportRowsInvalid}, nil
+}
+
+func decodeReconcileResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var resp reconcileResponse
+	return resp, decodeHTTPClientResponse(r, &resp)
+}
+
+func decodeGetAuditLogResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var resp getAuditLogResponse
+	return resp, decodeHTTPClientResponse(r, &resp)
+}
+
+func decodeVerifyAuditLogResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var resp verifyAuditLogResponse
+	return resp, decodeHTTPClientResponse(r, &resp)
+}
+
+// decodeHTTPClientResponse decodes a successful response into resp, or turns a failed one back into the
+// domain error that encodeError produced it from.
+func decodeHTTPClientResponse(r *http.Response, resp interface{}) error {
+	if r.StatusCode >= http.StatusBadRequest {
+		return decodeHTTPClientError(r)
+	}
+	return json.NewDecoder(r.Body).Decode(resp)
+}
+
+func decodeHTTPClientError(r *http.Response) error {
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Error == "" {
+		return &ResponseError{StatusCode: r.StatusCode, Message: http.StatusText(r.StatusCode)}
+	}
+
+	for _, err := range domainErrors {
+		if err.Error() == body.Error {
+			return err
+		}
+		// domain errors wrapped with details by the server, e.g. ErrRequestMalformed
+		if detail := strings.TrimPrefix(body.Error, err.Error()+": "); detail != body.Error {
+			return fmt.Errorf("%w: %s", err, detail)
+		}
+	}
+	return &ResponseError{StatusCode: r.StatusCode, Message: body.Error}
+}
+
+// --- helpers
+
+func encodeJSONBody(r *http.Request, request interface{}) error {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(request); err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	r.ContentLength = int64(buf.Len())
+	r.Body = ioutil.NopCloser(&buf)
+	return nil
+}
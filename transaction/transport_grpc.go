@@ -0,0 +1,387 @@
+package transaction
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/go-kit/kit/transport"
+	grpctransport "github.com/go-kit/kit/transport/grpc"
+	"github.com/go-kit/log"
+	"github.com/shopspring/decimal"
+	"go.opentelemetry.io/otel"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gopkg.in/guregu/null.v4"
+
+	"github.com/nogurenn/cph-wallet/transaction/pb"
+)
+
+type grpcServer struct {
+	pb.UnimplementedTransactionServiceServer
+
+	createAccount          grpctransport.Handler
+	getAccounts            grpctransport.Handler
+	getAccount             grpctransport.Handler
+	deposit                grpctransport.Handler
+	getPaymentTransactions grpctransport.Handler
+	sendPayment            grpctransport.Handler
+}
+
+func MakeGRPCServer(s Service, logger log.Logger) pb.TransactionServiceServer {
+	opts := []grpctransport.ServerOption{
+		grpctransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
+		grpctransport.ServerBefore(traceContextFromGRPCMetadata, idempotencyKeyFromGRPCMetadata, claimedActorFromGRPCMetadata),
+	}
+
+	return &grpcServer{
+		createAccount: grpctransport.NewServer(
+			traceEndpoint("create_account")(makeCreateAccountEndpoint(s)),
+			decodeGRPCCreateAccountRequest,
+			encodeGRPCCreateAccountResponse,
+			opts...,
+		),
+		getAccounts: grpctransport.NewServer(
+			traceEndpoint("get_accounts")(makeGetAccountsEndpoint(s)),
+			decodeGRPCGetAccountsRequest,
+			encodeGRPCGetAccountsResponse,
+			opts...,
+		),
+		getAccount: grpctransport.NewServer(
+			traceEndpoint("get_account")(makeGetAccountEndpoint(s)),
+			decodeGRPCGetAccountRequest,
+			encodeGRPCGetAccountResponse,
+			opts...,
+		),
+		deposit: grpctransport.NewServer(
+			traceEndpoint("deposit")(makeDepositEndpoint(s)),
+			decodeGRPCDepositRequest,
+			encodeGRPCDepositResponse,
+			opts...,
+		),
+		getPaymentTransactions: grpctransport.NewServer(
+			traceEndpoint("get_payment_transactions")(makeGetPaymentTransactionsEndpoint(s)),
+			decodeGRPCGetPaymentTransactionsRequest,
+			encodeGRPCGetPaymentTransactionsResponse,
+			opts...,
+		),
+		sendPayment: grpctransport.NewServer(
+			traceEndpoint("send_payment")(makeSendPaymentEndpoint(s)),
+			decodeGRPCSendPaymentRequest,
+			encodeGRPCSendPaymentResponse,
+			opts...,
+		),
+	}
+}
+
+func (s *grpcServer) CreateAccount(ctx context.Context, req *pb.CreateAccountRequest) (*pb.CreateAccountResponse, error) {
+	_, resp, err := s.createAccount.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.CreateAccountResponse), nil
+}
+
+func (s *grpcServer) GetAccounts(ctx context.Context, req *pb.GetAccountsRequest) (*pb.GetAccountsResponse, error) {
+	_, resp, err := s.getAccounts.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.GetAccountsResponse), nil
+}
+
+func (s *grpcServer) GetAccount(ctx context.Context, req *pb.GetAccountRequest) (*pb.GetAccountResponse, error) {
+	_, resp, err := s.getAccount.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.GetAccountResponse), nil
+}
+
+func (s *grpcServer) Deposit(ctx context.Context, req *pb.DepositRequest) (*pb.DepositResponse, error) {
+	_, resp, err := s.deposit.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.DepositResponse), nil
+}
+
+func (s *grpcServer) GetPaymentTransactions(ctx context.Context, req *pb.GetPaymentTransactionsRequest) (*pb.GetPaymentTransactionsResponse, error) {
+	_, resp, err := s.getPaymentTransactions.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.GetPaymentTransactionsResponse), nil
+}
+
+func (s *grpcServer) SendPayment(ctx context.Context, req *pb.SendPaymentRequest) (*pb.SendPaymentResponse, error) {
+	_, resp, err := s.sendPayment.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.(*pb.SendPaymentResponse), nil
+}
+
+// traceContextFromGRPCMetadata continues the trace of the traceparent metadata, if given.
+func traceContextFromGRPCMetadata(ctx context.Context, md metadata.MD) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, grpcMetadataCarrier(md))
+}
+
+// grpcMetadataCarrier adapts metadata.MD to propagation.TextMapCarrier.
+type grpcMetadataCarrier metadata.MD
+
+func (c grpcMetadataCarrier) Get(key string) string {
+	if values := metadata.MD(c).Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (c grpcMetadataCarrier) Set(key string, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c grpcMetadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// idempotencyKeyFromGRPCMetadata moves the idempotency-key metadata into the request context for Service to pick up.
+func idempotencyKeyFromGRPCMetadata(ctx context.Context, md metadata.MD) context.Context {
+	if keys := md.Get("idempotency-key"); len(keys) > 0 && keys[0] != "" {
+		return WithIdempotencyKey(ctx, keys[0])
+	}
+	return ctx
+}
+
+// claimedActorFromGRPCMetadata moves the x-actor metadata into the request context for the audit log, as a claim like
+// the X-Actor header.
+func claimedActorFromGRPCMetadata(ctx context.Context, md metadata.MD) context.Context {
+	if actors := md.Get("x-actor"); len(actors) > 0 && actors[0] != "" && len(actors[0]) <= maxActorLength {
+		return WithClaimedActor(ctx, actors[0])
+	}
+	return ctx
+}
+
+func decodeGRPCCreateAccountRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.CreateAccountRequest)
+	return createAccountRequest{Username: req.Username}, nil
+}
+
+func decodeGRPCGetAccountsRequest(_ context.Context, _ interface{}) (interface{}, error) {
+	return getAccountsRequest{}, nil
+}
+
+func decodeGRPCGetAccountRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.GetAccountRequest)
+	return getAccountRequest{Username: req.Id}, nil
+}
+
+func decodeGRPCDepositRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.DepositRequest)
+	amount, err := decodeGRPCAmount(req.Amount)
+	if err != nil {
+		return nil, err
+	}
+	return depositRequest{Username: req.Username, Amount: amount}, nil
+}
+
+func decodeGRPCGetPaymentTransactionsRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.GetPaymentTransactionsRequest)
+	return getPaymentTransactionsRequest{Filter: TransactionFilter{ExternalReference: req.ExternalReference}}, nil
+}
+
+func decodeGRPCSendPaymentRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.SendPaymentRequest)
+	amount, err := decodeGRPCAmount(req.Amount)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := decodeGRPCMetadata(req.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	return sendPaymentRequest{
+		Username:       req.Username,
+		TargetUsername: req.TargetUsername,
+		Amount:         amount,
+		PaymentDetails: PaymentDetails{
+			Description:       null.NewString(req.Description, req.Description != ""),
+			ExternalReference: null.NewString(req.ExternalReference, req.ExternalReference != ""),
+			Metadata:          metadata,
+		},
+	}, nil
+}
+
+func encodeGRPCCreateAccountResponse(_ context.Context, response interface{}) (interface{}, error) {
+	if e, ok := response.(errorer); ok && e.error() != nil {
+		return nil, encodeGRPCError(e.error())
+	}
+	return &pb.CreateAccountResponse{}, nil
+}
+
+func encodeGRPCGetAccountsResponse(_ context.Context, response interface{}) (interface{}, error) {
+	if e, ok := response.(errorer); ok && e.error() != nil {
+		return nil, encodeGRPCError(e.error())
+	}
+	resp := response.(getAccountsResponse)
+
+	accounts := []*pb.Account{}
+	for _, account := range resp.Accounts {
+		accounts = append(accounts, mapAccountDetailsToGRPC(mapAccountToAccountDetails(account)))
+	}
+	return &pb.GetAccountsResponse{Accounts: accounts}, nil
+}
+
+func encodeGRPCGetAccountResponse(_ context.Context, response interface{}) (interface{}, error) {
+	if e, ok := response.(errorer); ok && e.error() != nil {
+		return nil, encodeGRPCError(e.error())
+	}
+	resp := response.(getAccountResponse)
+	return &pb.GetAccountResponse{Account: mapAccountDetailsToGRPC(*resp.Account)}, nil
+}
+
+func encodeGRPCDepositResponse(_ context.Context, response interface{}) (interface{}, error) {
+	if e, ok := response.(errorer); ok && e.error() != nil {
+		return nil, encodeGRPCError(e.error())
+	}
+	return &pb.DepositResponse{}, nil
+}
+
+func encodeGRPCGetPaymentTransactionsResponse(_ context.Context, response interface{}) (interface{}, error) {
+	if e, ok := response.(errorer); ok && e.error() != nil {
+		return nil, encodeGRPCError(e.error())
+	}
+	resp := response.(getPaymentTransactionsResponse)
+
+	payments := []*pb.Payment{}
+	for _, payment := range resp.Payments {
+		p, err := mapPaymentToGRPC(payment)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, p)
+	}
+	return &pb.GetPaymentTransactionsResponse{Payments: payments}, nil
+}
+
+func encodeGRPCSendPaymentResponse(_ context.Context, response interface{}) (interface{}, error) {
+	if e, ok := response.(errorer); ok && e.error() != nil {
+		return nil, encodeGRPCError(e.error())
+	}
+	return &pb.SendPaymentResponse{}, nil
+}
+
+// encodeGRPCError maps domain errors to gRPC status errors, mirroring codeFrom for HTTP.
+func encodeGRPCError(err error) error {
+	return status.Error(grpcCodeFrom(err), err.Error())
+}
+
+func grpcCodeFrom(err error) codes.Code {
+	switch {
+	case errors.Is(err, ErrAccountNotFound),
+		errors.Is(err, ErrPaymentRequestNotFound):
+		return codes.NotFound
+	case errors.Is(err, ErrRequestMalformed),
+		errors.Is(err, ErrCreditAmountInvalid),
+		errors.Is(err, ErrPaymentSenderReceiverIdentical),
+		errors.Is(err, ErrAccountStatusReasonMissing),
+		errors.Is(err, ErrAuditFilterInvalid),
+		errors.Is(err, ErrAsOfInvalid),
+		errors.Is(err, ErrExportFilterInvalid),
+		errors.Is(err, ErrExportFormatNotAcceptable),
+		errors.Is(err, ErrImportMalformed),
+		errors.Is(err, ErrImportFormatUnsupported),
+		errors.Is(err, ErrImportRowsInvalid),
+		errors.Is(err, ErrLastEventIdInvalid),
+		errors.Is(err, ErrPaymentDetailsTooLarge),
+		errors.Is(err, ErrPaymentRequestExpiryInvalid),
+		errors.Is(err, ErrPaymentRequestFilterInvalid):
+		return codes.InvalidArgument
+	case errors.Is(err, ErrBalanceInsufficient),
+		errors.Is(err, ErrAccountFrozen),
+		errors.Is(err, ErrAccountClosed),
+		errors.Is(err, ErrAccountStatusTransitionInvalid),
+		errors.Is(err, ErrAccountBalanceNotZero),
+		errors.Is(err, ErrIdempotencyKeyReused),
+		errors.Is(err, ErrPaymentRequestNotPending),
+		errors.Is(err, ErrPaymentRequestExpired):
+		return codes.FailedPrecondition
+	case errors.Is(err, ErrAccountAlreadyExists),
+		errors.Is(err, ErrExternalReferenceReused):
+		return codes.AlreadyExists
+	default:
+		return codes.Internal
+	}
+}
+
+// --- helpers
+
+func decodeGRPCAmount(amount string) (decimal.Decimal, error) {
+	d, err := decimal.NewFromString(amount)
+	if err != nil {
+		return decimal.Zero, status.Errorf(codes.InvalidArgument, "amount %q is not a valid decimal", amount)
+	}
+	return d, nil
+}
+
+// decodeGRPCMetadata decodes the JSON object of payment metadata, where an empty string means none.
+func decodeGRPCMetadata(metadata string) (Metadata, error) {
+	if metadata == "" {
+		return nil, nil
+	}
+	var m Metadata
+	if err := m.UnmarshalJSON([]byte(metadata)); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "metadata is not a JSON object")
+	}
+	return m, nil
+}
+
+func mapAccountDetailsToGRPC(account AccountDetails) *pb.Account {
+	return &pb.Account{
+		Id:               account.Username,
+		Balance:          account.Balance.String(),
+		AvailableBalance: account.AvailableBalance.String(),
+		Currency:         account.Currency,
+		Status:           account.Status,
+		CreatedAt:        timestamppb.New(account.CreatedAt),
+	}
+}
+
+func mapPaymentToGRPC(payment Payment) (*pb.Payment, error) {
+	entries := []*pb.PaymentEntry{}
+	for _, entry := range payment.Entries {
+		entries = append(entries, &pb.PaymentEntry{
+			Account:     entry.Username,
+			Amount:      entry.Amount.String(),
+			ToAccount:   entry.ToAccount,
+			FromAccount: entry.FromAccount,
+			Direction:   entry.Direction,
+		})
+	}
+
+	var metadata string
+	if payment.Metadata != nil {
+		b, err := json.Marshal(payment.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		metadata = string(b)
+	}
+
+	return &pb.Payment{
+		Id:                payment.Id.String(),
+		Name:              payment.Name,
+		Entries:           entries,
+		CreatedAt:         timestamppb.New(payment.CreatedAt),
+		UpdatedAt:         timestamppb.New(payment.UpdatedAt),
+		Description:       payment.Description.String,
+		ExternalReference: payment.ExternalReference.String,
+		Metadata:          metadata,
+	}, nil
+}
@@ -0,0 +1,254 @@
+package transaction_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/google/uuid"
+	mocktransaction "github.com/nogurenn/cph-wallet/mocks/autogen/transaction"
+	"github.com/nogurenn/cph-wallet/transaction"
+	"github.com/nogurenn/cph-wallet/transaction/pb"
+	"github.com/nogurenn/cph-wallet/util"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"gopkg.in/guregu/null.v4"
+)
+
+// newGRPCClient serves the transaction service over an in-memory listener and returns a client connected to it.
+func newGRPCClient(t *testing.T, s transaction.Service) pb.TransactionServiceClient {
+	listener := bufconn.Listen(1024 * 1024)
+
+	server := grpc.NewServer()
+	pb.RegisterTransactionServiceServer(server, transaction.MakeGRPCServer(s, log.NewNopLogger()))
+	go server.Serve(listener)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return listener.Dial()
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		conn.Close()
+		server.Stop()
+	})
+
+	return pb.NewTransactionServiceClient(conn)
+}
+
+func Test_GRPCServer_GetAccount_Success(t *testing.T) {
+	// given
+	alice := &transaction.Account{
+		Id:          uuid.New(),
+		Username:    "alice456",
+		Balance:     decimal.NewFromFloat(270.62),
+		Currency:    "USD",
+		Status:      transaction.ActiveAccountStatus,
+		LastEntryId: util.NewNullUUID(uuid.New()),
+	}
+
+	s := new(mocktransaction.Service)
+	s.On("GetAccount", mock.Anything, alice.Username).Return(alice, nil)
+
+	client := newGRPCClient(t, s)
+
+	// when
+	resp, err := client.GetAccount(context.Background(), &pb.GetAccountRequest{Id: alice.Username})
+
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, alice.Username, resp.Account.Id)
+	assert.Equal(t, "270.62", resp.Account.Balance)
+	assert.Equal(t, "270.62", resp.Account.AvailableBalance)
+	assert.Equal(t, "USD", resp.Account.Currency)
+	assert.Equal(t, transaction.ActiveAccountStatus, resp.Account.Status)
+
+	s.AssertExpectations(t)
+}
+
+func Test_GRPCServer_GetAccount_NotFound(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+	s.On("GetAccount", mock.Anything, "nobody").Return(nil, transaction.ErrAccountNotFound)
+
+	client := newGRPCClient(t, s)
+
+	// when
+	_, err := client.GetAccount(context.Background(), &pb.GetAccountRequest{Id: "nobody"})
+
+	// then
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	s.AssertExpectations(t)
+}
+
+func Test_GRPCServer_CreateAccountAndDeposit_Success(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+	s.On("CreateAccount", mock.Anything, "alice456").Return(nil)
+	s.On("Deposit", mock.Anything, "alice456", mock.MatchedBy(func(amount decimal.Decimal) bool {
+		return amount.Equal(decimal.NewFromFloat(200.00))
+	})).Return(nil)
+
+	client := newGRPCClient(t, s)
+
+	// when
+	_, createErr := client.CreateAccount(context.Background(), &pb.CreateAccountRequest{Username: "alice456"})
+	_, depositErr := client.Deposit(context.Background(), &pb.DepositRequest{Username: "alice456", Amount: "200.00"})
+
+	// then
+	assert.NoError(t, createErr)
+	assert.NoError(t, depositErr)
+
+	s.AssertExpectations(t)
+}
+
+func Test_GRPCServer_GetPaymentTransactions_Success(t *testing.T) {
+	// given
+	payment := transaction.Transaction{
+		Id:   uuid.New(),
+		Name: transaction.PaymentTransaction,
+		Entries: []transaction.Entry{
+			{
+				Name:              transaction.OutgoingEntry,
+				Debit:             decimal.NewFromFloat(-44.79),
+				AccountName:       "karen789",
+				TargetAccountName: null.StringFrom("alice456"),
+			}, {
+				Name:              transaction.IncomingEntry,
+				Credit:            decimal.NewFromFloat(44.79),
+				AccountName:       "alice456",
+				TargetAccountName: null.StringFrom("karen789"),
+			},
+		},
+		PaymentDetails: transaction.PaymentDetails{
+			Description:       null.StringFrom("lunch"),
+			ExternalReference: null.StringFrom("INV-0001"),
+			Metadata:          transaction.Metadata{"lines": json.Number("2")},
+		},
+	}
+
+	s := new(mocktransaction.Service)
+	s.On("GetPaymentTransactions", mock.Anything, transaction.TransactionFilter{ExternalReference: "INV-0001"}).
+		Return([]transaction.Transaction{payment}, nil)
+
+	client := newGRPCClient(t, s)
+
+	// when
+	resp, err := client.GetPaymentTransactions(context.Background(), &pb.GetPaymentTransactionsRequest{ExternalReference: "INV-0001"})
+
+	// then
+	require.NoError(t, err)
+	require.Len(t, resp.Payments, 1)
+	assert.Equal(t, payment.Id.String(), resp.Payments[0].Id)
+	require.Len(t, resp.Payments[0].Entries, 2)
+	assert.Equal(t, "44.79", resp.Payments[0].Entries[0].Amount)
+	assert.Equal(t, "alice456", resp.Payments[0].Entries[0].ToAccount)
+	assert.Equal(t, "karen789", resp.Payments[0].Entries[1].FromAccount)
+	assert.Equal(t, "lunch", resp.Payments[0].Description)
+	assert.Equal(t, "INV-0001", resp.Payments[0].ExternalReference)
+	assert.JSONEq(t, `{"lines":2}`, resp.Payments[0].Metadata)
+
+	s.AssertExpectations(t)
+}
+
+func Test_GRPCServer_SendPayment_PaymentDetails(t *testing.T) {
+	// given
+	details := transaction.PaymentDetails{
+		Description:       null.StringFrom("lunch"),
+		ExternalReference: null.StringFrom("INV-0001"),
+		Metadata:          transaction.Metadata{"lines": json.Number("2")},
+	}
+
+	s := new(mocktransaction.Service)
+	s.On("SendPayment", mock.Anything, "bob123", "alice456", mock.Anything, details).Return(nil)
+
+	client := newGRPCClient(t, s)
+
+	// when
+	_, err := client.SendPayment(context.Background(), &pb.SendPaymentRequest{
+		Username:          "bob123",
+		TargetUsername:    "alice456",
+		Amount:            "95.12",
+		Description:       "lunch",
+		ExternalReference: "INV-0001",
+		Metadata:          `{"lines":2}`,
+	})
+
+	// then
+	assert.NoError(t, err)
+
+	s.AssertExpectations(t)
+}
+
+func Test_GRPCServer_SendPayment_MetadataInvalid(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+
+	client := newGRPCClient(t, s)
+
+	// when
+	_, err := client.SendPayment(context.Background(), &pb.SendPaymentRequest{
+		Username:       "bob123",
+		TargetUsername: "alice456",
+		Amount:         "95.12",
+		Metadata:       `["lines"]`,
+	})
+
+	// then
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	s.AssertExpectations(t)
+}
+
+func Test_GRPCServer_SendPayment_BalanceInsufficient(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+	s.On("SendPayment", mock.Anything, "bob123", "alice456", mock.Anything, transaction.PaymentDetails{}).Return(transaction.ErrBalanceInsufficient)
+
+	client := newGRPCClient(t, s)
+
+	// when
+	_, err := client.SendPayment(context.Background(), &pb.SendPaymentRequest{
+		Username:       "bob123",
+		TargetUsername: "alice456",
+		Amount:         "1000",
+	})
+
+	// then
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Equal(t, transaction.ErrBalanceInsufficient.Error(), status.Convert(err).Message())
+
+	s.AssertExpectations(t)
+}
+
+func Test_GRPCServer_SendPayment_AmountInvalid(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+
+	client := newGRPCClient(t, s)
+
+	// when
+	_, err := client.SendPayment(context.Background(), &pb.SendPaymentRequest{
+		Username:       "bob123",
+		TargetUsername: "alice456",
+		Amount:         "not-a-number",
+	})
+
+	// then
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	s.AssertExpectations(t)
+}
@@ -0,0 +1,1007 @@
+package transaction_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/google/uuid"
+	mocktransaction "github.com/nogurenn/cph-wallet/mocks/autogen/transaction"
+	"github.com/nogurenn/cph-wallet/transaction"
+	"github.com/nogurenn/cph-wallet/util"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gopkg.in/guregu/null.v4"
+)
+
+func Test_MakeHandler_GetAccount_Success(t *testing.T) {
+	// given
+	alice := &transaction.Account{
+		Id:          uuid.New(),
+		Username:    "alice456",
+		Balance:     decimal.NewFromFloat(200.0),
+		Currency:    "USD",
+		Status:      transaction.ActiveAccountStatus,
+		LastEntryId: util.NewNullUUID(uuid.New()),
+	}
+
+	s := new(mocktransaction.Service)
+	s.On("GetAccount", mock.Anything, alice.Username).Return(alice, nil)
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	// when
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/transaction/v1/accounts/alice456", nil))
+
+	// then
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("ETag"))
+
+	var body struct {
+		Account map[string]interface{} `json:"account"`
+	}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(t, "alice456", body.Account["id"])
+	assert.Equal(t, "200", body.Account["balance"])
+	assert.Equal(t, "200", body.Account["available_balance"])
+	assert.Equal(t, "USD", body.Account["currency"])
+	assert.Equal(t, transaction.ActiveAccountStatus, body.Account["status"])
+	assert.Contains(t, body.Account, "created_at")
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_GetAccount_NotModified(t *testing.T) {
+	// given
+	alice := &transaction.Account{
+		Id:          uuid.New(),
+		Username:    "alice456",
+		Balance:     decimal.NewFromFloat(200.0),
+		Currency:    "USD",
+		Status:      transaction.ActiveAccountStatus,
+		LastEntryId: util.NewNullUUID(uuid.New()),
+	}
+
+	s := new(mocktransaction.Service)
+	s.On("GetAccount", mock.Anything, alice.Username).Return(alice, nil)
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	first := httptest.NewRecorder()
+	handler.ServeHTTP(first, httptest.NewRequest(http.MethodGet, "/transaction/v1/accounts/alice456", nil))
+	etag := first.Header().Get("ETag")
+
+	// when
+	req := httptest.NewRequest(http.MethodGet, "/transaction/v1/accounts/alice456", nil)
+	req.Header.Set("If-None-Match", etag)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	// then
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Equal(t, etag, rec.Header().Get("ETag"))
+	assert.Empty(t, rec.Body.String())
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_GetAccount_ETagChangesWithNewEntry(t *testing.T) {
+	// given
+	alice := transaction.Account{
+		Id:          uuid.New(),
+		Username:    "alice456",
+		Currency:    "USD",
+		Status:      transaction.ActiveAccountStatus,
+		LastEntryId: util.NewNullUUID(uuid.New()),
+	}
+	aliceAfterDeposit := alice
+	aliceAfterDeposit.LastEntryId = util.NewNullUUID(uuid.New())
+
+	s := new(mocktransaction.Service)
+	s.On("GetAccount", mock.Anything, alice.Username).Return(&alice, nil).Once()
+	s.On("GetAccount", mock.Anything, alice.Username).Return(&aliceAfterDeposit, nil).Once()
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	first := httptest.NewRecorder()
+	handler.ServeHTTP(first, httptest.NewRequest(http.MethodGet, "/transaction/v1/accounts/alice456", nil))
+
+	// when
+	req := httptest.NewRequest(http.MethodGet, "/transaction/v1/accounts/alice456", nil)
+	req.Header.Set("If-None-Match", first.Header().Get("ETag"))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	// then
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEqual(t, first.Header().Get("ETag"), rec.Header().Get("ETag"))
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_GetAccount_ETagChangesWithBalance(t *testing.T) {
+	// given
+	alice := transaction.Account{
+		Id:          uuid.New(),
+		Username:    "alice456",
+		Currency:    "USD",
+		Status:      transaction.ActiveAccountStatus,
+		Balance:     decimal.NewFromFloat(100.0),
+		LastEntryId: util.NewNullUUID(uuid.New()),
+	}
+	// only the balance differs
+	aliceAfterDeposit := alice
+	aliceAfterDeposit.Balance = decimal.NewFromFloat(150.0)
+
+	s := new(mocktransaction.Service)
+	s.On("GetAccount", mock.Anything, alice.Username).Return(&alice, nil).Once()
+	s.On("GetAccount", mock.Anything, alice.Username).Return(&aliceAfterDeposit, nil).Once()
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	first := httptest.NewRecorder()
+	handler.ServeHTTP(first, httptest.NewRequest(http.MethodGet, "/transaction/v1/accounts/alice456", nil))
+
+	// when
+	req := httptest.NewRequest(http.MethodGet, "/transaction/v1/accounts/alice456", nil)
+	req.Header.Set("If-None-Match", first.Header().Get("ETag"))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	// then
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEqual(t, first.Header().Get("ETag"), rec.Header().Get("ETag"))
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_GetAccount_NotFound(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+	s.On("GetAccount", mock.Anything, "nobody").Return(nil, transaction.ErrAccountNotFound)
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	// when
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/transaction/v1/accounts/nobody", nil))
+
+	// then
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.JSONEq(t, `{"error": "account does not exist"}`, rec.Body.String())
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_GetAccounts_AsOf(t *testing.T) {
+	// given
+	asOf := time.Date(2022, 4, 30, 23, 59, 59, 0, time.UTC)
+	accounts := []transaction.Account{{Username: "alice456", Balance: decimal.NewFromInt(10), Currency: "USD"}}
+
+	s := new(mocktransaction.Service)
+	s.On("GetAccountsAsOf", mock.Anything, asOf).Return(accounts, nil)
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	// when
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/transaction/v1/accounts?as_of=2022-04-30T23:59:59Z", nil))
+
+	// then
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"accounts": [{"id": "alice456", "balance": "10", "currency": "USD", "status": ""}], "error": null}`, rec.Body.String())
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_GetAccount_AsOf(t *testing.T) {
+	// given
+	asOf := time.Date(2022, 4, 30, 15, 59, 59, 500000000, time.UTC)
+	alice := &transaction.Account{Username: "alice456", Balance: decimal.NewFromInt(10), Currency: "USD", Status: transaction.FrozenAccountStatus}
+
+	s := new(mocktransaction.Service)
+	s.On("GetAccountAsOf", mock.Anything, alice.Username, mock.MatchedBy(asOf.Equal)).Return(alice, nil)
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	// when
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/transaction/v1/accounts/alice456?as_of=2022-04-30T23:59:59.5%2B08:00", nil))
+
+	// then
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var body struct {
+		Account map[string]interface{} `json:"account"`
+	}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+	assert.Equal(t, "10", body.Account["balance"])
+	assert.Equal(t, "0", body.Account["available_balance"])
+	assert.Equal(t, transaction.FrozenAccountStatus, body.Account["status"])
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_GetAccount_AsOfInvalid(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	// when
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/transaction/v1/accounts/alice456?as_of=2022-04-30", nil))
+
+	// then
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.JSONEq(t, `{"error": "as_of is not a valid RFC 3339 timestamp"}`, rec.Body.String())
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_RequestIDPropagated(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+	s.On("GetAccounts", mock.MatchedBy(func(ctx context.Context) bool {
+		return transaction.RequestIDFrom(ctx) == "req-42"
+	})).Return([]transaction.Account{}, nil)
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	// when
+	req := httptest.NewRequest(http.MethodGet, "/transaction/v1/accounts", nil)
+	req.Header.Set("X-Request-ID", "req-42")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	// then
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "req-42", rec.Header().Get("X-Request-ID"))
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_RequestIDGenerated(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+	s.On("GetAccount", mock.Anything, "nobody").Return(nil, transaction.ErrAccountNotFound)
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	// when
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/transaction/v1/accounts/nobody", nil))
+
+	// then
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	_, err := uuid.Parse(rec.Header().Get("X-Request-ID"))
+	assert.NoError(t, err)
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_ActorPropagated(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+	s.On("CreateAccount", mock.MatchedBy(func(ctx context.Context) bool {
+		return transaction.ClaimedActorFrom(ctx) == "ops@example.com"
+	}), "alice456").Return(nil)
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	// when
+	req := httptest.NewRequest(http.MethodPost, "/transaction/v1/accounts", strings.NewReader(`{"username": "alice456"}`))
+	req.Header.Set("X-Actor", "ops@example.com")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	// then
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_GetAuditLog_Filtered(t *testing.T) {
+	// given
+	since := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	filter := transaction.AuditFilter{
+		ClaimedActor: "ops@example.com",
+		Action:       transaction.SendPaymentAuditAction,
+		Account:      "alice456",
+		Since:        since,
+		AfterSeq:     41,
+		Limit:        10,
+	}
+	records := []transaction.AuditRecord{{Seq: 42, Action: transaction.SendPaymentAuditAction, CreatedAt: since}}
+
+	s := new(mocktransaction.Service)
+	s.On("GetAuditLog", mock.Anything, filter).Return(records, nil)
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	// when
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet,
+		"/transaction/v1/admin/audit-log?claimed_actor=ops@example.com&action=send_payment&account=alice456&since=2022-05-01T00:00:00Z&after=41&limit=10", nil))
+
+	// then
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var body struct {
+		Records []transaction.AuditRecord `json:"records"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Len(t, body.Records, 1)
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_GetAuditLog_FilterInvalid(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	// when
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/transaction/v1/admin/audit-log?since=yesterday", nil))
+
+	// then
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.JSONEq(t, `{"error": "audit log filter is invalid"}`, rec.Body.String())
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_SendPayment_WithPaymentDetails(t *testing.T) {
+	// given
+	details := transaction.PaymentDetails{
+		Description:       null.StringFrom("March rent"),
+		ExternalReference: null.StringFrom("INV-0001"),
+		Metadata: transaction.Metadata{
+			"invoice": map[string]interface{}{"total": "12.50", "lines": json.Number("2")},
+		},
+	}
+
+	s := new(mocktransaction.Service)
+	s.On("SendPayment", mock.Anything, "bob123", "alice456", mock.Anything, details).Return(nil)
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	// when
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/transaction/v1/payments", strings.NewReader(`{
+		"username": "bob123",
+		"target_username": "alice456",
+		"amount": "12.50",
+		"description": "March rent",
+		"external_reference": "INV-0001",
+		"metadata": {"invoice": {"total": "12.50", "lines": 2}}
+	}`)))
+
+	// then
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_SendPayment_ExternalReferenceReused(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+	s.On("SendPayment", mock.Anything, "bob123", "alice456", mock.Anything, mock.Anything).Return(transaction.ErrExternalReferenceReused)
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	// when
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/transaction/v1/payments", strings.NewReader(
+		`{"username": "bob123", "target_username": "alice456", "amount": "12.50", "external_reference": "INV-0001"}`,
+	)))
+
+	// then
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.JSONEq(t, `{"error": "external reference was already used for another payment of the sender"}`, rec.Body.String())
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_SendPayment_AmountMalformed(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	// when
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/transaction/v1/payments", strings.NewReader(
+		`{"username": "bob123", "target_username": "alice456", "amount": "12.5O"}`,
+	)))
+
+	// then
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	var body struct {
+		Error string `json:"error"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.True(t, strings.HasPrefix(body.Error, transaction.ErrRequestMalformed.Error()+": "), body.Error)
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_GetPaymentTransactions_ByExternalReference(t *testing.T) {
+	// given
+	payment := transaction.Transaction{
+		Id:   uuid.New(),
+		Name: transaction.PaymentTransaction,
+		PaymentDetails: transaction.PaymentDetails{
+			Description:       null.StringFrom("March rent"),
+			ExternalReference: null.StringFrom("INV 0001"),
+			Metadata:          transaction.Metadata{"lines": json.Number("2")},
+		},
+	}
+
+	s := new(mocktransaction.Service)
+	s.On("GetPaymentTransactions", mock.Anything, transaction.TransactionFilter{ExternalReference: "INV 0001"}).
+		Return([]transaction.Transaction{payment}, nil)
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	// when
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/transaction/v1/payments?external_reference=INV+0001", nil))
+
+	// then
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var body struct {
+		Payments []map[string]interface{} `json:"payments"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Len(t, body.Payments, 1)
+	assert.Equal(t, "March rent", body.Payments[0]["description"])
+	assert.Equal(t, "INV 0001", body.Payments[0]["external_reference"])
+	assert.Equal(t, map[string]interface{}{"lines": float64(2)}, body.Payments[0]["metadata"])
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_CreatePaymentRequest_Success(t *testing.T) {
+	// given
+	expiresAt := time.Date(2022, 5, 8, 12, 0, 0, 0, time.UTC)
+	created := &transaction.PaymentRequest{
+		Id:        uuid.New(),
+		Requester: "alice456",
+		Payer:     "bob123",
+		Amount:    decimal.RequireFromString("25.00"),
+		Memo:      null.StringFrom("dinner"),
+		Status:    transaction.PendingPaymentRequestStatus,
+		ExpiresAt: expiresAt,
+	}
+
+	s := new(mocktransaction.Service)
+	s.On("CreatePaymentRequest", mock.Anything, "alice456", "bob123", mock.Anything, null.StringFrom("dinner"), expiresAt).
+		Return(created, nil)
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	// when
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/transaction/v1/payment-requests", strings.NewReader(
+		`{"requester": "alice456", "payer": "bob123", "amount": "25.00", "memo": "dinner", "expires_at": "2022-05-08T12:00:00Z"}`,
+	)))
+
+	// then
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	var body struct {
+		PaymentRequest map[string]interface{} `json:"payment_request"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, created.Id.String(), body.PaymentRequest["id"])
+	assert.Equal(t, "pending", body.PaymentRequest["status"])
+	assert.Nil(t, body.PaymentRequest["transaction_id"])
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_CreatePaymentRequest_DefaultExpiry(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+	s.On("CreatePaymentRequest", mock.Anything, "alice456", "bob123", mock.Anything, null.String{}, time.Time{}).
+		Return(&transaction.PaymentRequest{}, nil)
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	// when
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/transaction/v1/payment-requests", strings.NewReader(
+		`{"requester": "alice456", "payer": "bob123", "amount": "25.00"}`,
+	)))
+
+	// then
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_GetPaymentRequests_Filtered(t *testing.T) {
+	// given
+	filter := transaction.PaymentRequestFilter{Account: "bob123", Direction: "incoming", Status: "pending"}
+
+	s := new(mocktransaction.Service)
+	s.On("GetPaymentRequests", mock.Anything, filter).Return(nil, nil)
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	// when
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/transaction/v1/accounts/bob123/payment-requests?direction=incoming&status=pending", nil))
+
+	// then
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"payment_requests": [], "error": null}`, rec.Body.String())
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_AcceptPaymentRequest_NotPending(t *testing.T) {
+	// given
+	id := uuid.New()
+
+	s := new(mocktransaction.Service)
+	s.On("AcceptPaymentRequest", mock.Anything, id, "bob123").Return(nil, transaction.ErrPaymentRequestNotPending)
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	// when
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/transaction/v1/payment-requests/"+id.String()+"/accept", strings.NewReader(
+		`{"username": "bob123"}`,
+	)))
+
+	// then
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.JSONEq(t, `{"error": "payment request was already paid, declined, expired or cancelled"}`, rec.Body.String())
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_DeclinePaymentRequest_IdInvalid(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	// when
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/transaction/v1/payment-requests/42/decline", strings.NewReader(
+		`{"username": "bob123"}`,
+	)))
+
+	// then
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.JSONEq(t, `{"error": "payment request does not exist"}`, rec.Body.String())
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_ExportPayments_CSV(t *testing.T) {
+	// given
+	filter := transaction.ExportFilter{
+		Kind:    transaction.PaymentTransaction,
+		Account: "alice456",
+		Since:   time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC),
+	}
+	entry := transaction.ExportedEntry{
+		EntryId:         uuid.MustParse("11111111-1111-1111-1111-111111111111"),
+		TransactionId:   uuid.MustParse("22222222-2222-2222-2222-222222222222"),
+		TransactionName: transaction.PaymentTransaction,
+		Account:         "alice456",
+		Counterparty:    null.StringFrom("bob123"),
+		Amount:          decimal.RequireFromString("-44.79"),
+		CreatedAt:       time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	s := new(mocktransaction.Service)
+	s.On("ExportEntries", mock.Anything, filter, mock.Anything).
+		Run(func(args mock.Arguments) {
+			fn := args.Get(2).(func(transaction.ExportedEntry) error)
+			assert.NoError(t, fn(entry))
+		}).
+		Return(nil)
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	// when
+	req := httptest.NewRequest(http.MethodGet, "/transaction/v1/exports/payments?account=alice456&since=2022-05-01T00:00:00Z", nil)
+	req.Header.Set("Accept", "text/csv, application/json;q=0.5")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	// then
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "entry_id,transaction_id,type,account,counterparty,amount,balance,created_at\n"+
+		"11111111-1111-1111-1111-111111111111,22222222-2222-2222-2222-222222222222,payment,alice456,bob123,-44.79,,2022-05-01T12:00:00Z\n",
+		rec.Body.String())
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_ExportStatement_NDJSON(t *testing.T) {
+	// given
+	filter := transaction.ExportFilter{Kind: transaction.StatementExport, Account: "alice456"}
+	entries := []transaction.ExportedEntry{
+		{TransactionName: transaction.DepositTransaction, Account: "alice456", Amount: decimal.NewFromInt(10), Balance: decimal.NullDecimal{Decimal: decimal.NewFromInt(10), Valid: true}},
+		{TransactionName: transaction.PaymentTransaction, Account: "alice456", Amount: decimal.NewFromInt(-4), Balance: decimal.NullDecimal{Decimal: decimal.NewFromInt(6), Valid: true}},
+	}
+
+	s := new(mocktransaction.Service)
+	s.On("ExportEntries", mock.Anything, filter, mock.Anything).
+		Run(func(args mock.Arguments) {
+			fn := args.Get(2).(func(transaction.ExportedEntry) error)
+			for _, entry := range entries {
+				assert.NoError(t, fn(entry))
+			}
+		}).
+		Return(nil)
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	// when
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/transaction/v1/exports/statements/alice456", nil))
+
+	// then
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/x-ndjson; charset=utf-8", rec.Header().Get("Content-Type"))
+
+	lines := strings.Split(strings.TrimSuffix(rec.Body.String(), "\n"), "\n")
+	if assert.Len(t, lines, 2) {
+		var line map[string]interface{}
+		assert.NoError(t, json.Unmarshal([]byte(lines[1]), &line))
+		assert.Equal(t, "-4", line["amount"])
+		assert.Equal(t, "6", line["balance"])
+		assert.Nil(t, line["counterparty"])
+	}
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_ExportDeposits_Empty(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+	s.On("ExportEntries", mock.Anything, transaction.ExportFilter{Kind: transaction.DepositTransaction}, mock.Anything).Return(nil)
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	// when
+	req := httptest.NewRequest(http.MethodGet, "/transaction/v1/exports/deposits", nil)
+	req.Header.Set("Accept", "text/csv")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	// then
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "entry_id,transaction_id,type,account,counterparty,amount,balance,created_at\n", rec.Body.String())
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_ExportStatement_AccountNotFound(t *testing.T) {
+	// given
+	filter := transaction.ExportFilter{Kind: transaction.StatementExport, Account: "nobody"}
+
+	s := new(mocktransaction.Service)
+	s.On("ExportEntries", mock.Anything, filter, mock.Anything).Return(transaction.ErrAccountNotFound)
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	// when
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/transaction/v1/exports/statements/nobody", nil))
+
+	// then
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, "application/json; charset=utf-8", rec.Header().Get("Content-Type"))
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_ExportPayments_NotAcceptable(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	// when
+	req := httptest.NewRequest(http.MethodGet, "/transaction/v1/exports/payments", nil)
+	req.Header.Set("Accept", "application/json")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	// then
+	assert.Equal(t, http.StatusNotAcceptable, rec.Code)
+	assert.JSONEq(t, `{"error": "exports are available as text/csv or application/x-ndjson only"}`, rec.Body.String())
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_ExportPayments_FilterInvalid(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	// when
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/transaction/v1/exports/payments?until=tomorrow", nil))
+
+	// then
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.JSONEq(t, `{"error": "export filter is invalid"}`, rec.Body.String())
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_ExportPayments_AbortedMidway(t *testing.T) {
+	// given
+	s := new(mocktransaction.Service)
+	s.On("ExportEntries", mock.Anything, transaction.ExportFilter{Kind: transaction.PaymentTransaction}, mock.Anything).
+		Run(func(args mock.Arguments) {
+			fn := args.Get(2).(func(transaction.ExportedEntry) error)
+			assert.NoError(t, fn(transaction.ExportedEntry{Account: "alice456"}))
+		}).
+		Return(errors.New("connection reset"))
+
+	server := httptest.NewServer(transaction.MakeHandler(s, log.NewNopLogger()))
+	defer server.Close()
+
+	// when
+	resp, err := http.Get(server.URL + "/transaction/v1/exports/payments")
+
+	// then
+	// the entry written so far may not have left the buffers of the server yet
+	if err == nil {
+		defer resp.Body.Close()
+		_, err = ioutil.ReadAll(resp.Body)
+	}
+	assert.Error(t, err)
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_ImportAccounts_CSV(t *testing.T) {
+	// given
+	rows := []transaction.ImportRow{
+		{Username: "carol789", OpeningBalance: decimal.RequireFromString("150.50")},
+		{Username: "dave000", OpeningBalance: decimal.Zero},
+	}
+	report := &transaction.ImportReport{Rows: 2, Accounts: 2, OpeningBalance: decimal.RequireFromString("150.50")}
+
+	s := new(mocktransaction.Service)
+	s.On("ImportAccounts", mock.Anything, rows, false).Return(report, nil)
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	// when
+	req := httptest.NewRequest(http.MethodPost, "/transaction/v1/admin/imports",
+		strings.NewReader("username,opening_balance\ncarol789,150.50\ndave000,\n"))
+	req.Header.Set("Content-Type", "text/csv; charset=utf-8")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	// then
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.JSONEq(t, `{
+		"report": {"dry_run": false, "rows": 2, "accounts": 2, "opening_balance": "150.5", "errors": null},
+		"error": null
+	}`, rec.Body.String())
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_ImportAccounts_DryRun(t *testing.T) {
+	// given
+	rows := []transaction.ImportRow{{Username: "carol789", OpeningBalance: decimal.RequireFromString("0")}}
+	report := &transaction.ImportReport{DryRun: true, Rows: 1, Accounts: 1, OpeningBalance: decimal.Zero}
+
+	s := new(mocktransaction.Service)
+	s.On("ImportAccounts", mock.Anything, rows, true).Return(report, nil)
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	// when
+	req := httptest.NewRequest(http.MethodPost, "/transaction/v1/admin/imports?dry_run=true",
+		strings.NewReader(`{"username":"carol789","opening_balance":"0"}`+"\n"))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	// then
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_ImportAccounts_RowsInvalid(t *testing.T) {
+	// given
+	report := &transaction.ImportReport{
+		Rows:           1,
+		OpeningBalance: decimal.Zero,
+		Errors:         []transaction.ImportRowError{{Row: 1, Username: "bob123", Error: "account already exists"}},
+	}
+
+	s := new(mocktransaction.Service)
+	s.On("ImportAccounts", mock.Anything, mock.Anything, false).Return(report, transaction.ErrImportRowsInvalid)
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	// when
+	req := httptest.NewRequest(http.MethodPost, "/transaction/v1/admin/imports", strings.NewReader("username\nbob123\n"))
+	req.Header.Set("Content-Type", "text/csv")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	// then
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.JSONEq(t, `{
+		"report": {
+			"dry_run": false, "rows": 1, "accounts": 0, "opening_balance": "0",
+			"errors": [{"row": 1, "username": "bob123", "error": "account already exists"}]
+		},
+		"error": "import rows are invalid, see the report for details"
+	}`, rec.Body.String())
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_ImportAccounts_Rejected(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		code        int
+	}{
+		{"application/json", `[{"username":"carol789"}]`, http.StatusUnsupportedMediaType},
+		{"", "username\ncarol789\n", http.StatusUnsupportedMediaType},
+		{"text/csv", "name\ncarol789\n", http.StatusBadRequest},
+		{"application/x-ndjson", "", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		// given
+		s := new(mocktransaction.Service)
+
+		handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+		// when
+		req := httptest.NewRequest(http.MethodPost, "/transaction/v1/admin/imports", strings.NewReader(test.body))
+		req.Header.Set("Content-Type", test.contentType)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		// then
+		assert.Equal(t, test.code, rec.Code, "%q %q", test.contentType, test.body)
+
+		s.AssertExpectations(t)
+	}
+}
+
+func Test_MakeHandler_StreamAccountEvents_Resumed(t *testing.T) {
+	// given
+	events := []transaction.AccountEvent{
+		{Seq: 42, TransactionName: transaction.DepositTransaction, Amount: decimal.NewFromInt(10), Balance: decimal.NewFromInt(10)},
+		{Seq: 43, TransactionName: transaction.PaymentTransaction, Counterparty: null.StringFrom("bob123"), Amount: decimal.NewFromInt(-4), Balance: decimal.NewFromInt(6)},
+	}
+
+	s := new(mocktransaction.Service)
+	s.On("StreamAccountEvents", mock.Anything, "alice456", null.IntFrom(41), mock.Anything).
+		Run(func(args mock.Arguments) {
+			fn := args.Get(3).(func(transaction.AccountEvent) error)
+			for _, event := range events {
+				assert.NoError(t, fn(event))
+			}
+		}).
+		Return(nil)
+
+	handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+	// when
+	req := httptest.NewRequest(http.MethodGet, "/transaction/v1/accounts/alice456/events", nil)
+	req.Header.Set("Last-Event-ID", "41")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	// then
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/event-stream", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "no-cache", rec.Header().Get("Cache-Control"))
+	assert.True(t, rec.Flushed)
+
+	messages := strings.Split(strings.TrimSuffix(rec.Body.String(), "\n\n"), "\n\n")
+	if assert.Len(t, messages, 2) {
+		assert.True(t, strings.HasPrefix(messages[1], "id: 43\ndata: {"), messages[1])
+
+		var event transaction.AccountEvent
+		assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(messages[1], "id: 43\ndata: ")), &event))
+		assert.Equal(t, "bob123", event.Counterparty.String)
+		assert.True(t, decimal.NewFromInt(6).Equal(event.Balance))
+	}
+
+	s.AssertExpectations(t)
+}
+
+func Test_MakeHandler_StreamAccountEvents_Heartbeat(t *testing.T) {
+	// given
+	defer transaction.SetSSEHeartbeatInterval(10 * time.Millisecond)()
+
+	s := new(mocktransaction.Service)
+	s.On("StreamAccountEvents", mock.Anything, "alice456", null.Int{}, mock.Anything).
+		Run(func(args mock.Arguments) {
+			<-args.Get(0).(context.Context).Done()
+		}).
+		Return(nil)
+
+	server := httptest.NewServer(transaction.MakeHandler(s, log.NewNopLogger()))
+	defer server.Close()
+
+	// when
+	resp, err := http.Get(server.URL + "/transaction/v1/accounts/alice456/events")
+
+	// then
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	line := make([]byte, len(": heartbeat\n\n"))
+	_, err = io.ReadFull(resp.Body, line)
+	assert.NoError(t, err)
+	assert.Equal(t, ": heartbeat\n\n", string(line))
+	resp.Body.Close()
+}
+
+func Test_MakeHandler_StreamAccountEvents_Rejected(t *testing.T) {
+	tests := []struct {
+		lastEventId string
+		err         error
+		code        int
+	}{
+		{"latest", nil, http.StatusBadRequest},
+		{"-1", nil, http.StatusBadRequest},
+		{"", transaction.ErrAccountNotFound, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		// given
+		s := new(mocktransaction.Service)
+		if test.err != nil {
+			s.On("StreamAccountEvents", mock.Anything, "nobody", null.Int{}, mock.Anything).Return(test.err)
+		}
+
+		handler := transaction.MakeHandler(s, log.NewNopLogger())
+
+		// when
+		req := httptest.NewRequest(http.MethodGet, "/transaction/v1/accounts/nobody/events", nil)
+		if test.lastEventId != "" {
+			req.Header.Set("Last-Event-ID", test.lastEventId)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		// then
+		assert.Equal(t, test.code, rec.Code, "%q", test.lastEventId)
+		assert.Equal(t, "application/json; charset=utf-8", rec.Header().Get("Content-Type"))
+
+		s.AssertExpectations(t)
+	}
+}
@@ -0,0 +1,59 @@
+package transaction
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// errWorkerStopped is reported by the readiness check of a background worker that is no longer running.
+var errWorkerStopped = errors.New("not running")
+
+// workerState is what a background worker tells readiness about itself: whether it stopped, and since when it has
+// been failing, if it is.
+type workerState struct {
+	mu           sync.Mutex
+	stopped      bool
+	failingSince time.Time
+	lastErr      error
+}
+
+func (w *workerState) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.stopped = true
+}
+
+func (w *workerState) succeeded() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.failingSince = time.Time{}
+	w.lastErr = nil
+}
+
+func (w *workerState) failed(now time.Time, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.lastErr == nil {
+		w.failingSince = now
+	}
+	w.lastErr = err
+}
+
+// check fails once the worker stopped, or has been failing for at least tolerance.
+func (w *workerState) check(now time.Time, tolerance time.Duration) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.stopped {
+		return errWorkerStopped
+	}
+	if w.lastErr != nil && now.Sub(w.failingSince) >= tolerance {
+		return fmt.Errorf("failing since %s: %w", w.failingSince.UTC().Format(time.RFC3339), w.lastErr)
+	}
+	return nil
+}